@@ -0,0 +1,75 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// ParseSeverity returns the severity of the name, info if it is empty
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(name) {
+	case "", "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "critical":
+		return SeverityCritical, nil
+	default:
+		return SeverityInfo, fmt.Errorf("unknown severity %s", name)
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Alert is a firing or resolved problem found by a rule
+type Alert struct {
+	Rule  string `json:"rule"`
+	Chain string `json:"chain"`
+	// Subject tells the alerts of a rule on a chain apart, for example the token of a price alert
+	Subject  string    `json:"subject,omitempty"`
+	Severity Severity  `json:"severity"`
+	Message  string    `json:"message"`
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+func (a *Alert) key() string {
+	return a.Rule + "/" + a.Chain + "/" + a.Subject
+}
+
+// Text returns the alert as a line of text for the chat and email channels
+func (a *Alert) Text() string {
+	if a.Resolved {
+		return fmt.Sprintf("[RESOLVED] Statas Service %s on %s: %s", a.Rule, a.Chain, a.Message)
+	}
+	return fmt.Sprintf("[%s] Statas Service %s on %s: %s", strings.ToUpper(a.Severity.String()), a.Rule, a.Chain, a.Message)
+}
+
+// Notifier sends the alerts to a channel
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert *Alert) error
+}
@@ -0,0 +1,167 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// firingAlert is an alert notified and not resolved yet
+type firingAlert struct {
+	alert      *Alert
+	notifiedAt time.Time
+}
+
+// Manager checks the rules periodically and notifies the channels. A firing alert is notified again
+// only after the cooldown or when its severity rises, and once more when it is resolved.
+type Manager struct {
+	mux        sync.Mutex
+	notifiers  []Notifier
+	thresholds Thresholds
+	cooldown   time.Duration
+	rules      []Rule
+
+	// checkMux serializes the checks, which own the firing alerts and the state of the rules
+	checkMux sync.Mutex
+	firing   map[Rule]map[string]*firingAlert
+
+	wg sync.WaitGroup
+}
+
+// NewManager returns the manager of the alert config without rules
+func NewManager(cfg *util.AlertConfig) *Manager {
+	m := &Manager{
+		firing: make(map[Rule]map[string]*firingAlert, 0),
+	}
+	m.Reload(cfg)
+	return m
+}
+
+// Reload applies the notifiers and the thresholds of the alert config, the firing alerts are kept
+func (m *Manager) Reload(cfg *util.AlertConfig) {
+	notifiers := make([]Notifier, 0, len(cfg.Notifiers)+1)
+	if cfg.TelegramBotId != "" && cfg.TelegramChatId != "" {
+		notifiers = append(notifiers, &TelegramNotifier{BotId: cfg.TelegramBotId, ChatId: cfg.TelegramChatId})
+	}
+	for _, notifierConfig := range cfg.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			util.Logger.Errorf("init notifier error, type=%s, err=%s", notifierConfig.Type, err.Error())
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	cooldown := time.Duration(cfg.Cooldown) * time.Second
+	if cooldown == 0 {
+		cooldown = common.DefaultAlertCooldown
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.notifiers = notifiers
+	m.thresholds = thresholdsOf(cfg)
+	m.cooldown = cooldown
+}
+
+// AddRules adds the rules to check, it is called before Start
+func (m *Manager) AddRules(rules ...Rule) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.rules = append(m.rules, rules...)
+}
+
+// Start checks the rules periodically until the context is done
+func (m *Manager) Start(ctx context.Context) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for util.Sleep(ctx, common.AlertCheckInterval) {
+			m.Check(ctx, time.Now())
+		}
+	}()
+}
+
+// Wait waits for the check routine to stop
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// Check checks all rules once and notifies the alerts fired, repeated, escalated and resolved
+func (m *Manager) Check(ctx context.Context, now time.Time) {
+	m.mux.Lock()
+	notifiers, thresholds, cooldown, rules := m.notifiers, m.thresholds, m.cooldown, m.rules
+	m.mux.Unlock()
+
+	m.checkMux.Lock()
+	defer m.checkMux.Unlock()
+	notifications := make([]*Alert, 0)
+	for _, rule := range rules {
+		alerts, err := rule.Check(thresholds, now)
+		if err != nil {
+			// the alerts of the rule are neither repeated nor resolved while it can not be checked
+			util.Logger.Errorf("check alert rule error, rule=%s, err=%s", rule.Name(), err.Error())
+			continue
+		}
+		firing := m.firing[rule]
+		if firing == nil {
+			firing = make(map[string]*firingAlert, 0)
+			m.firing[rule] = firing
+		}
+		current := make(map[string]bool, len(alerts))
+		for _, alert := range alerts {
+			alert.Rule = rule.Name()
+			alert.Time = now
+			key := alert.key()
+			current[key] = true
+			last, exist := firing[key]
+			if exist && alert.Severity <= last.alert.Severity && now.Sub(last.notifiedAt) < cooldown {
+				continue
+			}
+			firing[key] = &firingAlert{alert: alert, notifiedAt: now}
+			notifications = append(notifications, alert)
+		}
+		for key, last := range firing {
+			if current[key] {
+				continue
+			}
+			resolved := *last.alert
+			resolved.Resolved = true
+			resolved.Time = now
+			notifications = append(notifications, &resolved)
+			delete(firing, key)
+		}
+	}
+
+	send(ctx, notifiers, notifications)
+}
+
+// Notify sends the alerts of events at once, they are neither deduplicated nor resolved
+func (m *Manager) Notify(ctx context.Context, alerts ...*Alert) {
+	m.mux.Lock()
+	notifiers := m.notifiers
+	m.mux.Unlock()
+
+	now := time.Now()
+	for _, alert := range alerts {
+		if alert.Time.IsZero() {
+			alert.Time = now
+		}
+	}
+	send(ctx, notifiers, alerts)
+}
+
+func send(ctx context.Context, notifiers []Notifier, alerts []*Alert) {
+	for _, alert := range alerts {
+		util.Logger.Warningf("alert: %s", alert.Text())
+		for _, notifier := range notifiers {
+			notifyCtx, cancel := context.WithTimeout(ctx, common.AlertNotifyTimeout)
+			if err := notifier.Notify(notifyCtx, alert); err != nil {
+				util.Logger.Errorf("notify alert error, notifier=%s, rule=%s, err=%s", notifier.Name(), alert.Rule, err.Error())
+			}
+			cancel()
+		}
+	}
+}
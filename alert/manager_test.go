@@ -0,0 +1,157 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// fakeSource serves the stats and the blocks the rules read
+type fakeSource struct {
+	blockLog    model.BlockLog
+	reorgDepth  int64
+	reorgAt     time.Time
+	prices      map[string]float64
+	tvl         float64
+	updateAt    time.Time
+	stale       bool
+	quarantined map[string]string
+}
+
+func (s *fakeSource) GetCurrentBlockLog() (*model.BlockLog, error) {
+	return &s.blockLog, nil
+}
+
+func (s *fakeSource) LastReorg() (int64, time.Time) {
+	return s.reorgDepth, s.reorgAt
+}
+
+func (s *fakeSource) GetPrice() (map[string]float64, time.Time) {
+	return s.prices, s.updateAt
+}
+
+func (s *fakeSource) GetTVL() (float64, time.Time) {
+	return s.tvl, s.updateAt
+}
+
+func (s *fakeSource) Stale() bool {
+	return s.stale
+}
+
+func (s *fakeSource) GetQuarantined() map[string]string {
+	return s.quarantined
+}
+
+type fakeRPC struct {
+	calls, failures uint64
+}
+
+func (r *fakeRPC) Stats() (uint64, uint64) {
+	return r.calls, r.failures
+}
+
+func TestManagerDedupeAndResolve(t *testing.T) {
+	stub, requests := newStub(t, http.StatusOK)
+	m := NewManager(&util.AlertConfig{
+		BlockUpdateTimeout: 300,
+		Cooldown:           3600,
+		Notifiers:          []*util.NotifierConfig{{Type: "webhook", URL: stub.URL}},
+	})
+	now := time.Unix(1600000000, 0)
+	source := &fakeSource{blockLog: model.BlockLog{Height: 100, CreateTime: now.Unix()}}
+	rpc := &fakeRPC{}
+	m.AddRules(ChainRules("bsc", source, source, rpc)...)
+
+	// notified returns the rules and the resolves of the alerts posted
+	type notice struct {
+		Rule     string `json:"rule"`
+		Resolved bool   `json:"resolved"`
+	}
+	notified := func() []notice {
+		notices := make([]notice, 0)
+		for _, req := range requests() {
+			var n notice
+			require.NoError(t, json.Unmarshal([]byte(req.body), &n))
+			notices = append(notices, n)
+		}
+		return notices
+	}
+
+	m.Check(context.Background(), now)
+	require.Empty(t, requests())
+
+	// the lag fires once, and again only after the cooldown
+	now = now.Add(10 * time.Minute)
+	m.Check(context.Background(), now)
+	m.Check(context.Background(), now.Add(time.Minute))
+	require.Equal(t, []notice{{Rule: "block_lag"}}, notified())
+	m.Check(context.Background(), now.Add(time.Hour))
+	require.Len(t, requests(), 2)
+
+	// a rising rpc failure rate escalates from warning to critical within the cooldown
+	rpc.calls, rpc.failures = 20, 12
+	m.Check(context.Background(), now.Add(time.Hour))
+	rpc.calls, rpc.failures = 40, 32
+	m.Check(context.Background(), now.Add(time.Hour))
+	require.Len(t, requests(), 4)
+
+	// new blocks and successful calls resolve them
+	source.blockLog.CreateTime = now.Add(time.Hour).Unix()
+	rpc.calls = 60
+	m.Check(context.Background(), now.Add(time.Hour))
+	alerts := notified()
+	require.Len(t, alerts, 6)
+	require.ElementsMatch(t, []notice{{Rule: "block_lag", Resolved: true}, {Rule: "rpc_failure", Resolved: true}}, alerts[4:])
+}
+
+func TestRules(t *testing.T) {
+	th := thresholdsOf(&util.AlertConfig{BlockUpdateTimeout: 300})
+	now := time.Unix(1600000000, 0)
+	source := &fakeSource{prices: map[string]float64{"WOKT": 20, "Pie": 1}, tvl: 1000, updateAt: now}
+
+	price := &PriceDeviationRule{Chain: "bsc", Stats: source}
+	alerts, err := price.Check(th, now)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+	source.prices, source.updateAt = map[string]float64{"WOKT": 30, "Pie": 1.1}, now.Add(5*time.Minute)
+	alerts, err = price.Check(th, now.Add(5*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "WOKT", alerts[0].Subject)
+	// firing until the next refresh
+	alerts, _ = price.Check(th, now.Add(6*time.Minute))
+	require.Len(t, alerts, 1)
+
+	tvl := &TVLDropRule{Chain: "bsc", Stats: source}
+	alerts, _ = tvl.Check(th, now.Add(5*time.Minute))
+	require.Empty(t, alerts)
+	source.tvl, source.updateAt = 600, now.Add(10*time.Minute)
+	alerts, _ = tvl.Check(th, now.Add(10*time.Minute))
+	require.Len(t, alerts, 1)
+	// the high falls out of the window
+	source.updateAt = now.Add(2 * time.Hour)
+	alerts, _ = tvl.Check(th, now.Add(2*time.Hour))
+	require.Empty(t, alerts)
+
+	reorg := &ReorgRule{Chain: "bsc", Indexer: source}
+	source.reorgDepth, source.reorgAt = 2, now
+	alerts, _ = reorg.Check(th, now)
+	require.Empty(t, alerts)
+	source.reorgDepth = 3
+	alerts, _ = reorg.Check(th, now)
+	require.Len(t, alerts, 1)
+	alerts, _ = reorg.Check(th, now.Add(2*time.Hour))
+	require.Empty(t, alerts)
+
+	refresh := &RefreshRule{Chain: "bsc", Stats: source}
+	source.stale = true
+	alerts, _ = refresh.Check(th, now)
+	require.Len(t, alerts, 1)
+}
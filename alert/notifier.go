@@ -0,0 +1,159 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// telegramEndpoint is the bot api, replaced by a stub in tests
+var telegramEndpoint = "https://api.telegram.org"
+
+// NewNotifier returns the notifier of the config, it only notifies the alerts of its min severity or above
+func NewNotifier(cfg *util.NotifierConfig) (Notifier, error) {
+	minSeverity, err := ParseSeverity(cfg.MinSeverity)
+	if err != nil {
+		return nil, err
+	}
+	var notifier Notifier
+	switch cfg.Type {
+	case common.AlertNotifierTelegram:
+		notifier = &TelegramNotifier{BotId: cfg.BotId, ChatId: cfg.ChatId}
+	case common.AlertNotifierWebhook:
+		notifier = &WebhookNotifier{URL: cfg.URL}
+	case common.AlertNotifierSlack:
+		notifier = &SlackNotifier{URL: cfg.URL}
+	case common.AlertNotifierEmail:
+		notifier = &EmailNotifier{Addr: cfg.SMTPAddr, Username: cfg.Username, Password: cfg.Password, From: cfg.From, To: cfg.To}
+	default:
+		return nil, fmt.Errorf("unknown notifier type %s", cfg.Type)
+	}
+	if minSeverity == SeverityInfo {
+		return notifier, nil
+	}
+	return &severityFilter{Notifier: notifier, min: minSeverity}, nil
+}
+
+// severityFilter drops the alerts below its min severity, the resolves of the ones notified pass
+type severityFilter struct {
+	Notifier
+	min Severity
+}
+
+func (f *severityFilter) Notify(ctx context.Context, alert *Alert) error {
+	if alert.Severity < f.min {
+		return nil
+	}
+	return f.Notifier.Notify(ctx, alert)
+}
+
+// post sends the request and fails on a non 2xx status
+func post(ctx context.Context, endpoint, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("status %d, body=%s", res.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func postJson(ctx context.Context, endpoint string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, endpoint, "application/json", body)
+}
+
+type TelegramNotifier struct {
+	BotId  string
+	ChatId string
+}
+
+func (n *TelegramNotifier) Name() string {
+	return common.AlertNotifierTelegram
+}
+
+func (n *TelegramNotifier) Notify(ctx context.Context, alert *Alert) error {
+	formData := url.Values{
+		"chat_id": {n.ChatId},
+		"text":    {alert.Text()},
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", telegramEndpoint, n.BotId)
+	return post(ctx, endpoint, "application/x-www-form-urlencoded", []byte(formData.Encode()))
+}
+
+// WebhookNotifier posts the alert as json
+type WebhookNotifier struct {
+	URL string
+}
+
+func (n *WebhookNotifier) Name() string {
+	return common.AlertNotifierWebhook
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert *Alert) error {
+	return postJson(ctx, n.URL, alert)
+}
+
+// SlackNotifier posts the alert to a slack compatible incoming webhook
+type SlackNotifier struct {
+	URL string
+}
+
+func (n *SlackNotifier) Name() string {
+	return common.AlertNotifierSlack
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, alert *Alert) error {
+	return postJson(ctx, n.URL, map[string]string{"text": alert.Text()})
+}
+
+// sendMail is smtp.SendMail, replaced in tests
+var sendMail = smtp.SendMail
+
+// EmailNotifier sends the alert by smtp, it authenticates with plain auth when the username is set
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *EmailNotifier) Name() string {
+	return common.AlertNotifierEmail
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, alert *Alert) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		n.From, strings.Join(n.To, ", "), alert.Text(), alert.Message)
+	return sendMail(n.Addr, auth, n.From, n.To, []byte(msg))
+}
@@ -0,0 +1,124 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/util"
+)
+
+type stubRequest struct {
+	path        string
+	contentType string
+	body        string
+}
+
+// newStub returns a local http server recording the requests, it answers with the status
+func newStub(t *testing.T, status int) (*httptest.Server, func() []stubRequest) {
+	var mux sync.Mutex
+	requests := make([]stubRequest, 0)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mux.Lock()
+		requests = append(requests, stubRequest{r.URL.Path, r.Header.Get("Content-Type"), string(body)})
+		mux.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub, func() []stubRequest {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]stubRequest{}, requests...)
+	}
+}
+
+func testAlert() *Alert {
+	return &Alert{
+		Rule:     "block_lag",
+		Chain:    "bsc",
+		Severity: SeverityCritical,
+		Message:  "no block",
+		Time:     time.Unix(1600000000, 0).UTC(),
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	stub, requests := newStub(t, http.StatusOK)
+	telegramEndpoint = stub.URL
+	defer func() { telegramEndpoint = "https://api.telegram.org" }()
+
+	for _, cfg := range []*util.NotifierConfig{
+		{Type: "telegram", BotId: "bot1", ChatId: "chat1"},
+		{Type: "webhook", URL: stub.URL + "/hook"},
+		{Type: "slack", URL: stub.URL + "/slack"},
+	} {
+		notifier, err := NewNotifier(cfg)
+		require.NoError(t, err)
+		require.NoError(t, notifier.Notify(context.Background(), testAlert()))
+	}
+
+	got := requests()
+	require.Len(t, got, 3)
+	require.Equal(t, "/botbot1/sendMessage", got[0].path)
+	form, err := url.ParseQuery(got[0].body)
+	require.NoError(t, err)
+	require.Equal(t, "chat1", form.Get("chat_id"))
+	require.Equal(t, "[CRITICAL] Statas Service block_lag on bsc: no block", form.Get("text"))
+
+	require.Equal(t, "/hook", got[1].path)
+	require.Equal(t, "application/json", got[1].contentType)
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(got[1].body), &payload))
+	require.Equal(t, "critical", payload["severity"])
+	require.Equal(t, "bsc", payload["chain"])
+	require.Equal(t, false, payload["resolved"])
+
+	require.Equal(t, "/slack", got[2].path)
+	require.JSONEq(t, `{"text": "[CRITICAL] Statas Service block_lag on bsc: no block"}`, got[2].body)
+}
+
+func TestNotifierErrors(t *testing.T) {
+	stub, _ := newStub(t, http.StatusBadGateway)
+	notifier, err := NewNotifier(&util.NotifierConfig{Type: "webhook", URL: stub.URL})
+	require.NoError(t, err)
+	require.Error(t, notifier.Notify(context.Background(), testAlert()))
+
+	// alerts below the min severity are dropped without a request
+	notifier, err = NewNotifier(&util.NotifierConfig{Type: "webhook", URL: stub.URL, MinSeverity: "critical"})
+	require.NoError(t, err)
+	warning := testAlert()
+	warning.Severity = SeverityWarning
+	require.NoError(t, notifier.Notify(context.Background(), warning))
+
+	_, err = NewNotifier(&util.NotifierConfig{Type: "pager"})
+	require.Error(t, err)
+}
+
+func TestEmailNotifier(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg []byte
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, msg
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	notifier, err := NewNotifier(&util.NotifierConfig{Type: "email", SMTPAddr: "localhost:25", From: "statas@pie", To: []string{"ops@pie"}})
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), testAlert()))
+	require.Equal(t, "localhost:25", gotAddr)
+	require.Equal(t, "statas@pie", gotFrom)
+	require.Equal(t, []string{"ops@pie"}, gotTo)
+	require.Contains(t, string(gotMsg), "Subject: [CRITICAL] Statas Service block_lag on bsc: no block\r\n")
+}
@@ -0,0 +1,300 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// Thresholds are the settings of the rules, the defaults are used for those not configured
+type Thresholds struct {
+	BlockUpdateTimeout time.Duration
+	RPCFailureRate     float64
+	PriceDeviation     float64
+	TVLDrop            float64
+	ReorgDepth         int64
+}
+
+func thresholdsOf(cfg *util.AlertConfig) Thresholds {
+	th := Thresholds{
+		BlockUpdateTimeout: time.Duration(cfg.BlockUpdateTimeout) * time.Second,
+		RPCFailureRate:     cfg.RPCFailureRate,
+		PriceDeviation:     cfg.PriceDeviation,
+		TVLDrop:            cfg.TVLDrop,
+		ReorgDepth:         cfg.ReorgDepth,
+	}
+	if th.RPCFailureRate == 0 {
+		th.RPCFailureRate = common.DefaultAlertRPCFailureRate
+	}
+	if th.PriceDeviation == 0 {
+		th.PriceDeviation = common.DefaultAlertPriceDeviation
+	}
+	if th.TVLDrop == 0 {
+		th.TVLDrop = common.DefaultAlertTVLDrop
+	}
+	if th.ReorgDepth == 0 {
+		th.ReorgDepth = common.DefaultAlertReorgDepth
+	}
+	return th
+}
+
+// Rule finds the problems of a chain
+type Rule interface {
+	Name() string
+	// Check returns the firing alerts, the ones of the rule not returned any more are resolved
+	Check(th Thresholds, now time.Time) ([]*Alert, error)
+}
+
+// Indexer is the observer of a chain as the rules read it
+type Indexer interface {
+	GetCurrentBlockLog() (*model.BlockLog, error)
+	// LastReorg returns the depth of the last reorg and when it completed
+	LastReorg() (int64, time.Time)
+}
+
+// Stats is the stat service of a chain as the rules read it
+type Stats interface {
+	GetPrice() (map[string]float64, time.Time)
+	GetTVL() (float64, time.Time)
+	Stale() bool
+	// GetQuarantined returns the tokens whose prices are quarantined and the checks they failed
+	GetQuarantined() map[string]string
+}
+
+// RPCStats counts the calls to a provider
+type RPCStats interface {
+	Stats() (calls, failures uint64)
+}
+
+// ChainRules returns all rules of the chain, the rpc rule is left out without rpc stats
+func ChainRules(chain string, indexer Indexer, stats Stats, rpc RPCStats) []Rule {
+	rules := []Rule{
+		&LagRule{Chain: chain, Indexer: indexer},
+		&ReorgRule{Chain: chain, Indexer: indexer},
+		&PriceDeviationRule{Chain: chain, Stats: stats},
+		&TVLDropRule{Chain: chain, Stats: stats},
+		&RefreshRule{Chain: chain, Stats: stats},
+		&QuarantineRule{Chain: chain, Stats: stats},
+	}
+	if rpc != nil {
+		rules = append(rules, &RPCFailureRule{Chain: chain, RPC: rpc})
+	}
+	return rules
+}
+
+// LagRule fires when no block was fetched for the block update timeout
+type LagRule struct {
+	Chain   string
+	Indexer Indexer
+}
+
+func (r *LagRule) Name() string {
+	return "block_lag"
+}
+
+func (r *LagRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	blockLog, err := r.Indexer.GetCurrentBlockLog()
+	if err != nil {
+		return nil, err
+	}
+	fetchedAt := time.Unix(blockLog.CreateTime, 0)
+	if blockLog.Height == 0 || now.Sub(fetchedAt) <= th.BlockUpdateTimeout {
+		return nil, nil
+	}
+	return []*Alert{{
+		Chain:    r.Chain,
+		Severity: SeverityCritical,
+		Message:  fmt.Sprintf("big lagger now, last block fetched at %s, height=%d", fetchedAt.String(), blockLog.Height),
+	}}, nil
+}
+
+// ReorgRule fires for a while after a reorg of the reorg depth or deeper
+type ReorgRule struct {
+	Chain   string
+	Indexer Indexer
+}
+
+func (r *ReorgRule) Name() string {
+	return "reorg"
+}
+
+func (r *ReorgRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	depth, at := r.Indexer.LastReorg()
+	if depth < th.ReorgDepth || now.Sub(at) > common.AlertReorgWindow {
+		return nil, nil
+	}
+	return []*Alert{{
+		Chain:    r.Chain,
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("reorg of %d blocks at %s", depth, at.String()),
+	}}, nil
+}
+
+// RPCFailureRule fires when the failed calls to the provider since the last check reach the rate,
+// it is critical when all calls failed
+type RPCFailureRule struct {
+	Chain string
+	RPC   RPCStats
+
+	calls, failures uint64
+}
+
+func (r *RPCFailureRule) Name() string {
+	return "rpc_failure"
+}
+
+func (r *RPCFailureRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	calls, failures := r.RPC.Stats()
+	deltaCalls, deltaFailures := calls-r.calls, failures-r.failures
+	r.calls, r.failures = calls, failures
+	if deltaCalls < common.AlertRPCMinCalls {
+		return nil, nil
+	}
+	rate := float64(deltaFailures) / float64(deltaCalls)
+	if rate < th.RPCFailureRate {
+		return nil, nil
+	}
+	severity := SeverityWarning
+	if deltaFailures == deltaCalls {
+		severity = SeverityCritical
+	}
+	return []*Alert{{
+		Chain:    r.Chain,
+		Severity: severity,
+		Message:  fmt.Sprintf("%d of %d provider calls failed", deltaFailures, deltaCalls),
+	}}, nil
+}
+
+// PriceDeviationRule fires for the tokens whose price changed more than the deviation in a refresh,
+// until a later refresh does not
+type PriceDeviationRule struct {
+	Chain string
+	Stats Stats
+
+	updateAt time.Time
+	prices   map[string]float64
+	alerts   []*Alert
+}
+
+func (r *PriceDeviationRule) Name() string {
+	return "price_deviation"
+}
+
+func (r *PriceDeviationRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	prices, updateAt := r.Stats.GetPrice()
+	if updateAt.Equal(r.updateAt) {
+		return r.alerts, nil
+	}
+	alerts := make([]*Alert, 0)
+	for token, price := range prices {
+		last, exist := r.prices[token]
+		if !exist || last <= 0 {
+			continue
+		}
+		if deviation := math.Abs(price-last) / last; deviation > th.PriceDeviation {
+			alerts = append(alerts, &Alert{
+				Chain:    r.Chain,
+				Subject:  token,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("price of %s moved %.1f%% from %g to %g", token, deviation*100, last, price),
+			})
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Subject < alerts[j].Subject })
+	r.updateAt, r.prices, r.alerts = updateAt, prices, alerts
+	return alerts, nil
+}
+
+type tvlSample struct {
+	at  time.Time
+	tvl float64
+}
+
+// TVLDropRule fires when the TVL dropped more than the rate from its high in the last hour
+type TVLDropRule struct {
+	Chain string
+	Stats Stats
+
+	samples []tvlSample
+}
+
+func (r *TVLDropRule) Name() string {
+	return "tvl_drop"
+}
+
+func (r *TVLDropRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	tvl, updateAt := r.Stats.GetTVL()
+	if updateAt.IsZero() {
+		return nil, nil
+	}
+	if len(r.samples) == 0 || !r.samples[len(r.samples)-1].at.Equal(updateAt) {
+		r.samples = append(r.samples, tvlSample{at: updateAt, tvl: tvl})
+	}
+	for len(r.samples) > 1 && now.Sub(r.samples[0].at) > common.AlertTVLWindow {
+		r.samples = r.samples[1:]
+	}
+	high := float64(0)
+	for _, sample := range r.samples {
+		high = math.Max(high, sample.tvl)
+	}
+	if high <= 0 || (high-tvl)/high < th.TVLDrop {
+		return nil, nil
+	}
+	return []*Alert{{
+		Chain:    r.Chain,
+		Severity: SeverityCritical,
+		Message:  fmt.Sprintf("tvl dropped %.1f%% from %.0f to %.0f USD", (high-tvl)/high*100, high, tvl),
+	}}, nil
+}
+
+// RefreshRule fires while the stats are stale because their refresh fails
+type RefreshRule struct {
+	Chain string
+	Stats Stats
+}
+
+func (r *RefreshRule) Name() string {
+	return "refresh_failure"
+}
+
+func (r *RefreshRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	if !r.Stats.Stale() {
+		return nil, nil
+	}
+	_, updateAt := r.Stats.GetPrice()
+	return []*Alert{{
+		Chain:    r.Chain,
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("refresh failed, serving the stats of %s", updateAt.String()),
+	}}, nil
+}
+
+// QuarantineRule fires for each token whose price is quarantined as suspicious
+type QuarantineRule struct {
+	Chain string
+	Stats Stats
+}
+
+func (r *QuarantineRule) Name() string {
+	return "price_quarantine"
+}
+
+func (r *QuarantineRule) Check(th Thresholds, now time.Time) ([]*Alert, error) {
+	quarantined := r.Stats.GetQuarantined()
+	alerts := make([]*Alert, 0, len(quarantined))
+	for symbol, reason := range quarantined {
+		alerts = append(alerts, &Alert{
+			Chain:    r.Chain,
+			Subject:  symbol,
+			Severity: SeverityCritical,
+			Message:  fmt.Sprintf("price of %s quarantined, failed %s, serving the last good price", symbol, reason),
+		})
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Subject < alerts[j].Subject })
+	return alerts, nil
+}
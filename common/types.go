@@ -4,11 +4,139 @@ import "time"
 
 const (
 	ObserverMaxBlockNumber = 10000
-	ObservceMaxTxNumber =  100000
+	ObservceMaxTxNumber    = 100000
 	ObserverPruneInterval  = 30 * time.Second
-	ObserverAlertInterval  = 100 * time.Second
 
 	RefreshInterval = 300 * time.Second
+
+	PairSnapshotInterval  = time.Hour
+	PairSnapshotRetention = 35 * 24 * time.Hour
+
+	DefaultReadyMaxBlockLag      = 100
+	DefaultReadyRefreshIntervals = 3
+	// ReadyCheckTimeout bounds the provider call of the readiness check of a chain, within the write timeout
+	ReadyCheckTimeout = time.Second
+
+	DefaultShutdownTimeout = 30 * time.Second
+
+	DefaultRetryMaxAttempts    = 5
+	DefaultRetryInitialBackoff = 200 * time.Millisecond
+	DefaultRetryMaxBackoff     = 10 * time.Second
+
+	// PairListRetryInterval is how soon the pair list is fetched again after a failure
+	PairListRetryInterval = 30 * time.Second
+	PairListInterval      = 1000 * time.Second
+
+	// ConfigWatchInterval is how often the config file is checked for changes
+	ConfigWatchInterval = 5 * time.Second
+)
+
+const (
+	// AlertCheckInterval is how often the alert rules are checked
+	AlertCheckInterval = time.Minute
+	// DefaultAlertCooldown is how long a firing alert is not notified again
+	DefaultAlertCooldown = time.Hour
+	// AlertNotifyTimeout bounds a notification to one channel
+	AlertNotifyTimeout = 10 * time.Second
+
+	DefaultAlertRPCFailureRate = 0.5
+	// AlertRPCMinCalls is the least calls in a check interval for the failure rate to count
+	AlertRPCMinCalls           = 10
+	DefaultAlertPriceDeviation = 0.2
+	DefaultAlertTVLDrop        = 0.3
+	// AlertTVLWindow is how far back the TVL is compared for a drop
+	AlertTVLWindow         = time.Hour
+	DefaultAlertReorgDepth = 3
+	// AlertReorgWindow is how long a deep reorg keeps the alert firing
+	AlertReorgWindow = time.Hour
+	// DefaultWhaleFlaggedRepeat events of a flagged address in DefaultWhaleFlaggedWindow are notified
+	DefaultWhaleFlaggedRepeat = 3
+	DefaultWhaleFlaggedWindow = time.Hour
+	// DefaultWhaleMaxEventAge is the block age beyond which an event is not notified, such as on a catch up
+	DefaultWhaleMaxEventAge = 10 * time.Minute
+
+	// DefaultPriceMaxJump is the change of a token price between two refreshes before it is quarantined
+	DefaultPriceMaxJump = 0.5
+	// DefaultPriceMaxTwapDeviation is the deviation of a token price from its twap before it is quarantined
+	DefaultPriceMaxTwapDeviation = 0.3
+	// DefaultPriceMaxCrossDeviation is the deviation of a token price from the price across its pairs
+	// before it is quarantined
+	DefaultPriceMaxCrossDeviation = 0.15
+	DefaultPriceTwapWindow        = time.Hour
+	// DefaultPriceConfirmRefreshes is how many refreshes in a row a suspicious price has to persist to be accepted
+	DefaultPriceConfirmRefreshes = 3
+
+	// DefaultWashRoundTripBlocks is within how many blocks a trader swapping a pair back is a round trip
+	DefaultWashRoundTripBlocks = 20
+	// DefaultWashMaxVolumeToLiquidity is the 24h volume of a pair against its reserves beyond which it is wash
+	DefaultWashMaxVolumeToLiquidity = 10.0
+
+	AlertNotifierTelegram = "telegram"
+	AlertNotifierWebhook  = "webhook"
+	AlertNotifierSlack    = "slack"
+	AlertNotifierEmail    = "email"
+)
+
+const (
+	// DefaultSwapFeeRate and DefaultProtocolFeeShare are the fee setting of uniswap v2
+	DefaultSwapFeeRate      = 0.003
+	DefaultProtocolFeeShare = 1.0 / 6
+)
+
+const (
+	// DefaultChainName tags the rows indexed before multi chain support and the legacy single chain config
+	DefaultChainName = "bsc"
+	// TokenBehaviourInterval is how often the listed pairs are checked for fee on transfer and rebasing tokens
+	TokenBehaviourInterval    = 10 * time.Minute
+	TokenBehaviourCallTimeout = 5 * time.Second
+	// TokenBehaviourMinFee is the least share missing from a transfer to detect a fee, below it is rounding
+	TokenBehaviourMinFee = 0.0001
+	// TokenBehaviourRebaseMoves is how many checks in a row the balance of a pair has to move without a Sync
+	// to detect a rebasing token, a single move is a donation
+	TokenBehaviourRebaseMoves = 3
+	// TokenBehaviourExpiry is how long a behaviour is kept without being detected again
+	TokenBehaviourExpiry = 7 * 24 * time.Hour
+	// TokenMetadataInterval is how often the tokens whose metadata could not be read are read again
+	TokenMetadataInterval = time.Hour
+	// LpEntryPriceMaxAge is how old a block may be for its lp transfers to be valued at the current prices,
+	// the transfers of older blocks, as in a backfill, have an unknown entry value
+	LpEntryPriceMaxAge = 10 * time.Minute
+	// SupplyInterval is how often the supplies of the priced tokens are read
+	SupplyInterval = 10 * time.Minute
+	// SupplyExcludedAll keys the holders excluded from the circulating supply of every token
+	SupplyExcludedAll = "*"
+	// DefaultSupplyHistoryDays and MaxSupplyHistoryDays bound the days of the supply history served
+	DefaultSupplyHistoryDays = 30
+	MaxSupplyHistoryDays     = 365
+	// DefaultTopHolders and MaxTopHolders bound the top holders served, the holder history has the supply bounds
+	DefaultTopHolders = 20
+	MaxTopHolders     = 100
+	// UserStatsInterval is how often the confirmed swaps are rolled up into the trader stats, UserRollupBatch
+	// swaps at a time
+	UserStatsInterval = 10 * time.Minute
+	UserRollupBatch   = 5000
+	// DefaultTradesLimit and MaxTradesLimit bound the transactions whose trades are served
+	DefaultTradesLimit = 50
+	MaxTradesLimit     = 500
+	// DefaultUserCohortWeeks and MaxUserCohortWeeks bound the weekly cohorts served
+	DefaultUserCohortWeeks = 12
+	MaxUserCohortWeeks     = 52
+	// DefaultTokenDecimals are the decimals of the tokens whose decimals can not be read
+	DefaultTokenDecimals = 18
+	// AllChains is the chain parameter of the api asking for the aggregate of all chains
+	AllChains = "all"
+
+	// DefaultProtocol is the protocol adapter of the factories without one configured
+	DefaultProtocol = "uniswap_v2"
+
+	DefaultProjectToken     = "Pie"
+	DefaultSyrupToken       = "0x0E09FaBB73Bd3Ade0a17ECC321fD13a19e81cE82"
+	DefaultSyrupTokenSymbol = "Cake"
+)
+
+var (
+	DefaultStableTokens = []string{"BUSD"}
+	DefaultBaseTokens   = []string{"WOKT", "BUSD"}
 )
 
 const (
@@ -0,0 +1,172 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// RetryClient is the ChainClient of a provider which retries the failed calls by its policy. It dials
+// on first use and again after a failed dial, so the service starts and serves the stale data while
+// the provider is down instead of crashing.
+type RetryClient struct {
+	provider string
+	policy   RetryPolicy
+
+	mux    sync.Mutex
+	client *ethclient.Client
+
+	// calls and failures count the attempts of the calls, updated atomically
+	calls    uint64
+	failures uint64
+}
+
+func NewRetryClient(provider string, policy RetryPolicy) *RetryClient {
+	return &RetryClient{
+		provider: provider,
+		policy:   policy,
+	}
+}
+
+func (c *RetryClient) dial(ctx context.Context) (*ethclient.Client, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client, err := ethclient.DialContext(ctx, c.provider)
+	if err != nil {
+		return nil, fmt.Errorf("dial provider error, err=%s", err.Error())
+	}
+	c.client = client
+	return client, nil
+}
+
+// do calls fn on the dialed client with retries
+func (c *RetryClient) do(ctx context.Context, op string, fn func(client *ethclient.Client) error) error {
+	return c.policy.Do(ctx, op, func() error {
+		atomic.AddUint64(&c.calls, 1)
+		client, err := c.dial(ctx)
+		if err == nil {
+			err = fn(client)
+		}
+		if err != nil && IsRetryable(err) {
+			atomic.AddUint64(&c.failures, 1)
+		}
+		return err
+	})
+}
+
+// Stats returns the number of attempts of the calls and the ones failed by the provider
+func (c *RetryClient) Stats() (calls, failures uint64) {
+	return atomic.LoadUint64(&c.calls), atomic.LoadUint64(&c.failures)
+}
+
+// Close closes the connection to the provider
+func (c *RetryClient) Close() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+func (c *RetryClient) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = c.do(ctx, "header by number", func(client *ethclient.Client) error {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (c *RetryClient) TransactionByHash(ctx context.Context, hash ethcmm.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = c.do(ctx, "transaction by hash", func(client *ethclient.Client) error {
+		tx, isPending, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (c *RetryClient) CodeAt(ctx context.Context, contract ethcmm.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = c.do(ctx, "code at", func(client *ethclient.Client) error {
+		code, err = client.CodeAt(ctx, contract, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (c *RetryClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) (data []byte, err error) {
+	err = c.do(ctx, "call contract", func(client *ethclient.Client) error {
+		data, err = client.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return data, err
+}
+
+func (c *RetryClient) PendingCodeAt(ctx context.Context, account ethcmm.Address) (code []byte, err error) {
+	err = c.do(ctx, "pending code at", func(client *ethclient.Client) error {
+		code, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+func (c *RetryClient) PendingNonceAt(ctx context.Context, account ethcmm.Address) (nonce uint64, err error) {
+	err = c.do(ctx, "pending nonce at", func(client *ethclient.Client) error {
+		nonce, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (c *RetryClient) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = c.do(ctx, "suggest gas price", func(client *ethclient.Client) error {
+		price, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+func (c *RetryClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error) {
+	err = c.do(ctx, "estimate gas", func(client *ethclient.Client) error {
+		gas, err = client.EstimateGas(ctx, call)
+		return err
+	})
+	return gas, err
+}
+
+// SendTransaction is not retried, a retry of a transaction which reached the node fails on its nonce
+func (c *RetryClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	client, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return client.SendTransaction(ctx, tx)
+}
+
+func (c *RetryClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = c.do(ctx, "filter logs", func(client *ethclient.Client) error {
+		logs, err = client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+func (c *RetryClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	err = c.do(ctx, "subscribe filter logs", func(client *ethclient.Client) error {
+		sub, err = client.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return sub, err
+}
@@ -2,111 +2,224 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcmm "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 
 	eabi "github.com/pieswap/pie-statas/abi"
 	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/model"
 	"github.com/pieswap/pie-statas/util"
 )
 
 type Executor interface {
 	GetBlockAndTxEvents(height int64) (*common.BlockAndEventLogs, error)
+	GetLatestHeight() (int64, error)
+	// ProbeLatestHeight reads the height of the chain head once within the context, for the readiness checks
+	ProbeLatestHeight(ctx context.Context) (int64, error)
 	GetPairList() []ethcmm.Address
+	// GetPairFactory returns the factory which created the pair
+	GetPairFactory(pair ethcmm.Address) (string, bool)
+	// GetPoolState reads the state of the pair with the protocol of its factory
+	GetPoolState(pair ethcmm.Address) (*PoolState, error)
+	// GetProtocolFeeOn returns whether the factory charges the protocol fee
+	GetProtocolFeeOn(factory string) (bool, error)
 }
 
-type DecimalQuerier interface {
+// ChainClient is the narrow reader of a chain the executor and the stat service work on, it is
+// *ethclient.Client against a node and the simulated backend or the fake client of simchain in tests
+type ChainClient interface {
+	bind.ContractBackend
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionByHash(ctx context.Context, hash ethcmm.Hash) (*types.Transaction, bool, error)
+}
+
+type InfoQuerier interface {
 	GetDecimals(addr ethcmm.Address) (uint8, uint8, error)
+	// GetTokenDecimals returns the decimals of the token
+	GetTokenDecimals(token ethcmm.Address) (uint8, error)
+	// GetTokenPrices returns the current USD prices of the tokens of the pair, if both are priced
+	GetTokenPrices(addr ethcmm.Address) (float64, float64, bool)
 }
 
 type ChainExecutor struct {
 	mux         sync.Mutex
+	Chain       string
 	PairList    []ethcmm.Address
-	SwapPairABI abi.ABI
-	Client      *ethclient.Client
-	Factory     string
+	pairFactory map[ethcmm.Address]string
+	Client      ChainClient
+	Factories   []string
+	// protocols are the adapters of the factories, keyed by the lower case factory address
+	protocols map[string]Protocol
+	// holderTokens are the tokens whose transfers are indexed for their holders
+	holderTokens map[ethcmm.Address]*eabi.Bep20Filterer
 
-	infoQuery DecimalQuerier
+	infoQuery InfoQuerier
+	wg        sync.WaitGroup
+	// pairListFailed is whether the last fetch of the pair list failed
+	pairListFailed bool
 }
 
-func NewExecutor(provider, factory string) *ChainExecutor {
-	swapPairAbi, err := abi.JSON(strings.NewReader(eabi.SwappairABI))
-	if err != nil {
-		panic("marshal abi error")
+// NewExecutor returns the executor of the chain reading from the client
+func NewExecutor(chainConfig *util.ChainConfig, client ChainClient) *ChainExecutor {
+	protocols := make(map[string]Protocol, len(chainConfig.SwapFactories))
+	for _, factory := range chainConfig.SwapFactories {
+		protocol, err := NewProtocol(chainConfig.ProtocolOf(factory), client)
+		if err != nil {
+			panic(fmt.Sprintf("init protocol of factory %s error, err=%s", factory, err.Error()))
+		}
+		protocols[strings.ToLower(factory)] = protocol
 	}
-	client, err := ethclient.Dial(provider)
-	if err != nil {
-		panic("new eth client error")
+	holderTokens := make(map[ethcmm.Address]*eabi.Bep20Filterer, len(chainConfig.HolderTokens))
+	for _, token := range chainConfig.HolderTokens {
+		filterer, err := eabi.NewBep20Filterer(ethcmm.HexToAddress(token), client)
+		if err != nil {
+			panic(fmt.Sprintf("init filterer of holder token %s error, err=%s", token, err.Error()))
+		}
+		holderTokens[ethcmm.HexToAddress(token)] = filterer
 	}
-	factoryIns, err := eabi.NewFactory(ethcmm.HexToAddress(factory), client)
-	if err != nil {
-		panic(err)
+	e := &ChainExecutor{
+		Chain:        chainConfig.Name,
+		Client:       client,
+		Factories:    chainConfig.SwapFactories,
+		protocols:    protocols,
+		holderTokens: holderTokens,
+		pairFactory:  make(map[ethcmm.Address]string, 0),
 	}
-	pairLength, err := factoryIns.AllPairsLength(nil)
+	e.refreshPairList()
+	return e
+}
+
+// refreshPairList fetches the pair list, the last one is kept when it fails
+func (e *ChainExecutor) refreshPairList() {
+	pairList, pairFactory, err := e.fetchPairList()
+	e.mux.Lock()
+	defer e.mux.Unlock()
 	if err != nil {
-		panic(err)
+		e.pairListFailed = true
+		util.Logger.Errorf("fetch pair list error, chain=%s, err=%s, keep %d pairs", e.Chain, err.Error(), len(e.PairList))
+		return
+	}
+	e.pairListFailed = false
+	e.PairList = pairList
+	e.pairFactory = pairFactory
+}
+
+// pairListInterval returns how long to wait before the pair list is fetched again
+func (e *ChainExecutor) pairListInterval() time.Duration {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.pairListFailed {
+		return common.PairListRetryInterval
 	}
-	pairList := make([]ethcmm.Address, 0, pairLength.Int64())
-	for i := int64(0); i < pairLength.Int64(); i++ {
-		pair, err := factoryIns.AllPairs(nil, big.NewInt(i))
+	return common.PairListInterval
+}
+
+// fetchPairList returns the pairs of all factories and the factory of each pair
+func (e *ChainExecutor) fetchPairList() ([]ethcmm.Address, map[ethcmm.Address]string, error) {
+	pairList := make([]ethcmm.Address, 0)
+	pairFactory := make(map[ethcmm.Address]string, 0)
+	for _, factory := range e.Factories {
+		factory = strings.ToLower(factory)
+		pools, err := e.protocols[factory].DiscoverPools(ethcmm.HexToAddress(factory))
 		if err != nil {
-			panic(err)
+			return nil, nil, err
+		}
+		for _, pair := range pools {
+			pairList = append(pairList, pair)
+			pairFactory[pair] = factory
 		}
-		pairList = append(pairList, pair)
-	}
-	return &ChainExecutor{
-		SwapPairABI: swapPairAbi,
-		Client:      client,
-		PairList:    pairList,
-		Factory:     factory,
 	}
+	return pairList, pairFactory, nil
 }
 
-func (e *ChainExecutor) SetInfoQuery(infoQuery DecimalQuerier) {
+func (e *ChainExecutor) SetInfoQuery(infoQuery InfoQuerier) {
 	e.infoQuery = infoQuery
 }
 
-func (e *ChainExecutor) Start() {
+// Start refreshes the pair list periodically until the context is done
+func (e *ChainExecutor) Start(ctx context.Context) {
+	e.wg.Add(1)
 	go func() {
-		for {
-			time.Sleep(1000 * time.Second)
-			factoryIns, err := eabi.NewFactory(ethcmm.HexToAddress(e.Factory), e.Client)
-			if err != nil {
-				panic(err)
-			}
-			pairLength, err := factoryIns.AllPairsLength(nil)
-			if err != nil {
-				panic(err)
-			}
-			pairList := make([]ethcmm.Address, 0, pairLength.Int64())
-			for i := int64(0); i < pairLength.Int64(); i++ {
-				pair, err := factoryIns.AllPairs(nil, big.NewInt(i))
-				if err != nil {
-					panic(err)
-				}
-				pairList = append(pairList, pair)
-			}
-			e.mux.Lock()
-			e.PairList = pairList
-			e.mux.Unlock()
+		defer e.wg.Done()
+		for util.Sleep(ctx, e.pairListInterval()) {
+			e.refreshPairList()
 		}
 	}()
 }
 
+// Wait waits for the refresh routine to stop
+func (e *ChainExecutor) Wait() {
+	e.wg.Wait()
+}
+
 func (e *ChainExecutor) GetPairList() []ethcmm.Address {
 	e.mux.Lock()
 	defer e.mux.Unlock()
 	return e.PairList
 }
 
+func (e *ChainExecutor) GetPairFactory(pair ethcmm.Address) (string, bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	factory, exist := e.pairFactory[pair]
+	return factory, exist
+}
+
+// protocolOf returns the adapter of the factory which created the pair
+func (e *ChainExecutor) protocolOf(pair ethcmm.Address) (Protocol, error) {
+	factory, exist := e.GetPairFactory(pair)
+	if !exist {
+		return nil, fmt.Errorf("factory of pair %s unknown", pair.String())
+	}
+	return e.protocols[factory], nil
+}
+
+func (e *ChainExecutor) GetPoolState(pair ethcmm.Address) (*PoolState, error) {
+	protocol, err := e.protocolOf(pair)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.ReadPoolState(pair)
+}
+
+func (e *ChainExecutor) GetProtocolFeeOn(factory string) (bool, error) {
+	protocol, exist := e.protocols[strings.ToLower(factory)]
+	if !exist {
+		return false, fmt.Errorf("factory %s not configured", factory)
+	}
+	return protocol.ProtocolFeeOn(ethcmm.HexToAddress(factory))
+}
+
+func (e *ChainExecutor) GetLatestHeight() (int64, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return e.latestHeight(ctxWithTimeout)
+}
+
+func (e *ChainExecutor) ProbeLatestHeight(ctx context.Context) (int64, error) {
+	return e.latestHeight(WithoutRetry(ctx))
+}
+
+func (e *ChainExecutor) latestHeight(ctx context.Context) (int64, error) {
+	header, err := e.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, ethereum.NotFound
+	}
+	return header.Number.Int64(), nil
+}
+
 func (e *ChainExecutor) GetBlockAndTxEvents(height int64) (*common.BlockAndEventLogs, error) {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -115,11 +228,20 @@ func (e *ChainExecutor) GetBlockAndTxEvents(height int64) (*common.BlockAndEvent
 	if err != nil {
 		return nil, err
 	}
+	// some clients return no header without an error for blocks not mined yet
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
 
 	packageLogs, err := e.GetLogs(header)
 	if err != nil {
 		return nil, err
 	}
+	transferLogs, err := e.GetTokenTransferLogs(header)
+	if err != nil {
+		return nil, err
+	}
+	packageLogs = append(packageLogs, transferLogs...)
 
 	return &common.BlockAndEventLogs{
 		Height:          height,
@@ -130,8 +252,34 @@ func (e *ChainExecutor) GetBlockAndTxEvents(height int64) (*common.BlockAndEvent
 	}, nil
 }
 
+// topics returns the event topics of all protocols of the chain
+func (e *ChainExecutor) topics() []ethcmm.Hash {
+	seen := make(map[ethcmm.Hash]bool, 0)
+	topics := make([]ethcmm.Hash, 0)
+	for _, factory := range e.Factories {
+		for _, topic := range e.protocols[strings.ToLower(factory)].Topics() {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
 func (e *ChainExecutor) GetLogs(header *types.Header) ([]interface{}, error) {
-	topics := [][]ethcmm.Hash{{SwapEventHash}}
+	pairList := e.GetPairList()
+	// no address in the filter matches the logs of every contract
+	if len(pairList) == 0 {
+		e.mux.Lock()
+		failed := e.pairListFailed
+		e.mux.Unlock()
+		if failed {
+			return nil, fmt.Errorf("pair list of chain %s not fetched yet", e.Chain)
+		}
+		return []interface{}{}, nil
+	}
+	topics := [][]ethcmm.Hash{e.topics()}
 
 	blockHash := header.Hash()
 
@@ -140,33 +288,221 @@ func (e *ChainExecutor) GetLogs(header *types.Header) ([]interface{}, error) {
 	logs, err := e.Client.FilterLogs(ctxWithTimeout, ethereum.FilterQuery{
 		BlockHash: &blockHash,
 		Topics:    topics,
-		Addresses: e.GetPairList(),
+		Addresses: pairList,
 	})
 	if err != nil {
 		return nil, err
 	}
 	eventModels := make([]interface{}, 0)
-	for _, log := range logs {
-		event, err := ParseSwapEvent(&e.SwapPairABI, &log)
+	origins := make(map[ethcmm.Hash]string, 0)
+	// a Mint follows the lp transfer from the zero address it mints in the tx, the protocol fee is
+	// minted before, so the last such transfer of the pair in the tx is the one the Mint values
+	mintTransfers := make(map[lpMint]*model.LpTransferLog, 0)
+	minted := make(map[*model.LpTransferLog]bool, 0)
+	lpTransfers := make([]*model.LpTransferLog, 0)
+	for idx := range logs {
+		log := &logs[idx]
+		util.Logger.Infof("get log: %d, %s, %s", log.BlockNumber, log.Topics[0].String(), log.TxHash.String())
+		protocol, err := e.protocolOf(log.Address)
+		if err != nil {
+			util.Logger.Errorf("protocol of log not found, err=%s", err.Error())
+			continue
+		}
+		eventModel, err := protocol.DecodeLog(log, e.infoQuery)
 		if err != nil {
-			util.Logger.Errorf("parse event log error, er=%s", err.Error())
+			util.Logger.Errorf("decode event log error, protocol=%s, height=%d, tx=%s, err=%s",
+				protocol.Name(), log.BlockNumber, log.TxHash.String(), err.Error())
 			continue
 		}
+		switch eventModel := eventModel.(type) {
+		case *model.TxEventLog:
+			eventModel.BlockTime = int64(header.Time)
+			if eventModel.Origin, err = e.cachedTxOrigin(origins, log.TxHash); err != nil {
+				return nil, err
+			}
+		case *model.LiquidityEventLog:
+			eventModel.BlockTime = int64(header.Time)
+			if eventModel.Origin, err = e.cachedTxOrigin(origins, log.TxHash); err != nil {
+				return nil, err
+			}
+			key := lpMint{tx: log.TxHash, pair: log.Address}
+			if transfer, exist := mintTransfers[key]; exist && eventModel.EventType == model.LiquidityEventMint {
+				transfer.Amount0, transfer.Amount1 = eventModel.Amount0, eventModel.Amount1
+				minted[transfer] = true
+				delete(mintTransfers, key)
+			}
+		case *model.LpTransferLog:
+			eventModel.BlockTime = int64(header.Time)
+			if isZeroAddress(eventModel.FromAddress) && !isZeroAddress(eventModel.ToAddress) {
+				mintTransfers[lpMint{tx: log.TxHash, pair: log.Address}] = eventModel
+			}
+			lpTransfers = append(lpTransfers, eventModel)
+		default:
+			continue
+		}
+		eventModels = append(eventModels, eventModel)
+	}
+	if err := e.valueLpTransfers(header, lpTransfers, minted); err != nil {
+		return nil, err
+	}
+	return eventModels, nil
+}
 
-		if event == nil {
+// lpMint keys the lp transfers minting to a wallet by their tx and pair
+type lpMint struct {
+	tx   ethcmm.Hash
+	pair ethcmm.Address
+}
+
+func isZeroAddress(address string) bool {
+	return ethcmm.HexToAddress(address) == ethcmm.Address{}
+}
+
+// valueLpTransfers values the lp transfers at their block. A transfer minted by a Mint is worth the
+// amounts of the Mint, the others the share of the reserves at the end of the block, and the USD value
+// needs the prices to be current for the block. The transfers which cannot be valued are marked
+// EntryUnknown rather than left at zero.
+func (e *ChainExecutor) valueLpTransfers(header *types.Header, transfers []*model.LpTransferLog, minted map[*model.LpTransferLog]bool) error {
+	type reserves struct {
+		amount0, amount1 float64
+		exist            bool
+	}
+	// the lp amounts of one lp token of the pairs at the end of the block
+	perLp := make(map[ethcmm.Address]reserves, 0)
+	recent := time.Since(time.Unix(int64(header.Time), 0)) <= common.LpEntryPriceMaxAge
+	for _, transfer := range transfers {
+		pair := ethcmm.HexToAddress(transfer.ContractAddress)
+		if !minted[transfer] {
+			state, exist := perLp[pair]
+			if !exist {
+				amount0, amount1, err := e.lpUnderlyingAt(pair, header.Number.Uint64())
+				if err != nil && IsRetryable(err) {
+					return err
+				}
+				if err != nil {
+					util.Logger.Warningf("lp transfers of pair %s at height %d not valued, err=%s", pair.String(), header.Number.Uint64(), err.Error())
+				}
+				state = reserves{amount0: amount0, amount1: amount1, exist: err == nil}
+				perLp[pair] = state
+			}
+			if !state.exist {
+				transfer.EntryUnknown = true
+				continue
+			}
+			transfer.Amount0 = transfer.Value * state.amount0
+			transfer.Amount1 = transfer.Value * state.amount1
+		}
+		price0, price1, priced := e.infoQuery.GetTokenPrices(pair)
+		if !recent || !priced {
+			transfer.EntryUnknown = true
 			continue
 		}
+		transfer.ValueUSD = transfer.Amount0*price0 + transfer.Amount1*price1
+	}
+	return nil
+}
 
-		util.Logger.Infof("get log: %d, %s, %s", log.BlockNumber, log.Topics[0].String(), log.TxHash.String())
-		d0, d1, err := e.infoQuery.GetDecimals(event.Contract)
+// lpUnderlyingAt returns the token amounts of one lp token of the pair at the end of the block at the height
+func (e *ChainExecutor) lpUnderlyingAt(pair ethcmm.Address, height uint64) (float64, float64, error) {
+	protocol, err := e.protocolOf(pair)
+	if err != nil {
+		return 0, 0, Permanent(err)
+	}
+	decimal0, decimal1, err := e.infoQuery.GetDecimals(pair)
+	if err != nil {
+		return 0, 0, Permanent(err)
+	}
+	reserves, lpSupply, err := protocol.ReadReservesAt(pair, height)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lpSupply.Sign() == 0 {
+		return 0, 0, Permanent(fmt.Errorf("no lp supply"))
+	}
+	supply := new(big.Float).Quo(new(big.Float).SetInt(lpSupply), defaultDecimal)
+	amount0, _ := new(big.Float).Quo(new(big.Float).Quo(new(big.Float).SetInt(reserves[0]), decimalsFactor(decimal0)), supply).Float64()
+	amount1, _ := new(big.Float).Quo(new(big.Float).Quo(new(big.Float).SetInt(reserves[1]), decimalsFactor(decimal1)), supply).Float64()
+	return amount0, amount1, nil
+}
+
+// cachedTxOrigin returns the origin of the transaction, the events of a transaction share one lookup
+func (e *ChainExecutor) cachedTxOrigin(origins map[ethcmm.Hash]string, txHash ethcmm.Hash) (string, error) {
+	if origin, exist := origins[txHash]; exist {
+		return origin, nil
+	}
+	origin, err := e.txOrigin(txHash)
+	if err != nil {
+		return "", err
+	}
+	origins[txHash] = origin
+	return origin, nil
+}
+
+// txOrigin returns the account which sent the transaction. A provider behind the one serving the logs
+// may not know the transaction yet, which fails the block to be fetched again.
+func (e *ChainExecutor) txOrigin(txHash ethcmm.Hash) (string, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, _, err := e.Client.TransactionByHash(ctxWithTimeout, txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return "", fmt.Errorf("tx %s of chain %s not found yet", txHash.String(), e.Chain)
+	}
+	if err != nil {
+		return "", err
+	}
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.NewEIP155Signer(tx.ChainId())
+	}
+	origin, err := types.Sender(signer, tx)
+	if err != nil {
+		util.Logger.Errorf("recover sender of tx error, chain=%s, tx=%s, err=%s", e.Chain, txHash.String(), err.Error())
+		return "", nil
+	}
+	return origin.String(), nil
+}
+
+// GetTokenTransferLogs returns the Transfer events of the holder tokens in the block
+func (e *ChainExecutor) GetTokenTransferLogs(header *types.Header) ([]interface{}, error) {
+	if len(e.holderTokens) == 0 {
+		return []interface{}{}, nil
+	}
+	tokens := make([]ethcmm.Address, 0, len(e.holderTokens))
+	for token := range e.holderTokens {
+		tokens = append(tokens, token)
+	}
+	blockHash := header.Hash()
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	logs, err := e.Client.FilterLogs(ctxWithTimeout, ethereum.FilterQuery{
+		BlockHash: &blockHash,
+		Topics:    [][]ethcmm.Hash{{TransferEventHash}},
+		Addresses: tokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	eventModels := make([]interface{}, 0)
+	for idx := range logs {
+		log := &logs[idx]
+		filterer, exist := e.holderTokens[log.Address]
+		if !exist || len(log.Topics) < 3 {
+			continue
+		}
+		event, err := filterer.ParseTransfer(*log)
 		if err != nil {
-			util.Logger.Error("Decimal can not found log: %d, %s, %s", log.BlockNumber, log.Topics[0].String(), log.TxHash.String())
+			util.Logger.Errorf("decode token transfer error, height=%d, tx=%s, err=%s", log.BlockNumber, log.TxHash.String(), err.Error())
 			continue
 		}
-		eventModel := event.ToTxLog(&log, d0, d1)
+		// the decimals are read once and kept, a failed read fails the block to be fetched again
+		decimals, err := e.infoQuery.GetTokenDecimals(log.Address)
+		if err != nil {
+			return nil, err
+		}
+		eventModel := ToTokenTransferLog(event, log, decimals)
 		eventModel.BlockTime = int64(header.Time)
 		eventModels = append(eventModels, eventModel)
 	}
 	return eventModels, nil
 }
-
@@ -2,35 +2,202 @@ package executor
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-
-	"github.com/stretchr/testify/assert"
+	"errors"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	eabi "github.com/pieswap/pie-statas/abi"
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/simchain"
+	"github.com/pieswap/pie-statas/util"
 )
 
 func TestParseSwapEvent(t *testing.T) {
-	client, err := ethclient.Dial("wss://bsc-ws-node.nariox.org:443")
-	assert.NoError(t, err)
-	r, err := client.TransactionReceipt(context.Background(), common.HexToHash("0xb3dfe9a97d08481faa5d3e5afad2d502383d69ced2360fbae87f69206dea0a83"))
-	assert.NoError(t, err)
-	swapLog := r.Logs[4]
+	chain, err := simchain.New()
+	require.NoError(t, err)
+	defer chain.Close()
+
+	factory, err := chain.DeployFactory(ethcmm.Address{})
+	require.NoError(t, err)
+	pair, err := chain.DeployPair(factory, ethcmm.Address{0x1}, ethcmm.Address{0x2})
+	require.NoError(t, err)
+	to := ethcmm.HexToAddress("0x00000000000000000000000000000000000000aa")
+	// 1.5 token0 in for 3 token1 out, token1 has 6 decimals
+	require.NoError(t, chain.Swap(pair, chain.From, to, big.NewInt(15e17), big.NewInt(0), big.NewInt(0), big.NewInt(3e6)))
+	chain.Commit()
+
+	logs, err := chain.Backend.FilterLogs(context.Background(), ethereum.FilterQuery{
+		Addresses: []ethcmm.Address{pair},
+		Topics:    [][]ethcmm.Hash{{SwapEventHash}},
+	})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	swapLog := &logs[0]
+
 	swapPairAbi, err := abi.JSON(strings.NewReader(eabi.SwappairABI))
-	if err != nil {
-		panic("marshal abi error")
-	}
+	require.NoError(t, err)
 	swapEvent, err := ParseSwapEvent(&swapPairAbi, swapLog)
-	assert.NoError(t, err)
-	bz, err := json.Marshal(swapEvent)
-	fmt.Println(string(bz))
+	require.NoError(t, err)
+	assert.Equal(t, chain.From, swapEvent.Sender)
+	assert.Equal(t, to, swapEvent.To)
+	assert.Equal(t, pair, swapEvent.Contract)
+	assert.Equal(t, big.NewInt(15e17), swapEvent.Amount0In)
+	assert.Equal(t, big.NewInt(3e6), swapEvent.Amount1Out)
+
+	eventModel := swapEvent.ToTxLog(swapLog, 18, 6)
+	assert.Equal(t, 1.5, eventModel.Amount0)
+	assert.Equal(t, float64(3), eventModel.Amount1)
+	assert.Equal(t, int64(swapLog.BlockNumber), eventModel.Height)
+	assert.Equal(t, swapLog.TxHash.String(), eventModel.TxHash)
+}
+
+func TestExecutorWithoutProvider(t *testing.T) {
+	client := simchain.NewFakeClient()
+	factory, err := client.DeployFactory(ethcmm.Address{})
+	require.NoError(t, err)
+	pair, err := client.DeployPair(factory, ethcmm.Address{0x1}, ethcmm.Address{0x2})
+	require.NoError(t, err)
+	client.Commit()
+
+	client.Fail(errors.New("connection refused"))
+	chainConfig := &util.ChainConfig{Name: "test", SwapFactories: []string{factory.Address.String()}}
+	e := NewExecutor(chainConfig, client)
+	require.Empty(t, e.GetPairList())
+	require.Equal(t, common.PairListRetryInterval, e.pairListInterval())
+
+	// the block is not fetched without the pair list, or the events of the pairs would be missed
+	client.Fail(nil)
+	_, err = e.GetBlockAndTxEvents(1)
+	require.Error(t, err)
+
+	e.refreshPairList()
+	require.Equal(t, []ethcmm.Address{pair}, e.GetPairList())
+	require.Equal(t, common.PairListInterval, e.pairListInterval())
+	blockAndEventLogs, err := e.GetBlockAndTxEvents(1)
+	require.NoError(t, err)
+	require.Empty(t, blockAndEventLogs.Events)
+}
+
+type testInfoQuery struct{}
+
+func (testInfoQuery) GetDecimals(addr ethcmm.Address) (uint8, uint8, error) { return 18, 18, nil }
+
+func (testInfoQuery) GetTokenDecimals(token ethcmm.Address) (uint8, error) { return 18, nil }
+
+func (testInfoQuery) GetTokenPrices(addr ethcmm.Address) (float64, float64, bool) {
+	return 0, 0, false
+}
+
+func TestSwapOriginNotFound(t *testing.T) {
+	client := simchain.NewFakeClient()
+	factory, err := client.DeployFactory(ethcmm.Address{})
+	require.NoError(t, err)
+	pair, err := client.DeployPair(factory, ethcmm.Address{0x1}, ethcmm.Address{0x2})
+	require.NoError(t, err)
+	client.Commit()
+	user := ethcmm.Address{0x3}
+	require.NoError(t, client.Swap(pair, user, user, big.NewInt(0), big.NewInt(100), big.NewInt(90), big.NewInt(0)))
+	client.Commit()
+
+	chainConfig := &util.ChainConfig{Name: "test", SwapFactories: []string{factory.Address.String()}}
+	e := NewExecutor(chainConfig, client)
+	e.SetInfoQuery(testInfoQuery{})
+	// the fake provider does not know the transaction of the swap, the block is fetched again rather
+	// than indexing the swap without its origin
+	_, err = e.GetBlockAndTxEvents(2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not found yet")
+	require.True(t, IsRetryable(err))
+}
+
+// pricedInfoQuery prices token0 at 20 USD and token1 at 1 USD
+type pricedInfoQuery struct {
+	testInfoQuery
+}
+
+func (pricedInfoQuery) GetTokenPrices(addr ethcmm.Address) (float64, float64, bool) {
+	return 20, 1, true
+}
+
+func lpTransfers(t *testing.T, e *ChainExecutor, height int64) []*model.LpTransferLog {
+	blockAndEventLogs, err := e.GetBlockAndTxEvents(height)
+	require.NoError(t, err)
+	transfers := make([]*model.LpTransferLog, 0)
+	for _, event := range blockAndEventLogs.Events {
+		if transfer, ok := event.(*model.LpTransferLog); ok {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+func TestLpTransferValue(t *testing.T) {
+	units := func(amount int64) *big.Int { return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18)) }
+	client := simchain.NewFakeClient()
+	client.KnowTransactions()
+	factory, err := client.DeployFactory(ethcmm.Address{})
+	require.NoError(t, err)
+	pair, err := client.DeployPair(factory, ethcmm.Address{0x1}, ethcmm.Address{0x2})
+	require.NoError(t, err)
+	// one lp token is worth 1 token0 and 20 token1 at the block
+	require.NoError(t, client.SetPairState(pair, units(1000), units(20000), units(1000)))
+	client.Commit()
+
+	feeTo, user, other := ethcmm.Address{0x3}, ethcmm.Address{0x4}, ethcmm.Address{0x5}
+	require.NoError(t, client.Tx(func() error {
+		if err := client.LpTransfer(pair, ethcmm.Address{}, feeTo, units(1)); err != nil {
+			return err
+		}
+		if err := client.LpTransfer(pair, ethcmm.Address{}, user, units(10)); err != nil {
+			return err
+		}
+		return client.Mint(pair, user, units(2), units(50))
+	}))
+	require.NoError(t, client.LpTransfer(pair, user, other, units(5)))
+	client.CommitAt(uint64(time.Now().Unix()))
+
+	chainConfig := &util.ChainConfig{Name: "test", SwapFactories: []string{factory.Address.String()}}
+	e := NewExecutor(chainConfig, client)
+	e.SetInfoQuery(pricedInfoQuery{})
+	blockAndEventLogs, err := e.GetBlockAndTxEvents(2)
+	require.NoError(t, err)
+	// the mint is of the liquidity provider sending the tx
+	mint := blockAndEventLogs.Events[2].(*model.LiquidityEventLog)
+	require.Equal(t, client.From.String(), mint.Origin)
+	transfers := lpTransfers(t, e, 2)
+	require.Len(t, transfers, 3)
+	// the protocol fee and the transfer are valued by the reserves at the block, the mint by its Mint
+	for i, expected := range []struct{ amount0, amount1, valueUSD float64 }{{1, 20, 40}, {2, 50, 90}, {5, 100, 200}} {
+		require.False(t, transfers[i].EntryUnknown)
+		require.Equal(t, expected.amount0, transfers[i].Amount0)
+		require.Equal(t, expected.amount1, transfers[i].Amount1)
+		require.Equal(t, expected.valueUSD, transfers[i].ValueUSD)
+	}
+
+	// a backfilled block is not valued at the current prices
+	require.NoError(t, client.LpTransfer(pair, user, other, units(5)))
+	client.CommitAt(uint64(time.Now().Add(-time.Hour).Unix()))
+	transfers = lpTransfers(t, e, 3)
+	require.Len(t, transfers, 1)
+	require.True(t, transfers[0].EntryUnknown)
+	require.Equal(t, 5.0, transfers[0].Amount0)
+	require.Zero(t, transfers[0].ValueUSD)
 
-	eventModel := swapEvent.ToTxLog(swapLog)
-	bz, err = json.Marshal(eventModel)
-	fmt.Println(string(bz))
+	// nor is a pair without lp supply at the block
+	require.NoError(t, client.SetPairState(pair, big.NewInt(0), big.NewInt(0), big.NewInt(0)))
+	require.NoError(t, client.LpTransfer(pair, user, other, units(5)))
+	client.CommitAt(uint64(time.Now().Unix()))
+	transfers = lpTransfers(t, e, 4)
+	require.Len(t, transfers, 1)
+	require.True(t, transfers[0].EntryUnknown)
+	require.Zero(t, transfers[0].Amount0)
 }
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	ProtocolUniswapV2 = common.DefaultProtocol
+)
+
+// PoolState is the on chain state of a pool, the amounts are raw token units
+type PoolState struct {
+	Tokens   []ethcmm.Address
+	Reserves []*big.Int
+	LpSupply *big.Int
+	// ProtocolFeeAccrues is whether the pool mints the protocol fee once the fee is on for its factory
+	ProtocolFeeAccrues bool
+}
+
+// Protocol is the adapter of an AMM design. It decodes the pool events into the normalized records
+// of the model, which are *model.TxEventLog for trades, *model.LiquidityEventLog for mints and
+// burns and *model.LpTransferLog for lp token transfers, so every design shares the same tables.
+type Protocol interface {
+	Name() string
+	// Topics returns the topics of the pool events the protocol decodes
+	Topics() []ethcmm.Hash
+	// DiscoverPools returns the pools created by the factory
+	DiscoverPools(factory ethcmm.Address) ([]ethcmm.Address, error)
+	// DecodeLog decodes a pool event into a normalized record, it returns nil for events it skips
+	DecodeLog(log *types.Log, infoQuery InfoQuerier) (interface{}, error)
+	// ReadPoolState reads the tokens, reserves and lp supply of the pool
+	ReadPoolState(pool ethcmm.Address) (*PoolState, error)
+	// ReadReservesAt reads the reserves and the lp supply of the pool at the end of the block at the height
+	ReadReservesAt(pool ethcmm.Address, height uint64) ([]*big.Int, *big.Int, error)
+	// ProtocolFeeOn returns whether the factory charges the protocol fee
+	ProtocolFeeOn(factory ethcmm.Address) (bool, error)
+}
+
+// ProtocolCreator creates the adapter of a protocol on the client of a chain
+type ProtocolCreator func(client ChainClient) (Protocol, error)
+
+var protocolCreators = map[string]ProtocolCreator{
+	ProtocolUniswapV2: NewUniswapV2Protocol,
+}
+
+// RegisterProtocol registers the adapter of a protocol, so factories can select it by name in config
+func RegisterProtocol(name string, creator ProtocolCreator) {
+	protocolCreators[strings.ToLower(name)] = creator
+	util.RegisterProtocolName(name)
+}
+
+// NewProtocol creates the adapter of the named protocol
+func NewProtocol(name string, client ChainClient) (Protocol, error) {
+	creator, exist := protocolCreators[strings.ToLower(name)]
+	if !exist {
+		return nil, fmt.Errorf("unknown protocol %s", name)
+	}
+	return creator(client)
+}
+
+// ProtocolNames returns the names of the registered protocols
+func ProtocolNames() []string {
+	names := make([]string, 0, len(protocolCreators))
+	for name := range protocolCreators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
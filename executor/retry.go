@@ -0,0 +1,138 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	backoffMultiplier = 2
+	// backoffJitter is the fraction of a backoff randomized, so clients do not retry in lockstep
+	backoffJitter = 0.2
+)
+
+// RetryPolicy retries the failed chain calls with exponential backoff
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func NewRetryPolicy(cfg util.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.InitialBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.MaxBackoff) * time.Millisecond,
+	}
+}
+
+// permanentError is an error which fails the same way however many times it is retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error as not retryable
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// permanentMessages are the errors of the node which are answers rather than failures
+var permanentMessages = []string{
+	"execution reverted",
+	"invalid opcode",
+	"invalid jump",
+	"out of gas",
+	"abi:",
+	"method not found",
+	"invalid argument",
+	"exceed maximum block range",
+	// the state of the height was pruned by a node which is not an archive node
+	"missing trie node",
+}
+
+// IsRetryable classifies the error of a chain call, errors of the connection and the node being
+// busy are retried, while reverts, decoding errors and missing data are not
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ethereum.NotFound) || errors.Is(err, bind.ErrNoCode) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permanentMsg := range permanentMessages {
+		if strings.Contains(msg, permanentMsg) {
+			return false
+		}
+	}
+	return true
+}
+
+// backoff returns the wait before the attempt after the given one, which counts from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= backoffMultiplier
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	jitter := (rand.Float64()*2 - 1) * backoffJitter * float64(backoff)
+	return backoff + time.Duration(jitter)
+}
+
+type noRetryKey struct{}
+
+// WithoutRetry returns the context whose calls are attempted once, for the callers which answer in time
+// rather than wait for the provider
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// Do calls fn until it succeeds, fails with an error not retryable, runs out of attempts or the
+// context is done, the last error is returned
+func (p RetryPolicy) Do(ctx context.Context, op string, fn func() error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	maxAttempts := p.MaxAttempts
+	if ctx.Value(noRetryKey{}) != nil {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !IsRetryable(err) || attempt >= maxAttempts {
+			return err
+		}
+		backoff := p.backoff(attempt)
+		util.Logger.Warningf("%s failed, attempt=%d, retry in %s, err=%s", op, attempt, backoff.String(), err.Error())
+		if !util.Sleep(ctx, backoff) {
+			return err
+		}
+	}
+}
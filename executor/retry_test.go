@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{errors.New("dial tcp 127.0.0.1:8545: connect: connection refused"), true},
+		{errors.New("429 Too Many Requests"), true},
+		{errors.New("EOF"), true},
+		{errors.New("execution reverted"), false},
+		{errors.New("abi: attempting to unmarshall an empty string while arguments are expected"), false},
+		{ethereum.NotFound, false},
+		{bind.ErrNoCode, false},
+		{context.Canceled, false},
+		{fmt.Errorf("call timeout: %w", context.DeadlineExceeded), false},
+		{Permanent(errors.New("connection refused")), false},
+	}
+	for _, testCase := range testCases {
+		require.Equal(t, testCase.retryable, IsRetryable(testCase.err), "%v", testCase.err)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	errDown := errors.New("connection refused")
+
+	testCases := []struct {
+		name     string
+		errs     []error
+		err      error
+		attempts int
+	}{
+		{"success", nil, nil, 1},
+		{"success after retries", []error{errDown, errDown}, nil, 3},
+		{"out of attempts", []error{errDown, errDown, errDown, errDown}, errDown, 3},
+		{"permanent error", []error{bind.ErrNoCode}, bind.ErrNoCode, 1},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			attempts := 0
+			err := policy.Do(context.Background(), "test", func() error {
+				attempts++
+				if attempts <= len(testCase.errs) {
+					return testCase.errs[attempts-1]
+				}
+				return nil
+			})
+			require.Equal(t, testCase.err, err)
+			require.Equal(t, testCase.attempts, attempts)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts := 0
+	err := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Hour, MaxBackoff: time.Hour}.Do(ctx, "test", func() error {
+		attempts++
+		return errDown
+	})
+	require.Equal(t, errDown, err)
+	require.Equal(t, 1, attempts)
+
+	// the calls of a readiness check are not retried
+	attempts = 0
+	err = policy.Do(WithoutRetry(context.Background()), "test", func() error {
+		attempts++
+		return errDown
+	})
+	require.Equal(t, errDown, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, expected := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond, 5: time.Second, 9: time.Second} {
+		backoff := policy.backoff(attempt)
+		require.True(t, backoff >= time.Duration(float64(expected)*(1-backoffJitter)) &&
+			backoff <= time.Duration(float64(expected)*(1+backoffJitter)), "attempt %d backoff %s", attempt, backoff)
+	}
+}
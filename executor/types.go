@@ -8,16 +8,28 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 
-	"github.com/pancakeswap/pancake-statas/model"
+	eabi "github.com/pieswap/pie-statas/abi"
+	"github.com/pieswap/pie-statas/model"
 )
 
 var (
-	SwapEventName = "Swap"
-	SwapEventHash = common.HexToHash("0xd78ad95fa46c994b6551d0da85fc275fe613ce37657fb8d5e3d130840159d822")
+	SwapEventName     = "Swap"
+	SwapEventHash     = common.HexToHash("0xd78ad95fa46c994b6551d0da85fc275fe613ce37657fb8d5e3d130840159d822")
+	MintEventName     = "Mint"
+	MintEventHash     = common.HexToHash("0x4c209b5fc8ad50758f13e2e1088ba56a560dff690a1c6fef26394f4c03821c4f")
+	BurnEventName     = "Burn"
+	BurnEventHash     = common.HexToHash("0xdccd412f0b1252819cb1fd330b93224ca42612892bb3f4f789976e6d81936496")
+	TransferEventName = "Transfer"
+	TransferEventHash = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
 
 	defaultDecimal = new(big.Float).SetInt64(1e18)
 )
 
+// decimalsFactor returns 10^decimals, the raw units of one token
+func decimalsFactor(decimals uint8) *big.Float {
+	return new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimals))))
+}
+
 type SwapEvent struct {
 	Contract   common.Address
 	Sender     common.Address
@@ -33,7 +45,6 @@ func (ev *SwapEvent) ToTxLog(log *types.Log, decimal0, decimal1 uint8) *model.Tx
 	d0 := new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal0))))
 	d1 := new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal1))))
 
-
 	if ev.Amount0In.Cmp(ev.Amount0Out) > 0 {
 		amount0, _ = new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Sub(ev.Amount0In, ev.Amount0Out)), d0).Float64()
 	} else {
@@ -44,10 +55,15 @@ func (ev *SwapEvent) ToTxLog(log *types.Log, decimal0, decimal1 uint8) *model.Tx
 	} else {
 		amount1, _ = new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Sub(ev.Amount1Out, ev.Amount1In)), d1).Float64()
 	}
+	logIndex := log.Index
 	pack := &model.TxEventLog{
 		ContractAddress: ev.Contract.String(),
 		Amount0:         amount0,
 		Amount1:         amount1,
+		Sender:          ev.Sender.String(),
+		To:              ev.To.String(),
+		LogIndex:        &logIndex,
+		Token0In:        ev.Amount0In.Cmp(ev.Amount0Out) > 0,
 		BlockHash:       log.BlockHash.Hex(),
 		TxHash:          log.TxHash.String(),
 		Height:          int64(log.BlockNumber),
@@ -70,3 +86,118 @@ func ParseSwapEvent(abi *abi.ABI, log *types.Log) (*SwapEvent, error) {
 	return &ev, nil
 }
 
+// LiquidityEvent is a Mint or Burn event of a swap pair, To is only set for Burn
+type LiquidityEvent struct {
+	Contract  common.Address
+	EventType model.LiquidityEventType
+	Sender    common.Address
+	To        common.Address
+	Amount0   *big.Int
+	Amount1   *big.Int
+}
+
+func (ev *LiquidityEvent) ToLiquidityLog(log *types.Log, decimal0, decimal1 uint8) *model.LiquidityEventLog {
+	d0 := new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal0))))
+	d1 := new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal1))))
+
+	amount0, _ := new(big.Float).Quo(new(big.Float).SetInt(ev.Amount0), d0).Float64()
+	amount1, _ := new(big.Float).Quo(new(big.Float).SetInt(ev.Amount1), d1).Float64()
+	pack := &model.LiquidityEventLog{
+		ContractAddress: ev.Contract.String(),
+		EventType:       ev.EventType,
+		Sender:          ev.Sender.String(),
+		Amount0:         amount0,
+		Amount1:         amount1,
+		BlockHash:       log.BlockHash.Hex(),
+		TxHash:          log.TxHash.String(),
+		Height:          int64(log.BlockNumber),
+	}
+	if ev.EventType == model.LiquidityEventBurn {
+		pack.To = ev.To.String()
+	}
+	return pack
+}
+
+func ParseMintEvent(abi *abi.ABI, log *types.Log) (*LiquidityEvent, error) {
+	var ev LiquidityEvent
+
+	err := abi.Unpack(&ev, MintEventName, log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.EventType = model.LiquidityEventMint
+	ev.Sender = common.BytesToAddress(log.Topics[1].Bytes())
+	ev.Contract = log.Address
+
+	return &ev, nil
+}
+
+func ParseBurnEvent(abi *abi.ABI, log *types.Log) (*LiquidityEvent, error) {
+	var ev LiquidityEvent
+
+	err := abi.Unpack(&ev, BurnEventName, log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.EventType = model.LiquidityEventBurn
+	ev.Sender = common.BytesToAddress(log.Topics[1].Bytes())
+	ev.To = common.BytesToAddress(log.Topics[2].Bytes())
+	ev.Contract = log.Address
+
+	return &ev, nil
+}
+
+// LpTransferEvent is a Transfer event of the lp token of a swap pair, lp tokens always have 18 decimals
+type LpTransferEvent struct {
+	Contract common.Address
+	From     common.Address
+	To       common.Address
+	Value    *big.Int
+}
+
+func (ev *LpTransferEvent) ToLpTransferLog(log *types.Log) *model.LpTransferLog {
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(ev.Value), defaultDecimal).Float64()
+	return &model.LpTransferLog{
+		ContractAddress: ev.Contract.String(),
+		FromAddress:     ev.From.String(),
+		ToAddress:       ev.To.String(),
+		Value:           value,
+		BlockHash:       log.BlockHash.Hex(),
+		TxHash:          log.TxHash.String(),
+		Height:          int64(log.BlockNumber),
+	}
+}
+
+func ParseLpTransferEvent(abi *abi.ABI, log *types.Log) (*LpTransferEvent, error) {
+	var ev LpTransferEvent
+
+	err := abi.Unpack(&ev, TransferEventName, log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.From = common.BytesToAddress(log.Topics[1].Bytes())
+	ev.To = common.BytesToAddress(log.Topics[2].Bytes())
+	ev.Contract = log.Address
+
+	return &ev, nil
+}
+
+// ToTokenTransferLog converts a Transfer event of a token whose holders are tracked
+func ToTokenTransferLog(ev *eabi.Bep20Transfer, log *types.Log, decimals uint8) *model.TokenTransferLog {
+	d := new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimals))))
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(ev.Value), d).Float64()
+	return &model.TokenTransferLog{
+		ContractAddress: log.Address.String(),
+		FromAddress:     ev.From.String(),
+		ToAddress:       ev.To.String(),
+		Value:           value,
+		RawValue:        ev.Value.String(),
+		Decimals:        decimals,
+		BlockHash:       log.BlockHash.Hex(),
+		TxHash:          log.TxHash.String(),
+		Height:          int64(log.BlockNumber),
+	}
+}
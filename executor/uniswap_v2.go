@@ -0,0 +1,167 @@
+package executor
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	eabi "github.com/pieswap/pie-statas/abi"
+)
+
+// UniswapV2Protocol is the adapter of uniswap v2 and its forks, pools are the pairs of the factory
+type UniswapV2Protocol struct {
+	client      ChainClient
+	swapPairABI abi.ABI
+}
+
+func NewUniswapV2Protocol(client ChainClient) (Protocol, error) {
+	swapPairAbi, err := abi.JSON(strings.NewReader(eabi.SwappairABI))
+	if err != nil {
+		return nil, err
+	}
+	return &UniswapV2Protocol{
+		client:      client,
+		swapPairABI: swapPairAbi,
+	}, nil
+}
+
+func (p *UniswapV2Protocol) Name() string {
+	return ProtocolUniswapV2
+}
+
+func (p *UniswapV2Protocol) Topics() []ethcmm.Hash {
+	return []ethcmm.Hash{SwapEventHash, MintEventHash, BurnEventHash, TransferEventHash}
+}
+
+func (p *UniswapV2Protocol) DiscoverPools(factory ethcmm.Address) ([]ethcmm.Address, error) {
+	factoryIns, err := eabi.NewFactory(factory, p.client)
+	if err != nil {
+		return nil, err
+	}
+	pairLength, err := factoryIns.AllPairsLength(nil)
+	if err != nil {
+		return nil, err
+	}
+	pairList := make([]ethcmm.Address, 0, pairLength.Int64())
+	for i := int64(0); i < pairLength.Int64(); i++ {
+		pair, err := factoryIns.AllPairs(nil, big.NewInt(i))
+		if err != nil {
+			return nil, err
+		}
+		pairList = append(pairList, pair)
+	}
+	return pairList, nil
+}
+
+func (p *UniswapV2Protocol) DecodeLog(log *types.Log, infoQuery InfoQuerier) (interface{}, error) {
+	if len(log.Topics) == 0 {
+		return nil, nil
+	}
+	// lp transfers are valued by the executor, they need no decimals
+	if log.Topics[0] == TransferEventHash {
+		event, err := ParseLpTransferEvent(&p.swapPairABI, log)
+		if err != nil {
+			return nil, err
+		}
+		return event.ToLpTransferLog(log), nil
+	}
+
+	d0, d1, err := infoQuery.GetDecimals(log.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch log.Topics[0] {
+	case SwapEventHash:
+		event, err := ParseSwapEvent(&p.swapPairABI, log)
+		if err != nil {
+			return nil, err
+		}
+		return event.ToTxLog(log, d0, d1), nil
+	case MintEventHash, BurnEventHash:
+		var event *LiquidityEvent
+		if log.Topics[0] == MintEventHash {
+			event, err = ParseMintEvent(&p.swapPairABI, log)
+		} else {
+			event, err = ParseBurnEvent(&p.swapPairABI, log)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return event.ToLiquidityLog(log, d0, d1), nil
+	}
+	return nil, nil
+}
+
+func (p *UniswapV2Protocol) ReadPoolState(pool ethcmm.Address) (*PoolState, error) {
+	pairInstance, err := eabi.NewSwappair(pool, p.client)
+	if err != nil {
+		return nil, err
+	}
+	reserve, err := pairInstance.GetReserves(nil)
+	if err != nil {
+		return nil, err
+	}
+	token0, err := pairInstance.Token0(nil)
+	if err != nil {
+		return nil, err
+	}
+	token1, err := pairInstance.Token1(nil)
+	if err != nil {
+		return nil, err
+	}
+	totalSupply, err := pairInstance.TotalSupply(nil)
+	if err != nil {
+		return nil, err
+	}
+	// the protocol fee accrues once kLast is recorded with feeTo set, a fork without kLast does not accrue it
+	kLast, err := pairInstance.KLast(nil)
+	if err != nil && IsRetryable(err) {
+		return nil, err
+	}
+	if err != nil {
+		kLast = new(big.Int)
+	}
+	return &PoolState{
+		Tokens:             []ethcmm.Address{token0, token1},
+		Reserves:           []*big.Int{reserve.Reserve0, reserve.Reserve1},
+		LpSupply:           totalSupply,
+		ProtocolFeeAccrues: kLast.Sign() != 0,
+	}, nil
+}
+
+// ReadReservesAt reads the pair at the height, a node which pruned the state of the height fails it
+// permanently
+func (p *UniswapV2Protocol) ReadReservesAt(pool ethcmm.Address, height uint64) ([]*big.Int, *big.Int, error) {
+	pairInstance, err := eabi.NewSwappair(pool, p.client)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &bind.CallOpts{BlockNumber: new(big.Int).SetUint64(height)}
+	reserve, err := pairInstance.GetReserves(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	totalSupply, err := pairInstance.TotalSupply(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []*big.Int{reserve.Reserve0, reserve.Reserve1}, totalSupply, nil
+}
+
+// ProtocolFeeOn returns whether feeTo of the factory is set
+func (p *UniswapV2Protocol) ProtocolFeeOn(factory ethcmm.Address) (bool, error) {
+	factoryIns, err := eabi.NewFactory(factory, p.client)
+	if err != nil {
+		return false, err
+	}
+	feeTo, err := factoryIns.FeeTo(nil)
+	if err != nil {
+		return false, err
+	}
+	return feeTo != ethcmm.Address{}, nil
+}
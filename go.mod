@@ -6,6 +6,7 @@ require (
 	github.com/aws/aws-sdk-go v1.34.21
 	github.com/ethereum/go-ethereum v1.9.12
 	github.com/gorilla/mux v1.8.0
+	github.com/graph-gophers/graphql-go v0.0.0-20191115155744-f33e81362277
 	github.com/jinzhu/gorm v1.9.16
 	github.com/mattn/go-sqlite3 v2.0.1+incompatible // indirect
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
@@ -0,0 +1,91 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"github.com/pieswap/pie-statas/statas"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	DefaultMaxComplexity = 1000
+	DefaultMaxDepth      = 6
+
+	maxParallelism = 10
+)
+
+type budgetKey struct{}
+
+// budget is the number of rows a single query may resolve, every list field charges its page size
+type budget struct {
+	mux  sync.Mutex
+	left int
+}
+
+func charge(ctx context.Context, rows int) error {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if rows > b.left {
+		return fmt.Errorf("query too complex, the complexity limit is exceeded")
+	}
+	b.left -= rows
+	return nil
+}
+
+type Handler struct {
+	schema        *graphql.Schema
+	maxComplexity int
+}
+
+func NewHandler(statSvc *statas.StatasSvc, maxComplexity, maxDepth int) *Handler {
+	if maxComplexity <= 0 {
+		maxComplexity = DefaultMaxComplexity
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	schema := graphql.MustParseSchema(Schema, NewResolver(statSvc),
+		graphql.MaxDepth(maxDepth), graphql.MaxParallelism(maxParallelism))
+	return &Handler{
+		schema:        schema,
+		maxComplexity: maxComplexity,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), budgetKey{}, &budget{left: h.maxComplexity})
+	response := h.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
@@ -0,0 +1,187 @@
+package gql
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/simchain"
+	"github.com/pieswap/pie-statas/statas"
+)
+
+// newTestSvc returns the refreshed stat service of a WOKT/BUSD pair on a fake client, with a swap and a mint
+// of the pair in an in memory db
+func newTestSvc(t *testing.T) (*statas.StatasSvc, ethcmm.Address) {
+	fixture := simchain.NewFixture(t, []simchain.FixturePair{{Token0: "WOKT", Token1: "BUSD", Reserve0: 1000, Reserve1: 20000}})
+	pair := fixture.Pairs[0]
+	require.NoError(t, fixture.DB.Create(&model.TxEventLog{Chain: simchain.FixtureChain, ContractAddress: pair.String(), Amount0: 10, Amount1: 200,
+		TxHash: "0x2", BlockTime: simchain.FixtureBlockTime, Height: 100, Status: model.TxStatusConfirmed}).Error)
+	require.NoError(t, fixture.DB.Create(&model.LiquidityEventLog{Chain: simchain.FixtureChain, ContractAddress: pair.String(), EventType: model.LiquidityEventMint,
+		Sender: "0x3", Amount0: 100, Amount1: 2000, TxHash: "0x4", BlockTime: simchain.FixtureBlockTime, Height: 100}).Error)
+
+	svc := statas.NewStatasSvc(fixture.DB, fixture.Config, fixture.ChainConfig, fixture.Client, executor.NewExecutor(fixture.ChainConfig, fixture.Client))
+	svc.Refresh()
+	return svc, pair
+}
+
+// query runs the query on the handler, the data is decoded into resp and the errors are returned
+func query(t *testing.T, handler *Handler, q string, resp interface{}) []string {
+	body, err := json.Marshal(map[string]string{"query": q})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	errs := make([]string, 0, len(result.Errors))
+	for _, err := range result.Errors {
+		errs = append(errs, err.Message)
+	}
+	if len(errs) == 0 && resp != nil {
+		require.NoError(t, json.Unmarshal(result.Data, resp))
+	}
+	return errs
+}
+
+type testToken struct {
+	Id       string
+	Symbol   string
+	Decimals int
+	PriceUSD *float64
+}
+
+func TestPairs(t *testing.T) {
+	svc, pair := newTestSvc(t)
+	handler := NewHandler(svc, 0, 0)
+
+	var resp struct {
+		Pair *struct {
+			Id            string
+			Token0        testToken
+			Token1        testToken
+			Price         float64
+			Reserve0      float64
+			BaseVolume24h float64
+			VolumeUSD24h  float64
+			Swaps         []struct{ TxHash string }
+			DayData       []struct{ TxCount int }
+		}
+		Pairs []struct{ Id string }
+	}
+	require.Empty(t, query(t, handler, `{
+		pair(id: "`+pair.String()+`") {
+			id token0 { id symbol decimals priceUSD } token1 { symbol priceUSD } price reserve0 baseVolume24h volumeUSD24h
+			swaps { txHash } dayData { txCount }
+		}
+		pairs(where: {token: "busd"}) { id }
+	}`, &resp))
+	require.NotNil(t, resp.Pair)
+	require.Equal(t, pair.String(), resp.Pair.Id)
+	require.Equal(t, "WOKT", resp.Pair.Token0.Symbol)
+	require.Equal(t, 18, resp.Pair.Token0.Decimals)
+	require.Equal(t, 20.0, *resp.Pair.Token0.PriceUSD)
+	require.Equal(t, 1.0, *resp.Pair.Token1.PriceUSD)
+	require.Equal(t, 20.0, resp.Pair.Price)
+	require.Equal(t, 1000.0, resp.Pair.Reserve0)
+	require.Equal(t, 10.0, resp.Pair.BaseVolume24h)
+	require.Equal(t, 400.0, resp.Pair.VolumeUSD24h)
+	require.Len(t, resp.Pair.Swaps, 1)
+	require.Equal(t, []struct{ TxCount int }{{1}}, resp.Pair.DayData)
+	require.Len(t, resp.Pairs, 1)
+
+	require.Empty(t, query(t, handler, `{ pair(id: "0x1") { id } pairs(where: {minReserveUSD: 1e9}) { id } }`, &resp))
+	require.Nil(t, resp.Pair)
+	require.Empty(t, resp.Pairs)
+}
+
+func TestTokens(t *testing.T) {
+	svc, pair := newTestSvc(t)
+	handler := NewHandler(svc, 0, 0)
+	info, exist := svc.GetSwapPairInfo(pair)
+	require.True(t, exist)
+	token0, _ := info.Tokens()
+
+	var resp struct {
+		Token  *testToken
+		Tokens []testToken
+	}
+	require.Empty(t, query(t, handler, `{
+		token(id: "`+token0.String()+`") { id symbol decimals priceUSD }
+		tokens(where: {symbol: "busd"}) { symbol priceUSD }
+	}`, &resp))
+	require.NotNil(t, resp.Token)
+	require.Equal(t, token0.String(), resp.Token.Id)
+	require.Equal(t, "WOKT", resp.Token.Symbol)
+	require.Len(t, resp.Tokens, 1)
+	require.Equal(t, "BUSD", resp.Tokens[0].Symbol)
+}
+
+func TestEvents(t *testing.T) {
+	svc, pair := newTestSvc(t)
+	handler := NewHandler(svc, 0, 0)
+
+	var resp struct {
+		Swaps []struct {
+			Pair      string
+			TxHash    string
+			Amount0   float64
+			Confirmed bool
+		}
+		LiquidityEvents []struct {
+			Type      string
+			Sender    string
+			To        *string
+			Confirmed bool
+		}
+		PairDayDatas []struct {
+			Date    int64
+			Volume1 float64
+			TxCount int
+		}
+		SyrupPools []struct{ Name string }
+	}
+	require.Empty(t, query(t, handler, `{
+		swaps(where: {pair: "`+pair.String()+`", fromTime: 1600000000}) { pair txHash amount0 confirmed }
+		liquidityEvents(where: {type: MINT}) { type sender to confirmed }
+		pairDayDatas(pair: "`+pair.String()+`") { date volume1 txCount }
+		syrupPools { name }
+	}`, &resp))
+	require.Len(t, resp.Swaps, 1)
+	require.Equal(t, strings.ToLower(pair.String()), resp.Swaps[0].Pair)
+	require.Equal(t, 10.0, resp.Swaps[0].Amount0)
+	require.True(t, resp.Swaps[0].Confirmed)
+	require.Len(t, resp.LiquidityEvents, 1)
+	require.Equal(t, "MINT", resp.LiquidityEvents[0].Type)
+	require.Nil(t, resp.LiquidityEvents[0].To)
+	require.False(t, resp.LiquidityEvents[0].Confirmed)
+	require.Len(t, resp.PairDayDatas, 1)
+	require.Equal(t, simchain.FixtureBlockTime-simchain.FixtureBlockTime%86400, resp.PairDayDatas[0].Date)
+	require.Equal(t, 200.0, resp.PairDayDatas[0].Volume1)
+	require.Empty(t, resp.SyrupPools)
+
+	require.Empty(t, query(t, handler, `{ liquidityEvents(where: {type: BURN}) { type } }`, &resp))
+	require.Empty(t, resp.LiquidityEvents)
+}
+
+func TestQueryLimits(t *testing.T) {
+	svc, _ := newTestSvc(t)
+
+	require.Equal(t, []string{"first should not be larger than 100"},
+		query(t, NewHandler(svc, 0, 0), `{ swaps(first: 101) { txHash } }`, nil))
+	require.Equal(t, []string{"query too complex, the complexity limit is exceeded"},
+		query(t, NewHandler(svc, 30, 0), `{ pairs { swaps { txHash } } }`, nil))
+}
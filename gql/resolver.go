@@ -0,0 +1,573 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/statas"
+)
+
+const (
+	MaxPageSize = 100
+	MaxSkip     = 5000
+
+	orderAsc = "ASC"
+
+	liquidityEventMint = "MINT"
+	liquidityEventBurn = "BURN"
+)
+
+type Resolver struct {
+	statSvc *statas.StatasSvc
+}
+
+func NewResolver(statSvc *statas.StatasSvc) *Resolver {
+	return &Resolver{
+		statSvc: statSvc,
+	}
+}
+
+type pageArgs struct {
+	First int32
+	Skip  int32
+}
+
+// limit checks the page arguments and charges the rows against the query budget
+func (args pageArgs) limit(ctx context.Context) (int, int, error) {
+	first, skip := int(args.First), int(args.Skip)
+	if first < 0 || skip < 0 {
+		return 0, 0, fmt.Errorf("first and skip should not be negative")
+	}
+	if first > MaxPageSize {
+		return 0, 0, fmt.Errorf("first should not be larger than %d", MaxPageSize)
+	}
+	if skip > MaxSkip {
+		return 0, 0, fmt.Errorf("skip should not be larger than %d", MaxSkip)
+	}
+	if err := charge(ctx, first); err != nil {
+		return 0, 0, err
+	}
+	return first, skip, nil
+}
+
+func page(total, first, skip int) (int, int) {
+	if skip > total {
+		skip = total
+	}
+	end := skip + first
+	if end > total {
+		end = total
+	}
+	return skip, end
+}
+
+func orderOf(direction string) string {
+	if direction == orderAsc {
+		return "block_time asc, id asc"
+	}
+	return "block_time desc, id desc"
+}
+
+func (r *Resolver) pairResolver(info *statas.SwapPairInfo, prices map[string]float64) *PairResolver {
+	return &PairResolver{
+		root:   r,
+		info:   info,
+		prices: prices,
+	}
+}
+
+func (r *Resolver) Pair(args struct{ Id string }) *PairResolver {
+	info, exist := r.statSvc.GetSwapPairInfo(ethcmm.HexToAddress(args.Id))
+	if !exist {
+		return nil
+	}
+	prices, _ := r.statSvc.GetPrice()
+	return r.pairResolver(info, prices)
+}
+
+type pairFilter struct {
+	Token         *string
+	MinReserveUSD *float64
+	MinVolumeUSD  *float64
+}
+
+func (f *pairFilter) match(info *statas.SwapPairInfo) bool {
+	if f == nil {
+		return true
+	}
+	if f.Token != nil {
+		token0, token1 := info.Tokens()
+		token := *f.Token
+		if !strings.EqualFold(token, info.BaseSymbol) && !strings.EqualFold(token, info.QuoteSymbol) &&
+			!strings.EqualFold(token, token0.String()) && !strings.EqualFold(token, token1.String()) {
+			return false
+		}
+	}
+	if f.MinReserveUSD != nil && info.ReserveUSD() < *f.MinReserveUSD {
+		return false
+	}
+	if f.MinVolumeUSD != nil && info.VolumeUSD() < *f.MinVolumeUSD {
+		return false
+	}
+	return true
+}
+
+func (r *Resolver) Pairs(ctx context.Context, args struct {
+	pageArgs
+	Where          *pairFilter
+	OrderBy        string
+	OrderDirection string
+}) ([]*PairResolver, error) {
+	first, skip, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*statas.SwapPairInfo, 0)
+	for _, info := range r.statSvc.GetAllSwapPairInfos() {
+		if args.Where.match(info) {
+			infos = append(infos, info)
+		}
+	}
+
+	key := func(info *statas.SwapPairInfo) float64 {
+		switch args.OrderBy {
+		case "RESERVE_USD":
+			return info.ReserveUSD()
+		case "PRICE":
+			return info.LastPrice
+		default:
+			return info.VolumeUSD()
+		}
+	}
+	asc := args.OrderDirection == orderAsc
+	sort.SliceStable(infos, func(i, j int) bool {
+		if key(infos[i]) == key(infos[j]) {
+			return infos[i].SwapPairContract < infos[j].SwapPairContract
+		}
+		if asc {
+			return key(infos[i]) < key(infos[j])
+		}
+		return key(infos[i]) > key(infos[j])
+	})
+
+	prices, _ := r.statSvc.GetPrice()
+	start, end := page(len(infos), first, skip)
+	res := make([]*PairResolver, 0, end-start)
+	for _, info := range infos[start:end] {
+		res = append(res, r.pairResolver(info, prices))
+	}
+	return res, nil
+}
+
+// tokens collects the distinct tokens of all refreshed pairs
+func (r *Resolver) tokens() []*TokenResolver {
+	prices, _ := r.statSvc.GetPrice()
+	tokenMap := make(map[ethcmm.Address]*TokenResolver, 0)
+	for _, info := range r.statSvc.GetAllSwapPairInfos() {
+		token0, token1 := info.Tokens()
+		decimal0, decimal1 := info.Decimals()
+		tokenMap[token0] = &TokenResolver{address: token0, symbol: info.BaseSymbol, decimals: decimal0, prices: prices}
+		tokenMap[token1] = &TokenResolver{address: token1, symbol: info.QuoteSymbol, decimals: decimal1, prices: prices}
+	}
+	tokens := make([]*TokenResolver, 0, len(tokenMap))
+	for _, token := range tokenMap {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].symbol < tokens[j].symbol
+	})
+	return tokens
+}
+
+func (r *Resolver) Token(args struct{ Id string }) *TokenResolver {
+	addr := ethcmm.HexToAddress(args.Id)
+	for _, token := range r.tokens() {
+		if token.address == addr {
+			return token
+		}
+	}
+	return nil
+}
+
+type tokenFilter struct {
+	Symbol *string
+}
+
+func (r *Resolver) Tokens(ctx context.Context, args struct {
+	pageArgs
+	Where *tokenFilter
+}) ([]*TokenResolver, error) {
+	first, skip, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*TokenResolver, 0)
+	for _, token := range r.tokens() {
+		if args.Where != nil && args.Where.Symbol != nil && !strings.EqualFold(*args.Where.Symbol, token.symbol) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	start, end := page(len(tokens), first, skip)
+	return tokens[start:end], nil
+}
+
+type eventFilter struct {
+	Pair     *string
+	FromTime *int32
+	ToTime   *int32
+}
+
+type liquidityEventFilter struct {
+	Pair     *string
+	Type     *string
+	FromTime *int32
+	ToTime   *int32
+}
+
+func (r *Resolver) Swaps(ctx context.Context, args struct {
+	pageArgs
+	Where          *eventFilter
+	OrderDirection string
+}) ([]*SwapResolver, error) {
+	first, skip, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.statSvc.GetDB().Model(model.TxEventLog{}).Where("chain = ?", r.statSvc.Chain())
+	if args.Where != nil {
+		if args.Where.Pair != nil {
+			query = query.Where("contract_address = ?", strings.ToLower(*args.Where.Pair))
+		}
+		if args.Where.FromTime != nil {
+			query = query.Where("block_time >= ?", *args.Where.FromTime)
+		}
+		if args.Where.ToTime != nil {
+			query = query.Where("block_time < ?", *args.Where.ToTime)
+		}
+	}
+	txLogs := make([]model.TxEventLog, 0)
+	err = query.Order(orderOf(args.OrderDirection)).Limit(first).Offset(skip).Find(&txLogs).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*SwapResolver, 0, len(txLogs))
+	for idx := range txLogs {
+		res = append(res, &SwapResolver{txLogs[idx]})
+	}
+	return res, nil
+}
+
+func (r *Resolver) LiquidityEvents(ctx context.Context, args struct {
+	pageArgs
+	Where          *liquidityEventFilter
+	OrderDirection string
+}) ([]*LiquidityEventResolver, error) {
+	first, skip, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.statSvc.GetDB().Model(model.LiquidityEventLog{}).Where("chain = ?", r.statSvc.Chain())
+	if args.Where != nil {
+		if args.Where.Pair != nil {
+			query = query.Where("contract_address = ?", strings.ToLower(*args.Where.Pair))
+		}
+		if args.Where.Type != nil {
+			eventType := model.LiquidityEventMint
+			if *args.Where.Type == liquidityEventBurn {
+				eventType = model.LiquidityEventBurn
+			}
+			query = query.Where("event_type = ?", eventType)
+		}
+		if args.Where.FromTime != nil {
+			query = query.Where("block_time >= ?", *args.Where.FromTime)
+		}
+		if args.Where.ToTime != nil {
+			query = query.Where("block_time < ?", *args.Where.ToTime)
+		}
+	}
+	liquidityLogs := make([]model.LiquidityEventLog, 0)
+	err = query.Order(orderOf(args.OrderDirection)).Limit(first).Offset(skip).Find(&liquidityLogs).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*LiquidityEventResolver, 0, len(liquidityLogs))
+	for idx := range liquidityLogs {
+		res = append(res, &LiquidityEventResolver{liquidityLogs[idx]})
+	}
+	return res, nil
+}
+
+func (r *Resolver) PairDayDatas(ctx context.Context, args struct {
+	Pair string
+	pageArgs
+}) ([]*PairDayDataResolver, error) {
+	first, skip, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dayData, err := model.GetPairDayData(r.statSvc.GetDB(), r.statSvc.Chain(), args.Pair, first, skip)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*PairDayDataResolver, 0, len(dayData))
+	for _, data := range dayData {
+		res = append(res, &PairDayDataResolver{data})
+	}
+	return res, nil
+}
+
+func (r *Resolver) SyrupPools() []*SyrupPoolResolver {
+	pools, _, _ := r.statSvc.GetSynup()
+	res := make([]*SyrupPoolResolver, 0, len(pools))
+	for _, pool := range pools {
+		res = append(res, &SyrupPoolResolver{pool})
+	}
+	return res
+}
+
+type TokenResolver struct {
+	address  ethcmm.Address
+	symbol   string
+	decimals uint8
+	prices   map[string]float64
+}
+
+func (t *TokenResolver) Id() string {
+	return t.address.String()
+}
+
+func (t *TokenResolver) Symbol() string {
+	return t.symbol
+}
+
+func (t *TokenResolver) Decimals() int32 {
+	return int32(t.decimals)
+}
+
+func (t *TokenResolver) PriceUSD() *float64 {
+	price, exist := t.prices[t.symbol]
+	if !exist {
+		return nil
+	}
+	return &price
+}
+
+type PairResolver struct {
+	root   *Resolver
+	info   *statas.SwapPairInfo
+	prices map[string]float64
+}
+
+func (p *PairResolver) Id() string {
+	return p.info.SwapPairContract
+}
+
+func (p *PairResolver) Token0() *TokenResolver {
+	token0, _ := p.info.Tokens()
+	decimal0, _ := p.info.Decimals()
+	return &TokenResolver{address: token0, symbol: p.info.BaseSymbol, decimals: decimal0, prices: p.prices}
+}
+
+func (p *PairResolver) Token1() *TokenResolver {
+	_, token1 := p.info.Tokens()
+	_, decimal1 := p.info.Decimals()
+	return &TokenResolver{address: token1, symbol: p.info.QuoteSymbol, decimals: decimal1, prices: p.prices}
+}
+
+func (p *PairResolver) Price() float64 {
+	return p.info.LastPrice
+}
+
+func (p *PairResolver) Reserve0() float64 {
+	reserve0, _ := p.info.Reserves()
+	return reserve0
+}
+
+func (p *PairResolver) Reserve1() float64 {
+	_, reserve1 := p.info.Reserves()
+	return reserve1
+}
+
+func (p *PairResolver) ReserveUSD() float64 {
+	return p.info.ReserveUSD()
+}
+
+func (p *PairResolver) BaseVolume24h() float64 {
+	return p.info.BaseVolume24h
+}
+
+func (p *PairResolver) QuoteVolume24h() float64 {
+	return p.info.QuoteVolume24h
+}
+
+func (p *PairResolver) VolumeUSD24h() float64 {
+	return p.info.VolumeUSD()
+}
+
+func (p *PairResolver) LpFeeUSD24h() float64 {
+	return p.info.Fees.Last24h.LpFee
+}
+
+func (p *PairResolver) ProtocolFeeUSD24h() float64 {
+	return p.info.Fees.Last24h.ProtocolFee
+}
+
+func (p *PairResolver) Swaps(ctx context.Context, args struct {
+	pageArgs
+	OrderDirection string
+}) ([]*SwapResolver, error) {
+	pair := p.info.SwapPairContract
+	return p.root.Swaps(ctx, struct {
+		pageArgs
+		Where          *eventFilter
+		OrderDirection string
+	}{args.pageArgs, &eventFilter{Pair: &pair}, args.OrderDirection})
+}
+
+func (p *PairResolver) LiquidityEvents(ctx context.Context, args struct {
+	pageArgs
+	OrderDirection string
+}) ([]*LiquidityEventResolver, error) {
+	pair := p.info.SwapPairContract
+	return p.root.LiquidityEvents(ctx, struct {
+		pageArgs
+		Where          *liquidityEventFilter
+		OrderDirection string
+	}{args.pageArgs, &liquidityEventFilter{Pair: &pair}, args.OrderDirection})
+}
+
+func (p *PairResolver) DayData(ctx context.Context, args pageArgs) ([]*PairDayDataResolver, error) {
+	return p.root.PairDayDatas(ctx, struct {
+		Pair string
+		pageArgs
+	}{p.info.SwapPairContract, args})
+}
+
+type SwapResolver struct {
+	txLog model.TxEventLog
+}
+
+func (s *SwapResolver) Pair() string {
+	return s.txLog.ContractAddress
+}
+
+func (s *SwapResolver) TxHash() string {
+	return s.txLog.TxHash
+}
+
+func (s *SwapResolver) Height() int32 {
+	return int32(s.txLog.Height)
+}
+
+func (s *SwapResolver) BlockTime() int32 {
+	return int32(s.txLog.BlockTime)
+}
+
+func (s *SwapResolver) Amount0() float64 {
+	return s.txLog.Amount0
+}
+
+func (s *SwapResolver) Amount1() float64 {
+	return s.txLog.Amount1
+}
+
+func (s *SwapResolver) Confirmed() bool {
+	return s.txLog.Status == model.TxStatusConfirmed
+}
+
+type LiquidityEventResolver struct {
+	liquidityLog model.LiquidityEventLog
+}
+
+func (l *LiquidityEventResolver) Pair() string {
+	return l.liquidityLog.ContractAddress
+}
+
+func (l *LiquidityEventResolver) Type() string {
+	if l.liquidityLog.EventType == model.LiquidityEventBurn {
+		return liquidityEventBurn
+	}
+	return liquidityEventMint
+}
+
+func (l *LiquidityEventResolver) Sender() string {
+	return l.liquidityLog.Sender
+}
+
+func (l *LiquidityEventResolver) To() *string {
+	if l.liquidityLog.To == "" {
+		return nil
+	}
+	return &l.liquidityLog.To
+}
+
+func (l *LiquidityEventResolver) TxHash() string {
+	return l.liquidityLog.TxHash
+}
+
+func (l *LiquidityEventResolver) Height() int32 {
+	return int32(l.liquidityLog.Height)
+}
+
+func (l *LiquidityEventResolver) BlockTime() int32 {
+	return int32(l.liquidityLog.BlockTime)
+}
+
+func (l *LiquidityEventResolver) Amount0() float64 {
+	return l.liquidityLog.Amount0
+}
+
+func (l *LiquidityEventResolver) Amount1() float64 {
+	return l.liquidityLog.Amount1
+}
+
+func (l *LiquidityEventResolver) Confirmed() bool {
+	return l.liquidityLog.Status == model.TxStatusConfirmed
+}
+
+type PairDayDataResolver struct {
+	data model.PairDayData
+}
+
+func (d *PairDayDataResolver) Pair() string {
+	return d.data.ContractAddress
+}
+
+func (d *PairDayDataResolver) Date() int32 {
+	return int32(d.data.Date)
+}
+
+func (d *PairDayDataResolver) Volume0() float64 {
+	return d.data.TotalAmount0
+}
+
+func (d *PairDayDataResolver) Volume1() float64 {
+	return d.data.TotalAmount1
+}
+
+func (d *PairDayDataResolver) TxCount() int32 {
+	return int32(d.data.TxCount)
+}
+
+type SyrupPoolResolver struct {
+	pool statas.SyrupTVL
+}
+
+func (s *SyrupPoolResolver) Name() string {
+	return s.pool.Name
+}
+
+func (s *SyrupPoolResolver) Tvl() float64 {
+	return s.pool.Tvl
+}
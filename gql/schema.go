@@ -0,0 +1,119 @@
+package gql
+
+const Schema = `
+schema {
+	query: Query
+}
+
+type Query {
+	pair(id: String!): Pair
+	pairs(first: Int = 20, skip: Int = 0, where: PairFilter, orderBy: PairOrderBy = VOLUME_USD, orderDirection: OrderDirection = DESC): [Pair!]!
+	token(id: String!): Token
+	tokens(first: Int = 20, skip: Int = 0, where: TokenFilter): [Token!]!
+	swaps(first: Int = 20, skip: Int = 0, where: EventFilter, orderDirection: OrderDirection = DESC): [Swap!]!
+	liquidityEvents(first: Int = 20, skip: Int = 0, where: LiquidityEventFilter, orderDirection: OrderDirection = DESC): [LiquidityEvent!]!
+	pairDayDatas(pair: String!, first: Int = 7, skip: Int = 0): [PairDayData!]!
+	syrupPools: [SyrupPool!]!
+}
+
+enum OrderDirection {
+	ASC
+	DESC
+}
+
+enum PairOrderBy {
+	VOLUME_USD
+	RESERVE_USD
+	PRICE
+}
+
+enum LiquidityEventType {
+	MINT
+	BURN
+}
+
+input PairFilter {
+	# symbol or address of either token of the pair
+	token: String
+	minReserveUSD: Float
+	minVolumeUSD: Float
+}
+
+input TokenFilter {
+	symbol: String
+}
+
+input EventFilter {
+	pair: String
+	fromTime: Int
+	toTime: Int
+}
+
+input LiquidityEventFilter {
+	pair: String
+	type: LiquidityEventType
+	fromTime: Int
+	toTime: Int
+}
+
+type Token {
+	id: String!
+	symbol: String!
+	decimals: Int!
+	priceUSD: Float
+}
+
+type Pair {
+	id: String!
+	token0: Token!
+	token1: Token!
+	price: Float!
+	reserve0: Float!
+	reserve1: Float!
+	reserveUSD: Float!
+	baseVolume24h: Float!
+	quoteVolume24h: Float!
+	volumeUSD24h: Float!
+	lpFeeUSD24h: Float!
+	protocolFeeUSD24h: Float!
+	swaps(first: Int = 20, skip: Int = 0, orderDirection: OrderDirection = DESC): [Swap!]!
+	liquidityEvents(first: Int = 20, skip: Int = 0, orderDirection: OrderDirection = DESC): [LiquidityEvent!]!
+	dayData(first: Int = 7, skip: Int = 0): [PairDayData!]!
+}
+
+type Swap {
+	pair: String!
+	txHash: String!
+	height: Int!
+	blockTime: Int!
+	amount0: Float!
+	amount1: Float!
+	confirmed: Boolean!
+}
+
+type LiquidityEvent {
+	pair: String!
+	type: LiquidityEventType!
+	sender: String!
+	to: String
+	txHash: String!
+	height: Int!
+	blockTime: Int!
+	amount0: Float!
+	amount1: Float!
+	confirmed: Boolean!
+}
+
+type PairDayData {
+	pair: String!
+	date: Int!
+	volume0: Float!
+	volume1: Float!
+	txCount: Int!
+}
+
+type SyrupPool {
+	name: String!
+	tvl: Float!
+}
+`
@@ -0,0 +1,600 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/observer"
+	"github.com/pieswap/pie-statas/pubsub"
+	"github.com/pieswap/pie-statas/server"
+	"github.com/pieswap/pie-statas/simchain"
+	"github.com/pieswap/pie-statas/statas"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const chainName = "sim"
+
+// ether returns the raw amount of a token with 18 decimals
+func ether(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18))
+}
+
+// env is the swap deployed on a simulated chain and the services indexing it, the envs of a test
+// share the db and the server
+type env struct {
+	t     *testing.T
+	name  string
+	chain *simchain.Chain
+	db    *gorm.DB
+
+	wokt, busd, pie, cake ethcmm.Address
+	factory               *simchain.Factory
+	woktBusd, pieWokt     ethcmm.Address
+	cakePie, syrupPool    ethcmm.Address
+
+	executor *executor.ChainExecutor
+	observer *observer.Observer
+	svc      *statas.StatasSvc
+	server   *server.Server
+}
+
+func newEnv(t *testing.T) *env {
+	return newEnvs(t, chainName)[0]
+}
+
+// newEnvs deploys the swap on a simulated chain of each name, indexed into one db and served by one server
+func newEnvs(t *testing.T, names ...string) []*env {
+	dir, err := ioutil.TempDir("", "statas")
+	require.NoError(t, err)
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "statas.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	db.AutoMigrate(model.Tables...)
+
+	config := &util.Config{
+		AlertConfig:  &util.AlertConfig{},
+		ServerConfig: util.ServerConfig{},
+	}
+	bus := pubsub.NewBus(16, 16)
+	envs := make([]*env, 0, len(names))
+	chains := make([]*server.Chain, 0, len(names))
+	for _, name := range names {
+		e := deployEnv(t, name, db)
+		config.ChainConfigs = append(config.ChainConfigs, &util.ChainConfig{
+			Name:              name,
+			StartHeight:       1,
+			Provider:          "simulated",
+			ConfirmNum:        3,
+			FetchInterval:     1,
+			SwapFactories:     []string{strings.ToLower(e.factory.Address.String())},
+			CertificatedPairs: []string{e.woktBusd.String()},
+			SynupPools:        []string{e.syrupPool.String()},
+			SyrupToken:        e.cake.String(),
+			HolderTokens:      []string{e.pie.String()},
+		})
+		envs = append(envs, e)
+	}
+	for idx, e := range envs {
+		chainConfig := config.ChainConfigs[idx]
+		e.executor = executor.NewExecutor(chainConfig, e.chain.Client())
+		e.observer = observer.NewObserver(db, config, chainConfig, e.executor)
+		e.observer.SetBus(bus)
+		e.svc = statas.NewStatasSvc(db, config, chainConfig, e.chain.Client(), e.executor)
+		e.svc.SetBus(bus)
+		e.executor.SetInfoQuery(e.svc)
+		e.svc.Refresh()
+		chains = append(chains, server.NewChain(e.svc, e.observer))
+	}
+	srv := server.NewServer(config, chains, bus)
+	for _, e := range envs {
+		e.server = srv
+	}
+	return envs
+}
+
+// deployEnv deploys the tokens, pairs and syrup pool of the swap on a new simulated chain
+func deployEnv(t *testing.T, name string, db *gorm.DB) *env {
+	chain, err := simchain.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { chain.Close() })
+	e := &env{t: t, name: name, chain: chain, db: db}
+
+	e.wokt = e.deployToken("Wrapped OKT", "WOKT")
+	e.busd = e.deployToken("BUSD Token", "BUSD")
+	e.pie = e.deployToken("Pie Token", "Pie")
+	e.cake = e.deployToken("Cake Token", "Cake")
+
+	e.factory, err = chain.DeployFactory(ethcmm.Address{})
+	require.NoError(t, err)
+	// WOKT is priced by BUSD, Pie by WOKT and Cake by Pie
+	e.woktBusd = e.deployPair(e.wokt, e.busd, ether(1000), ether(20000))
+	e.pieWokt = e.deployPair(e.pie, e.wokt, ether(10000), ether(500))
+	e.cakePie = e.deployPair(e.cake, e.pie, ether(100), ether(500))
+
+	e.syrupPool, err = chain.DeploySmartchef(e.pie)
+	require.NoError(t, err)
+	require.NoError(t, chain.SetBalance(e.cake, e.syrupPool, ether(1000)))
+	chain.Commit()
+	return e
+}
+
+func (e *env) deployToken(name, symbol string) ethcmm.Address {
+	token, err := e.chain.DeployToken(name, symbol, 18, ether(1e9))
+	require.NoError(e.t, err)
+	return token
+}
+
+func (e *env) deployPair(token0, token1 ethcmm.Address, reserve0, reserve1 *big.Int) ethcmm.Address {
+	pair, err := e.chain.DeployPair(e.factory, token0, token1)
+	require.NoError(e.t, err)
+	require.NoError(e.t, e.chain.SetPairState(pair, reserve0, reserve1, ether(1000)))
+	return pair
+}
+
+// swap sells amountIn of token0 of the pair for amountOut of token1
+func (e *env) swap(pair ethcmm.Address, amountIn, amountOut *big.Int) {
+	require.NoError(e.t, e.chain.Swap(pair, e.chain.From, e.chain.From, amountIn, big.NewInt(0), big.NewInt(0), amountOut))
+}
+
+// sync runs the observer until it indexed the head of the chain, reorgs included
+func (e *env) sync() {
+	head := e.chain.Backend.Blockchain().CurrentBlock()
+	for i := 0; i < 100; i++ {
+		cur, err := e.observer.GetCurrentBlockLog()
+		require.NoError(e.t, err)
+		if cur.Height == head.Number().Int64() && strings.EqualFold(cur.BlockHash, head.Hash().String()) {
+			return
+		}
+		require.NoError(e.t, e.observer.FetchOnce(e.observer.StartHeight))
+	}
+	e.t.Fatalf("observer not synced to height %d", head.Number().Int64())
+}
+
+func (e *env) swapLogs(pair ethcmm.Address) []model.TxEventLog {
+	logs := make([]model.TxEventLog, 0)
+	require.NoError(e.t, e.db.Where("chain = ? and contract_address = ?", e.name,
+		strings.ToLower(pair.String())).Order("height asc").Find(&logs).Error)
+	return logs
+}
+
+func (e *env) get(path string, resp interface{}) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	e.server.Handler().ServeHTTP(rec, req)
+	require.Equal(e.t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(e.t, json.Unmarshal(rec.Body.Bytes(), resp))
+}
+
+func requireNear(t *testing.T, expected, actual float64) {
+	require.True(t, math.Abs(expected-actual) < 1e-6, "expected %v, got %v", expected, actual)
+}
+
+func TestIndexAndServe(t *testing.T) {
+	e := newEnv(t)
+	wallet := ethcmm.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	e.swap(e.woktBusd, ether(10), ether(200))
+	e.swap(e.pieWokt, ether(200), ether(10))
+	e.swap(e.cakePie, ether(40), ether(200))
+	require.NoError(t, e.chain.Mint(e.woktBusd, e.chain.From, ether(1), ether(20)))
+	require.NoError(t, e.chain.LpTransfer(e.woktBusd, ethcmm.Address{}, wallet, ether(10)))
+	e.chain.Commit()
+	// confirm the events
+	for i := 0; i < 3; i++ {
+		e.chain.Commit()
+	}
+	e.sync()
+	e.svc.Refresh()
+
+	swaps := e.swapLogs(e.woktBusd)
+	require.Len(t, swaps, 1)
+	requireNear(t, 10, swaps[0].Amount0)
+	requireNear(t, 200, swaps[0].Amount1)
+	require.Equal(t, model.TxStatusConfirmed, swaps[0].Status)
+	// the trader is the account sending the transaction
+	require.Equal(t, strings.ToLower(e.chain.From.String()), swaps[0].Origin)
+
+	liquidityLogs := make([]model.LiquidityEventLog, 0)
+	require.NoError(t, e.db.Where("chain = ?", chainName).Find(&liquidityLogs).Error)
+	require.Len(t, liquidityLogs, 1)
+	requireNear(t, 1, liquidityLogs[0].Amount0)
+	requireNear(t, 20, liquidityLogs[0].Amount1)
+
+	positions, err := model.GetWalletLpPositions(e.db, chainName, strings.ToLower(wallet.String()))
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	requireNear(t, 10, positions[0].Balance)
+
+	prices, _ := e.svc.GetPrice()
+	requireNear(t, 1, prices["BUSD"])
+	requireNear(t, 20, prices["WOKT"])
+	requireNear(t, 1, prices["Pie"])
+	requireNear(t, 5, prices["Cake"])
+
+	info, exist := e.svc.GetSwapPairInfo(e.woktBusd)
+	require.True(t, exist)
+	requireNear(t, 10, info.BaseVolume24h)
+	requireNear(t, 200, info.QuoteVolume24h)
+	requireNear(t, 20, info.LastPrice)
+
+	var stat struct {
+		TotalVolume         float64               `json:"24h_total_volume"`
+		LockVolume          float64               `json:"total_value_locked"`
+		TradePairs          []statas.SwapPairInfo `json:"trade_pairs"`
+		TotalValueLockedAll float64               `json:"total_value_locked_all"`
+	}
+	e.get("/api/v1/stat", &stat)
+	require.Len(t, stat.TradePairs, 3)
+	// every pair traded 400 USD
+	requireNear(t, 1200, stat.TotalVolume)
+	requireNear(t, 40000+20000+1000, stat.LockVolume)
+	// 1000 Cake staked in the syrup pool
+	requireNear(t, 61000+5000, stat.TotalValueLockedAll)
+
+	var price struct {
+		Prices map[string]float64 `json:"prices"`
+	}
+	e.get("/api/v1/price", &price)
+	requireNear(t, 20, price.Prices["WOKT"])
+	requireNear(t, 5, price.Prices["Cake"])
+
+	var syrup struct {
+		Pools []statas.SyrupTVL `json:"pools"`
+	}
+	e.get("/api/v1/syrup", &syrup)
+	require.Len(t, syrup.Pools, 1)
+	require.Equal(t, "Pie", syrup.Pools[0].Name)
+	requireNear(t, 5000, syrup.Pools[0].Tvl)
+}
+
+func TestWalletLiquidity(t *testing.T) {
+	e := newEnv(t)
+	wallet := ethcmm.HexToAddress("0x00000000000000000000000000000000000000aa")
+	other := ethcmm.HexToAddress("0x00000000000000000000000000000000000000bb")
+	liquidity := func() (float64, []statas.LiquidityPosition) {
+		var resp struct {
+			TotalValue float64                    `json:"total_value_usd"`
+			Positions  []statas.LiquidityPosition `json:"positions"`
+		}
+		e.get("/api/v1/wallets/"+wallet.String()+"/liquidity", &resp)
+		return resp.TotalValue, resp.Positions
+	}
+
+	// WOKT is priced before the deposits are indexed, one lp token is worth 1 WOKT and 20 BUSD, 40 USD
+	e.swap(e.woktBusd, ether(10), ether(200))
+	e.chain.Commit()
+	e.sync()
+	e.svc.Refresh()
+
+	// the simulated chain starts at time 0, the deposit goes into a recent block to be valued at the current prices
+	require.NoError(t, e.chain.Mint(e.woktBusd, e.chain.From, ether(10), ether(200)))
+	require.NoError(t, e.chain.LpTransfer(e.woktBusd, ethcmm.Address{}, wallet, ether(10)))
+	head := e.chain.Backend.Blockchain().CurrentBlock()
+	require.NoError(t, e.chain.Backend.AdjustTime(time.Since(time.Unix(int64(head.Time()), 0))-5*time.Minute))
+	e.chain.Commit()
+	require.NoError(t, e.chain.LpTransfer(e.woktBusd, wallet, other, ether(2)))
+	for i := 0; i < 4; i++ {
+		e.chain.Commit()
+	}
+	e.sync()
+
+	// a partial burn, served before it is confirmed
+	require.NoError(t, e.chain.LpTransfer(e.woktBusd, wallet, e.woktBusd, ether(4)))
+	require.NoError(t, e.chain.Burn(e.woktBusd, e.chain.From, wallet, ether(4), ether(80)))
+	require.NoError(t, e.chain.LpTransfer(e.woktBusd, e.woktBusd, ethcmm.Address{}, ether(4)))
+	e.chain.Commit()
+	e.sync()
+	e.svc.Refresh()
+
+	for _, confirmed := range []bool{false, true} {
+		if confirmed {
+			for i := 0; i < 3; i++ {
+				e.chain.Commit()
+			}
+			e.sync()
+		}
+		totalValue, positions := liquidity()
+		require.Len(t, positions, 1, "confirmed %v", confirmed)
+		position := positions[0]
+		require.Equal(t, e.woktBusd.String(), position.SwapPairContract)
+		require.Equal(t, "WOKT", position.BaseSymbol)
+		requireNear(t, 4, position.LpBalance)
+		requireNear(t, 4.0/1000, position.ShareOfPool)
+		requireNear(t, 4, position.Amount0)
+		requireNear(t, 80, position.Amount1)
+		requireNear(t, 160, position.ValueUSD)
+		requireNear(t, 160, totalValue)
+		// 10 lp tokens entered at 400 USD, a fifth and then half of the rest left
+		require.False(t, position.EntryUnknown)
+		requireNear(t, 4, position.EntryAmount0)
+		requireNear(t, 80, position.EntryAmount1)
+		requireNear(t, 160, position.EntryValueUSD)
+	}
+
+	positions, err := model.GetWalletLpPositions(e.db, chainName, strings.ToLower(other.String()))
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	requireNear(t, 2, positions[0].Balance)
+	requireNear(t, 80, positions[0].EntryValueUSD)
+}
+
+func TestReorg(t *testing.T) {
+	e := newEnv(t)
+
+	e.swap(e.woktBusd, ether(10), ether(200))
+	e.chain.Commit()
+	e.swap(e.woktBusd, ether(5), ether(100))
+	e.chain.Commit()
+	e.chain.Commit()
+	e.sync()
+	orphaned := e.swapLogs(e.woktBusd)
+	require.Len(t, orphaned, 2)
+
+	// the block of the second swap is replaced by one with another swap
+	require.NoError(t, e.chain.Reorg(2, func() error {
+		return e.chain.Swap(e.woktBusd, e.chain.From, e.chain.From, ether(1), big.NewInt(0), big.NewInt(0), ether(20))
+	}))
+	e.sync()
+
+	swaps := e.swapLogs(e.woktBusd)
+	require.Len(t, swaps, 2)
+	requireNear(t, 10, swaps[0].Amount0)
+	requireNear(t, 1, swaps[1].Amount0)
+	require.Equal(t, orphaned[1].Height, swaps[1].Height)
+	require.NotEqual(t, orphaned[1].BlockHash, swaps[1].BlockHash)
+	depth, _ := e.observer.LastReorg()
+	require.Equal(t, int64(2), depth)
+
+	blockLogs := make([]model.BlockLog, 0)
+	require.NoError(t, e.db.Where("chain = ?", chainName).Order("height asc").Find(&blockLogs).Error)
+	for idx := 1; idx < len(blockLogs); idx++ {
+		require.Equal(t, blockLogs[idx-1].BlockHash, blockLogs[idx].ParentHash)
+	}
+
+	e.svc.Refresh()
+	info, exist := e.svc.GetSwapPairInfo(e.woktBusd)
+	require.True(t, exist)
+	requireNear(t, 11, info.BaseVolume24h)
+}
+
+func TestTokenHolders(t *testing.T) {
+	e := newEnv(t)
+	a := ethcmm.HexToAddress("0x00000000000000000000000000000000000000aa")
+	b := ethcmm.HexToAddress("0x00000000000000000000000000000000000000bb")
+	c := ethcmm.HexToAddress("0x00000000000000000000000000000000000000cc")
+	balances := func() map[string]float64 {
+		holders := make([]model.TokenHolder, 0)
+		require.NoError(t, e.db.Where("chain = ? and token = ?", chainName, strings.ToLower(e.pie.String())).Find(&holders).Error)
+		balances := make(map[string]float64, 0)
+		for _, holder := range holders {
+			balances[holder.Holder] = holder.Balance
+		}
+		return balances
+	}
+
+	require.NoError(t, e.chain.TokenTransfer(e.pie, ethcmm.Address{}, a, ether(1000)))
+	require.NoError(t, e.chain.TokenTransfer(e.pie, ethcmm.Address{}, b, ether(500)))
+	require.NoError(t, e.chain.TokenTransfer(e.pie, a, b, ether(200)))
+	// transfers of other tokens are not tracked
+	require.NoError(t, e.chain.TokenTransfer(e.cake, ethcmm.Address{}, c, ether(1)))
+	e.chain.Commit()
+	e.chain.Commit()
+	e.chain.Commit()
+	e.sync()
+	require.Len(t, balances(), 2)
+	requireNear(t, 800, balances()[strings.ToLower(a.String())])
+	requireNear(t, 700, balances()[strings.ToLower(b.String())])
+
+	// the holders only change once the transfer is confirmed, a transfer reverted by a fork never counts
+	require.NoError(t, e.chain.TokenTransfer(e.pie, b, c, ether(700)))
+	e.chain.Commit()
+	e.sync()
+	requireNear(t, 700, balances()[strings.ToLower(b.String())])
+	require.NoError(t, e.chain.Reorg(1, func() error {
+		return e.chain.TokenTransfer(e.pie, a, c, ether(100))
+	}))
+	e.chain.Commit()
+	e.sync()
+	require.Len(t, balances(), 3)
+	requireNear(t, 700, balances()[strings.ToLower(a.String())])
+	requireNear(t, 700, balances()[strings.ToLower(b.String())])
+	requireNear(t, 100, balances()[strings.ToLower(c.String())])
+	// the transfers are summed in raw units
+	holder := model.TokenHolder{}
+	require.NoError(t, e.db.Where("chain = ? and holder = ?", chainName, strings.ToLower(a.String())).First(&holder).Error)
+	require.Equal(t, ether(700).String(), holder.RawBalance)
+	require.Equal(t, uint8(18), holder.Decimals)
+
+	var holders statas.TokenHolders
+	e.get("/api/v1/tokens/"+e.pie.String()+"/holders?top=2&days=7", &holders)
+	require.Equal(t, 3, holders.Holders)
+	require.Len(t, holders.TopHolders, 2)
+	requireNear(t, 700, holders.TopHolders[0].Balance)
+	requireNear(t, 700.0/1500, holders.TopHolders[0].Share)
+	require.Len(t, holders.Distribution, 8)
+	require.Equal(t, 3, holders.Distribution[3].Holders)
+	requireNear(t, 1500, holders.Distribution[3].Balance)
+	require.Len(t, holders.History, 7)
+	require.Equal(t, int64(3), holders.History[6].Holders)
+	require.False(t, holders.Partial)
+
+	// a sender given its balance before the start height goes below zero, the holders are partial
+	d := ethcmm.HexToAddress("0x00000000000000000000000000000000000000dd")
+	require.NoError(t, e.chain.TokenTransfer(e.pie, d, a, ether(5)))
+	for i := 0; i < 3; i++ {
+		e.chain.Commit()
+	}
+	e.sync()
+	requireNear(t, -5, balances()[strings.ToLower(d.String())])
+	e.get("/api/v1/tokens/"+e.pie.String()+"/holders?top=2&days=7", &holders)
+	require.True(t, holders.Partial)
+	require.Equal(t, 3, holders.Holders)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tokens/"+e.cake.String()+"/holders", nil)
+	rec := httptest.NewRecorder()
+	e.server.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestVolume24h(t *testing.T) {
+	e := newEnv(t)
+
+	e.swap(e.woktBusd, ether(10), ether(200))
+	e.chain.Commit()
+	e.swap(e.woktBusd, ether(2), ether(40))
+	require.NoError(t, e.chain.Backend.AdjustTime(25*time.Hour))
+	e.chain.Commit()
+	e.sync()
+	e.svc.Refresh()
+
+	require.Len(t, e.swapLogs(e.woktBusd), 2)
+	info, exist := e.svc.GetSwapPairInfo(e.woktBusd)
+	require.True(t, exist)
+	// the first swap is over a day before the latest block
+	requireNear(t, 2, info.BaseVolume24h)
+	requireNear(t, 40, info.QuoteVolume24h)
+}
+
+func TestShutdown(t *testing.T) {
+	e := newEnv(t)
+	e.swap(e.woktBusd, ether(10), ether(200))
+	e.chain.Commit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.svc.Start(ctx)
+	e.executor.Start(ctx)
+	e.observer.Start(ctx)
+
+	head := e.chain.Height()
+	for i := 0; ; i++ {
+		cur, err := e.observer.GetCurrentBlockLog()
+		require.NoError(t, err)
+		if cur.Height == head {
+			break
+		}
+		require.True(t, i < 100, "observer not synced")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	streamSrv := httptest.NewServer(e.server.StreamHandler())
+	defer streamSrv.Close()
+	resp, err := http.Get(streamSrv.URL + "/api/v1/stream?topics=blocks")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		e.observer.Wait()
+		e.svc.Wait()
+		e.executor.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("routines not stopped")
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	require.NoError(t, e.server.Shutdown(shutdownCtx))
+	// the stream ends instead of holding the shutdown
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+}
+
+func TestMultiChain(t *testing.T) {
+	envs := newEnvs(t, chainName, "sim2")
+	a, b := envs[0], envs[1]
+	// a swap indexed before multi chain support is tagged with the first chain, it adds 40 USD to its volume
+	require.NoError(t, a.db.Create(&model.TxEventLog{ContractAddress: a.woktBusd.String(), Amount0: 1, Amount1: 20, TxHash: "0x1",
+		BlockTime: 1, Height: 1, Status: model.TxStatusConfirmed}).Error)
+	require.NoError(t, model.TagLegacyRows(a.db, chainName))
+
+	a.swap(a.woktBusd, ether(10), ether(200))
+	b.swap(b.woktBusd, ether(5), ether(100))
+	// the other pairs trade 400 USD on both chains, which prices all tokens
+	for _, e := range envs {
+		e.swap(e.pieWokt, ether(200), ether(10))
+		e.swap(e.cakePie, ether(40), ether(200))
+	}
+	for i := 0; i < 4; i++ {
+		a.chain.Commit()
+		b.chain.Commit()
+	}
+	for _, e := range envs {
+		e.sync()
+		e.svc.Refresh()
+	}
+
+	swaps := a.swapLogs(a.woktBusd)
+	require.Len(t, swaps, 2)
+	requireNear(t, 1, swaps[0].Amount0)
+	requireNear(t, 10, swaps[1].Amount0)
+	swaps = b.swapLogs(b.woktBusd)
+	require.Len(t, swaps, 1)
+	requireNear(t, 5, swaps[0].Amount0)
+
+	type stat struct {
+		TotalVolume         float64               `json:"24h_total_volume"`
+		LockVolume          float64               `json:"total_value_locked"`
+		TradePairs          []statas.SwapPairInfo `json:"trade_pairs"`
+		TotalValueLockedAll float64               `json:"total_value_locked_all"`
+	}
+	var chainStat, allStat stat
+	b.get("/api/v1/stat?chain=sim2", &chainStat)
+	requireNear(t, 200+400+400, chainStat.TotalVolume)
+	require.Len(t, chainStat.TradePairs, 3)
+	for _, pair := range chainStat.TradePairs {
+		require.Equal(t, "sim2", pair.Chain)
+	}
+	// the totals of all chains are summed, the pairs of each chain kept apart
+	a.get("/api/v1/stat?chain=all", &allStat)
+	requireNear(t, 1240+1000, allStat.TotalVolume)
+	requireNear(t, 61000*2, allStat.LockVolume)
+	requireNear(t, 66000*2, allStat.TotalValueLockedAll)
+	require.Len(t, allStat.TradePairs, 6)
+	pairChains := make(map[string]int, 0)
+	for _, pair := range allStat.TradePairs {
+		pairChains[pair.Chain]++
+	}
+	require.Equal(t, map[string]int{chainName: 3, "sim2": 3}, pairChains)
+
+	var price struct {
+		Prices map[string]map[string]float64 `json:"prices"`
+	}
+	a.get("/api/v1/price?chain=all", &price)
+	require.Len(t, price.Prices, 2)
+	requireNear(t, 20, price.Prices[chainName]["WOKT"])
+	requireNear(t, 20, price.Prices["sim2"]["WOKT"])
+
+	var syrup struct {
+		TVL   float64           `json:"tvl"`
+		Pools []statas.SyrupTVL `json:"pools"`
+	}
+	a.get("/api/v1/syrup?chain=all", &syrup)
+	require.Len(t, syrup.Pools, 2)
+	requireNear(t, 10000, syrup.TVL)
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/spf13/pflag"
@@ -10,9 +17,12 @@ import (
 
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 
+	"github.com/pieswap/pie-statas/alert"
+	"github.com/pieswap/pie-statas/common"
 	"github.com/pieswap/pie-statas/executor"
 	"github.com/pieswap/pie-statas/model"
 	"github.com/pieswap/pie-statas/observer"
+	"github.com/pieswap/pie-statas/pubsub"
 	"github.com/pieswap/pie-statas/server"
 	"github.com/pieswap/pie-statas/statas"
 	"github.com/pieswap/pie-statas/util"
@@ -22,12 +32,16 @@ const (
 	flagConfigAwsRegion    = "aws-region"
 	flagConfigAwsSecretKey = "aws-secret-key"
 	flagConfigPath         = "config-path"
+	flagConfigSet          = "set"
+	flagRedacted           = "redacted"
 )
 
 func initFlags() {
 	flag.String(flagConfigPath, "", "config path")
 	flag.String(flagConfigAwsRegion, "", "aws s3 region")
 	flag.String(flagConfigAwsSecretKey, "", "aws s3 secret key")
+	pflag.StringArray(flagConfigSet, nil, "override a config field, e.g. chain_config.bsc.provider=wss://..., repeatable")
+	pflag.Bool(flagRedacted, false, "redact the secrets of the printed config")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
@@ -37,35 +51,65 @@ func initFlags() {
 	}
 }
 
-func main() {
-	initFlags()
-
-	var config *util.Config
-	configFilePath := viper.GetString(flagConfigPath)
-	awsSecretKey := viper.GetString(flagConfigAwsSecretKey)
-	awsRegion := viper.GetString(flagConfigAwsRegion)
+// loadConfig layers the config from the file, the aws secret, the environment and the flags
+func loadConfig() (*util.Config, error) {
+	overrides, err := pflag.CommandLine.GetStringArray(flagConfigSet)
+	if err != nil {
+		return nil, err
+	}
+	return util.LoadConfig(util.ConfigSources{
+		FilePath:     viper.GetString(flagConfigPath),
+		AwsSecretKey: viper.GetString(flagConfigAwsSecretKey),
+		AwsRegion:    viper.GetString(flagConfigAwsRegion),
+		Environ:      os.Environ(),
+		Overrides:    overrides,
+	})
+}
 
-	if configFilePath == "" {
-		panic("config-path can't be empty")
+// printConfig prints the effective config, and the validation errors to stderr
+func printConfig(config *util.Config) {
+	printed := config
+	if viper.GetBool(flagRedacted) {
+		printed = config.Redacted()
+	}
+	bz, err := json.MarshalIndent(printed, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal config error, err=%s\n", err.Error())
+		os.Exit(1)
 	}
+	fmt.Println(string(bz))
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config, err=%s\n", err.Error())
+		os.Exit(1)
+	}
+}
 
-	config = util.ParseConfigFromFile(configFilePath)
+func main() {
+	initFlags()
 
-	if awsSecretKey != "" && awsRegion != "" {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config error, err=%s\n", err.Error())
+		os.Exit(1)
+	}
 
-		configContent, err := util.GetSecret(awsSecretKey, awsRegion)
-		if err != nil {
-			fmt.Printf("get aws config error, err=%s", err.Error())
-			return
-		}
-		config = util.ParseConfigFromJson(configContent, config)
+	switch args := pflag.Args(); {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		printConfig(config)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s, only config print supported\n", strings.Join(args, " "))
+		os.Exit(1)
 	}
 
-	config.Validate()
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config, err=%s\n", err.Error())
+		os.Exit(1)
+	}
 
 	// init logger
 	util.InitLogger(*config.LogConfig)
-	util.InitTgAlerter(config.AlertConfig)
 
 	reconDb, err := gorm.Open(config.StatasDBConfig.Dialect, config.StatasDBConfig.DBPath)
 	if err != nil {
@@ -73,20 +117,97 @@ func main() {
 	}
 	defer reconDb.Close()
 
-	reconDb.AutoMigrate(&model.TxEventLog{}, &model.BlockLog{})
+	reconDb.AutoMigrate(model.Tables...)
 
-	bscExecutor := executor.NewExecutor(config.ChainConfig.BSCProvider, config.ChainConfig.SwapFactory)
-
-	bscObserver := observer.NewObserver(reconDb, config, bscExecutor)
+	if err := model.TagLegacyRows(reconDb, config.ChainConfigs[0].Name); err != nil {
+		panic(fmt.Sprintf("tag legacy rows error, err=%s", err.Error()))
+	}
 
-	reconSvc := statas.NewStatasSvc(reconDb, config, bscExecutor)
-	reconSvc.Start()
-	bscExecutor.SetInfoQuery(reconSvc)
-	bscExecutor.Start()
-	bscObserver.Start()
+	bus := pubsub.NewBus(config.ServerConfig.StreamBufferSize, config.ServerConfig.StreamMaxDropped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chains := make([]*server.Chain, 0, len(config.ChainConfigs))
+	clients := make([]*executor.RetryClient, 0, len(config.ChainConfigs))
+	routines := make([]waiter, 0, 4*len(config.ChainConfigs)+2)
+	alerts := alert.NewManager(config.AlertConfig)
+	configReloader := newReloader(config, viper.GetString(flagConfigPath), alerts)
+	for _, chainConfig := range config.ChainConfigs {
+		// the client dials on first use, the chain serves stale data while its provider is down
+		client := executor.NewRetryClient(chainConfig.Provider, executor.NewRetryPolicy(chainConfig.RetrySetting()))
+		clients = append(clients, client)
+		chainExecutor := executor.NewExecutor(chainConfig, client)
+
+		chainObserver := observer.NewObserver(reconDb, config, chainConfig, chainExecutor)
+		chainObserver.SetBus(bus)
+
+		reconSvc := statas.NewStatasSvc(reconDb, config, chainConfig, client, chainExecutor)
+		reconSvc.SetBus(bus)
+		reconSvc.Start(ctx)
+		chainExecutor.SetInfoQuery(reconSvc)
+		chainExecutor.Start(ctx)
+		chainObserver.Start(ctx)
+
+		whales := statas.NewWhaleWatcher(reconSvc, bus, alerts, config.AlertConfig)
+		whales.Start(ctx)
+
+		chains = append(chains, server.NewChain(reconSvc, chainObserver))
+		routines = append(routines, reconSvc, chainExecutor, chainObserver, whales)
+		configReloader.add(reconSvc, whales)
+		alerts.AddRules(alert.ChainRules(chainConfig.Name, chainObserver, reconSvc, client)...)
+	}
+	alerts.Start(ctx)
+	configReloader.Start(ctx)
+	routines = append(routines, configReloader, alerts)
 
-	server := server.NewServer(config, reconSvc)
+	server := server.NewServer(config, chains, bus)
 	go server.Serve()
-	select {}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	util.Logger.Infof("received signal %s, shutting down", sig.String())
+
+	timeout := common.DefaultShutdownTimeout
+	if config.ServerConfig.ShutdownTimeout > 0 {
+		timeout = time.Duration(config.ServerConfig.ShutdownTimeout) * time.Second
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+	defer shutdownCancel()
+
+	// stop fetching and refreshing first, the blocks being saved are committed before the routines return
+	cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		util.Logger.Errorf("shutdown server error, err=%s", err.Error())
+	}
+	if !waitAll(shutdownCtx, routines) {
+		util.Logger.Errorf("routines not stopped in %s, exit anyway", timeout.String())
+		os.Exit(1)
+	}
+	for _, client := range clients {
+		client.Close()
+	}
+	util.Logger.Infof("shutdown completed")
+}
+
+type waiter interface {
+	Wait()
 }
 
+// waitAll waits for all routines to stop, it returns false if the context is done before
+func waitAll(ctx context.Context, routines []waiter) bool {
+	done := make(chan struct{})
+	go func() {
+		for _, routine := range routines {
+			routine.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,250 @@
+package model
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TokenTransferLog is a Transfer event of a token whose holders are tracked, Value is in token units. RawValue
+// is the value in the smallest unit of the token as a decimal string, empty for the transfers indexed before it
+// was kept, and Decimals the decimals of the token when the transfer was indexed.
+type TokenTransferLog struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Chain           string  `gorm:"not null;default:'';index:token_transfer_chain"`
+	ContractAddress string  `gorm:"not null;index:token_transfer_contract_addr"`
+	FromAddress     string  `gorm:"not null;index:token_transfer_from"`
+	ToAddress       string  `gorm:"not null;index:token_transfer_to"`
+	Value           float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	RawValue        string  `gorm:"not null;default:''"`
+	Decimals        uint8   `gorm:"not null;default:0"`
+
+	Status       TxStatus `gorm:"not null;index:token_transfer_status"`
+	TxHash       string   `gorm:"not null;index:token_transfer_tx_hash"`
+	BlockHash    string   `gorm:"not null"`
+	BlockTime    int64    `gorm:"not null"`
+	Height       int64    `gorm:"not null;index:token_transfer_height"`
+	ConfirmedNum int64
+}
+
+func (TokenTransferLog) TableName() string {
+	return "token_transfer_log"
+}
+
+func (l *TokenTransferLog) BeforeCreate() (err error) {
+	l.CreatedAt = time.Now()
+	l.ContractAddress = strings.ToLower(l.ContractAddress)
+	l.FromAddress = strings.ToLower(l.FromAddress)
+	l.ToAddress = strings.ToLower(l.ToAddress)
+	l.TxHash = strings.ToLower(l.TxHash)
+	l.BlockHash = strings.ToLower(l.BlockHash)
+	return nil
+}
+
+// TokenHolder is the confirmed balance of a holder of a tracked token, a holder keeps its row at a zero balance.
+// The transfers are summed in RawBalance, the balance in the smallest unit of the token as a decimal string,
+// and Balance is it in token units by the decimals of the last transfer, kept for ordering and serving.
+type TokenHolder struct {
+	ID        uint      `gorm:"primary_key"`
+	UpdatedAt time.Time `gorm:"not null"`
+
+	Chain       string  `gorm:"not null;default:'';unique_index:token_holder_chain_token_holder"`
+	Token       string  `gorm:"not null;unique_index:token_holder_chain_token_holder"`
+	Holder      string  `gorm:"not null;unique_index:token_holder_chain_token_holder;index:token_holder_holder"`
+	Balance     float64 `gorm:"not null;index:token_holder_balance" sql:"type:decimal(38,18);"`
+	RawBalance  string  `gorm:"not null;default:''"`
+	Decimals    uint8   `gorm:"not null;default:0"`
+	FirstHeight int64
+	LastHeight  int64
+}
+
+func (TokenHolder) TableName() string {
+	return "token_holder"
+}
+
+// TokenHolderCount is the number of holders of a token at the end of a day, days without transfers have no row
+type TokenHolderCount struct {
+	ID uint `gorm:"primary_key"`
+
+	Chain string `gorm:"not null;default:'';unique_index:token_holder_count_chain_token_date"`
+	Token string `gorm:"not null;unique_index:token_holder_count_chain_token_date"`
+	// Date is the unix time of the start of the day in utc
+	Date    int64 `gorm:"not null;unique_index:token_holder_count_chain_token_date"`
+	Holders int64 `gorm:"not null"`
+}
+
+func (TokenHolderCount) TableName() string {
+	return "token_holder_count"
+}
+
+// apply adds the transfer to the balance of the holder, sign is 1 for incoming and -1 for outgoing. A holder
+// or a transfer saved before the raw values were kept is converted to raw units by the decimals of the other.
+func (h *TokenHolder) apply(transfer *TokenTransferLog, sign int) {
+	decimals, known := transfer.Decimals, transfer.RawValue != ""
+	if !known && h.RawBalance != "" {
+		decimals, known = h.Decimals, true
+	}
+	if known {
+		balance := toRaw(h.RawBalance, h.Balance, decimals)
+		value := toRaw(transfer.RawValue, transfer.Value, decimals)
+		if sign < 0 {
+			value.Neg(value)
+		}
+		balance.Add(balance, value)
+		h.RawBalance, h.Decimals = balance.String(), decimals
+		h.Balance = fromRaw(balance, decimals)
+	} else {
+		// neither knows the raw units, the float balance is kept as before
+		h.Balance += float64(sign) * transfer.Value
+		if h.Balance > 0 && h.Balance < transfer.Value*1e-12 {
+			h.Balance = 0
+		}
+	}
+	if h.FirstHeight == 0 {
+		h.FirstHeight = transfer.Height
+	}
+	h.LastHeight = transfer.Height
+}
+
+// toRaw returns the raw amount, or the amount in token units converted when the raw one is unknown
+func toRaw(raw string, amount float64, decimals uint8) *big.Int {
+	if value, ok := new(big.Int).SetString(raw, 10); ok {
+		return value
+	}
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	value, _ := new(big.Float).Mul(big.NewFloat(amount), scale).Int(nil)
+	return value
+}
+
+// fromRaw returns the raw amount in token units
+func fromRaw(raw *big.Int, decimals uint8) float64 {
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(raw), scale).Float64()
+	return value
+}
+
+// ConfirmTokenTransfers applies the token transfers which reach the confirm number to the holders, marks
+// them confirmed and counts the holders of the tokens transferred on the day of the last transfer, so the
+// holders never include transfers which may be reverted by a fork. The transfers which take their sender
+// below zero are returned, the token was indexed from after the sender was given its balance.
+func ConfirmTokenTransfers(db *gorm.DB, chain string, confirmNum int64) ([]TokenTransferLog, error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	transfers := make([]TokenTransferLog, 0)
+	if err := tx.Where("chain = ? and status = ? and confirmed_num >= ?", chain, TxStatusInit, confirmNum).
+		Order("height asc, id asc").Find(&transfers).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	lastTransferAt := make(map[string]int64, 0)
+	overdrawn := make([]TokenTransferLog, 0)
+	for idx := range transfers {
+		transfer := &transfers[idx]
+		for _, side := range []struct {
+			holder string
+			sign   int
+		}{{transfer.FromAddress, -1}, {transfer.ToAddress, 1}} {
+			if side.holder == ZeroAddress {
+				continue
+			}
+			holder := TokenHolder{}
+			if err := tx.Where(TokenHolder{Chain: chain, Token: transfer.ContractAddress, Holder: side.holder}).
+				FirstOrInit(&holder).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			holder.apply(transfer, side.sign)
+			if side.sign < 0 && holder.Balance < 0 {
+				overdrawn = append(overdrawn, *transfer)
+			}
+			if err := tx.Save(&holder).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+		if err := tx.Model(transfer).Update("status", TxStatusConfirmed).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		lastTransferAt[transfer.ContractAddress] = transfer.BlockTime
+	}
+
+	for token, blockTime := range lastTransferAt {
+		var holders int64
+		if err := tx.Model(TokenHolder{}).Where("chain = ? and token = ? and balance > 0", chain, token).
+			Count(&holders).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		count := TokenHolderCount{}
+		if err := tx.Where(TokenHolderCount{Chain: chain, Token: token, Date: blockTime - blockTime%86400}).
+			FirstOrInit(&count).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		count.Holders = holders
+		if err := tx.Save(&count).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return overdrawn, nil
+}
+
+// GetTopTokenHolders returns the holders of the token with the largest balances
+func GetTopTokenHolders(db *gorm.DB, chain, token string, limit int) ([]TokenHolder, error) {
+	holders := make([]TokenHolder, 0)
+	err := db.Where("chain = ? and token = ? and balance > 0", chain, strings.ToLower(token)).
+		Order("balance desc").Limit(limit).Find(&holders).Error
+	return holders, err
+}
+
+// HasOverdrawnTokenHolders returns whether a holder of the token sent more than it was given by the
+// indexed transfers, so the balances of the token are partial
+func HasOverdrawnTokenHolders(db *gorm.DB, chain, token string) (bool, error) {
+	var overdrawn int64
+	err := db.Model(TokenHolder{}).Where("chain = ? and token = ? and balance < 0", chain, strings.ToLower(token)).
+		Count(&overdrawn).Error
+	return overdrawn > 0, err
+}
+
+// GetTokenHolderBalances returns the balances of all holders of the token
+func GetTokenHolderBalances(db *gorm.DB, chain, token string) ([]float64, error) {
+	balances := make([]float64, 0)
+	err := db.Model(TokenHolder{}).Where("chain = ? and token = ? and balance > 0", chain, strings.ToLower(token)).
+		Pluck("balance", &balances).Error
+	return balances, err
+}
+
+// GetTokenHolderCounts returns the daily holder counts of the token from the given day, oldest first. The
+// count of the last day before it is included, so the first days without transfers can be filled.
+func GetTokenHolderCounts(db *gorm.DB, chain, token string, since int64) ([]TokenHolderCount, error) {
+	token = strings.ToLower(token)
+	counts := make([]TokenHolderCount, 0)
+	before := TokenHolderCount{}
+	err := db.Where("chain = ? and token = ? and date < ?", chain, token, since).Order("date desc").First(&before).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == nil {
+		counts = append(counts, before)
+	}
+	after := make([]TokenHolderCount, 0)
+	if err := db.Where("chain = ? and token = ? and date >= ?", chain, token, since).
+		Order("date asc").Find(&after).Error; err != nil {
+		return nil, err
+	}
+	return append(counts, after...), nil
+}
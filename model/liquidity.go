@@ -0,0 +1,182 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ZeroAddress = "0x0000000000000000000000000000000000000000"
+)
+
+// LpTransferLog is a Transfer event of a swap pair's lp token. Amount0, Amount1 and ValueUSD are
+// the underlying token amounts and the USD value of the transferred lp tokens at their block, they
+// are zero and EntryUnknown is set when the state or the prices of that block could not be read.
+type LpTransferLog struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Chain           string  `gorm:"not null;default:'';index:lp_transfer_chain"`
+	ContractAddress string  `gorm:"not null;index:lp_transfer_contract_addr"`
+	FromAddress     string  `gorm:"not null;index:lp_transfer_from"`
+	ToAddress       string  `gorm:"not null;index:lp_transfer_to"`
+	Value           float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Amount0         float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Amount1         float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	ValueUSD        float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	EntryUnknown    bool    `gorm:"not null;default:false"`
+
+	Status       TxStatus `gorm:"not null;index:lp_transfer_status"`
+	TxHash       string   `gorm:"not null;index:lp_transfer_tx_hash"`
+	BlockHash    string   `gorm:"not null"`
+	BlockTime    int64    `gorm:"not null"`
+	Height       int64    `gorm:"not null;index:lp_transfer_height"`
+	ConfirmedNum int64
+}
+
+func (LpTransferLog) TableName() string {
+	return "lp_transfer_log"
+}
+
+func (l *LpTransferLog) BeforeCreate() (err error) {
+	l.CreatedAt = time.Now()
+	l.ContractAddress = strings.ToLower(l.ContractAddress)
+	l.FromAddress = strings.ToLower(l.FromAddress)
+	l.ToAddress = strings.ToLower(l.ToAddress)
+	l.TxHash = strings.ToLower(l.TxHash)
+	l.BlockHash = strings.ToLower(l.BlockHash)
+	return nil
+}
+
+// LpPosition is the confirmed lp balance of a wallet in a swap pair. The entry amounts and value
+// are the cost basis of the balance, they grow with incoming transfers and shrink pro rata with
+// outgoing ones. EntryUnknown is set once an incoming transfer could not be valued, until the
+// position is closed.
+type LpPosition struct {
+	ID        uint      `gorm:"primary_key"`
+	UpdatedAt time.Time `gorm:"not null"`
+
+	Chain           string  `gorm:"not null;default:'';unique_index:lp_position_chain_pair_wallet"`
+	ContractAddress string  `gorm:"not null;unique_index:lp_position_chain_pair_wallet"`
+	Wallet          string  `gorm:"not null;unique_index:lp_position_chain_pair_wallet;index:lp_position_wallet"`
+	Balance         float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	EntryAmount0    float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	EntryAmount1    float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	EntryValueUSD   float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	EntryUnknown    bool    `gorm:"not null;default:false"`
+	FirstHeight     int64
+	LastHeight      int64
+}
+
+func (LpPosition) TableName() string {
+	return "lp_position"
+}
+
+// apply adds the transfer to the position of the wallet, sign is 1 for incoming and -1 for outgoing
+func (p *LpPosition) apply(transfer *LpTransferLog, sign float64) {
+	if sign > 0 {
+		p.EntryAmount0 += transfer.Amount0
+		p.EntryAmount1 += transfer.Amount1
+		p.EntryValueUSD += transfer.ValueUSD
+		p.EntryUnknown = p.EntryUnknown || transfer.EntryUnknown
+		p.Balance += transfer.Value
+	} else {
+		if p.Balance > 0 {
+			ratio := transfer.Value / p.Balance
+			if ratio >= 1 {
+				ratio = 1
+				p.EntryUnknown = false
+			}
+			p.EntryAmount0 -= p.EntryAmount0 * ratio
+			p.EntryAmount1 -= p.EntryAmount1 * ratio
+			p.EntryValueUSD -= p.EntryValueUSD * ratio
+		}
+		p.Balance -= transfer.Value
+	}
+	if p.FirstHeight == 0 {
+		p.FirstHeight = transfer.Height
+	}
+	p.LastHeight = transfer.Height
+}
+
+// ConfirmLpTransfers applies the lp transfers which reach the confirm number to the positions and
+// marks them confirmed, so positions never include transfers which may be reverted by a fork
+func ConfirmLpTransfers(db *gorm.DB, chain string, confirmNum int64) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	transfers := make([]LpTransferLog, 0)
+	if err := tx.Where("chain = ? and status = ? and confirmed_num >= ?", chain, TxStatusInit, confirmNum).
+		Order("height asc, id asc").Find(&transfers).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for idx := range transfers {
+		transfer := &transfers[idx]
+		for _, side := range []struct {
+			wallet string
+			sign   float64
+		}{{transfer.FromAddress, -1}, {transfer.ToAddress, 1}} {
+			if side.wallet == ZeroAddress {
+				continue
+			}
+			position := LpPosition{}
+			if err := tx.Where(LpPosition{Chain: chain, ContractAddress: transfer.ContractAddress, Wallet: side.wallet}).
+				FirstOrInit(&position).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			position.apply(transfer, side.sign)
+			if err := tx.Save(&position).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Model(transfer).Update("status", TxStatusConfirmed).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// GetWalletLpPositions returns the positions of the wallet on the chain, including transfers not confirmed yet
+func GetWalletLpPositions(db *gorm.DB, chain, wallet string) ([]LpPosition, error) {
+	wallet = strings.ToLower(wallet)
+	positions := make([]LpPosition, 0)
+	if err := db.Where("chain = ? and wallet = ?", chain, wallet).Find(&positions).Error; err != nil {
+		return nil, err
+	}
+
+	pending := make([]LpTransferLog, 0)
+	if err := db.Where("chain = ? and status = ? and (from_address = ? or to_address = ?)", chain, TxStatusInit, wallet, wallet).
+		Order("height asc, id asc").Find(&pending).Error; err != nil {
+		return nil, err
+	}
+	for idx := range pending {
+		transfer := &pending[idx]
+		var position *LpPosition
+		for i := range positions {
+			if positions[i].ContractAddress == transfer.ContractAddress {
+				position = &positions[i]
+			}
+		}
+		if position == nil {
+			positions = append(positions, LpPosition{Chain: chain, ContractAddress: transfer.ContractAddress, Wallet: wallet})
+			position = &positions[len(positions)-1]
+		}
+		if transfer.FromAddress == wallet {
+			position.apply(transfer, -1)
+		}
+		if transfer.ToAddress == wallet {
+			position.apply(transfer, 1)
+		}
+	}
+	return positions, nil
+}
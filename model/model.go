@@ -9,7 +9,7 @@ import (
 
 type BlockLog struct {
 	Id         int64
-	Chain      string
+	Chain      string `gorm:"not null;default:'';index:block_chain"`
 	BlockHash  string `gorm:"not null;index:block_hash"`
 	ParentHash string `gorm:"not null;index:block_parent_hash"`
 	Height     int64  `gorm:"not null;index:block_height"`
@@ -37,9 +37,19 @@ type TxEventLog struct {
 	ID        uint      `gorm:"primary_key"`
 	CreatedAt time.Time `gorm:"not null"`
 
+	Chain           string  `gorm:"not null;default:'';index:tx_event_chain"`
 	ContractAddress string  `gorm:"not null;index:tx_event_contract_addr"`
 	Amount0         float64 `gorm:"not null" sql:"type:decimal(28,18);"`
 	Amount1         float64 `gorm:"not null" sql:"type:decimal(28,18);"`
+	// Sender is the caller of the swap, usually a router, and To the receiver of the output
+	Sender string `gorm:"not null;default:''"`
+	To     string `gorm:"not null;default:''"`
+	// Origin is the account which sent the transaction of the swap, the trader
+	Origin string `gorm:"not null;default:'';index:tx_event_origin"`
+	// LogIndex orders the swaps of a transaction, Token0In is whether token0 went into the pair. Both are
+	// unknown for the swaps indexed before them, whose LogIndex is null.
+	LogIndex *uint `gorm:"default:null"`
+	Token0In bool  `gorm:"not null;default:false"`
 
 	Status       TxStatus `gorm:"not null;index:tx_event_status"`
 	TxHash       string   `gorm:"not null;index:tx_event_tx_hash"`
@@ -56,6 +66,53 @@ func (TxEventLog) TableName() string {
 func (l *TxEventLog) BeforeCreate() (err error) {
 	l.CreatedAt = time.Now()
 	l.ContractAddress = strings.ToLower(l.ContractAddress)
+	l.Sender = strings.ToLower(l.Sender)
+	l.To = strings.ToLower(l.To)
+	l.Origin = strings.ToLower(l.Origin)
+	l.TxHash = strings.ToLower(l.TxHash)
+	l.BlockHash = strings.ToLower(l.BlockHash)
+	return nil
+}
+
+type LiquidityEventType int
+
+const (
+	LiquidityEventMint LiquidityEventType = 1
+	LiquidityEventBurn LiquidityEventType = 2
+)
+
+type LiquidityEventLog struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Chain           string             `gorm:"not null;default:'';index:liquidity_event_chain"`
+	ContractAddress string             `gorm:"not null;index:liquidity_event_contract_addr"`
+	EventType       LiquidityEventType `gorm:"not null"`
+	Sender          string             `gorm:"not null"`
+	To              string
+	Amount0         float64 `gorm:"not null" sql:"type:decimal(28,18);"`
+	Amount1         float64 `gorm:"not null" sql:"type:decimal(28,18);"`
+	// Origin is the account which sent the transaction of the mint or burn, the provider of the liquidity
+	Origin string `gorm:"not null;default:'';index:liquidity_event_origin"`
+
+	Status       TxStatus `gorm:"not null;index:liquidity_event_status"`
+	TxHash       string   `gorm:"not null;index:liquidity_event_tx_hash"`
+	BlockHash    string   `gorm:"not null"`
+	BlockTime    int64    `gorm:"not null;index:liquidity_event_block_time"`
+	Height       int64    `gorm:"not null;index:liquidity_event_tx_height"`
+	ConfirmedNum int64
+}
+
+func (LiquidityEventLog) TableName() string {
+	return "liquidity_event_log"
+}
+
+func (l *LiquidityEventLog) BeforeCreate() (err error) {
+	l.CreatedAt = time.Now()
+	l.ContractAddress = strings.ToLower(l.ContractAddress)
+	l.Sender = strings.ToLower(l.Sender)
+	l.To = strings.ToLower(l.To)
+	l.Origin = strings.ToLower(l.Origin)
 	l.TxHash = strings.ToLower(l.TxHash)
 	l.BlockHash = strings.ToLower(l.BlockHash)
 	return nil
@@ -67,13 +124,143 @@ type Result24Hour struct {
 	TotalAmount1    float64
 }
 
-func GetLast24HourTotalAccount(db *gorm.DB) ([]Result24Hour, error) {
+func GetLast24HourTotalAccount(db *gorm.DB, chain string) ([]Result24Hour, error) {
+	blockTime, err := GetLatestBlockTime(db, chain)
+	if err != nil {
+		return nil, err
+	}
+	return GetTotalAccountSince(db, chain, blockTime-time.Duration(24*time.Hour).Milliseconds()/1000)
+}
+
+// GetLatestBlockTime returns the block time of the highest block log of the chain, 0 if there is none
+func GetLatestBlockTime(db *gorm.DB, chain string) (int64, error) {
+	blockLog := BlockLog{}
+	err := db.Where("chain = ?", chain).Order("height desc").First(&blockLog).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	return blockLog.BlockTime, nil
+}
+
+// GetBlockHash returns the hash of the block log of the chain at the height, empty if there is none
+func GetBlockHash(db *gorm.DB, chain string, height int64) (string, error) {
+	blockLog := BlockLog{}
+	err := db.Where("chain = ? and height = ?", chain, height).First(&blockLog).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+	return blockLog.BlockHash, nil
+}
+
+// GetLatestHeight returns the height of the highest block log of the chain, 0 if there is none
+func GetLatestHeight(db *gorm.DB, chain string) (int64, error) {
 	blockLog := BlockLog{}
-	err := db.Order("height desc").First(&blockLog).Error
+	err := db.Where("chain = ?", chain).Order("height desc").First(&blockLog).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	return blockLog.Height, nil
+}
+
+// GetLatestSwap returns the latest swap of the pair, nil if there is none
+func GetLatestSwap(db *gorm.DB, chain, contractAddress string) (*TxEventLog, error) {
+	swap := TxEventLog{}
+	err := db.Where("chain = ? and contract_address = ?", chain, strings.ToLower(contractAddress)).Order("id desc").First(&swap).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
+	return &swap, nil
+}
+
+// GetMultiSwapTxs returns the swaps after the given block time of the transactions with more than one swap,
+// ordered by transaction and log index
+func GetMultiSwapTxs(db *gorm.DB, chain string, blockTime int64) ([]TxEventLog, error) {
+	swaps := make([]TxEventLog, 0)
+	multi := db.Table("tx_event_log").Select("tx_hash").Where("chain = ? and block_time > ?", chain, blockTime).
+		Group("tx_hash").Having("count(*) > 1").SubQuery()
+	err := db.Where("chain = ? and block_time > ? and tx_hash in ?", chain, blockTime, multi).
+		Order("tx_hash asc, log_index asc, id asc").Find(&swaps).Error
+	return swaps, err
+}
+
+// GetSwapsSince returns the swaps after the given block time ordered by height, transaction and log index
+func GetSwapsSince(db *gorm.DB, chain string, blockTime int64) ([]TxEventLog, error) {
+	swaps := make([]TxEventLog, 0)
+	err := db.Where("chain = ? and block_time > ?", chain, blockTime).Order("height asc, tx_hash asc, log_index asc, id asc").
+		Find(&swaps).Error
+	return swaps, err
+}
+
+// GetLatestTxSwaps returns the swaps of the latest transactions with swaps, or of the given transaction,
+// ordered by transaction and log index
+func GetLatestTxSwaps(db *gorm.DB, chain, txHash string, limit int) ([]TxEventLog, error) {
+	swaps := make([]TxEventLog, 0)
+	if txHash != "" {
+		err := db.Where("chain = ? and tx_hash = ?", chain, strings.ToLower(txHash)).Order("log_index asc, id asc").Find(&swaps).Error
+		return swaps, err
+	}
+	latest := make([]struct{ TxHash string }, 0)
+	if err := db.Table("tx_event_log").Select("tx_hash, max(id) as last_id").Where("chain = ?", chain).
+		Group("tx_hash").Order("last_id desc").Limit(limit).Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+	txHashes := make([]string, 0, len(latest))
+	for _, tx := range latest {
+		txHashes = append(txHashes, tx.TxHash)
+	}
+	err := db.Where("chain = ? and tx_hash in (?)", chain, txHashes).Order("tx_hash asc, log_index asc, id asc").Find(&swaps).Error
+	return swaps, err
+}
+
+// GetTotalAccountSince returns the swap amounts of every pair after the given block time
+func GetTotalAccountSince(db *gorm.DB, chain string, blockTime int64) ([]Result24Hour, error) {
 	res := make([]Result24Hour, 0)
-	dbIns := db.Table("tx_event_log").Select("contract_address, sum(amount0) as total_amount0, sum(amount1) as total_amount1").Group("contract_address").Where("block_time > ?", blockLog.BlockTime-time.Duration(24*time.Hour).Milliseconds()/1000).Find(&res)
+	dbIns := db.Table("tx_event_log").Select("contract_address, sum(amount0) as total_amount0, sum(amount1) as total_amount1").Group("contract_address").Where("chain = ? and block_time > ?", chain, blockTime).Find(&res)
 	return res, dbIns.Error
 }
+
+type PairDayData struct {
+	ContractAddress string
+	Date            int64
+	TotalAmount0    float64
+	TotalAmount1    float64
+	TxCount         int64
+}
+
+// GetPairDayData returns the daily swap volume of the pair, latest day first. Days are
+// only available as far back as tx_event_log is kept.
+func GetPairDayData(db *gorm.DB, chain, contractAddress string, limit, offset int) ([]PairDayData, error) {
+	res := make([]PairDayData, 0)
+	dbIns := db.Table("tx_event_log").
+		Select("contract_address, (block_time - block_time % 86400) as date, sum(amount0) as total_amount0, sum(amount1) as total_amount1, count(*) as tx_count").
+		Where("chain = ? and contract_address = ?", chain, strings.ToLower(contractAddress)).
+		Group("contract_address, date").Order("date desc").Limit(limit).Offset(offset).Find(&res)
+	return res, dbIns.Error
+}
+
+// Tables are all the tables of the statas db, migrated at startup
+var Tables = []interface{}{&TxEventLog{}, &LiquidityEventLog{}, &LpTransferLog{}, &LpPosition{}, &PairSnapshot{}, &PriceQuarantineLog{}, &Token{}, &TokenSupplySnapshot{}, &TokenTransferLog{}, &TokenHolder{}, &TokenHolderCount{}, &TraderDay{}, &Trader{}, &UserStatDay{}, &UserCohort{}, &BlockLog{}}
+
+// chainTables are the tables whose rows are tagged with the chain they are indexed from. The tag is the
+// name of the chain in the config, which every query and ?chain= select by, the chain id is optional in
+// the config and legacy rows are tagged before any provider is asked for it.
+var chainTables = []interface{}{BlockLog{}, TxEventLog{}, LiquidityEventLog{}, LpTransferLog{}, LpPosition{}, PairSnapshot{}}
+
+// TagLegacyRows tags the rows indexed before multi chain support with the given chain, and drops the
+// lp position index which did not include the chain
+func TagLegacyRows(db *gorm.DB, chain string) error {
+	if db.Dialect().HasIndex(LpPosition{}.TableName(), "lp_position_pair_wallet") {
+		if err := db.Model(LpPosition{}).RemoveIndex("lp_position_pair_wallet").Error; err != nil {
+			return err
+		}
+	}
+	for _, table := range chainTables {
+		if err := db.Model(table).Where("chain = ? or chain is null", "").Update("chain", chain).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// QuarantineActionQuarantine serves the last good price instead of the suspicious one
+	QuarantineActionQuarantine = "quarantine"
+	// QuarantineActionRelease serves the price again since it is back to normal
+	QuarantineActionRelease = "release"
+	// QuarantineActionAccept serves the suspicious price since it persisted
+	QuarantineActionAccept = "accept"
+)
+
+// PriceQuarantineLog records a decision of the price guard on a token price, kept for audit
+type PriceQuarantineLog struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Chain  string `gorm:"not null;default:'';index:price_quarantine_chain"`
+	Symbol string `gorm:"not null;index:price_quarantine_symbol"`
+	Action string `gorm:"not null;default:''"`
+	// Reason lists the checks the price failed, comma separated
+	Reason string `gorm:"not null;default:''"`
+	// Price is the price of the refresh, LastPrice the last good one, Twap and CrossPrice the references
+	// it was checked against, 0 when there is none
+	Price      float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	LastPrice  float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Twap       float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	CrossPrice float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+}
+
+func (PriceQuarantineLog) TableName() string {
+	return "price_quarantine_log"
+}
+
+func (l *PriceQuarantineLog) BeforeCreate() (err error) {
+	l.CreatedAt = time.Now()
+	return nil
+}
+
+func SavePriceQuarantineLogs(db *gorm.DB, logs []*PriceQuarantineLog) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for _, log := range logs {
+		if err := tx.Create(log).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetPriceQuarantineLogs returns the latest decisions of the chain, newest first
+func GetPriceQuarantineLogs(db *gorm.DB, chain string, limit int) ([]PriceQuarantineLog, error) {
+	logs := make([]PriceQuarantineLog, 0)
+	err := db.Where("chain = ?", chain).Order("id desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
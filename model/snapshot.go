@@ -0,0 +1,60 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PairSnapshot is the state of a swap pair at a point of time, kept as reserve history
+type PairSnapshot struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Chain           string  `gorm:"not null;default:'';index:pair_snapshot_chain"`
+	ContractAddress string  `gorm:"not null;index:pair_snapshot_contract_addr"`
+	Reserve0        float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Reserve1        float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	LpSupply        float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Price           float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	ReserveUSD      float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	SnapshotTime    int64   `gorm:"not null;index:pair_snapshot_time"`
+}
+
+func (PairSnapshot) TableName() string {
+	return "pair_snapshot"
+}
+
+func (l *PairSnapshot) BeforeCreate() (err error) {
+	l.CreatedAt = time.Now()
+	l.ContractAddress = strings.ToLower(l.ContractAddress)
+	return nil
+}
+
+func SavePairSnapshots(db *gorm.DB, snapshots []*PairSnapshot) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		if err := tx.Create(snapshot).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetPairSnapshotsSince returns the snapshots of the pair after the given time, oldest first
+func GetPairSnapshotsSince(db *gorm.DB, chain, contractAddress string, since int64) ([]PairSnapshot, error) {
+	snapshots := make([]PairSnapshot, 0)
+	err := db.Where("chain = ? and contract_address = ? and snapshot_time >= ?", chain, strings.ToLower(contractAddress), since).
+		Order("snapshot_time asc").Find(&snapshots).Error
+	return snapshots, err
+}
+
+func PrunePairSnapshots(db *gorm.DB, chain string, before int64) error {
+	return db.Where("chain = ? and snapshot_time < ?", chain, before).Delete(PairSnapshot{}).Error
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TokenSupplySnapshot is the supply and valuation of a token on a day, the latest of the day is kept
+type TokenSupplySnapshot struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+
+	Chain  string `gorm:"not null;default:'';unique_index:token_supply_chain_token_date"`
+	Token  string `gorm:"not null;unique_index:token_supply_chain_token_date"`
+	Symbol string `gorm:"not null;default:''"`
+	// Date is the unix time of the start of the day in utc
+	Date              int64   `gorm:"not null;unique_index:token_supply_chain_token_date"`
+	TotalSupply       float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	CirculatingSupply float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	Price             float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	MarketCap         float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	FDV               float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+}
+
+func (TokenSupplySnapshot) TableName() string {
+	return "token_supply_snapshot"
+}
+
+func (s *TokenSupplySnapshot) BeforeSave() (err error) {
+	s.Token = strings.ToLower(s.Token)
+	return nil
+}
+
+// SaveTokenSupplySnapshots saves the snapshots, replacing those of the same tokens on the same days
+func SaveTokenSupplySnapshots(db *gorm.DB, snapshots []*TokenSupplySnapshot) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		existing := TokenSupplySnapshot{}
+		err := tx.Where("chain = ? and token = ? and date = ?", snapshot.Chain, strings.ToLower(snapshot.Token), snapshot.Date).
+			First(&existing).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return err
+		}
+		snapshot.ID, snapshot.CreatedAt = existing.ID, existing.CreatedAt
+		if err := tx.Save(snapshot).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetTokenSupplySnapshots returns the daily snapshots of the token from the given day, oldest first
+func GetTokenSupplySnapshots(db *gorm.DB, chain, token string, since int64) ([]TokenSupplySnapshot, error) {
+	snapshots := make([]TokenSupplySnapshot, 0)
+	err := db.Where("chain = ? and token = ? and date >= ?", chain, strings.ToLower(token), since).
+		Order("date asc").Find(&snapshots).Error
+	return snapshots, err
+}
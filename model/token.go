@@ -0,0 +1,66 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// TokenBehaviourFeeOnTransfer tokens deliver less than the amount transferred
+	TokenBehaviourFeeOnTransfer = "fee_on_transfer"
+	// TokenBehaviourRebasing tokens change the balances of the holders without transfers
+	TokenBehaviourRebasing = "rebasing"
+)
+
+// Token is the metadata of a token, read from the chain when it is first seen. Verified, Name and
+// LogoURI are set from the token lists, the symbol stays the one on chain since the prices are keyed by it.
+type Token struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+
+	Chain       string  `gorm:"not null;default:'';unique_index:token_chain_address"`
+	Address     string  `gorm:"not null;unique_index:token_chain_address"`
+	Symbol      string  `gorm:"not null;default:''"`
+	Name        string  `gorm:"not null;default:''"`
+	Decimals    uint8   `gorm:"not null"`
+	TotalSupply float64 `gorm:"not null" sql:"type:decimal(38,18);"`
+	// FirstSeenBlock is the indexed height when the token was first seen
+	FirstSeenBlock int64 `gorm:"not null"`
+	// Degraded lists the metadata which could not be read from the chain, comma separated, the
+	// address is the symbol, the decimals are the default and the total supply is 0 for them
+	Degraded string `gorm:"not null;default:''"`
+
+	// Behaviour is how the token departs from a plain erc20, empty if it does not, and TransferFee the share
+	// of a transfer taken by a fee on transfer token as detected. DetectedAt is the unix time it was last detected.
+	Behaviour   string  `gorm:"not null;default:''"`
+	TransferFee float64 `gorm:"not null;default:0"`
+	DetectedAt  int64   `gorm:"not null;default:0"`
+
+	Verified bool   `gorm:"not null;default:false"`
+	LogoURI  string `gorm:"not null;default:''"`
+	// ListName is the token list the token is verified by
+	ListName string `gorm:"not null;default:''"`
+}
+
+func (Token) TableName() string {
+	return "tokens"
+}
+
+func (t *Token) BeforeSave() (err error) {
+	t.Address = strings.ToLower(t.Address)
+	return nil
+}
+
+func SaveToken(db *gorm.DB, token *Token) error {
+	return db.Save(token).Error
+}
+
+// GetTokens returns the tokens of the chain in the order they were seen
+func GetTokens(db *gorm.DB, chain string) ([]Token, error) {
+	tokens := make([]Token, 0)
+	err := db.Where("chain = ?", chain).Order("id asc").Find(&tokens).Error
+	return tokens, err
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// ProtocolWide is the pair of the trader rows and the user stats of all pairs together
+const ProtocolWide = ""
+
+// TraderDay is the activity of a trader in a pair on a day. LastSwapID is the last swap counted, the
+// highest of all rows is where the next rollup continues.
+type TraderDay struct {
+	ID uint `gorm:"primary_key"`
+
+	Chain  string `gorm:"not null;default:'';unique_index:trader_day_chain_pair_trader_date"`
+	Pair   string `gorm:"not null;unique_index:trader_day_chain_pair_trader_date"`
+	Trader string `gorm:"not null;unique_index:trader_day_chain_pair_trader_date"`
+	// Date is the unix time of the start of the day in utc
+	Date       int64 `gorm:"not null;unique_index:trader_day_chain_pair_trader_date;index:trader_day_date"`
+	Swaps      int64 `gorm:"not null"`
+	LastSwapID uint  `gorm:"not null"`
+}
+
+func (TraderDay) TableName() string {
+	return "trader_day"
+}
+
+// Trader is the first day a trader traded in a pair, or in any pair for ProtocolWide
+type Trader struct {
+	ID uint `gorm:"primary_key"`
+
+	Chain     string `gorm:"not null;default:'';unique_index:trader_chain_pair_trader"`
+	Pair      string `gorm:"not null;unique_index:trader_chain_pair_trader"`
+	Trader    string `gorm:"not null;unique_index:trader_chain_pair_trader"`
+	FirstDate int64  `gorm:"not null;index:trader_first_date"`
+}
+
+func (Trader) TableName() string {
+	return "trader"
+}
+
+// UserStatDay are the active traders of a pair, or of all pairs for ProtocolWide, on a day and in the
+// week and the month to it. New traders traded for the first time that day, the others are returning.
+type UserStatDay struct {
+	ID uint `gorm:"primary_key"`
+
+	Chain          string `gorm:"not null;default:'';unique_index:user_stat_day_chain_pair_date"`
+	Pair           string `gorm:"not null;unique_index:user_stat_day_chain_pair_date"`
+	Date           int64  `gorm:"not null;unique_index:user_stat_day_chain_pair_date"`
+	DAU            int64  `gorm:"column:dau;not null"`
+	WAU            int64  `gorm:"column:wau;not null"`
+	MAU            int64  `gorm:"column:mau;not null"`
+	NewUsers       int64  `gorm:"not null"`
+	ReturningUsers int64  `gorm:"not null"`
+}
+
+func (UserStatDay) TableName() string {
+	return "user_stat_day"
+}
+
+// UserCohort are the traders first seen in the week of Cohort who traded Week weeks later, Week 0 is the
+// size of the cohort
+type UserCohort struct {
+	ID uint `gorm:"primary_key"`
+
+	Chain  string `gorm:"not null;default:'';unique_index:user_cohort_chain_pair_cohort_week"`
+	Pair   string `gorm:"not null;unique_index:user_cohort_chain_pair_cohort_week"`
+	Cohort int64  `gorm:"not null;unique_index:user_cohort_chain_pair_cohort_week"`
+	Week   int64  `gorm:"not null;unique_index:user_cohort_chain_pair_cohort_week"`
+	Users  int64  `gorm:"not null"`
+}
+
+func (UserCohort) TableName() string {
+	return "user_cohort"
+}
+
+// RollupTraders counts the confirmed swaps with a known origin not counted yet into the trader days and the
+// first days of the traders, at most limit swaps at a time. It returns the pairs and the earliest day
+// touched, the day is -1 when there was nothing to count.
+func RollupTraders(db *gorm.DB, chain string, limit int) (map[string]bool, int64, error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, -1, err
+	}
+
+	var cursor struct{ LastSwapID uint }
+	if err := tx.Model(TraderDay{}).Select("coalesce(max(last_swap_id), 0) as last_swap_id").Where("chain = ?", chain).
+		Scan(&cursor).Error; err != nil {
+		tx.Rollback()
+		return nil, -1, err
+	}
+	swaps := make([]TxEventLog, 0)
+	if err := tx.Where("chain = ? and status = ? and origin <> '' and id > ?", chain, TxStatusConfirmed, cursor.LastSwapID).
+		Order("id asc").Limit(limit).Find(&swaps).Error; err != nil {
+		tx.Rollback()
+		return nil, -1, err
+	}
+
+	pairs := make(map[string]bool, 0)
+	earliest := int64(-1)
+	for idx := range swaps {
+		swap := &swaps[idx]
+		date := swap.BlockTime - swap.BlockTime%86400
+		// the conditions are explicit, struct conditions skip the zero date and the protocol wide pair
+		day := TraderDay{Chain: chain, Pair: swap.ContractAddress, Trader: swap.Origin, Date: date}
+		err := tx.Where("chain = ? and pair = ? and trader = ? and date = ?", chain, swap.ContractAddress, swap.Origin, date).
+			First(&day).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return nil, -1, err
+		}
+		day.Swaps++
+		day.LastSwapID = swap.ID
+		if err := tx.Save(&day).Error; err != nil {
+			tx.Rollback()
+			return nil, -1, err
+		}
+		for _, pair := range []string{swap.ContractAddress, ProtocolWide} {
+			trader := Trader{Chain: chain, Pair: pair, Trader: swap.Origin}
+			err := tx.Where("chain = ? and pair = ? and trader = ?", chain, pair, swap.Origin).First(&trader).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				tx.Rollback()
+				return nil, -1, err
+			}
+			if trader.ID != 0 && trader.FirstDate <= date {
+				continue
+			}
+			trader.FirstDate = date
+			if err := tx.Save(&trader).Error; err != nil {
+				tx.Rollback()
+				return nil, -1, err
+			}
+		}
+		pairs[swap.ContractAddress] = true
+		if earliest < 0 || date < earliest {
+			earliest = date
+		}
+	}
+	return pairs, earliest, tx.Commit().Error
+}
+
+// GetLatestTraderDate returns the latest day with trades of the chain, -1 if there is none
+func GetLatestTraderDate(db *gorm.DB, chain string) (int64, error) {
+	day := TraderDay{}
+	err := db.Where("chain = ?", chain).Order("date desc").First(&day).Error
+	if err == gorm.ErrRecordNotFound {
+		return -1, nil
+	}
+	return day.Date, err
+}
+
+// CountActiveTraders counts the traders of the pair, or of all pairs for ProtocolWide, in [from, to)
+func CountActiveTraders(db *gorm.DB, chain, pair string, from, to int64) (int64, error) {
+	query := db.Model(TraderDay{}).Where("chain = ? and date >= ? and date < ?", chain, from, to)
+	if pair != ProtocolWide {
+		query = query.Where("pair = ?", pair)
+	}
+	var count struct{ Traders int64 }
+	err := query.Select("count(distinct trader) as traders").Scan(&count).Error
+	return count.Traders, err
+}
+
+// CountNewTraders counts the traders first seen in the pair, or in any pair for ProtocolWide, in [from, to)
+func CountNewTraders(db *gorm.DB, chain, pair string, from, to int64) (int64, error) {
+	var count int64
+	err := db.Model(Trader{}).Where("chain = ? and pair = ? and first_date >= ? and first_date < ?", chain, pair, from, to).
+		Count(&count).Error
+	return count, err
+}
+
+// TradersByFirstDate counts the traders of the pair, or of all pairs for ProtocolWide, active in [from, to)
+// by the day they were first seen
+func TradersByFirstDate(db *gorm.DB, chain, pair string, from, to int64) (map[int64]int64, error) {
+	query := db.Table("trader_day").Select("trader.first_date as first_date, count(distinct trader_day.trader) as traders").
+		Joins("join trader on trader.chain = trader_day.chain and trader.trader = trader_day.trader and trader.pair = ?", pair).
+		Where("trader_day.chain = ? and trader_day.date >= ? and trader_day.date < ?", chain, from, to)
+	if pair != ProtocolWide {
+		query = query.Where("trader_day.pair = ?", pair)
+	}
+	rows := make([]struct {
+		FirstDate int64
+		Traders   int64
+	}, 0)
+	if err := query.Group("trader.first_date").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		counts[row.FirstDate] = row.Traders
+	}
+	return counts, nil
+}
+
+// SaveUserStats saves the stats and the cohorts, replacing those of the same pairs on the same days and weeks
+func SaveUserStats(db *gorm.DB, stats []*UserStatDay, cohorts []*UserCohort) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for _, stat := range stats {
+		existing := UserStatDay{}
+		err := tx.Where("chain = ? and pair = ? and date = ?", stat.Chain, stat.Pair, stat.Date).First(&existing).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return err
+		}
+		stat.ID = existing.ID
+		if err := tx.Save(stat).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, cohort := range cohorts {
+		existing := UserCohort{}
+		err := tx.Where("chain = ? and pair = ? and cohort = ? and week = ?", cohort.Chain, cohort.Pair, cohort.Cohort, cohort.Week).
+			First(&existing).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return err
+		}
+		cohort.ID = existing.ID
+		if err := tx.Save(cohort).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetUserStatDays returns the stats of the pair, or of all pairs for ProtocolWide, from the given day, oldest first
+func GetUserStatDays(db *gorm.DB, chain, pair string, since int64) ([]UserStatDay, error) {
+	stats := make([]UserStatDay, 0)
+	err := db.Where("chain = ? and pair = ? and date >= ?", chain, pair, since).Order("date asc").Find(&stats).Error
+	return stats, err
+}
+
+// GetUserCohorts returns the cohorts of the pair, or of all pairs for ProtocolWide, from the given week
+func GetUserCohorts(db *gorm.DB, chain, pair string, since int64) ([]UserCohort, error) {
+	cohorts := make([]UserCohort, 0)
+	err := db.Where("chain = ? and pair = ? and cohort >= ?", chain, pair, since).Order("cohort asc, week asc").
+		Find(&cohorts).Error
+	return cohorts, err
+}
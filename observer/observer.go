@@ -1,8 +1,10 @@
 package observer
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -10,66 +12,107 @@ import (
 	"github.com/pieswap/pie-statas/common"
 	"github.com/pieswap/pie-statas/executor"
 	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/pubsub"
 	"github.com/pieswap/pie-statas/util"
 )
 
+// eventTables are the tables of chain events, which follow the reorg, confirmation and prune rules of the block logs
+var eventTables = []interface{}{model.TxEventLog{}, model.LiquidityEventLog{}, model.LpTransferLog{}, model.TokenTransferLog{}}
+
 type Observer struct {
+	Chain       string
 	StatasDB    *gorm.DB
 	StartHeight int64
 	ConfirmNum  int64
 
 	Config   *util.Config
 	Executor executor.Executor
+	Bus      *pubsub.Bus
 
 	FetchInterval time.Duration
+
+	wg sync.WaitGroup
+	// reorgMux guards the reorg in progress and the last reorg completed
+	reorgMux       sync.Mutex
+	reorgDepth     int64
+	lastReorgDepth int64
+	lastReorgAt    time.Time
 }
 
-// NewObserver returns the observer instance
-func NewObserver(stataDB *gorm.DB, cfg *util.Config, executor executor.Executor) *Observer {
+// NewObserver returns the observer instance of the chain
+func NewObserver(stataDB *gorm.DB, cfg *util.Config, chainConfig *util.ChainConfig, executor executor.Executor) *Observer {
 	return &Observer{
+		Chain:    chainConfig.Name,
 		StatasDB: stataDB,
 
-		StartHeight: cfg.ChainConfig.BSCStartHeight,
-		ConfirmNum:  cfg.ChainConfig.BSCConfirmNum,
+		StartHeight: chainConfig.StartHeight,
+		ConfirmNum:  chainConfig.ConfirmNum,
 
 		Config:        cfg,
-		FetchInterval: time.Duration(cfg.ChainConfig.BSCFetchInterval) * time.Millisecond,
+		FetchInterval: time.Duration(chainConfig.FetchInterval) * time.Millisecond,
 		Executor:      executor,
 	}
 }
 
-// Start starts the routines of observer
-func (ob *Observer) Start() {
-	go ob.Fetch(ob.StartHeight)
-	go ob.Prune()
-	go ob.Alert()
+// LastReorg returns the number of blocks rolled back by the last reorg and when it completed
+func (ob *Observer) LastReorg() (int64, time.Time) {
+	ob.reorgMux.Lock()
+	defer ob.reorgMux.Unlock()
+	return ob.lastReorgDepth, ob.lastReorgAt
 }
 
-// Fetch starts the main routine for fetching blocks of BSC
-func (ob *Observer) Fetch(startHeight int64) {
-	for {
-		curBlockLog, err := ob.GetCurrentBlockLog()
-		if err != nil {
-			util.Logger.Errorf("get current block log error, err=%s, sleep %d second", err.Error(), ob.FetchInterval.Seconds())
-			time.Sleep(ob.FetchInterval)
-			continue
-		}
+// SetBus sets the bus that committed blocks and trades are published to
+func (ob *Observer) SetBus(bus *pubsub.Bus) {
+	ob.Bus = bus
+}
 
-		nextHeight := curBlockLog.Height + 1
-		if curBlockLog.Height == 0 && startHeight != 0 {
-			nextHeight = startHeight
-		}
+// Start starts the routines of observer, they stop when the context is done
+func (ob *Observer) Start(ctx context.Context) {
+	ob.wg.Add(2)
+	go func() {
+		defer ob.wg.Done()
+		ob.Fetch(ctx, ob.StartHeight)
+	}()
+	go func() {
+		defer ob.wg.Done()
+		ob.Prune(ctx)
+	}()
+}
 
-		util.Logger.Infof("fetching block, height=%d", nextHeight)
-		err = ob.fetchBlock(curBlockLog.Height, nextHeight, curBlockLog.BlockHash)
+// Wait waits for the routines to stop, a block being saved is always committed before
+func (ob *Observer) Wait() {
+	ob.wg.Wait()
+}
+
+// Fetch starts the main routine for fetching blocks of the chain
+func (ob *Observer) Fetch(ctx context.Context, startHeight int64) {
+	for ctx.Err() == nil {
+		err := ob.FetchOnce(startHeight)
 		if err != nil {
-			util.Logger.Errorf("fetch block error, err=%s", err.Error())
-			time.Sleep(ob.FetchInterval)
+			util.Logger.Errorf("fetch block error, chain=%s, err=%s", ob.Chain, err.Error())
+			util.Sleep(ctx, ob.FetchInterval)
 		}
 	}
+	util.Logger.Infof("fetch stopped, chain=%s", ob.Chain)
+}
+
+// FetchOnce fetches the block next to the current block log, or deletes the current block on a fork
+func (ob *Observer) FetchOnce(startHeight int64) error {
+	curBlockLog, err := ob.GetCurrentBlockLog()
+	if err != nil {
+		return fmt.Errorf("get current block log error, err=%s", err.Error())
+	}
+
+	nextHeight := curBlockLog.Height + 1
+	if curBlockLog.Height == 0 && startHeight != 0 {
+		nextHeight = startHeight
+	}
+
+	util.Logger.Infof("fetching block, chain=%s, height=%d", ob.Chain, nextHeight)
+	return ob.fetchBlock(curBlockLog.Height, nextHeight, curBlockLog.BlockHash)
 }
 
-// fetchBlock fetches the next block of BSC and saves it to database. if the next block hash
+// fetchBlock fetches the next block of the chain and saves it to database. if the next block hash
 // does not match to the parent hash, the current block will be deleted for there is a fork.
 func (ob *Observer) fetchBlock(curHeight, nextHeight int64, curBlockHash string) error {
 	blockAndEventLogs, err := ob.Executor.GetBlockAndTxEvents(nextHeight)
@@ -79,16 +122,23 @@ func (ob *Observer) fetchBlock(curHeight, nextHeight int64, curBlockHash string)
 
 	parentHash := blockAndEventLogs.ParentBlockHash
 	if curHeight != 0 && parentHash != curBlockHash {
-		return ob.DeleteBlockAndTxEvents(curHeight)
+		if err := ob.DeleteBlockAndTxEvents(curHeight); err != nil {
+			return err
+		}
+		ob.reorgMux.Lock()
+		ob.reorgDepth++
+		ob.reorgMux.Unlock()
+		return nil
 	} else {
 		nextBlockLog := model.BlockLog{
+			Chain:      ob.Chain,
 			BlockHash:  blockAndEventLogs.BlockHash,
 			ParentHash: parentHash,
 			Height:     blockAndEventLogs.Height,
 			BlockTime:  blockAndEventLogs.BlockTime,
 		}
 
-		err := ob.SaveBlockAndTxEvents(&nextBlockLog, blockAndEventLogs.Events)
+		saved, err := ob.SaveBlockAndTxEvents(&nextBlockLog, blockAndEventLogs.Events)
 		if err != nil {
 			return err
 		}
@@ -97,10 +147,33 @@ func (ob *Observer) fetchBlock(curHeight, nextHeight int64, curBlockHash string)
 		if err != nil {
 			return err
 		}
+
+		ob.reorgMux.Lock()
+		if ob.reorgDepth > 0 {
+			util.Logger.Warningf("reorg completed, chain=%s, depth=%d, height=%d", ob.Chain, ob.reorgDepth, nextBlockLog.Height)
+			ob.lastReorgDepth, ob.lastReorgAt = ob.reorgDepth, time.Now()
+			ob.reorgDepth = 0
+		}
+		ob.reorgMux.Unlock()
+
+		ob.publish(&nextBlockLog, saved)
 	}
 	return nil
 }
 
+// publish pushes the committed block and the trades saved with it to the bus
+func (ob *Observer) publish(blockLog *model.BlockLog, packages []interface{}) {
+	ob.Bus.Publish(ob.Chain, pubsub.TopicBlocks, blockLog)
+	for _, pack := range packages {
+		switch eventLog := pack.(type) {
+		case *model.TxEventLog:
+			ob.Bus.Publish(ob.Chain, pubsub.TradeTopic(eventLog.ContractAddress), eventLog)
+		case *model.LiquidityEventLog:
+			ob.Bus.Publish(ob.Chain, pubsub.LiquidityTopic(eventLog.ContractAddress), eventLog)
+		}
+	}
+}
+
 // DeleteBlockAndTxEvents deletes the block and txs of the given height
 func (ob *Observer) DeleteBlockAndTxEvents(height int64) error {
 	tx := ob.StatasDB.Begin()
@@ -108,114 +181,136 @@ func (ob *Observer) DeleteBlockAndTxEvents(height int64) error {
 		return err
 	}
 
-	if err := tx.Where("height = ?", height).Delete(model.BlockLog{}).Error; err != nil {
+	if err := tx.Where("chain = ? and height = ?", ob.Chain, height).Delete(model.BlockLog{}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	if err := tx.Where("height = ? and status = ?", height, model.TxStatusInit).Delete(model.TxEventLog{}).Error; err != nil {
-		tx.Rollback()
-		return err
+	for _, table := range eventTables {
+		if err := tx.Where("chain = ? and height = ? and status = ?", ob.Chain, height, model.TxStatusInit).Delete(table).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	return tx.Commit().Error
 }
 
 func (ob *Observer) UpdateConfirmedNum(height int64) error {
-	err := ob.StatasDB.Model(model.TxEventLog{}).Where("status = ?", model.TxStatusInit).Updates(
-		map[string]interface{}{
-			"confirmed_num": gorm.Expr("? - height", height+1),
-		}).Error
-	if err != nil {
-		return err
+	for _, table := range eventTables {
+		err := ob.StatasDB.Model(table).Where("chain = ? and status = ?", ob.Chain, model.TxStatusInit).Updates(
+			map[string]interface{}{
+				"confirmed_num": gorm.Expr("? - height", height+1),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		// lp and token transfers are confirmed along with applying them to the positions and the holders
+		switch table.(type) {
+		case model.LpTransferLog, model.TokenTransferLog:
+			continue
+		}
+
+		err = ob.StatasDB.Model(table).Where("chain = ? and status = ? and confirmed_num >= ?",
+			ob.Chain, model.TxStatusInit, ob.ConfirmNum).Updates(
+			map[string]interface{}{
+				"status": model.TxStatusConfirmed,
+			}).Error
+		if err != nil {
+			return err
+		}
 	}
 
-	err = ob.StatasDB.Model(model.TxEventLog{}).Where("status = ? and confirmed_num >= ?",
-		model.TxStatusInit, ob.ConfirmNum).Updates(
-		map[string]interface{}{
-			"status": model.TxStatusConfirmed,
-		}).Error
+	if err := model.ConfirmLpTransfers(ob.StatasDB, ob.Chain, ob.ConfirmNum); err != nil {
+		return err
+	}
+	overdrawn, err := model.ConfirmTokenTransfers(ob.StatasDB, ob.Chain, ob.ConfirmNum)
 	if err != nil {
 		return err
 	}
-
+	for _, transfer := range overdrawn {
+		util.Logger.Errorf("token transfer overdraws its sender, chain=%s, token=%s, holder=%s, height=%d, "+
+			"the token is indexed from after its first transfer, start_height should be before it",
+			ob.Chain, transfer.ContractAddress, transfer.FromAddress, transfer.Height)
+	}
 	return nil
 }
 
 // Prune prunes the outdated blocks
-func (ob *Observer) Prune() {
+func (ob *Observer) Prune(ctx context.Context) {
 	for {
 		curBlockLog, err := ob.GetCurrentBlockLog()
 		if err != nil {
 			util.Logger.Errorf("get current block log error, err=%s", err.Error())
-			time.Sleep(common.ObserverPruneInterval)
-
+			if !util.Sleep(ctx, common.ObserverPruneInterval) {
+				return
+			}
 			continue
 		}
-		err = ob.StatasDB.Where("height < ?", curBlockLog.Height-common.ObserverMaxBlockNumber).Delete(model.BlockLog{}).Error
+		err = ob.StatasDB.Where("chain = ? and height < ?", ob.Chain, curBlockLog.Height-common.ObserverMaxBlockNumber).Delete(model.BlockLog{}).Error
 		if err != nil {
 			util.Logger.Infof("prune block logs error, err=%s", err.Error())
 		}
-		err = ob.StatasDB.Where("height < ?", curBlockLog.Height-common.ObservceMaxTxNumber).Delete(model.TxEventLog{}).Error
-		if err != nil {
-			util.Logger.Infof("prune block logs error, err=%s", err.Error())
+		for _, table := range eventTables {
+			err = ob.StatasDB.Where("chain = ? and height < ?", ob.Chain, curBlockLog.Height-common.ObservceMaxTxNumber).Delete(table).Error
+			if err != nil {
+				util.Logger.Infof("prune block logs error, err=%s", err.Error())
+			}
+		}
+		if !util.Sleep(ctx, common.ObserverPruneInterval) {
+			return
 		}
-		time.Sleep(common.ObserverPruneInterval)
 	}
 }
 
-func (ob *Observer) SaveBlockAndTxEvents(blockLog *model.BlockLog, packages []interface{}) error {
+// SaveBlockAndTxEvents saves the block and its events, an event whose amounts overflow the columns is
+// skipped. It returns the events saved.
+func (ob *Observer) SaveBlockAndTxEvents(blockLog *model.BlockLog, packages []interface{}) ([]interface{}, error) {
 	tx := ob.StatasDB.Begin()
 	if err := tx.Error; err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := tx.Create(blockLog).Error; err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
+	saved := make([]interface{}, 0, len(packages))
 	for _, pack := range packages {
+		switch eventLog := pack.(type) {
+		case *model.TxEventLog:
+			eventLog.Chain = ob.Chain
+		case *model.LiquidityEventLog:
+			eventLog.Chain = ob.Chain
+		case *model.LpTransferLog:
+			eventLog.Chain = ob.Chain
+		case *model.TokenTransferLog:
+			eventLog.Chain = ob.Chain
+		}
 		if err := tx.Create(pack).Error; err != nil {
-			if strings.Contains(err.Error(),"Out of range value"){
+			if strings.Contains(err.Error(), "Out of range value") {
 				continue
-			}else{
+			} else {
 				tx.Rollback()
-				return err
+				return nil, err
 			}
 		}
+		saved = append(saved, pack)
 	}
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return saved, nil
 }
 
-// GetCurrentBlockLog returns the highest block log
+// GetCurrentBlockLog returns the highest block log of the chain
 func (ob *Observer) GetCurrentBlockLog() (*model.BlockLog, error) {
 	blockLog := model.BlockLog{}
-	err := ob.StatasDB.Order("height desc").First(&blockLog).Error
+	err := ob.StatasDB.Where("chain = ?", ob.Chain).Order("height desc").First(&blockLog).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
 	return &blockLog, nil
 }
-
-// Alert sends alerts to tg group if there is no new block fetched in a specific time
-func (ob *Observer) Alert() {
-	for {
-		curChainBlockLog, err := ob.GetCurrentBlockLog()
-		if err != nil {
-			util.Logger.Errorf("get current block log error, err=%s", err.Error())
-			time.Sleep(common.ObserverAlertInterval)
-
-			continue
-		}
-		if curChainBlockLog.Height > 0 {
-			if time.Now().Unix()-curChainBlockLog.CreateTime > ob.Config.AlertConfig.BlockUpdateTimeout {
-				msg := fmt.Sprintf("Statas Service: big lagger now, last block fetched at %s, height=%d",
-					time.Unix(curChainBlockLog.CreateTime, 0).String(), curChainBlockLog.Height)
-				util.SendTelegramMessage(msg)
-			}
-		}
-
-		time.Sleep(common.ObserverAlertInterval)
-	}
-}
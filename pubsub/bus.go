@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TopicBlocks    = "blocks"
+	TopicPrices    = "prices"
+	TopicStats     = "stats"
+	TopicTrades    = "trades"
+	TopicLiquidity = "liquidity"
+
+	DefaultBufferSize = 256
+	// DefaultMaxDropped is the number of consecutive dropped events after which a slow subscriber is evicted
+	DefaultMaxDropped = 64
+)
+
+// TradeTopic returns the topic for trades of the given swap pair
+func TradeTopic(pair string) string {
+	return TopicTrades + ":" + strings.ToLower(pair)
+}
+
+// LiquidityTopic returns the topic for mints and burns of the given swap pair
+func LiquidityTopic(pair string) string {
+	return TopicLiquidity + ":" + strings.ToLower(pair)
+}
+
+type Event struct {
+	Chain string      `json:"chain"`
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type Subscription struct {
+	bus    *Bus
+	chain  string
+	topics map[string]bool
+	ch     chan Event
+
+	dropped int
+	closed  bool
+}
+
+// Events returns the channel events are delivered on, it is closed when the subscription is
+// unsubscribed or evicted for being too slow
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+}
+
+// match reports whether the subscription wants the topic of the chain, an empty chain of the subscription
+// matches every chain and "trades" matches the trades of every pair
+func (s *Subscription) match(chain, topic string) bool {
+	if s.chain != "" && s.chain != chain {
+		return false
+	}
+	if s.topics[topic] {
+		return true
+	}
+	if idx := strings.Index(topic, ":"); idx > 0 {
+		return s.topics[topic[:idx]]
+	}
+	return false
+}
+
+// Bus is an in-process fan-out of events to subscribers. Publish never blocks, events for a
+// subscriber whose buffer is full are dropped, and a subscriber dropping too many in a row is evicted.
+type Bus struct {
+	mux        sync.Mutex
+	subs       map[*Subscription]bool
+	bufferSize int
+	maxDropped int
+}
+
+func NewBus(bufferSize, maxDropped int) *Bus {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if maxDropped <= 0 {
+		maxDropped = DefaultMaxDropped
+	}
+	return &Bus{
+		subs:       make(map[*Subscription]bool, 0),
+		bufferSize: bufferSize,
+		maxDropped: maxDropped,
+	}
+}
+
+// Subscribe subscribes the topics of the chain, or of every chain if chain is empty
+func (b *Bus) Subscribe(chain string, topics []string) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		chain:  chain,
+		topics: make(map[string]bool, len(topics)),
+		ch:     make(chan Event, b.bufferSize),
+	}
+	for _, topic := range topics {
+		sub.topics[strings.ToLower(topic)] = true
+	}
+
+	b.mux.Lock()
+	b.subs[sub] = true
+	b.mux.Unlock()
+	return sub
+}
+
+// Publish delivers the event of the chain to all matching subscribers, it is safe to call on a nil bus
+func (b *Bus) Publish(chain, topic string, data interface{}) {
+	if b == nil {
+		return
+	}
+	event := Event{
+		Chain: chain,
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for sub := range b.subs {
+		if !sub.match(chain, topic) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+			if sub.dropped >= b.maxDropped {
+				b.closeLocked(sub)
+			}
+		}
+	}
+}
+
+func (b *Bus) SubscriberCount() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.subs)
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.closeLocked(sub)
+}
+
+func (b *Bus) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(b.subs, sub)
+	close(sub.ch)
+}
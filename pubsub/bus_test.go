@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// received drains the events delivered so far
+func received(sub *Subscription) []Event {
+	events := make([]Event, 0)
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBusTopics(t *testing.T) {
+	bus := NewBus(0, 0)
+	all := bus.Subscribe("", []string{TopicTrades})
+	pair := bus.Subscribe("bsc", []string{TradeTopic("0xABC")})
+	blocks := bus.Subscribe("bsc", []string{"BLOCKS"})
+
+	bus.Publish("bsc", TradeTopic("0xabc"), 1)
+	bus.Publish("bsc", TradeTopic("0xdef"), 2)
+	bus.Publish("eth", TradeTopic("0xabc"), 3)
+	bus.Publish("bsc", LiquidityTopic("0xabc"), 4)
+	bus.Publish("bsc", TopicBlocks, 5)
+
+	data := func(events []Event) []interface{} {
+		values := make([]interface{}, 0, len(events))
+		for _, event := range events {
+			values = append(values, event.Data)
+		}
+		return values
+	}
+	// "trades" matches the trades of every pair, of every chain without a chain
+	require.Equal(t, []interface{}{1, 2, 3}, data(received(all)))
+	require.Equal(t, []interface{}{1}, data(received(pair)))
+	require.Equal(t, []interface{}{5}, data(received(blocks)))
+
+	var nilBus *Bus
+	nilBus.Publish("bsc", TopicBlocks, 6)
+}
+
+func TestBusEvictsSlowSubscriber(t *testing.T) {
+	bus := NewBus(2, 3)
+	slow := bus.Subscribe("bsc", []string{TopicBlocks})
+	fast := bus.Subscribe("bsc", []string{TopicBlocks})
+
+	// the events beyond the buffer are dropped, a delivery resets the drops
+	for height := 0; height < 4; height++ {
+		bus.Publish("bsc", TopicBlocks, height)
+	}
+	require.Len(t, received(fast), 2)
+	require.Equal(t, 2, slow.dropped)
+	<-slow.Events()
+	bus.Publish("bsc", TopicBlocks, 4)
+	require.Equal(t, 0, slow.dropped)
+	require.Len(t, received(fast), 1)
+	require.Equal(t, 2, bus.SubscriberCount())
+
+	// a subscriber dropping max dropped events in a row is evicted and its channel closed
+	for height := 5; height < 8; height++ {
+		bus.Publish("bsc", TopicBlocks, height)
+	}
+	require.Equal(t, 1, bus.SubscriberCount())
+	require.Len(t, received(slow), 2)
+	_, ok := <-slow.Events()
+	require.False(t, ok)
+	require.Len(t, received(fast), 2)
+
+	fast.Unsubscribe()
+	fast.Unsubscribe()
+	require.Equal(t, 0, bus.SubscriberCount())
+	_, ok = <-fast.Events()
+	require.False(t, ok)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pieswap/pie-statas/alert"
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/statas"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// reloader applies the config again on SIGHUP or when the config file changes. Only the certificated
+// pairs, the syrup pools, the pricing anchors, the alert and whale settings and the log level are applied, a
+// config changing any other field is rejected as a whole.
+type reloader struct {
+	current *util.Config
+	path    string
+	svcs    map[string]*statas.StatasSvc
+	whales  []*statas.WhaleWatcher
+	alerts  *alert.Manager
+
+	wg sync.WaitGroup
+}
+
+func newReloader(config *util.Config, path string, alerts *alert.Manager) *reloader {
+	return &reloader{
+		current: config,
+		path:    path,
+		svcs:    make(map[string]*statas.StatasSvc, 0),
+		alerts:  alerts,
+	}
+}
+
+func (r *reloader) add(svc *statas.StatasSvc, whales *statas.WhaleWatcher) {
+	r.svcs[svc.Chain()] = svc
+	r.whales = append(r.whales, whales)
+}
+
+// Start watches the config until the context is done
+func (r *reloader) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(common.ConfigWatchInterval)
+		defer ticker.Stop()
+		modTime := r.modTime()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				util.Logger.Infof("received SIGHUP, reloading config")
+				modTime = r.modTime()
+				r.reload()
+			case <-ticker.C:
+				if latest := r.modTime(); !latest.Equal(modTime) {
+					util.Logger.Infof("config file %s changed, reloading config", r.path)
+					modTime = latest
+					r.reload()
+				}
+			}
+		}
+	}()
+}
+
+// Wait waits for the watch routine to stop
+func (r *reloader) Wait() {
+	r.wg.Wait()
+}
+
+func (r *reloader) modTime() time.Time {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		util.Logger.Errorf("stat config file error, err=%s", err.Error())
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (r *reloader) reload() {
+	next, err := loadConfig()
+	if err != nil {
+		util.Logger.Errorf("reload config error, err=%s", err.Error())
+		return
+	}
+	if err := next.Validate(); err != nil {
+		util.Logger.Errorf("reloaded config invalid, keep the current config, err=%s", err.Error())
+		return
+	}
+	if cold := r.current.ColdChanges(next); len(cold) > 0 {
+		util.Logger.Errorf("reloaded config rejected, %s can only be changed by a restart", strings.Join(cold, ", "))
+		return
+	}
+
+	if err := util.SetLogLevel(next.LogConfig.Level); err != nil {
+		util.Logger.Errorf("set log level error, err=%s", err.Error())
+	}
+	r.alerts.Reload(next.AlertConfig)
+	for _, whales := range r.whales {
+		whales.Reload(next.AlertConfig)
+	}
+	for _, chainConfig := range next.ChainConfigs {
+		if svc, exist := r.svcs[chainConfig.Name]; exist {
+			svc.Reload(chainConfig)
+		}
+	}
+	r.current = next
+	util.Logger.Infof("config reloaded")
+}
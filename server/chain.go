@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/gql"
+	"github.com/pieswap/pie-statas/observer"
+	"github.com/pieswap/pie-statas/statas"
+)
+
+// Chain is the indexing pipeline of one configured chain
+type Chain struct {
+	Name     string
+	StatSvc  *statas.StatasSvc
+	Observer *observer.Observer
+
+	graphql *gql.Handler
+}
+
+func NewChain(statSvc *statas.StatasSvc, observer *observer.Observer) *Chain {
+	return &Chain{
+		Name:     statSvc.Chain(),
+		StatSvc:  statSvc,
+		Observer: observer,
+	}
+}
+
+// selectChains returns the chains asked for by the chain parameter of the request. An empty
+// parameter selects the first configured chain and "all" selects every chain.
+func (s *Server) selectChains(r *http.Request) ([]*Chain, error) {
+	name := r.URL.Query().Get("chain")
+	switch {
+	case name == "":
+		return s.chains[:1], nil
+	case strings.EqualFold(name, common.AllChains):
+		return s.chains, nil
+	}
+	for _, chain := range s.chains {
+		if strings.EqualFold(chain.Name, name) {
+			return []*Chain{chain}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown chain %s", name)
+}
+
+// selectChain is selectChains for the endpoints which can only serve a single chain
+func (s *Server) selectChain(r *http.Request) (*Chain, error) {
+	chains, err := s.selectChains(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(chains) != 1 {
+		return nil, fmt.Errorf("a single chain should be given")
+	}
+	return chains[0], nil
+}
@@ -1,13 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	ethcmm "github.com/ethereum/go-ethereum/common"
 	"github.com/gorilla/mux"
 
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/gql"
+	"github.com/pieswap/pie-statas/pubsub"
 	"github.com/pieswap/pie-statas/statas"
 	"github.com/pieswap/pie-statas/util"
 )
@@ -19,31 +27,106 @@ const (
 type Server struct {
 	config *util.Config
 
-	statSvc *statas.StatasSvc
+	// chains are in the order of the config, the first one is served when no chain is given
+	chains []*Chain
+	bus    *pubsub.Bus
+
+	srv       *http.Server
+	streamSrv *http.Server
+	// stopStream is closed on shutdown to end the streams, which never go idle by themselves
+	stopStream chan struct{}
+}
+
+func NewServer(config *util.Config, chains []*Chain, bus *pubsub.Bus) *Server {
+	for _, chain := range chains {
+		chain.graphql = gql.NewHandler(chain.StatSvc, config.ServerConfig.GraphqlMaxComplexity,
+			config.ServerConfig.GraphqlMaxDepth)
+	}
+	listenAddr := DefaultListenAddr
+	if config.ServerConfig.ListenAddr != "" {
+		listenAddr = config.ServerConfig.ListenAddr
+	}
+	s := &Server{
+		config:     config,
+		chains:     chains,
+		bus:        bus,
+		stopStream: make(chan struct{}),
+	}
+	s.srv = &http.Server{
+		Handler:      s.Handler(),
+		Addr:         listenAddr,
+		WriteTimeout: 3 * time.Second,
+		ReadTimeout:  3 * time.Second,
+	}
+	if config.ServerConfig.StreamListenAddr != "" {
+		s.streamSrv = &http.Server{
+			Handler:     s.StreamHandler(),
+			Addr:        config.ServerConfig.StreamListenAddr,
+			ReadTimeout: 3 * time.Second,
+		}
+	}
+	return s
 }
 
-func NewServer(config *util.Config, statSvc *statas.StatasSvc) *Server {
-	return &Server{
-		config:  config,
-		statSvc: statSvc,
+// updateAtOf keeps the oldest update time of the chains, so aggregates never look fresher than they are
+func updateAtOf(updateAt, chainUpdateAt time.Time) time.Time {
+	if updateAt.IsZero() || chainUpdateAt.Before(updateAt) {
+		return chainUpdateAt
 	}
+	return updateAt
 }
 
 func (s *Server) Stat(w http.ResponseWriter, r *http.Request) {
-		swapPiars, totalVolume, lockVolume, updateAt := s.statSvc.GetSwapPairInfos()
-	_, t, _ := s.statSvc.GetSynup()
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	swapPiars := make([]statas.SwapPairInfo, 0)
+	var totalVolume, adjustedVolume, lockVolume, t, hopVolume, userTradeVolume float64
+	var fees statas.FeeStats
+	var updateAt time.Time
+	var stale bool
+	for _, chain := range chains {
+		chainPairs, chainVolume, chainLockVolume, chainUpdateAt := chain.StatSvc.GetSwapPairInfos()
+		stale = stale || chain.StatSvc.Stale()
+		_, chainSyrupTvl, _ := chain.StatSvc.GetSynup()
+		chainFees, _ := chain.StatSvc.GetFees()
+		chainHopVolume, chainUserTradeVolume := chain.StatSvc.GetTradeVolumes()
+		hopVolume += chainHopVolume
+		userTradeVolume += chainUserTradeVolume
+		swapPiars = append(swapPiars, chainPairs...)
+		totalVolume += chainVolume
+		adjustedVolume += chain.StatSvc.GetAdjustedVolume()
+		lockVolume += chainLockVolume
+		t += chainSyrupTvl
+		fees.Add(chainFees)
+		updateAt = updateAtOf(updateAt, chainUpdateAt)
+	}
 	resp := struct {
 		UpdateAt            time.Time             `json:"update_at"`
 		TotalVolume         float64               `json:"24h_total_volume"`
+		AdjustedVolume      float64               `json:"24h_adjusted_volume"`
 		LockVolume          float64               `json:"total_value_locked"`
 		TradePairs          []statas.SwapPairInfo `json:"trade_pairs"`
 		TotalValueLockedAll float64               `json:"total_value_locked_all"`
+		Fees                statas.FeeStats       `json:"fees"`
+		Stale               bool                  `json:"stale"`
+		// HopVolume is every swap valued as a trade, UserTradeVolume counts a multi hop trade of several swaps once
+		// by the value of the trade
+		HopVolume       float64 `json:"24h_hop_volume"`
+		UserTradeVolume float64 `json:"24h_user_trade_volume"`
 	}{
 		updateAt,
 		totalVolume,
+		adjustedVolume,
 		lockVolume,
 		swapPiars,
-			t + lockVolume,
+		t + lockVolume,
+		fees,
+		stale,
+		hopVolume,
+		userTradeVolume,
 	}
 	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
@@ -60,14 +143,54 @@ func (s *Server) Stat(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Price returns the token prices of the chain, or the prices keyed by chain for all chains since
+// the same symbol may be a different token on another chain
 func (s *Server) Price(w http.ResponseWriter, r *http.Request) {
-	prices, updateAt := s.statSvc.GetPrice()
-	resp := struct {
-		UpdateAt time.Time          `json:"update_at"`
-		Prices   map[string]float64 `json:"prices"`
-	}{
-		updateAt,
-		prices,
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var resp interface{}
+	if len(chains) == 1 && !strings.EqualFold(r.URL.Query().Get("chain"), common.AllChains) {
+		prices, updateAt := chains[0].StatSvc.GetPrice()
+		resp = struct {
+			UpdateAt time.Time          `json:"update_at"`
+			Prices   map[string]float64 `json:"prices"`
+			Stale    bool               `json:"stale"`
+			// Quarantined are the tokens served with their last good price, with the checks they failed
+			Quarantined map[string]string `json:"quarantined,omitempty"`
+		}{
+			updateAt,
+			prices,
+			chains[0].StatSvc.Stale(),
+			chains[0].StatSvc.GetQuarantined(),
+		}
+	} else {
+		chainPrices := make(map[string]map[string]float64, len(chains))
+		chainQuarantined := make(map[string]map[string]string, 0)
+		var updateAt time.Time
+		var stale bool
+		for _, chain := range chains {
+			prices, chainUpdateAt := chain.StatSvc.GetPrice()
+			chainPrices[chain.Name] = prices
+			updateAt = updateAtOf(updateAt, chainUpdateAt)
+			stale = stale || chain.StatSvc.Stale()
+			if quarantined := chain.StatSvc.GetQuarantined(); len(quarantined) > 0 {
+				chainQuarantined[chain.Name] = quarantined
+			}
+		}
+		resp = struct {
+			UpdateAt    time.Time                     `json:"update_at"`
+			Prices      map[string]map[string]float64 `json:"prices"`
+			Stale       bool                          `json:"stale"`
+			Quarantined map[string]map[string]string  `json:"quarantined,omitempty"`
+		}{
+			updateAt,
+			chainPrices,
+			stale,
+			chainQuarantined,
+		}
 	}
 	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
@@ -83,16 +206,224 @@ func (s *Server) Price(w http.ResponseWriter, r *http.Request) {
 		util.Logger.Errorf("write response error, err=%s", err.Error())
 	}
 }
+
 func (s *Server) Syrup(w http.ResponseWriter, r *http.Request) {
-	synups, tvl, updateAt := s.statSvc.GetSynup()
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	synups := make([]statas.SyrupTVL, 0)
+	var tvl float64
+	var updateAt time.Time
+	var stale bool
+	for _, chain := range chains {
+		chainSynups, chainTvl, chainUpdateAt := chain.StatSvc.GetSynup()
+		synups = append(synups, chainSynups...)
+		tvl += chainTvl
+		updateAt = updateAtOf(updateAt, chainUpdateAt)
+		stale = stale || chain.StatSvc.Stale()
+	}
 	resp := struct {
-		UpdateAt time.Time  `json:"update_at"`
-		TVL      float64    `json:"tvl"`
+		UpdateAt time.Time         `json:"update_at"`
+		TVL      float64           `json:"tvl"`
 		Pools    []statas.SyrupTVL `json:"pools"`
+		Stale    bool              `json:"stale"`
 	}{
 		updateAt,
 		tvl,
 		synups,
+		stale,
+	}
+	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+func (s *Server) WalletLiquidity(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !ethcmm.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	positions := make([]statas.LiquidityPosition, 0)
+	var totalValue float64
+	var updateAt time.Time
+	for _, chain := range chains {
+		chainPositions, chainValue, err := chain.StatSvc.GetWalletLiquidity(address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		positions = append(positions, chainPositions...)
+		totalValue += chainValue
+		_, chainUpdateAt := chain.StatSvc.GetPrice()
+		updateAt = updateAtOf(updateAt, chainUpdateAt)
+	}
+	resp := struct {
+		UpdateAt   time.Time                  `json:"update_at"`
+		Wallet     string                     `json:"wallet"`
+		TotalValue float64                    `json:"total_value_usd"`
+		Positions  []statas.LiquidityPosition `json:"positions"`
+	}{
+		updateAt,
+		ethcmm.HexToAddress(address).String(),
+		totalValue,
+		positions,
+	}
+	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+func (s *Server) WalletPerformance(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !ethcmm.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	performances := make([]statas.PositionPerformance, 0)
+	var updateAt time.Time
+	for _, chain := range chains {
+		chainPerformances, err := chain.StatSvc.GetWalletPerformance(address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		performances = append(performances, chainPerformances...)
+		_, chainUpdateAt := chain.StatSvc.GetPrice()
+		updateAt = updateAtOf(updateAt, chainUpdateAt)
+	}
+	resp := struct {
+		UpdateAt  time.Time                    `json:"update_at"`
+		Wallet    string                       `json:"wallet"`
+		Positions []statas.PositionPerformance `json:"positions"`
+	}{
+		updateAt,
+		ethcmm.HexToAddress(address).String(),
+		performances,
+	}
+	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+func (s *Server) PairPerformance(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !ethcmm.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	performance, err := chain.StatSvc.GetPairPerformance(ethcmm.HexToAddress(address))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if performance == nil {
+		http.Error(w, "pair not found", http.StatusNotFound)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(performance, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// Tokens lists the metadata of the tokens seen on the chain, with the verification by the token lists
+func (s *Server) Tokens(w http.ResponseWriter, r *http.Request) {
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tokens, err := chain.StatSvc.GetTokens()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// Supply lists the supply, market cap and fully diluted valuation of the priced tokens of the chain
+func (s *Server) Supply(w http.ResponseWriter, r *http.Request) {
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	supplies, updateAt := chain.StatSvc.GetSupplies()
+	resp := struct {
+		UpdateAt time.Time            `json:"update_at"`
+		Tokens   []statas.TokenSupply `json:"tokens"`
+	}{
+		updateAt,
+		supplies,
 	}
 	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
@@ -109,33 +440,408 @@ func (s *Server) Syrup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// TokenSupplyHistory returns the daily supply, market cap and fully diluted valuation of the token
+func (s *Server) TokenSupplyHistory(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !ethcmm.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	days := common.DefaultSupplyHistoryDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxSupplyHistoryDays {
+			http.Error(w, fmt.Sprintf("days should be in [1, %d]", common.MaxSupplyHistoryDays), http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snapshots, err := chain.StatSvc.GetSupplyHistory(ethcmm.HexToAddress(address), days, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type daySupply struct {
+		Date              time.Time `json:"date"`
+		TotalSupply       float64   `json:"total_supply"`
+		CirculatingSupply float64   `json:"circulating_supply"`
+		Price             float64   `json:"price"`
+		MarketCap         float64   `json:"market_cap"`
+		FDV               float64   `json:"fdv"`
+	}
+	history := make([]daySupply, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		history = append(history, daySupply{
+			Date:              time.Unix(snapshot.Date, 0).UTC(),
+			TotalSupply:       snapshot.TotalSupply,
+			CirculatingSupply: snapshot.CirculatingSupply,
+			Price:             snapshot.Price,
+			MarketCap:         snapshot.MarketCap,
+			FDV:               snapshot.FDV,
+		})
+	}
+	resp := struct {
+		Token   string      `json:"token"`
+		History []daySupply `json:"history"`
+	}{
+		ethcmm.HexToAddress(address).String(),
+		history,
+	}
+	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// TokenHolders returns the top holders, the holder distribution and the daily holder counts of a tracked token
+func (s *Server) TokenHolders(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !ethcmm.IsHexAddress(address) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	top := common.DefaultTopHolders
+	if value := query.Get("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxTopHolders {
+			http.Error(w, fmt.Sprintf("top should be in [1, %d]", common.MaxTopHolders), http.StatusBadRequest)
+			return
+		}
+		top = parsed
+	}
+	days := common.DefaultSupplyHistoryDays
+	if value := query.Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxSupplyHistoryDays {
+			http.Error(w, fmt.Sprintf("days should be in [1, %d]", common.MaxSupplyHistoryDays), http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := ethcmm.HexToAddress(address)
+	if !chain.StatSvc.TracksHolders(token) {
+		http.Error(w, "holders of token not tracked", http.StatusNotFound)
+		return
+	}
+	holders, err := chain.StatSvc.GetTokenHolders(token, top, days, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(holders, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// UserStats returns the daily, weekly and monthly active traders, the new and returning ones and the weekly
+// retention cohorts of a pair, or of all pairs without the pair parameter
+func (s *Server) UserStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pair := query.Get("pair")
+	if pair != "" && !ethcmm.IsHexAddress(pair) {
+		http.Error(w, "invalid pair", http.StatusBadRequest)
+		return
+	}
+	days := common.DefaultSupplyHistoryDays
+	if value := query.Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxSupplyHistoryDays {
+			http.Error(w, fmt.Sprintf("days should be in [1, %d]", common.MaxSupplyHistoryDays), http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	weeks := common.DefaultUserCohortWeeks
+	if value := query.Get("weeks"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxUserCohortWeeks {
+			http.Error(w, fmt.Sprintf("weeks should be in [1, %d]", common.MaxUserCohortWeeks), http.StatusBadRequest)
+			return
+		}
+		weeks = parsed
+	}
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := chain.StatSvc.GetUserStats(pair, days, weeks, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// Trades returns the trades reconstructed from the swaps of the latest transactions, or of the tx parameter
+func (s *Server) Trades(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	txHash := query.Get("tx")
+	if txHash != "" && len(ethcmm.FromHex(txHash)) != ethcmm.HashLength {
+		http.Error(w, "invalid tx", http.StatusBadRequest)
+		return
+	}
+	limit := common.DefaultTradesLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > common.MaxTradesLimit {
+			http.Error(w, fmt.Sprintf("limit should be in [1, %d]", common.MaxTradesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trades, err := chain.StatSvc.GetTrades(txHash, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(trades, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
 func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *Server) Serve() {
+type ReadyCheck struct {
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readyz reports whether the instance should receive traffic, it fails when db or rpc is unreachable,
+// the indexed height lags too far behind the chain head or the pair info has not been refreshed in time.
+// Every chain is checked unless the chain parameter is given.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	maxBlockLag := int64(common.DefaultReadyMaxBlockLag)
+	if s.config.ServerConfig.ReadyMaxBlockLag > 0 {
+		maxBlockLag = s.config.ServerConfig.ReadyMaxBlockLag
+	}
+	refreshIntervals := int64(common.DefaultReadyRefreshIntervals)
+	if s.config.ServerConfig.ReadyRefreshIntervals > 0 {
+		refreshIntervals = s.config.ServerConfig.ReadyRefreshIntervals
+	}
+
+	chains := s.chains
+	if r.URL.Query().Get("chain") != "" {
+		selected, err := s.selectChains(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chains = selected
+	}
+
+	checks := make(map[string]ReadyCheck, 0)
+
+	if err := s.chains[0].Observer.StatasDB.DB().Ping(); err != nil {
+		checks["db"] = ReadyCheck{Detail: err.Error()}
+	} else {
+		checks["db"] = ReadyCheck{Ok: true}
+	}
+
+	// the chains are checked at once, each provider call bounded, to answer within the write timeout
+	ctx, cancel := context.WithTimeout(r.Context(), common.ReadyCheckTimeout)
+	defer cancel()
+	var checksMux sync.Mutex
+	var wg sync.WaitGroup
+	for _, chain := range chains {
+		wg.Add(1)
+		go func(chain *Chain) {
+			defer wg.Done()
+			chainChecks := make(map[string]ReadyCheck, 0)
+			s.readyChecks(ctx, chain, maxBlockLag, refreshIntervals, chainChecks)
+			checksMux.Lock()
+			defer checksMux.Unlock()
+			for key, check := range chainChecks {
+				checks[key] = check
+			}
+		}(chain)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Ok
+	}
+	resp := struct {
+		Ready  bool                  `json:"ready"`
+		Checks map[string]ReadyCheck `json:"checks"`
+	}{
+		ready,
+		checks,
+	}
+	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// readyChecks adds the checks of the chain, keyed by the chain name. The provider is asked once, not retried.
+func (s *Server) readyChecks(ctx context.Context, chain *Chain, maxBlockLag, refreshIntervals int64, checks map[string]ReadyCheck) {
+	rpcKey, lagKey, pairInfoKey := chain.Name+".rpc", chain.Name+".block_lag", chain.Name+".pair_info"
+
+	latestHeight, err := chain.Observer.Executor.ProbeLatestHeight(ctx)
+	if err != nil {
+		checks[rpcKey] = ReadyCheck{Detail: err.Error()}
+	} else {
+		checks[rpcKey] = ReadyCheck{Ok: true}
+	}
+
+	curBlockLog, err := chain.Observer.GetCurrentBlockLog()
+	switch {
+	case err != nil:
+		checks[lagKey] = ReadyCheck{Detail: err.Error()}
+	case !checks[rpcKey].Ok:
+		checks[lagKey] = ReadyCheck{Detail: "chain head unknown"}
+	case latestHeight-curBlockLog.Height > maxBlockLag:
+		checks[lagKey] = ReadyCheck{Detail: fmt.Sprintf("indexed height %d, chain height %d, max lag %d",
+			curBlockLog.Height, latestHeight, maxBlockLag)}
+	default:
+		checks[lagKey] = ReadyCheck{Ok: true, Detail: fmt.Sprintf("%d", latestHeight-curBlockLog.Height)}
+	}
+
+	_, updateAt := chain.StatSvc.GetPrice()
+	checks[pairInfoKey] = pairInfoCheck(updateAt, time.Duration(refreshIntervals)*common.RefreshInterval)
+}
+
+// pairInfoCheck checks the pair info was refreshed within the max age
+func pairInfoCheck(updateAt time.Time, maxAge time.Duration) ReadyCheck {
+	switch {
+	case updateAt.IsZero():
+		return ReadyCheck{Detail: "never refreshed"}
+	case time.Since(updateAt) > maxAge:
+		return ReadyCheck{Detail: fmt.Sprintf("last refreshed at %s, max age %s", updateAt.String(), maxAge.String())}
+	default:
+		return ReadyCheck{Ok: true}
+	}
+}
+
+// Graphql serves the graphql endpoint of the chain asked for, the schema covers a single chain
+func (s *Server) Graphql(w http.ResponseWriter, r *http.Request) {
+	chain, err := s.selectChain(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chain.graphql.ServeHTTP(w, r)
+}
+
+// Handler returns the handler of the api routes
+func (s *Server) Handler() http.Handler {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/healthz", s.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", s.Readyz).Methods("GET")
 	router.HandleFunc("/api/v1/stat", s.Stat).Methods("GET")
+	router.HandleFunc("/api/v1/stat/users", s.UserStats).Methods("GET")
+	router.HandleFunc("/api/v1/trades", s.Trades).Methods("GET")
 	router.HandleFunc("/api/v1/price", s.Price).Methods("GET")
 	router.HandleFunc("/api/v1/syrup", s.Syrup).Methods("GET")
+	router.HandleFunc("/api/v1/tokens", s.Tokens).Methods("GET")
+	router.HandleFunc("/api/v1/tokens/{address}/supply", s.TokenSupplyHistory).Methods("GET")
+	router.HandleFunc("/api/v1/tokens/{address}/holders", s.TokenHolders).Methods("GET")
+	router.HandleFunc("/api/v1/supply", s.Supply).Methods("GET")
+	router.HandleFunc("/api/v1/wallets/{address}/liquidity", s.WalletLiquidity).Methods("GET")
+	router.HandleFunc("/api/v1/wallets/{address}/performance", s.WalletPerformance).Methods("GET")
+	router.HandleFunc("/api/v1/pairs/{address}/performance", s.PairPerformance).Methods("GET")
+	router.HandleFunc("/api/v1/graphql", s.Graphql).Methods("POST")
+	return router
+}
 
-	listenAddr := DefaultListenAddr
-	if s.config.ServerConfig.ListenAddr != "" {
-		listenAddr = s.config.ServerConfig.ListenAddr
-	}
-	srv := &http.Server{
-		Handler:      router,
-		Addr:         listenAddr,
-		WriteTimeout: 3 * time.Second,
-		ReadTimeout:  3 * time.Second,
+// Serve serves the api and the stream until Shutdown
+func (s *Server) Serve() {
+	if s.streamSrv != nil {
+		go s.ServeStream()
 	}
 
-	util.Logger.Infof("start admin server at %s", srv.Addr)
+	util.Logger.Infof("start admin server at %s", s.srv.Addr)
 
-	err := srv.ListenAndServe()
-	if err != nil {
+	err := s.srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("start admin server error, err=%s", err.Error()))
 	}
 }
+
+// Shutdown ends the streams and drains the requests in flight until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.stopStream)
+	var streamErr error
+	if s.streamSrv != nil {
+		streamErr = s.streamSrv.Shutdown(ctx)
+	}
+	if err := s.srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	return streamErr
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/observer"
+	"github.com/pieswap/pie-statas/simchain"
+	"github.com/pieswap/pie-statas/statas"
+)
+
+type readyz struct {
+	Ready  bool                  `json:"ready"`
+	Checks map[string]ReadyCheck `json:"checks"`
+}
+
+func getReadyz(t *testing.T, s *Server) (int, readyz) {
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp readyz
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, resp
+}
+
+func TestReadyz(t *testing.T) {
+	fixture := simchain.NewFixture(t, []simchain.FixturePair{{Token0: "WOKT", Token1: "BUSD"}})
+	client, db := fixture.Client, fixture.DB
+	client.Commit()
+	require.NoError(t, db.Model(&model.BlockLog{}).Update("height", client.Head().Number.Int64()).Error)
+
+	chainConfig, config := fixture.ChainConfig, fixture.Config
+	config.ServerConfig.ReadyMaxBlockLag = 2
+	e := executor.NewExecutor(chainConfig, client)
+	svc := statas.NewStatasSvc(db, config, chainConfig, client, e)
+	s := NewServer(config, []*Chain{NewChain(svc, observer.NewObserver(db, config, chainConfig, e))}, nil)
+
+	code, resp := getReadyz(t, s)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, resp.Ready)
+	require.Equal(t, ReadyCheck{Detail: "never refreshed"}, resp.Checks["test.pair_info"])
+	require.True(t, resp.Checks["test.rpc"].Ok)
+
+	svc.Refresh()
+	code, resp = getReadyz(t, s)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, readyz{Ready: true, Checks: map[string]ReadyCheck{
+		"db":             {Ok: true},
+		"test.rpc":       {Ok: true},
+		"test.block_lag": {Ok: true, Detail: "0"},
+		"test.pair_info": {Ok: true},
+	}}, resp)
+
+	// the indexer is 3 blocks behind
+	for i := 0; i < 3; i++ {
+		client.Commit()
+	}
+	code, resp = getReadyz(t, s)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, ReadyCheck{Detail: "indexed height 1, chain height 4, max lag 2"}, resp.Checks["test.block_lag"])
+
+	client.Fail(errors.New("connection refused"))
+	code, resp = getReadyz(t, s)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, resp.Checks["test.rpc"].Ok)
+	require.Contains(t, resp.Checks["test.rpc"].Detail, "connection refused")
+	require.Equal(t, ReadyCheck{Detail: "chain head unknown"}, resp.Checks["test.block_lag"])
+	client.Fail(nil)
+
+	db.Close()
+	code, resp = getReadyz(t, s)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, resp.Checks["db"].Ok)
+	require.Contains(t, resp.Checks["db"].Detail, "closed")
+
+	// pair info refreshed too long ago
+	require.False(t, pairInfoCheck(time.Now().Add(-time.Hour), 30*time.Minute).Ok)
+	require.True(t, pairInfoCheck(time.Now().Add(-time.Minute), 30*time.Minute).Ok)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	streamKeepAliveInterval = 15 * time.Second
+)
+
+// Stream pushes the events of the requested topics to the client as server-sent events,
+// topics are given as a comma separated list, e.g. ?topics=prices,stats,trades:0xabc...
+// Events of the chain parameter are pushed, chain=all pushes the events of every chain.
+func (s *Server) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	chains, err := s.selectChains(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chain := chains[0].Name
+	if len(chains) > 1 {
+		chain = ""
+	}
+
+	topics := make([]string, 0)
+	for _, topic := range strings.Split(r.URL.Query().Get("topics"), ",") {
+		topic = strings.TrimSpace(topic)
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		http.Error(w, "topics should not be empty", http.StatusBadRequest)
+		return
+	}
+
+	sub := s.bus.Subscribe(chain, topics)
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopStream:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				util.Logger.Infof("stream subscriber evicted for being too slow, remote=%s", r.RemoteAddr)
+				fmt.Fprint(w, "event: evicted\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			jsonBytes, err := json.Marshal(event)
+			if err != nil {
+				util.Logger.Errorf("marshal stream event error, err=%s", err.Error())
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Topic, jsonBytes); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// StreamHandler returns the handler of the streaming endpoint
+func (s *Server) StreamHandler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/stream", s.Stream).Methods("GET")
+	return router
+}
+
+// ServeStream serves the streaming endpoint on its own listener, since long lived
+// connections can not live with the write timeout of the api server
+func (s *Server) ServeStream() {
+	util.Logger.Infof("start stream server at %s", s.streamSrv.Addr)
+
+	err := s.streamSrv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		panic(fmt.Sprintf("start stream server error, err=%s", err.Error()))
+	}
+}
@@ -0,0 +1,209 @@
+// Package simchain is an offline chain for tests, built on the simulated backend of go-ethereum.
+// Tokens, factories, pairs and syrup pools are played by mock contracts, whose call results and
+// events are set by the test, and reorgs are made by importing a longer fork. FakeClient plays the
+// same mocks in memory without an evm, for unit tests which need no transactions. NewFixture sets up
+// the pairs of a fake client with an in memory db for the tests of the stat service.
+//
+// No contract logic runs on the mocks, the compiled factory, pair, BEP20 and Smartchef contracts are
+// not part of the tree and the tests build offline. Reserves, kLast and the lp supply are what the
+// test sets and the events are what it emits, so they agree only as far as the test makes them agree.
+// The harness covers the indexer and the stats from the events and call results on, not the
+// contracts or whether their events match their state.
+package simchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	gasLimit   = 100000000
+	txGasLimit = 5000000
+)
+
+var (
+	// chainConfig is the config the simulated backend runs with
+	chainConfig = params.AllEthashProtocolChanges
+	signer      = types.NewEIP155Signer(chainConfig.ChainID)
+)
+
+type Chain struct {
+	Mocks
+	Backend *backends.SimulatedBackend
+	From    common.Address
+
+	db  ethdb.Database
+	key *ecdsa.PrivateKey
+
+	// fork collects the transactions of a fork being built instead of sending them
+	fork      []*types.Transaction
+	forkNonce uint64
+	forking   bool
+}
+
+// New returns a simulated chain with a funded account sending all the transactions
+func New() (*Chain, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	db := rawdb.NewMemoryDatabase()
+	alloc := core.GenesisAlloc{from: {Balance: new(big.Int).Mul(big.NewInt(1e18), big.NewInt(1e6))}}
+	c := &Chain{
+		Backend: backends.NewSimulatedBackendWithDatabase(db, alloc, gasLimit),
+		From:    from,
+		db:      db,
+		key:     key,
+	}
+	c.Mocks = Mocks{backend: c}
+	return c, nil
+}
+
+// Client returns the backend as the client of the indexer. The simulated backend only calls the head,
+// so calls at a past height are made at the head too, the mocks answer their latest results anyway.
+func (c *Chain) Client() *Client {
+	return &Client{SimulatedBackend: c.Backend}
+}
+
+type Client struct {
+	*backends.SimulatedBackend
+}
+
+func (c *Client) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.SimulatedBackend.CallContract(ctx, call, nil)
+}
+
+func (c *Chain) Close() error {
+	return c.Backend.Close()
+}
+
+// Commit mines the pending transactions into a block
+func (c *Chain) Commit() {
+	c.Backend.Commit()
+}
+
+// Height returns the height of the chain head
+func (c *Chain) Height() int64 {
+	return c.Backend.Blockchain().CurrentBlock().Number().Int64()
+}
+
+// send signs the transaction and adds it to the pending block, or to the fork being built
+func (c *Chain) send(to *common.Address, data []byte) (*types.Transaction, error) {
+	var nonce uint64
+	if c.forking {
+		nonce = c.forkNonce
+		c.forkNonce++
+	} else {
+		pending, err := c.Backend.PendingNonceAt(context.Background(), c.From)
+		if err != nil {
+			return nil, err
+		}
+		nonce = pending
+	}
+
+	var tx *types.Transaction
+	if to == nil {
+		tx = types.NewContractCreation(nonce, big.NewInt(0), txGasLimit, big.NewInt(1), data)
+	} else {
+		tx = types.NewTransaction(nonce, *to, big.NewInt(0), txGasLimit, big.NewInt(1), data)
+	}
+	signedTx, err := types.SignTx(tx, signer, c.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.forking {
+		c.fork = append(c.fork, signedTx)
+		return signedTx, nil
+	}
+	return signedTx, c.Backend.SendTransaction(context.Background(), signedTx)
+}
+
+// DeployMock deploys a mock contract, it answers nothing until its call results are set
+func (c *Chain) DeployMock() (common.Address, error) {
+	tx, err := c.send(nil, mockCode())
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.CreateAddress(c.From, tx.Nonce()), nil
+}
+
+// SetCallResult makes the mock return data for the calldata
+func (c *Chain) SetCallResult(mock common.Address, calldata, data []byte) error {
+	payload := make([]byte, 4, 4+32+len(data))
+	binary.BigEndian.PutUint32(payload, mockSetSelector)
+	payload = append(payload, crypto.Keccak256(calldata)...)
+	payload = append(payload, data...)
+	_, err := c.send(&mock, payload)
+	return err
+}
+
+// EmitLog makes the mock emit a log with the topics and data
+func (c *Chain) EmitLog(mock common.Address, topics []common.Hash, data []byte) error {
+	if len(topics) > 4 {
+		return fmt.Errorf("at most 4 topics, got %d", len(topics))
+	}
+	payload := make([]byte, 4, 4+32+32*len(topics)+len(data))
+	binary.BigEndian.PutUint32(payload, mockEmitSelector)
+	payload = append(payload, common.BigToHash(big.NewInt(int64(len(topics)))).Bytes()...)
+	for _, topic := range topics {
+		payload = append(payload, topic.Bytes()...)
+	}
+	payload = append(payload, data...)
+	_, err := c.send(&mock, payload)
+	return err
+}
+
+// Reorg replaces the last depth blocks with a fork of depth+1 blocks, the transactions sent by
+// build go into the first block of the fork. The fork is heavier, so it becomes canonical.
+func (c *Chain) Reorg(depth int, build func() error) error {
+	head := c.Backend.Blockchain().CurrentBlock()
+	if int64(depth) > head.Number().Int64() {
+		return fmt.Errorf("reorg depth %d over height %d", depth, head.Number().Int64())
+	}
+	parent := c.Backend.Blockchain().GetBlockByNumber(head.NumberU64() - uint64(depth))
+	// NonceAt of the backend locks itself twice for past blocks, so the state is read directly
+	state, err := c.Backend.Blockchain().StateAt(parent.Root())
+	if err != nil {
+		return err
+	}
+	nonce := state.GetNonce(c.From)
+
+	c.fork, c.forkNonce, c.forking = nil, nonce, true
+	err = build()
+	c.forking = false
+	if err != nil {
+		return err
+	}
+
+	txs := c.fork
+	blocks, _ := core.GenerateChain(chainConfig, parent, ethash.NewFaker(), c.db, depth+1, func(i int, block *core.BlockGen) {
+		// a different coinbase makes the fork blocks differ from the replaced ones even without txs
+		block.SetCoinbase(common.Address{0x1})
+		if i == 0 {
+			for _, tx := range txs {
+				block.AddTx(tx)
+			}
+		}
+	})
+	if _, err := c.Backend.Blockchain().InsertChain(blocks); err != nil {
+		return err
+	}
+	c.Backend.Rollback()
+	return nil
+}
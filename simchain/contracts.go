@@ -0,0 +1,197 @@
+package simchain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	eabi "github.com/pieswap/pie-statas/abi"
+)
+
+var (
+	bep20ABI     = mustParseABI(eabi.Bep20ABI)
+	factoryABI   = mustParseABI(eabi.FactoryABI)
+	swapPairABI  = mustParseABI(eabi.SwappairABI)
+	smartchefABI = mustParseABI(eabi.SmartchefABI)
+)
+
+// backend is where the mocks live, the simulated chain or the fake client
+type backend interface {
+	DeployMock() (common.Address, error)
+	SetCallResult(mock common.Address, calldata, data []byte) error
+	EmitLog(mock common.Address, topics []common.Hash, data []byte) error
+}
+
+// Mocks plays the contracts of the swap by the mocks of its backend
+type Mocks struct {
+	backend backend
+}
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// SetResult makes the mock answer the call of the method with the args by the outputs
+func (m *Mocks) SetResult(mock common.Address, contractABI abi.ABI, method string, args []interface{}, outputs ...interface{}) error {
+	calldata, err := contractABI.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	data, err := contractABI.Methods[method].Outputs.Pack(outputs...)
+	if err != nil {
+		return err
+	}
+	return m.backend.SetCallResult(mock, calldata, data)
+}
+
+// Emit makes the mock emit the event, args are all inputs of the event in order
+func (m *Mocks) Emit(mock common.Address, contractABI abi.ABI, name string, args ...interface{}) error {
+	event, exist := contractABI.Events[name]
+	if !exist {
+		return fmt.Errorf("event %s not found", name)
+	}
+	if len(args) != len(event.Inputs) {
+		return fmt.Errorf("event %s has %d inputs, got %d args", name, len(event.Inputs), len(args))
+	}
+	topics := []common.Hash{event.ID()}
+	nonIndexed := make([]interface{}, 0, len(args))
+	for idx, input := range event.Inputs {
+		if !input.Indexed {
+			nonIndexed = append(nonIndexed, args[idx])
+			continue
+		}
+		switch arg := args[idx].(type) {
+		case common.Address:
+			topics = append(topics, common.BytesToHash(arg.Bytes()))
+		case *big.Int:
+			topics = append(topics, common.BigToHash(arg))
+		default:
+			return fmt.Errorf("indexed input %s of type %T not supported", input.Name, arg)
+		}
+	}
+	data, err := event.Inputs.NonIndexed().Pack(nonIndexed...)
+	if err != nil {
+		return err
+	}
+	return m.backend.EmitLog(mock, topics, data)
+}
+
+// DeployToken deploys a BEP20 token, balances are set by SetBalance
+func (m *Mocks) DeployToken(name, symbol string, decimals uint8, totalSupply *big.Int) (common.Address, error) {
+	token, err := m.backend.DeployMock()
+	if err != nil {
+		return token, err
+	}
+	results := []struct {
+		method string
+		output interface{}
+	}{{"name", name}, {"symbol", symbol}, {"decimals", decimals}, {"totalSupply", totalSupply}}
+	for _, result := range results {
+		if err := m.SetResult(token, bep20ABI, result.method, nil, result.output); err != nil {
+			return token, err
+		}
+	}
+	return token, nil
+}
+
+func (m *Mocks) SetBalance(token, holder common.Address, balance *big.Int) error {
+	return m.SetResult(token, bep20ABI, "balanceOf", []interface{}{holder}, balance)
+}
+
+// TokenTransfer emits a Transfer event of the token
+func (m *Mocks) TokenTransfer(token, from, to common.Address, value *big.Int) error {
+	return m.Emit(token, bep20ABI, "Transfer", from, to, value)
+}
+
+// Factory is a mock swap factory, pairs are registered by DeployPair
+type Factory struct {
+	Address common.Address
+	Pairs   []common.Address
+}
+
+func (m *Mocks) DeployFactory(feeTo common.Address) (*Factory, error) {
+	address, err := m.backend.DeployMock()
+	if err != nil {
+		return nil, err
+	}
+	factory := &Factory{Address: address}
+	if err := m.SetResult(address, factoryABI, "feeTo", nil, feeTo); err != nil {
+		return nil, err
+	}
+	if err := m.SetResult(address, factoryABI, "allPairsLength", nil, big.NewInt(0)); err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+// DeployPair deploys a swap pair of the factory without reserves
+func (m *Mocks) DeployPair(factory *Factory, token0, token1 common.Address) (common.Address, error) {
+	pair, err := m.backend.DeployMock()
+	if err != nil {
+		return pair, err
+	}
+	results := []struct {
+		method string
+		output interface{}
+	}{{"token0", token0}, {"token1", token1}, {"factory", factory.Address}, {"kLast", big.NewInt(0)}}
+	for _, result := range results {
+		if err := m.SetResult(pair, swapPairABI, result.method, nil, result.output); err != nil {
+			return pair, err
+		}
+	}
+	if err := m.SetPairState(pair, big.NewInt(0), big.NewInt(0), big.NewInt(0)); err != nil {
+		return pair, err
+	}
+
+	if err := m.SetResult(factory.Address, factoryABI, "allPairs", []interface{}{big.NewInt(int64(len(factory.Pairs)))}, pair); err != nil {
+		return pair, err
+	}
+	factory.Pairs = append(factory.Pairs, pair)
+	return pair, m.SetResult(factory.Address, factoryABI, "allPairsLength", nil, big.NewInt(int64(len(factory.Pairs))))
+}
+
+// SetPairState sets the reserves and the lp supply of the pair, which the events do not change
+func (m *Mocks) SetPairState(pair common.Address, reserve0, reserve1, lpSupply *big.Int) error {
+	if err := m.SetResult(pair, swapPairABI, "getReserves", nil, reserve0, reserve1, uint32(0)); err != nil {
+		return err
+	}
+	return m.SetResult(pair, swapPairABI, "totalSupply", nil, lpSupply)
+}
+
+// SetKLast sets kLast of the pair, the protocol fee accrues when it is not zero
+func (m *Mocks) SetKLast(pair common.Address, kLast *big.Int) error {
+	return m.SetResult(pair, swapPairABI, "kLast", nil, kLast)
+}
+
+func (m *Mocks) Swap(pair, sender, to common.Address, amount0In, amount1In, amount0Out, amount1Out *big.Int) error {
+	return m.Emit(pair, swapPairABI, "Swap", sender, amount0In, amount1In, amount0Out, amount1Out, to)
+}
+
+func (m *Mocks) Mint(pair, sender common.Address, amount0, amount1 *big.Int) error {
+	return m.Emit(pair, swapPairABI, "Mint", sender, amount0, amount1)
+}
+
+func (m *Mocks) Burn(pair, sender, to common.Address, amount0, amount1 *big.Int) error {
+	return m.Emit(pair, swapPairABI, "Burn", sender, amount0, amount1, to)
+}
+
+// LpTransfer emits a Transfer event of the lp token of the pair
+func (m *Mocks) LpTransfer(pair, from, to common.Address, value *big.Int) error {
+	return m.Emit(pair, swapPairABI, "Transfer", from, to, value)
+}
+
+// DeploySmartchef deploys a syrup pool, its staked amount is the balance of the syrup token it holds
+func (m *Mocks) DeploySmartchef(rewardToken common.Address) (common.Address, error) {
+	pool, err := m.backend.DeployMock()
+	if err != nil {
+		return pool, err
+	}
+	return pool, m.SetResult(pool, smartchefABI, "rewardToken", nil, rewardToken)
+}
@@ -0,0 +1,301 @@
+package simchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var errFakeUnsupported = errors.New("not supported by the fake client")
+
+// FakeClient is an in-memory chain client without an evm. Mocks answer calls by the results set for
+// their calldata, and the logs they emit are mined into the next block by Commit. It serves the
+// calls and queries of the indexer, sending transactions and subscriptions are not supported.
+type FakeClient struct {
+	Mocks
+	// From sends the transactions of the logs once KnowTransactions is called
+	From common.Address
+
+	mux     sync.Mutex
+	mocks   map[common.Address]bool
+	results map[common.Address]map[string][]byte
+	headers []*types.Header
+	logs    []types.Log
+	pending []types.Log
+	// pendingTx is the transaction of each pending log, a log emitted outside Tx is a transaction of its own
+	pendingTx []int
+	txCount   int
+	inTx      bool
+	// txs are the mined transactions TransactionByHash finds, signed by key
+	txs     map[common.Hash]*types.Transaction
+	key     *ecdsa.PrivateKey
+	knowTxs bool
+	// err fails every call of the indexer, as if the node were down
+	err error
+}
+
+// NewFakeClient returns a fake client with only the genesis block
+func NewFakeClient() *FakeClient {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		panic(err)
+	}
+	f := &FakeClient{
+		From:    crypto.PubkeyToAddress(key.PublicKey),
+		mocks:   make(map[common.Address]bool, 0),
+		results: make(map[common.Address]map[string][]byte, 0),
+		headers: []*types.Header{{Number: big.NewInt(0), Difficulty: big.NewInt(1)}},
+		txs:     make(map[common.Hash]*types.Transaction, 0),
+		key:     key,
+	}
+	f.Mocks = Mocks{backend: f}
+	return f
+}
+
+func (f *FakeClient) DeployMock() (common.Address, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	mock := common.BigToAddress(big.NewInt(int64(0x10000 + len(f.mocks))))
+	f.mocks[mock] = true
+	f.results[mock] = make(map[string][]byte, 0)
+	return mock, nil
+}
+
+func (f *FakeClient) SetCallResult(mock common.Address, calldata, data []byte) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if !f.mocks[mock] {
+		return errors.New("mock not deployed")
+	}
+	f.results[mock][string(calldata)] = data
+	return nil
+}
+
+// EmitLog adds a log of the mock to the pending block
+func (f *FakeClient) EmitLog(mock common.Address, topics []common.Hash, data []byte) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.pending = append(f.pending, types.Log{Address: mock, Topics: topics, Data: data})
+	if !f.inTx {
+		f.txCount++
+	}
+	f.pendingTx = append(f.pendingTx, f.txCount)
+	return nil
+}
+
+// Tx emits the logs of emit in one transaction of the pending block
+func (f *FakeClient) Tx(emit func() error) error {
+	f.mux.Lock()
+	f.txCount++
+	f.inTx = true
+	f.mux.Unlock()
+	defer func() {
+		f.mux.Lock()
+		f.inTx = false
+		f.mux.Unlock()
+	}()
+	return emit()
+}
+
+// Fail makes every call fail with the error until it is set to nil
+func (f *FakeClient) Fail(err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.err = err
+}
+
+// Commit mines the pending logs into a block 10 seconds after its parent
+func (f *FakeClient) Commit() {
+	f.CommitAt(f.Head().Time + 10)
+}
+
+// CommitAt mines the pending logs into a block of the given time
+func (f *FakeClient) CommitAt(blockTime uint64) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	parent := f.headers[len(f.headers)-1]
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+		Difficulty: big.NewInt(1),
+		Time:       blockTime,
+	}
+	for idx, log := range f.pending {
+		log.BlockNumber = header.Number.Uint64()
+		log.BlockHash = header.Hash()
+		log.TxHash = crypto.Keccak256Hash(log.BlockHash.Bytes(), big.NewInt(int64(f.pendingTx[idx])).Bytes())
+		log.TxIndex = uint(f.pendingTx[idx])
+		log.Index = uint(idx)
+		f.logs = append(f.logs, log)
+		if _, exist := f.txs[log.TxHash]; f.knowTxs && !exist {
+			tx := types.NewTransaction(uint64(f.pendingTx[idx]), log.Address, big.NewInt(0), 0, big.NewInt(0), nil)
+			signedTx, err := types.SignTx(tx, types.HomesteadSigner{}, f.key)
+			if err != nil {
+				panic(err)
+			}
+			f.txs[log.TxHash] = signedTx
+		}
+	}
+	f.pending, f.pendingTx = nil, nil
+	f.headers = append(f.headers, header)
+}
+
+// Head returns the header of the latest block
+func (f *FakeClient) Head() *types.Header {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.headers[len(f.headers)-1]
+}
+
+func (f *FakeClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if number == nil {
+		return f.headers[len(f.headers)-1], nil
+	}
+	if number.Sign() < 0 || number.Int64() >= int64(len(f.headers)) {
+		return nil, ethereum.NotFound
+	}
+	return f.headers[number.Int64()], nil
+}
+
+// KnowTransactions makes the transactions of the logs mined from now on found, sent by From
+func (f *FakeClient) KnowTransactions() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.knowTxs = true
+}
+
+// TransactionByHash finds no transaction until KnowTransactions is called, like a provider behind the
+// one which served the logs
+func (f *FakeClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	if tx, exist := f.txs[hash]; exist {
+		return tx, false, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (f *FakeClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return f.PendingCodeAt(ctx, contract)
+}
+
+// PendingCodeAt returns a stub code for mocks, so bindings tell them from accounts
+func (f *FakeClient) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !f.mocks[account] {
+		return nil, nil
+	}
+	return []byte{opStop}, nil
+}
+
+func (f *FakeClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if call.To == nil {
+		return nil, nil
+	}
+	return f.results[*call.To][string(call.Data)], nil
+}
+
+func (f *FakeClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	logs := make([]types.Log, 0)
+	for _, log := range f.logs {
+		if query.BlockHash != nil && log.BlockHash != *query.BlockHash {
+			continue
+		}
+		if query.BlockHash == nil {
+			if query.FromBlock != nil && log.BlockNumber < query.FromBlock.Uint64() {
+				continue
+			}
+			if query.ToBlock != nil && log.BlockNumber > query.ToBlock.Uint64() {
+				continue
+			}
+		}
+		if matchLog(log, query) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+// matchLog returns whether the log is of the addresses and topics of the query
+func matchLog(log types.Log, query ethereum.FilterQuery) bool {
+	if len(query.Addresses) > 0 {
+		found := false
+		for _, address := range query.Addresses {
+			if address == log.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(query.Topics) > len(log.Topics) {
+		return false
+	}
+	for idx, topics := range query.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range topics {
+			if topic == log.Topics[idx] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FakeClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return 0, nil
+}
+
+func (f *FakeClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(0), nil
+}
+
+func (f *FakeClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 0, errFakeUnsupported
+}
+
+func (f *FakeClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return errFakeUnsupported
+}
+
+func (f *FakeClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errFakeUnsupported
+}
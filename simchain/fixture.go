@@ -0,0 +1,105 @@
+package simchain
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	// FixtureChain is the name of the chain of a fixture, FixtureHeight and FixtureBlockTime its latest
+	// indexed block
+	FixtureChain     = "test"
+	FixtureHeight    = int64(100)
+	FixtureBlockTime = int64(1600000000)
+)
+
+// FixturePair is a pair of a fixture, Token0 and Token1 are the symbols, a suffix after @ deploys another
+// token of the symbol
+type FixturePair struct {
+	Token0, Token1     string
+	Reserve0, Reserve1 int64
+}
+
+// Fixture is the setup of the tests of the stat service: the pairs of a factory on a fake client, with 1000
+// lp tokens each, and an in memory db with every table and the latest indexed block
+type Fixture struct {
+	Client      *FakeClient
+	Factory     *Factory
+	Pairs       []common.Address
+	Tokens      map[string]common.Address
+	DB          *gorm.DB
+	ChainConfig *util.ChainConfig
+	Config      *util.Config
+}
+
+// TokenAmount returns the raw amount of a token with 18 decimals
+func TokenAmount(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18))
+}
+
+// NewFixture deploys the pairs and opens the db, which is closed when the test ends
+func NewFixture(t *testing.T, pairs []FixturePair) *Fixture {
+	client := NewFakeClient()
+	factory, err := client.DeployFactory(common.Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fixture{Client: client, Factory: factory, Tokens: make(map[string]common.Address, 0)}
+	for _, pair := range pairs {
+		token0, token1 := f.token(t, pair.Token0), f.token(t, pair.Token1)
+		pairAddr, err := client.DeployPair(factory, token0, token1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetPairState(pairAddr, TokenAmount(pair.Reserve0), TokenAmount(pair.Reserve1), TokenAmount(1000)); err != nil {
+			t.Fatal(err)
+		}
+		f.Pairs = append(f.Pairs, pairAddr)
+	}
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection opens its own in memory db
+	db.DB().SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := db.AutoMigrate(model.Tables...).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.BlockLog{Chain: FixtureChain, BlockHash: "0x1", Height: FixtureHeight, BlockTime: FixtureBlockTime}).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.DB = db
+
+	f.ChainConfig = &util.ChainConfig{
+		Name:          FixtureChain,
+		ConfirmNum:    1,
+		SwapFactories: []string{strings.ToLower(factory.Address.String())},
+	}
+	f.Config = &util.Config{ChainConfigs: util.ChainConfigs{f.ChainConfig}}
+	return f
+}
+
+// token returns the token of the symbol, deployed on first use
+func (f *Fixture) token(t *testing.T, symbol string) common.Address {
+	if token, exist := f.Tokens[symbol]; exist {
+		return token
+	}
+	onChain := strings.Split(symbol, "@")[0]
+	token, err := f.Client.DeployToken(onChain+" Token", onChain, 18, TokenAmount(1e9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Tokens[symbol] = token
+	return token
+}
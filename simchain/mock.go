@@ -0,0 +1,187 @@
+package simchain
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The mock contract answers every call with the return data registered for its calldata, so the
+// contracts of the swap can be played by one tiny contract without compiling solidity. Its
+// selectors are reserved:
+//
+//	0xffffffff ++ key ++ data          registers data as the return data of the calldata hashing to key
+//	0xfffffffe ++ n ++ topics[n] ++ data  emits a log with n topics from the contract
+//
+// Return data is stored as its length at slot key and its words at slots key+1, key+2...
+// Calls of unregistered calldata return nothing. Anyone may program the mock, it is for tests only.
+const (
+	mockSetSelector  = 0xffffffff
+	mockEmitSelector = 0xfffffffe
+)
+
+const (
+	opStop         = 0x00
+	opAdd          = 0x01
+	opSub          = 0x03
+	opLt           = 0x10
+	opEq           = 0x14
+	opIsZero       = 0x15
+	opShl          = 0x1b
+	opShr          = 0x1c
+	opSha3         = 0x20
+	opCallDataLoad = 0x35
+	opCallDataSize = 0x36
+	opCallDataCopy = 0x37
+	opCodeCopy     = 0x39
+	opPop          = 0x50
+	opMstore       = 0x52
+	opSload        = 0x54
+	opSstore       = 0x55
+	opJump         = 0x56
+	opJumpi        = 0x57
+	opJumpDest     = 0x5b
+	opPush1        = 0x60
+	opPush2        = 0x61
+	opPush4        = 0x63
+	opDup1         = 0x80
+	opSwap1        = 0x90
+	opLog0         = 0xa0
+	opReturn       = 0xf3
+	opRevert       = 0xfd
+)
+
+// assembler builds evm bytecode with labels, jump targets are always pushed as two bytes
+type assembler struct {
+	code   []byte
+	labels map[string]int
+	fixups map[int]string
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		labels: make(map[string]int, 0),
+		fixups: make(map[int]string, 0),
+	}
+}
+
+func (a *assembler) op(ops ...byte) *assembler {
+	a.code = append(a.code, ops...)
+	return a
+}
+
+func (a *assembler) push1(v byte) *assembler {
+	return a.op(opPush1, v)
+}
+
+func (a *assembler) push4(v uint32) *assembler {
+	bz := make([]byte, 4)
+	binary.BigEndian.PutUint32(bz, v)
+	return a.op(opPush4).op(bz...)
+}
+
+// pushLabel pushes the position of the label, which may be defined later
+func (a *assembler) pushLabel(label string) *assembler {
+	a.op(opPush2)
+	a.fixups[len(a.code)] = label
+	return a.op(0, 0)
+}
+
+func (a *assembler) label(label string) *assembler {
+	a.labels[label] = len(a.code)
+	return a.op(opJumpDest)
+}
+
+func (a *assembler) jumpi(label string) *assembler {
+	return a.pushLabel(label).op(opJumpi)
+}
+
+func (a *assembler) jump(label string) *assembler {
+	return a.pushLabel(label).op(opJump)
+}
+
+func (a *assembler) bytes() []byte {
+	code := make([]byte, len(a.code))
+	copy(code, a.code)
+	for pos, label := range a.fixups {
+		target, exist := a.labels[label]
+		if !exist {
+			panic(fmt.Sprintf("label %s not defined", label))
+		}
+		binary.BigEndian.PutUint16(code[pos:], uint16(target))
+	}
+	return code
+}
+
+// mockRuntime returns the runtime code of the mock contract
+func mockRuntime() []byte {
+	a := newAssembler()
+
+	// dispatch on the selector
+	a.push1(0).op(opCallDataLoad).push1(0xe0).op(opShr)
+	a.op(opDup1).push4(mockSetSelector).op(opEq).jumpi("set")
+	a.op(opDup1).push4(mockEmitSelector).op(opEq).jumpi("emit")
+	a.op(opPop)
+
+	// call: return the data registered at keccak256(calldata)
+	a.op(opCallDataSize).push1(0).push1(0).op(opCallDataCopy)
+	a.op(opCallDataSize).push1(0).op(opSha3) // [key]
+	a.op(opDup1).op(opSload)                 // [key, len]
+	a.push1(0)                               // [key, len, i]
+	a.label("load")
+	a.op(opDup1+1, opDup1+1, opLt, opIsZero).jumpi("return")
+	a.op(opDup1).push1(5).op(opShr).push1(1).op(opAdd).op(opDup1 + 3).op(opAdd).op(opSload) // [key, len, i, word]
+	a.op(opDup1 + 1).op(opMstore)
+	a.push1(32).op(opAdd).jump("load")
+	a.label("return")
+	a.op(opPop).push1(0).op(opReturn)
+
+	// set: store the return data of a key
+	a.label("set")
+	a.op(opPop)
+	a.push1(4).op(opCallDataLoad)            // [key]
+	a.push1(36).op(opCallDataSize).op(opSub) // [key, len]
+	a.op(opDup1, opDup1+2, opSstore)         // sstore(key, len)
+	a.push1(0)                               // [key, len, i]
+	a.label("store")
+	a.op(opDup1+1, opDup1+1, opLt, opIsZero).jumpi("stop")
+	a.op(opDup1).push1(36).op(opAdd).op(opCallDataLoad) // [key, len, i, word]
+	a.op(opDup1 + 1).push1(5).op(opShr).push1(1).op(opAdd).op(opDup1 + 4).op(opAdd).op(opSstore)
+	a.push1(32).op(opAdd).jump("store")
+	a.label("stop")
+	a.op(opStop)
+
+	// emit: log the data with the given topics
+	a.label("emit")
+	a.op(opPop)
+	a.push1(4).op(opCallDataLoad)                       // [n]
+	a.op(opDup1).push1(5).op(opShl).push1(36).op(opAdd) // [n, start]
+	a.op(opDup1).op(opCallDataSize).op(opSub)           // [n, start, size]
+	a.op(opDup1, opDup1+2).push1(0).op(opCallDataCopy)
+	a.op(opSwap1, opPop) // [n, size]
+	for n := 0; n <= 4; n++ {
+		a.op(opDup1 + 1).push1(byte(n)).op(opEq).jumpi(fmt.Sprintf("log%d", n))
+	}
+	a.push1(0).push1(0).op(opRevert)
+	for n := 0; n <= 4; n++ {
+		a.label(fmt.Sprintf("log%d", n))
+		for topic := n - 1; topic >= 0; topic-- {
+			a.push1(byte(36 + 32*topic)).op(opCallDataLoad)
+		}
+		a.op(opDup1 + byte(n)).push1(0).op(opLog0 + byte(n)).op(opStop)
+	}
+	return a.bytes()
+}
+
+// mockCode returns the creation code of the mock contract
+func mockCode() []byte {
+	runtime := mockRuntime()
+	a := newAssembler()
+	a.op(opPush2, byte(len(runtime)>>8), byte(len(runtime)))
+	a.op(opDup1)
+	a.op(opPush2, 0, 0) // offset of the runtime, patched below
+	a.push1(0).op(opCodeCopy)
+	a.push1(0).op(opReturn)
+	init := a.bytes()
+	binary.BigEndian.PutUint16(init[5:], uint16(len(init)))
+	return append(init, runtime...)
+}
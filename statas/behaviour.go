@@ -0,0 +1,234 @@
+package statas
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/pieswap/pie-statas/abi"
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// pairBalance is a token balance of a pair and its reserve at a check, moves counts the checks in a row
+// the balance moved while the reserve stayed
+type pairBalance struct {
+	reserve *big.Int
+	balance *big.Int
+	moves   int
+}
+
+// behaviourDetector finds the fee on transfer and rebasing tokens of the listed pairs. The latest swap of
+// a pair is checked against the token transfers of its transaction, a fee on transfer token delivers less
+// than the pair sends or the pair receives less than is sent. The balances of a pair are checked against
+// its reserves, a rebasing token moves the balance of the pair without a Sync of the reserves. A
+// behaviour not detected again within the expiry is cleared.
+type behaviourDetector struct {
+	mux         sync.Mutex
+	swapPairABI ethabi.ABI
+	// checked is the last swap checked of each pair
+	checked map[ethcmm.Address]uint
+	// balances are the balances of the tokens of each pair at the last check
+	balances map[ethcmm.Address][2]*pairBalance
+}
+
+func newBehaviourDetector() *behaviourDetector {
+	swapPairABI, err := ethabi.JSON(strings.NewReader(abi.SwappairABI))
+	if err != nil {
+		panic(err)
+	}
+	return &behaviourDetector{
+		swapPairABI: swapPairABI,
+		checked:     make(map[ethcmm.Address]uint, 0),
+		balances:    make(map[ethcmm.Address][2]*pairBalance, 0),
+	}
+}
+
+// detectLoop detects the token behaviours periodically until the context is done
+func (r *StatasSvc) detectLoop(ctx context.Context) {
+	for util.Sleep(ctx, common.TokenBehaviourInterval) {
+		r.detectTokenBehaviours()
+	}
+}
+
+// detectTokenBehaviours checks the listed pairs once, the tokens detected are classified in the registry
+func (r *StatasSvc) detectTokenBehaviours() {
+	r.detector.mux.Lock()
+	defer r.detector.mux.Unlock()
+	for _, info := range r.GetAllSwapPairInfos() {
+		pair := ethcmm.HexToAddress(info.SwapPairContract)
+		tokens := [2]ethcmm.Address{info.token0, info.token1}
+		if err := r.checkTransfers(pair, tokens); err != nil {
+			util.Logger.Errorf("check transfers of pair error, chain=%s, pair=%s, err=%s", r.Chain(), pair.String(), err.Error())
+		}
+		if err := r.checkBalances(pair, tokens); err != nil {
+			util.Logger.Errorf("check balances of pair error, chain=%s, pair=%s, err=%s", r.Chain(), pair.String(), err.Error())
+		}
+	}
+	expired, err := r.tokens.expire(time.Now().Add(-common.TokenBehaviourExpiry))
+	if err != nil {
+		util.Logger.Errorf("expire token behaviours error, chain=%s, err=%s", r.Chain(), err.Error())
+	}
+	for _, token := range expired {
+		util.Logger.Infof("token behaviour expired, chain=%s, token=%s", r.Chain(), token.String())
+	}
+}
+
+// checkTransfers compares the latest swap of the pair with the transfers of its transaction
+func (r *StatasSvc) checkTransfers(pair ethcmm.Address, tokens [2]ethcmm.Address) error {
+	swap, err := model.GetLatestSwap(r.statasDB, r.Chain(), pair.String())
+	if err != nil || swap == nil || swap.ID <= r.detector.checked[pair] {
+		return err
+	}
+
+	blockHash := ethcmm.HexToHash(swap.BlockHash)
+	ctx, cancel := context.WithTimeout(context.Background(), common.TokenBehaviourCallTimeout)
+	defer cancel()
+	logs, err := r.client.FilterLogs(ctx, ethereum.FilterQuery{
+		BlockHash: &blockHash,
+		Addresses: []ethcmm.Address{pair, tokens[0], tokens[1]},
+		Topics:    [][]ethcmm.Hash{{executor.SwapEventHash, executor.TransferEventHash}},
+	})
+	if err != nil {
+		return err
+	}
+	r.detector.checked[pair] = swap.ID
+
+	var swapEvent *executor.SwapEvent
+	txLogs := make([]types.Log, 0)
+	for idx := range logs {
+		log := logs[idx]
+		if log.TxHash.String() != swap.TxHash {
+			continue
+		}
+		if log.Address == pair && log.Topics[0] == executor.SwapEventHash && swapEvent == nil {
+			if swapEvent, err = executor.ParseSwapEvent(&r.detector.swapPairABI, &log); err != nil {
+				return err
+			}
+			continue
+		}
+		txLogs = append(txLogs, log)
+	}
+	if swapEvent == nil {
+		return nil
+	}
+
+	amountsIn := [2]*big.Int{swapEvent.Amount0In, swapEvent.Amount1In}
+	amountsOut := [2]*big.Int{swapEvent.Amount0Out, swapEvent.Amount1Out}
+	for idx, token := range tokens {
+		sent, received := new(big.Int), new(big.Int)
+		for _, log := range txLogs {
+			if log.Address != token || log.Topics[0] != executor.TransferEventHash || len(log.Topics) < 3 {
+				continue
+			}
+			from := ethcmm.BytesToAddress(log.Topics[1].Bytes())
+			to := ethcmm.BytesToAddress(log.Topics[2].Bytes())
+			value := new(big.Int).SetBytes(log.Data)
+			if to == pair && from != pair {
+				sent.Add(sent, value)
+			}
+			if from == pair && to == swapEvent.To {
+				received.Add(received, value)
+			}
+		}
+		// the pair records what it received in and what it sent out
+		var fee float64
+		if amountsIn[idx].Sign() > 0 && sent.Cmp(amountsIn[idx]) > 0 {
+			fee = 1 - ratio(amountsIn[idx], sent)
+		}
+		if amountsOut[idx].Sign() > 0 && received.Sign() > 0 && received.Cmp(amountsOut[idx]) < 0 {
+			fee = 1 - ratio(received, amountsOut[idx])
+		}
+		if fee < common.TokenBehaviourMinFee {
+			continue
+		}
+		if err := r.classifyToken(token, model.TokenBehaviourFeeOnTransfer, fee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBalances compares the balances of the pair with its reserves at the head block
+func (r *StatasSvc) checkBalances(pair ethcmm.Address, tokens [2]ethcmm.Address) error {
+	ctx, cancel := context.WithTimeout(context.Background(), common.TokenBehaviourCallTimeout)
+	defer cancel()
+	header, err := r.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: header.Number}
+	pairIns, err := abi.NewSwappair(pair, r.client)
+	if err != nil {
+		return err
+	}
+	reserves, err := pairIns.GetReserves(opts)
+	if err != nil {
+		return err
+	}
+
+	current := [2]*pairBalance{}
+	for idx, token := range tokens {
+		tokenIns, err := abi.NewBep20(token, r.client)
+		if err != nil {
+			return err
+		}
+		balance, err := tokenIns.BalanceOf(opts, pair)
+		if err != nil {
+			return err
+		}
+		current[idx] = &pairBalance{balance: balance, reserve: reserves.Reserve0}
+		if idx == 1 {
+			current[idx].reserve = reserves.Reserve1
+		}
+	}
+
+	last, exist := r.detector.balances[pair]
+	r.detector.balances[pair] = current
+	for idx, token := range tokens {
+		// a plain token only leaves the pair by its transfers, which sync the reserves
+		rebased := current[idx].balance.Cmp(current[idx].reserve) < 0
+		// and only enters it by transfers. A balance moving while the reserves stay is rebased or donated, a
+		// donation is a single move, so only moves repeated without a Sync in between are rebased.
+		if exist && last[idx].reserve.Cmp(current[idx].reserve) == 0 && last[idx].balance.Cmp(current[idx].balance) != 0 {
+			current[idx].moves = last[idx].moves + 1
+		}
+		if current[idx].moves >= common.TokenBehaviourRebaseMoves {
+			rebased = true
+		}
+		if !rebased {
+			continue
+		}
+		if err := r.classifyToken(token, model.TokenBehaviourRebasing, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *StatasSvc) classifyToken(token ethcmm.Address, behaviour string, fee float64) error {
+	changed, err := r.tokens.classify(token, behaviour, fee)
+	if err != nil {
+		return err
+	}
+	if changed {
+		util.Logger.Warningf("token behaviour detected, chain=%s, token=%s, behaviour=%s, fee=%f",
+			r.Chain(), token.String(), behaviour, fee)
+	}
+	return nil
+}
+
+func ratio(numerator, denominator *big.Int) float64 {
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(numerator), new(big.Float).SetInt(denominator)).Float64()
+	return value
+}
@@ -0,0 +1,136 @@
+package statas
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/pieswap/pie-statas/executor"
+)
+
+// the erc20 metadata methods are called raw, many legacy tokens do not return what the standard says
+var (
+	selectorName        = crypto.Keccak256([]byte("name()"))[:4]
+	selectorSymbol      = crypto.Keccak256([]byte("symbol()"))[:4]
+	selectorDecimals    = crypto.Keccak256([]byte("decimals()"))[:4]
+	selectorTotalSupply = crypto.Keccak256([]byte("totalSupply()"))[:4]
+)
+
+const (
+	degradedName        = "name"
+	degradedSymbol      = "symbol"
+	degradedDecimals    = "decimals"
+	degradedTotalSupply = "total_supply"
+)
+
+// tokenMetadata is the metadata of a token contract, degraded are the fields which could not be read
+type tokenMetadata struct {
+	name        string
+	symbol      string
+	decimals    uint8
+	totalSupply *big.Int
+	degraded    []string
+}
+
+// readTokenMetadata reads the metadata of the token. Symbols and names returned as string or bytes32 are
+// accepted. A method reverting or returning garbage degrades the token: the symbol falls back to the
+// address, the decimals to defaultDecimals and the total supply to 0. Errors of the provider are returned.
+func readTokenMetadata(client executor.ChainClient, token ethcmm.Address, defaultDecimals uint8) (*tokenMetadata, error) {
+	call := func(selector []byte) ([]byte, error) {
+		data, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: selector}, nil)
+		if err != nil && executor.IsRetryable(err) {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	metadata := &tokenMetadata{}
+	data, err := call(selectorSymbol)
+	if err != nil {
+		return nil, err
+	}
+	symbol, ok := decodeText(data)
+	if !ok || symbol == "" {
+		symbol = token.String()
+		metadata.degraded = append(metadata.degraded, degradedSymbol)
+	}
+	metadata.symbol = symbol
+
+	data, err = call(selectorName)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := decodeText(data)
+	if !ok {
+		metadata.degraded = append(metadata.degraded, degradedName)
+	}
+	metadata.name = name
+
+	data, err = call(selectorDecimals)
+	if err != nil {
+		return nil, err
+	}
+	decimals, ok := decodeWord(data)
+	if !ok || !decimals.IsUint64() || decimals.Uint64() > 255 {
+		metadata.decimals = defaultDecimals
+		metadata.degraded = append(metadata.degraded, degradedDecimals)
+	} else {
+		metadata.decimals = uint8(decimals.Uint64())
+	}
+
+	data, err = call(selectorTotalSupply)
+	if err != nil {
+		return nil, err
+	}
+	totalSupply, ok := decodeWord(data)
+	if !ok {
+		totalSupply = new(big.Int)
+		metadata.degraded = append(metadata.degraded, degradedTotalSupply)
+	}
+	metadata.totalSupply = totalSupply
+	return metadata, nil
+}
+
+// decodeText decodes the return of a symbol or name, an abi encoded string or a bytes32
+func decodeText(data []byte) (string, bool) {
+	if len(data) >= 64 {
+		offset := new(big.Int).SetBytes(data[:32])
+		if offset.IsUint64() && offset.Uint64()+32 <= uint64(len(data)) {
+			start := offset.Uint64() + 32
+			length := new(big.Int).SetBytes(data[start-32 : start])
+			if length.IsUint64() && start+length.Uint64() <= uint64(len(data)) {
+				return cleanText(data[start : start+length.Uint64()]), true
+			}
+		}
+	}
+	if len(data) == 32 {
+		return cleanText(bytes.TrimRight(data, "\x00")), true
+	}
+	return "", false
+}
+
+// cleanText drops the invalid utf8 and the non printable characters
+func cleanText(data []byte) string {
+	text := strings.ToValidUTF8(string(data), "")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, text)
+	return strings.TrimSpace(text)
+}
+
+// decodeWord decodes the return of a uint method
+func decodeWord(data []byte) (*big.Int, bool) {
+	if len(data) < 32 {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(data[:32]), true
+}
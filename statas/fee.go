@@ -0,0 +1,153 @@
+package statas
+
+import (
+	"strings"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// FeeStat is the fee revenue in USD and the traded value it is charged on. The traded value counts one
+// side of every swap of every priced pair, while the 24h total volume adds both sides of the qualified
+// pairs, so the fees reconcile with VolumeUSD rather than with the total volume.
+type FeeStat struct {
+	LpFee       float64 `json:"lp_fee"`
+	ProtocolFee float64 `json:"protocol_fee"`
+	VolumeUSD   float64 `json:"volume_usd"`
+}
+
+func (s *FeeStat) add(o FeeStat) {
+	s.LpFee += o.LpFee
+	s.ProtocolFee += o.ProtocolFee
+	s.VolumeUSD += o.VolumeUSD
+}
+
+// FeeStats is the fee revenue in USD over the last hour, the current UTC day and the last 24 hours
+type FeeStats struct {
+	LastHour FeeStat `json:"1h"`
+	Today    FeeStat `json:"today"`
+	Last24h  FeeStat `json:"24h"`
+}
+
+func (s *FeeStats) Add(o FeeStats) {
+	s.LastHour.add(o.LastHour)
+	s.Today.add(o.Today)
+	s.Last24h.add(o.Last24h)
+}
+
+// pairVolumeUSD values the swapped amounts of a pair as the 24h total volume does, the priced sides are
+// added. The side of a fee on transfer or rebasing token is valued by the other side when it is plain.
+func pairVolumeUSD(info *SwapPairInfo, amount0, amount1 float64, tokenPrice map[string]float64) float64 {
+	price0, exist0 := tokenPrice[info.BaseSymbol]
+	price1, exist1 := tokenPrice[info.QuoteSymbol]
+	value0, value1 := amount0*price0, amount1*price1
+	if exist0 && exist1 && info.nonstandard0 && !info.nonstandard1 {
+		value0 = value1
+	} else if exist0 && exist1 && info.nonstandard1 && !info.nonstandard0 {
+		value1 = value0
+	}
+	return value0 + value1
+}
+
+// tradeVolumeUSD values the swapped amounts of a pair. Every swap moves both tokens, so either
+// side gives the traded value, the average is taken when both tokens are priced. The side of a fee
+// on transfer or rebasing token is left out when the other side is plain.
+func tradeVolumeUSD(info *SwapPairInfo, amount0, amount1 float64, tokenPrice map[string]float64) float64 {
+	price0, exist0 := tokenPrice[info.BaseSymbol]
+	price1, exist1 := tokenPrice[info.QuoteSymbol]
+	switch {
+	case exist0 && exist1 && info.nonstandard0 && !info.nonstandard1:
+		return amount1 * price1
+	case exist0 && exist1 && info.nonstandard1 && !info.nonstandard0:
+		return amount0 * price0
+	case exist0 && exist1:
+		return (amount0*price0 + amount1*price1) / 2
+	case exist0:
+		return amount0 * price0
+	case exist1:
+		return amount1 * price1
+	}
+	return 0
+}
+
+// feeOf splits the swap fee of the traded value into the lp part and the protocol part
+func (r *StatasSvc) feeOf(info *SwapPairInfo, volumeUSD float64) FeeStat {
+	feeConfig := r.chainConfig.FeeConfigOf(info.factory)
+	fee := volumeUSD * feeConfig.SwapFeeRate
+	if !info.protocolFeeOn {
+		return FeeStat{LpFee: fee, VolumeUSD: volumeUSD}
+	}
+	return FeeStat{
+		LpFee:       fee * (1 - feeConfig.ProtocolFeeShare),
+		ProtocolFee: fee * feeConfig.ProtocolFeeShare,
+		VolumeUSD:   volumeUSD,
+	}
+}
+
+// getFeeOn returns whether the protocol fee is on for each factory of the chain. A factory whose feeTo can
+// not be read keeps the value of the refresh before, or is fee off, such as a fork without feeTo. The
+// returned stale is whether a retryable error kept a value.
+func (r *StatasSvc) getFeeOn() (map[string]bool, bool) {
+	r.mux.Lock()
+	lastFeeOn := r.feeOn
+	r.mux.Unlock()
+	feeOn := make(map[string]bool, len(r.chainConfig.SwapFactories))
+	stale := false
+	for _, factory := range r.chainConfig.SwapFactories {
+		factory = strings.ToLower(factory)
+		on, err := r.executor.GetProtocolFeeOn(factory)
+		if err != nil {
+			util.Logger.Errorf("get feeTo failed, chain=%s, factory=%s, err=%v, keep fee on=%v", r.Chain(), factory, err, lastFeeOn[factory])
+			on = lastFeeOn[factory]
+			stale = stale || executor.IsRetryable(err)
+		}
+		feeOn[factory] = on
+	}
+	r.mux.Lock()
+	r.feeOn = feeOn
+	r.mux.Unlock()
+	return feeOn, stale
+}
+
+// refreshFees fills the fee stats of every pair, the 24h volume of the pairs should be set already.
+// It returns the protocol wide fee stats.
+func (r *StatasSvc) refreshFees(swapPairInfoMap map[ethcmm.Address]*SwapPairInfo, tokenPrice map[string]float64) (FeeStats, error) {
+	var totalFees FeeStats
+
+	blockTime, err := model.GetLatestBlockTime(r.statasDB, r.Chain())
+	if err != nil {
+		return totalFees, err
+	}
+	hourStatas, err := model.GetTotalAccountSince(r.statasDB, r.Chain(), blockTime-int64(time.Hour.Seconds()))
+	if err != nil {
+		return totalFees, err
+	}
+	dayStart := blockTime - blockTime%int64((24*time.Hour).Seconds())
+	todayStatas, err := model.GetTotalAccountSince(r.statasDB, r.Chain(), dayStart-1)
+	if err != nil {
+		return totalFees, err
+	}
+
+	for _, swapInfo := range swapPairInfoMap {
+		swapInfo.Fees = FeeStats{}
+		swapInfo.Fees.Last24h = r.feeOf(swapInfo, tradeVolumeUSD(swapInfo, swapInfo.BaseVolume24h, swapInfo.QuoteVolume24h, tokenPrice))
+	}
+	for _, stata := range hourStatas {
+		if swapInfo := swapPairInfoMap[ethcmm.HexToAddress(stata.ContractAddress)]; swapInfo != nil {
+			swapInfo.Fees.LastHour = r.feeOf(swapInfo, tradeVolumeUSD(swapInfo, stata.TotalAmount0, stata.TotalAmount1, tokenPrice))
+		}
+	}
+	for _, stata := range todayStatas {
+		if swapInfo := swapPairInfoMap[ethcmm.HexToAddress(stata.ContractAddress)]; swapInfo != nil {
+			swapInfo.Fees.Today = r.feeOf(swapInfo, tradeVolumeUSD(swapInfo, stata.TotalAmount0, stata.TotalAmount1, tokenPrice))
+		}
+	}
+	for _, swapInfo := range swapPairInfoMap {
+		totalFees.Add(swapInfo.Fees)
+	}
+	return totalFees, nil
+}
@@ -0,0 +1,143 @@
+package statas
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/model"
+)
+
+// holderBuckets are the lower bounds of the balance buckets of the holder distribution
+var holderBuckets = []float64{0, 1, 10, 100, 1e3, 1e4, 1e5, 1e6}
+
+// TokenHolders are the confirmed holders of a tracked token, the shares are of the balances of all holders.
+// Partial is whether a holder sent more than the indexed transfers gave it, the token is indexed from after
+// its first transfer and the balances miss what was transferred before.
+type TokenHolders struct {
+	Token        string          `json:"token"`
+	Partial      bool            `json:"partial"`
+	Holders      int             `json:"holders"`
+	TopHolders   []HolderBalance `json:"top_holders"`
+	Distribution []HolderBucket  `json:"distribution"`
+	History      []HolderCount   `json:"history"`
+}
+
+type HolderBalance struct {
+	Address string  `json:"address"`
+	Balance float64 `json:"balance"`
+	Share   float64 `json:"share"`
+}
+
+// HolderBucket are the holders with a balance in [Min, Max), the last bucket has no Max
+type HolderBucket struct {
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max,omitempty"`
+	Holders int     `json:"holders"`
+	Balance float64 `json:"balance"`
+	Share   float64 `json:"share"`
+}
+
+// HolderCount is the number of holders at the end of a day
+type HolderCount struct {
+	Date    time.Time `json:"date"`
+	Holders int64     `json:"holders"`
+}
+
+// GetTokenDecimals returns the decimals of the token from the registry
+func (r *StatasSvc) GetTokenDecimals(token ethcmm.Address) (uint8, error) {
+	info, err := r.tokens.token(token)
+	if err != nil {
+		return 0, err
+	}
+	return info.Decimals, nil
+}
+
+// TracksHolders returns whether the transfers of the token are indexed for its holders
+func (r *StatasSvc) TracksHolders(token ethcmm.Address) bool {
+	for _, holderToken := range r.chainConfig.HolderTokens {
+		if strings.EqualFold(holderToken, token.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTokenHolders returns the top holders of the token, the distribution of all holders and the daily
+// holder counts of the last days. Only confirmed transfers are counted.
+func (r *StatasSvc) GetTokenHolders(token ethcmm.Address, top, days int, now time.Time) (*TokenHolders, error) {
+	balances, err := model.GetTokenHolderBalances(r.statasDB, r.Chain(), token.String())
+	if err != nil {
+		return nil, err
+	}
+	topHolders, err := model.GetTopTokenHolders(r.statasDB, r.Chain(), token.String(), top)
+	if err != nil {
+		return nil, err
+	}
+	partial, err := model.HasOverdrawnTokenHolders(r.statasDB, r.Chain(), token.String())
+	if err != nil {
+		return nil, err
+	}
+	since := dayStart(now).AddDate(0, 0, -days+1)
+	counts, err := model.GetTokenHolderCounts(r.statasDB, r.Chain(), token.String(), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	var total float64
+	for _, balance := range balances {
+		total += balance
+	}
+	share := func(balance float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return balance / total
+	}
+
+	holders := &TokenHolders{
+		Token:        token.String(),
+		Partial:      partial,
+		Holders:      len(balances),
+		TopHolders:   make([]HolderBalance, 0, len(topHolders)),
+		Distribution: make([]HolderBucket, 0, len(holderBuckets)),
+		History:      make([]HolderCount, 0, days),
+	}
+	for _, holder := range topHolders {
+		holders.TopHolders = append(holders.TopHolders, HolderBalance{
+			Address: ethcmm.HexToAddress(holder.Holder).String(),
+			Balance: holder.Balance,
+			Share:   share(holder.Balance),
+		})
+	}
+	for idx, min := range holderBuckets {
+		bucket := HolderBucket{Min: min}
+		max := math.Inf(1)
+		if idx+1 < len(holderBuckets) {
+			max = holderBuckets[idx+1]
+			bucket.Max = max
+		}
+		for _, balance := range balances {
+			if balance >= min && balance < max {
+				bucket.Holders++
+				bucket.Balance += balance
+			}
+		}
+		bucket.Share = share(bucket.Balance)
+		holders.Distribution = append(holders.Distribution, bucket)
+	}
+	// a day without transfers keeps the count of the day before
+	var last *model.TokenHolderCount
+	for day := since; !day.After(now); day = day.AddDate(0, 0, 1) {
+		for len(counts) > 0 && counts[0].Date <= day.Unix() {
+			last, counts = &counts[0], counts[1:]
+		}
+		if last == nil {
+			continue
+		}
+		holders.History = append(holders.History, HolderCount{Date: day, Holders: last.Holders})
+	}
+	return holders, nil
+}
@@ -0,0 +1,69 @@
+package statas
+
+import (
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/model"
+)
+
+const (
+	// dustLpBalance is the lp balance below which a position is treated as closed
+	dustLpBalance = 1e-12
+)
+
+// LiquidityPosition is an lp position of a wallet, EntryUnknown is set when a deposit of it could not be
+// valued at its block
+type LiquidityPosition struct {
+	Chain            string  `json:"chain"`
+	SwapPairContract string  `json:"swap_pair_contract"`
+	BaseSymbol       string  `json:"base_symbol"`
+	QuoteSymbol      string  `json:"quote_symbol"`
+	LpBalance        float64 `json:"lp_balance"`
+	ShareOfPool      float64 `json:"share_of_pool"`
+	Amount0          float64 `json:"amount0"`
+	Amount1          float64 `json:"amount1"`
+	ValueUSD         float64 `json:"value_usd"`
+	EntryAmount0     float64 `json:"entry_amount0"`
+	EntryAmount1     float64 `json:"entry_amount1"`
+	EntryValueUSD    float64 `json:"entry_value_usd"`
+	EntryUnknown     bool    `json:"entry_unknown"`
+	FirstHeight      int64   `json:"first_height"`
+}
+
+// GetWalletLiquidity returns the open lp positions of the wallet valued with the latest reserves and
+// prices, and the total USD value of them. Pairs which are not refreshed only report the lp balance.
+func (r *StatasSvc) GetWalletLiquidity(wallet string) ([]LiquidityPosition, float64, error) {
+	lpPositions, err := model.GetWalletLpPositions(r.statasDB, r.Chain(), wallet)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	positions := make([]LiquidityPosition, 0, len(lpPositions))
+	var totalValue float64
+	for _, lpPosition := range lpPositions {
+		if lpPosition.Balance < dustLpBalance {
+			continue
+		}
+		position := LiquidityPosition{
+			Chain:            r.Chain(),
+			SwapPairContract: ethcmm.HexToAddress(lpPosition.ContractAddress).String(),
+			LpBalance:        lpPosition.Balance,
+			EntryAmount0:     lpPosition.EntryAmount0,
+			EntryAmount1:     lpPosition.EntryAmount1,
+			EntryValueUSD:    lpPosition.EntryValueUSD,
+			EntryUnknown:     lpPosition.EntryUnknown,
+			FirstHeight:      lpPosition.FirstHeight,
+		}
+		if info, exist := r.GetSwapPairInfo(ethcmm.HexToAddress(lpPosition.ContractAddress)); exist && info.lpSupply > 0 {
+			position.BaseSymbol = info.BaseSymbol
+			position.QuoteSymbol = info.QuoteSymbol
+			position.ShareOfPool = lpPosition.Balance / info.lpSupply
+			position.Amount0 = info.reserve0 * position.ShareOfPool
+			position.Amount1 = info.reserve1 * position.ShareOfPool
+			position.ValueUSD = info.reserveUSD * position.ShareOfPool
+		}
+		totalValue += position.ValueUSD
+		positions = append(positions, position)
+	}
+	return positions, totalValue, nil
+}
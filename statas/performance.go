@@ -0,0 +1,190 @@
+package statas
+
+import (
+	"math"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// PositionPerformance compares an lp position with holding the deposited amounts. The position value
+// is split into the value the deposit would have without fees, which gives the impermanent loss
+// against holding, and the fees earned on top of it.
+type PositionPerformance struct {
+	Chain               string  `json:"chain"`
+	SwapPairContract    string  `json:"swap_pair_contract"`
+	BaseSymbol          string  `json:"base_symbol"`
+	QuoteSymbol         string  `json:"quote_symbol"`
+	ValueUSD            float64 `json:"value_usd"`
+	HoldValueUSD        float64 `json:"hold_value_usd"`
+	ValueWithoutFeesUSD float64 `json:"value_without_fees_usd"`
+	ImpermanentLoss     float64 `json:"impermanent_loss"`
+	ImpermanentLossUSD  float64 `json:"impermanent_loss_usd"`
+	FeesEarnedUSD       float64 `json:"fees_earned_usd"`
+	EntryValueUSD       float64 `json:"entry_value_usd"`
+	NetPnlUSD           float64 `json:"net_pnl_usd"`
+	// Priced is false when a token of the pair has no price or the entry is unknown, only the entry figures
+	// are valid then
+	Priced bool `json:"priced"`
+}
+
+type PairPerformance struct {
+	Chain            string `json:"chain"`
+	SwapPairContract string `json:"swap_pair_contract"`
+	BaseSymbol       string `json:"base_symbol"`
+	QuoteSymbol      string `json:"quote_symbol"`
+	// ImpermanentLoss7d and ImpermanentLoss30d are the average loss of entering at any snapshot of the window and exiting now
+	ImpermanentLoss7d  float64 `json:"impermanent_loss_7d"`
+	ImpermanentLoss30d float64 `json:"impermanent_loss_30d"`
+	// FeeYield7d and FeeYield30d are the growth of the underlying value of one lp token from fees over the window
+	FeeYield7d  float64 `json:"fee_yield_7d"`
+	FeeYield30d float64 `json:"fee_yield_30d"`
+	Snapshots   int     `json:"snapshots_30d"`
+}
+
+// impermanentLoss returns the loss of an lp position against holding when the price changes by the ratio
+func impermanentLoss(priceRatio float64) float64 {
+	if priceRatio <= 0 {
+		return 0
+	}
+	return 2*math.Sqrt(priceRatio)/(1+priceRatio) - 1
+}
+
+func (r *StatasSvc) GetWalletPerformance(wallet string) ([]PositionPerformance, error) {
+	positions, _, err := r.GetWalletLiquidity(wallet)
+	if err != nil {
+		return nil, err
+	}
+	prices, _ := r.GetPrice()
+
+	performances := make([]PositionPerformance, 0, len(positions))
+	for _, position := range positions {
+		performance := PositionPerformance{
+			Chain:            position.Chain,
+			SwapPairContract: position.SwapPairContract,
+			BaseSymbol:       position.BaseSymbol,
+			QuoteSymbol:      position.QuoteSymbol,
+			ValueUSD:         position.ValueUSD,
+			EntryValueUSD:    position.EntryValueUSD,
+		}
+		price0, exist0 := prices[position.BaseSymbol]
+		price1, exist1 := prices[position.QuoteSymbol]
+		// a position whose deposits were not all valued has no entry to compare with
+		entryKnown := !position.EntryUnknown && position.EntryAmount0 > 0 && position.EntryAmount1 > 0 && position.EntryValueUSD > 0
+		if exist0 && exist1 && position.Amount0 > 0 && position.Amount1 > 0 && entryKnown {
+			performance.Priced = true
+			performance.HoldValueUSD = position.EntryAmount0*price0 + position.EntryAmount1*price1
+
+			// the deposit keeps its k without fees, rebalanced to the current reserve ratio
+			k := position.EntryAmount0 * position.EntryAmount1
+			ratio := position.Amount1 / position.Amount0
+			amount0 := math.Sqrt(k / ratio)
+			amount1 := math.Sqrt(k * ratio)
+			performance.ValueWithoutFeesUSD = amount0*price0 + amount1*price1
+
+			if performance.HoldValueUSD > 0 {
+				performance.ImpermanentLoss = performance.ValueWithoutFeesUSD/performance.HoldValueUSD - 1
+			}
+			performance.ImpermanentLossUSD = performance.ValueWithoutFeesUSD - performance.HoldValueUSD
+			performance.FeesEarnedUSD = position.ValueUSD - performance.ValueWithoutFeesUSD
+			performance.NetPnlUSD = position.ValueUSD - position.EntryValueUSD
+		}
+		performances = append(performances, performance)
+	}
+	return performances, nil
+}
+
+func (r *StatasSvc) GetPairPerformance(addr ethcmm.Address) (*PairPerformance, error) {
+	info, exist := r.GetSwapPairInfo(addr)
+	if !exist {
+		return nil, nil
+	}
+
+	now := time.Now()
+	snapshots, err := model.GetPairSnapshotsSince(r.statasDB, r.Chain(), addr.String(), now.Add(-30*24*time.Hour).Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	performance := &PairPerformance{
+		Chain:            r.Chain(),
+		SwapPairContract: info.SwapPairContract,
+		BaseSymbol:       info.BaseSymbol,
+		QuoteSymbol:      info.QuoteSymbol,
+		Snapshots:        len(snapshots),
+	}
+	if len(snapshots) == 0 || info.LastPrice == 0 || info.lpSupply == 0 {
+		return performance, nil
+	}
+
+	since7d := now.Add(-7 * 24 * time.Hour).Unix()
+	var total7d, total30d float64
+	var count7d, count30d int
+	for _, snapshot := range snapshots {
+		if snapshot.Price == 0 {
+			continue
+		}
+		loss := impermanentLoss(info.LastPrice / snapshot.Price)
+		total30d += loss
+		count30d++
+		if snapshot.SnapshotTime >= since7d {
+			total7d += loss
+			count7d++
+		}
+	}
+	if count30d > 0 {
+		performance.ImpermanentLoss30d = total30d / float64(count30d)
+	}
+	if count7d > 0 {
+		performance.ImpermanentLoss7d = total7d / float64(count7d)
+	}
+
+	// sqrt(k) per lp token only grows with fees, price moves keep it unchanged
+	rootK := math.Sqrt(info.reserve0*info.reserve1) / info.lpSupply
+	feeYield := func(snapshot model.PairSnapshot) float64 {
+		if snapshot.LpSupply == 0 || snapshot.Reserve0*snapshot.Reserve1 == 0 {
+			return 0
+		}
+		return rootK/(math.Sqrt(snapshot.Reserve0*snapshot.Reserve1)/snapshot.LpSupply) - 1
+	}
+	performance.FeeYield30d = feeYield(snapshots[0])
+	for _, snapshot := range snapshots {
+		if snapshot.SnapshotTime >= since7d {
+			performance.FeeYield7d = feeYield(snapshot)
+			break
+		}
+	}
+	return performance, nil
+}
+
+// snapshotPairs saves the state of the pairs as reserve history once every PairSnapshotInterval
+func (r *StatasSvc) snapshotPairs(swapPairInfoMap map[ethcmm.Address]*SwapPairInfo, now time.Time) {
+	if now.Sub(r.lastSnapshotAt) < common.PairSnapshotInterval {
+		return
+	}
+	snapshots := make([]*model.PairSnapshot, 0, len(swapPairInfoMap))
+	for _, info := range swapPairInfoMap {
+		snapshots = append(snapshots, &model.PairSnapshot{
+			Chain:           r.Chain(),
+			ContractAddress: info.SwapPairContract,
+			Reserve0:        info.reserve0,
+			Reserve1:        info.reserve1,
+			LpSupply:        info.lpSupply,
+			Price:           info.LastPrice,
+			ReserveUSD:      info.reserveUSD,
+			SnapshotTime:    now.Unix(),
+		})
+	}
+	if err := model.SavePairSnapshots(r.statasDB, snapshots); err != nil {
+		util.Logger.Errorf("save pair snapshots error, err=%s", err.Error())
+		return
+	}
+	if err := model.PrunePairSnapshots(r.statasDB, r.Chain(), now.Add(-common.PairSnapshotRetention).Unix()); err != nil {
+		util.Logger.Errorf("prune pair snapshots error, err=%s", err.Error())
+	}
+	r.lastSnapshotAt = now
+}
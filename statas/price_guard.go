@@ -0,0 +1,222 @@
+package statas
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	guardReasonJump  = "jump"
+	guardReasonTwap  = "twap"
+	guardReasonCross = "cross_pair"
+)
+
+type priceSample struct {
+	at    time.Time
+	price float64
+}
+
+// priceGuard quarantines the suspicious token prices of the refreshes, a price jumping from the last
+// good one, deviating from the twap of the good ones or from the prices implied by the other pairs of
+// the token. The last good price is served instead until the price is normal again, or accepted when
+// it stays suspicious for the confirm refreshes, since a manipulation by a flash loan does not last.
+type priceGuard struct {
+	mux     sync.Mutex
+	chain   string
+	setting util.PriceGuardConfig
+
+	lastGood map[string]float64
+	samples  map[string][]priceSample
+	// suspects counts the refreshes in a row a price is suspicious
+	suspects map[string]int
+	// quarantined is the reason of each quarantined token
+	quarantined map[string]string
+}
+
+func newPriceGuard(chain string, setting util.PriceGuardConfig) *priceGuard {
+	return &priceGuard{
+		chain:       chain,
+		setting:     setting,
+		lastGood:    make(map[string]float64, 0),
+		samples:     make(map[string][]priceSample, 0),
+		suspects:    make(map[string]int, 0),
+		quarantined: make(map[string]string, 0),
+	}
+}
+
+// deviation returns the deviation of the price from the reference, 0 without a reference
+func deviation(price, reference float64) float64 {
+	if reference <= 0 {
+		return 0
+	}
+	return math.Abs(price-reference) / reference
+}
+
+// check returns the prices to serve with the quarantined ones replaced, the reasons of the quarantined
+// tokens and the decisions made. The stable tokens are not checked.
+func (g *priceGuard) check(now time.Time, prices map[string]float64, metrics map[string]map[string]*PriceVolume,
+	stableTokens []string) (map[string]float64, map[string]string, []*model.PriceQuarantineLog) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	stable := make(map[string]bool, len(stableTokens))
+	for _, token := range stableTokens {
+		stable[token] = true
+	}
+	guarded := make(map[string]float64, len(prices))
+	decisions := make([]*model.PriceQuarantineLog, 0)
+	// the symbols are checked in order so the decisions are recorded in order
+	symbols := make([]string, 0, len(prices))
+	for symbol := range prices {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		price := prices[symbol]
+		guarded[symbol] = price
+		if stable[symbol] {
+			continue
+		}
+		lastGood, hasLast := g.lastGood[symbol]
+		twap := g.twap(symbol, now)
+		crossPrice := crossPriceOf(symbol, prices, metrics)
+
+		reasons := make([]string, 0)
+		if hasLast && deviation(price, lastGood) > g.setting.MaxJump {
+			reasons = append(reasons, guardReasonJump)
+		}
+		if deviation(price, twap) > g.setting.MaxTwapDeviation {
+			reasons = append(reasons, guardReasonTwap)
+		}
+		if deviation(price, crossPrice) > g.setting.MaxCrossDeviation {
+			reasons = append(reasons, guardReasonCross)
+		}
+		decision := &model.PriceQuarantineLog{
+			Chain:      g.chain,
+			Symbol:     symbol,
+			Reason:     strings.Join(reasons, ","),
+			Price:      price,
+			LastPrice:  lastGood,
+			Twap:       twap,
+			CrossPrice: crossPrice,
+		}
+
+		if len(reasons) == 0 {
+			if _, exist := g.quarantined[symbol]; exist {
+				decision.Action = model.QuarantineActionRelease
+				decisions = append(decisions, decision)
+			}
+			g.accept(symbol, price, now, false)
+			continue
+		}
+
+		g.suspects[symbol]++
+		if g.suspects[symbol] >= g.setting.ConfirmRefreshes {
+			decision.Action = model.QuarantineActionAccept
+			decisions = append(decisions, decision)
+			util.Logger.Warningf("suspicious price accepted after %d refreshes, chain=%s, symbol=%s, price=%v, reason=%s",
+				g.suspects[symbol], g.chain, symbol, price, decision.Reason)
+			// the twap starts over from the new level
+			g.accept(symbol, price, now, true)
+			continue
+		}
+
+		decision.Action = model.QuarantineActionQuarantine
+		decisions = append(decisions, decision)
+		g.quarantined[symbol] = decision.Reason
+		util.Logger.Warningf("price quarantined, chain=%s, symbol=%s, price=%v, last=%v, twap=%v, cross=%v, reason=%s",
+			g.chain, symbol, price, lastGood, twap, crossPrice, decision.Reason)
+		if hasLast {
+			guarded[symbol] = lastGood
+		} else {
+			delete(guarded, symbol)
+		}
+	}
+
+	quarantined := make(map[string]string, len(g.quarantined))
+	for symbol, reason := range g.quarantined {
+		if _, exist := prices[symbol]; exist {
+			quarantined[symbol] = reason
+		}
+	}
+	return guarded, quarantined, decisions
+}
+
+// accept takes the price as the good one
+func (g *priceGuard) accept(symbol string, price float64, now time.Time, reset bool) {
+	delete(g.quarantined, symbol)
+	delete(g.suspects, symbol)
+	g.lastGood[symbol] = price
+	samples := g.samples[symbol]
+	if reset {
+		samples = nil
+	}
+	samples = append(samples, priceSample{at: now, price: price})
+	window := time.Duration(g.setting.TwapWindow) * time.Second
+	for len(samples) > 1 && now.Sub(samples[0].at) > window {
+		samples = samples[1:]
+	}
+	g.samples[symbol] = samples
+}
+
+// twap returns the time weighted average of the good prices in the window, each price holds until
+// the next one
+func (g *priceGuard) twap(symbol string, now time.Time) float64 {
+	samples := g.samples[symbol]
+	if len(samples) == 0 {
+		return 0
+	}
+	var weighted, total float64
+	for idx, sample := range samples {
+		end := now
+		if idx+1 < len(samples) {
+			end = samples[idx+1].at
+		}
+		weight := end.Sub(sample.at).Seconds()
+		weighted += sample.price * weight
+		total += weight
+	}
+	if total <= 0 {
+		return samples[len(samples)-1].price
+	}
+	return weighted / total
+}
+
+// crossPriceOf returns the median of the prices of the token implied by its pairs with priced tokens,
+// weighted by the reserve of the token in the pair so a thin pair does not move it, 0 with less than
+// two pairs
+func crossPriceOf(symbol string, prices map[string]float64, metrics map[string]map[string]*PriceVolume) float64 {
+	type implied struct {
+		price, weight float64
+	}
+	implieds := make([]implied, 0)
+	var total float64
+	for other, pairs := range metrics {
+		metric, exist := pairs[symbol]
+		otherPrice, priced := prices[other]
+		if other == symbol || !exist || !priced || metric.Price == 0 || metric.Reserve <= 0 {
+			continue
+		}
+		implieds = append(implieds, implied{price: otherPrice / metric.Price, weight: metric.Reserve})
+		total += metric.Reserve
+	}
+	if len(implieds) < 2 {
+		return 0
+	}
+	sort.Slice(implieds, func(i, j int) bool { return implieds[i].price < implieds[j].price })
+	var cumulative float64
+	for _, item := range implieds {
+		cumulative += item.weight
+		if cumulative >= total/2 {
+			return item.price
+		}
+	}
+	return implieds[len(implieds)-1].price
+}
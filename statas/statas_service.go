@@ -1,6 +1,7 @@
 package statas
 
 import (
+	"context"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/pieswap/pie-statas/executor"
@@ -12,10 +13,10 @@ import (
 
 	ethcmm "github.com/ethereum/go-ethereum/common"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/pieswap/pie-statas/abi"
 	"github.com/pieswap/pie-statas/common"
 	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/pubsub"
 	"github.com/pieswap/pie-statas/util"
 )
 
@@ -23,173 +24,345 @@ const (
 	QulifiedVolume = 100
 )
 
-var STABLE_TOKENS = map[string]bool{"BUSD": true}
-var BASE_TOKENS = []string{"WOKT", "BUSD"}
-
 type SwapPairInfo struct {
-	SwapPairContract string  `json:"swap_pair_contract"`
-	BaseSymbol       string  `json:"base_symbol"`
-	QuoteSymbol      string  `json:"quote_symbol"`
-	LastPrice        float64 `json:"last_price"`
-	BaseVolume24h    float64 `json:"base_volume_24_h"`
-	QuoteVolume24h   float64 `json:"quote_volume_24_h"`
+	Chain            string   `json:"chain"`
+	SwapPairContract string   `json:"swap_pair_contract"`
+	BaseSymbol       string   `json:"base_symbol"`
+	QuoteSymbol      string   `json:"quote_symbol"`
+	LastPrice        float64  `json:"last_price"`
+	BaseVolume24h    float64  `json:"base_volume_24_h"`
+	QuoteVolume24h   float64  `json:"quote_volume_24_h"`
+	Fees             FeeStats `json:"fees"`
+	// Approximate is set when a token of the pair is fee on transfer or rebasing, the amounts the pair
+	// records are not what the traders got
+	Approximate bool `json:"approximate,omitempty"`
+	// WashRatio is the share of the 24h volume classified as wash trading, for the reasons in WashFlags
+	WashRatio float64  `json:"wash_ratio"`
+	WashFlags []string `json:"wash_flags,omitempty"`
+
+	factory       string
+	protocolFeeOn bool
+
+	token0     ethcmm.Address
+	token1     ethcmm.Address
+	decimal0   uint8
+	decimal1   uint8
+	reserve0   float64
+	reserve1   float64
+	lpSupply   float64
+	volumeUSD  float64
+	reserveUSD float64
+
+	// nonstandard0 and nonstandard1 are whether the tokens are fee on transfer or rebasing
+	nonstandard0 bool
+	nonstandard1 bool
+	// decimalsDegraded is whether the decimals of a token could not be read, the reserves may be off by
+	// orders of magnitude and the pair neither prices tokens nor counts in the TVL
+	decimalsDegraded bool
+}
+
+func (info *SwapPairInfo) Tokens() (ethcmm.Address, ethcmm.Address) {
+	return info.token0, info.token1
+}
+
+func (info *SwapPairInfo) Decimals() (uint8, uint8) {
+	return info.decimal0, info.decimal1
+}
+
+func (info *SwapPairInfo) Reserves() (float64, float64) {
+	return info.reserve0, info.reserve1
+}
+
+// LpSupply returns the total supply of the lp token of the pair
+func (info *SwapPairInfo) LpSupply() float64 {
+	return info.lpSupply
+}
+
+// VolumeUSD returns the 24h volume in USD, it is only counted for tokens with a known price
+func (info *SwapPairInfo) VolumeUSD() float64 {
+	return info.volumeUSD
+}
+
+// ReserveUSD returns the liquidity in USD, it is only counted for tokens with a known price
+func (info *SwapPairInfo) ReserveUSD() float64 {
+	return info.reserveUSD
+}
 
-	decimal0 uint8
-	decimal1 uint8
-	reserve0 float64
-	reserve1 float64
+type PriceSnapshot struct {
+	UpdateAt time.Time          `json:"update_at"`
+	Prices   map[string]float64 `json:"prices"`
+}
+
+type StatSnapshot struct {
+	UpdateAt            time.Time `json:"update_at"`
+	TotalVolume         float64   `json:"24h_total_volume"`
+	AdjustedVolume      float64   `json:"24h_adjusted_volume"`
+	LockVolume          float64   `json:"total_value_locked"`
+	TotalValueLockedAll float64   `json:"total_value_locked_all"`
+	Fees                FeeStats  `json:"fees"`
 }
 
 type SyrupTVL struct {
-	Name string  `json:"name"`
-	Tvl  float64 `json:"tvl"`
+	Chain string  `json:"chain"`
+	Name  string  `json:"name"`
+	Tvl   float64 `json:"tvl"`
 }
 
 type StatasSvc struct {
-	mux       sync.Mutex
-	statasDB  *gorm.DB
-	config    *util.Config
-	bscClient *ethclient.Client
-	executor  executor.Executor
-
-	updateAt        time.Time
-	tokenPrice      map[string]float64
+	mux         sync.Mutex
+	statasDB    *gorm.DB
+	config      *util.Config
+	chainConfig *util.ChainConfig
+	client      executor.ChainClient
+	executor    executor.Executor
+	bus         *pubsub.Bus
+	wg          sync.WaitGroup
+
+	updateAt time.Time
+	// stale is whether the last refresh failed, the data of the refresh before is served then
+	stale      bool
+	tokenPrice map[string]float64
+	// quarantined are the tokens served with their last good price, with the reasons
+	quarantined     map[string]string
+	priceGuard      *priceGuard
 	totalVolume     float64
 	totalLockVolume float64
+	// adjustedVolume is the total volume without the wash trading
+	adjustedVolume float64
+	// hopVolume is the trade value of every swap, userTradeVolume counts a multi hop trade once
+	hopVolume       float64
+	userTradeVolume float64
+	totalFees       FeeStats
+	lastSnapshotAt  time.Time
+	// feeOn is whether the protocol fee is on for each factory at the last refresh
+	feeOn map[string]bool
 
 	TVL        float64
 	SyrupPools []SyrupTVL
 
+	tokens   *tokenRegistry
+	detector *behaviourDetector
+	// supplies are the supplies of the priced tokens by symbol, read at supplyAt
+	supplies map[string]*TokenSupply
+	supplyAt time.Time
+
+	// anchors, poolList, CertPairList, the token lists, the allowed and denied tokens and the holders excluded
+	// from the circulating supply are replaced on a config reload
+	anchors         util.PricingAnchors
 	poolList        []ethcmm.Address
 	swapPairList    []ethcmm.Address
 	CertPairList    []ethcmm.Address
+	tokenLists      []string
+	allowTokens     []ethcmm.Address
+	denyTokens      map[ethcmm.Address]bool
+	supplyExcluded  map[string][]ethcmm.Address
+	reloaded        chan struct{}
 	swapPairInfoMap map[ethcmm.Address]*SwapPairInfo
 	swapPairInfos   []SwapPairInfo
+	// pairTokens are the tokens of the pairs listed
+	pairTokens map[ethcmm.Address][]ethcmm.Address
 }
 
-func NewStatasSvc(statasDB *gorm.DB, config *util.Config, executor executor.Executor) *StatasSvc {
-	bscClient, err := ethclient.Dial(config.ChainConfig.BSCProvider)
-	if err != nil {
-		panic("new eth client error")
-	}
-	pairList := make([]ethcmm.Address, 0, len(config.ChainConfig.CertificatedPairs))
-	for _, addr := range config.ChainConfig.CertificatedPairs {
-		pairList = append(pairList, ethcmm.HexToAddress(addr))
+func NewStatasSvc(statasDB *gorm.DB, config *util.Config, chainConfig *util.ChainConfig,
+	client executor.ChainClient, executor executor.Executor) *StatasSvc {
+	return &StatasSvc{
+		statasDB:       statasDB,
+		client:         client,
+		tokens:         newTokenRegistry(statasDB, chainConfig.Name, client, chainConfig.TokenDecimals()),
+		detector:       newBehaviourDetector(),
+		anchors:        chainConfig.PricingAnchors(),
+		CertPairList:   toAddresses(chainConfig.CertificatedPairs),
+		poolList:       toAddresses(chainConfig.SynupPools),
+		tokenLists:     chainConfig.TokenLists,
+		allowTokens:    toAddresses(chainConfig.AllowTokens),
+		denyTokens:     toAddressSet(chainConfig.DenyTokens),
+		supplyExcluded: toSupplyExcluded(chainConfig.SupplyExcluded),
+		reloaded:       make(chan struct{}, 1),
+		pairTokens:     make(map[ethcmm.Address][]ethcmm.Address, 0),
+		priceGuard:     newPriceGuard(chainConfig.Name, chainConfig.PriceGuardSetting()),
+		config:         config,
+		chainConfig:    chainConfig,
+		executor:       executor,
 	}
-	poolList := make([]ethcmm.Address, 0, len(config.ChainConfig.SynupPools))
-	for _, addr := range config.ChainConfig.SynupPools {
-		poolList = append(poolList, ethcmm.HexToAddress(addr))
+}
+
+func toAddresses(addrs []string) []ethcmm.Address {
+	addresses := make([]ethcmm.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, ethcmm.HexToAddress(addr))
 	}
-	return &StatasSvc{
-		statasDB:     statasDB,
-		bscClient:    bscClient,
-		CertPairList: pairList,
-		poolList:     poolList,
-		config:       config,
-		executor:     executor,
+	return addresses
+}
+
+// Reload applies the certificated pairs, the syrup pools, the pricing anchors, the token lists, the
+// allowed and denied tokens and the supply excluded holders of the reloaded chain config. The prices are kept, the pairs are listed again
+// at once and priced by the next refresh.
+func (r *StatasSvc) Reload(chainConfig *util.ChainConfig) {
+	r.mux.Lock()
+	r.anchors = chainConfig.PricingAnchors()
+	r.CertPairList = toAddresses(chainConfig.CertificatedPairs)
+	r.poolList = toAddresses(chainConfig.SynupPools)
+	r.tokenLists = chainConfig.TokenLists
+	r.allowTokens = toAddresses(chainConfig.AllowTokens)
+	r.denyTokens = toAddressSet(chainConfig.DenyTokens)
+	r.supplyExcluded = toSupplyExcluded(chainConfig.SupplyExcluded)
+	r.mux.Unlock()
+	r.importTokenLists()
+	select {
+	case r.reloaded <- struct{}{}:
+	default:
 	}
 }
 
-func (r *StatasSvc) refreshSwapPairs() []ethcmm.Address {
-	totalSwapPairList := r.executor.GetPairList()
-	certiMap := make(map[string]ethcmm.Address, 0)
-	swapPairList := make([]ethcmm.Address, 0)
-	for _, swapPairAddr := range r.CertPairList {
-		pairInstance, err := abi.NewSwappair(swapPairAddr, r.bscClient)
-		if err != nil {
-			continue
-		}
-		token0, err := pairInstance.Token0(nil)
-		if err != nil {
-			continue
-		}
-		token1, err := pairInstance.Token1(nil)
-		if err != nil {
-			continue
-		}
-		token0Instance, err := abi.NewBep20(token0, r.bscClient)
-		if err != nil {
-			continue
-		}
-		token1Instance, err := abi.NewBep20(token1, r.bscClient)
-		if err != nil {
-			continue
-		}
-		symbol0, err := token0Instance.Symbol(nil)
-		if err != nil {
-			continue
-		}
+// reloadable returns the settings replaced on a config reload
+func (r *StatasSvc) reloadable() (util.PricingAnchors, []ethcmm.Address, []ethcmm.Address) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.anchors, r.CertPairList, r.poolList
+}
 
-		symbol1, err := token1Instance.Symbol(nil)
+// tokenFilter returns the allowed and the denied tokens
+func (r *StatasSvc) tokenFilter() ([]ethcmm.Address, map[ethcmm.Address]bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.allowTokens, r.denyTokens
+}
+
+// Chain returns the name of the chain the service computes the stats of
+func (r *StatasSvc) Chain() string {
+	return r.chainConfig.Name
+}
+
+// SetBus sets the bus that refreshed prices and stats are published to
+func (r *StatasSvc) SetBus(bus *pubsub.Bus) {
+	r.bus = bus
+}
+
+// pairSymbols returns the tokens of the pair and their symbols, the tokens of a pair never change and are
+// read once
+func (r *StatasSvc) pairSymbols(swapPairAddr ethcmm.Address) ([]ethcmm.Address, []string, error) {
+	r.mux.Lock()
+	tokens, exist := r.pairTokens[swapPairAddr]
+	r.mux.Unlock()
+	if !exist {
+		state, err := r.executor.GetPoolState(swapPairAddr)
 		if err != nil {
-			continue
+			return nil, nil, err
 		}
-		certiMap[symbol0] = token0
-		certiMap[symbol1] = token1
+		tokens = state.Tokens
+		r.mux.Lock()
+		r.pairTokens[swapPairAddr] = tokens
+		r.mux.Unlock()
 	}
-	for _, swapPairAddr := range totalSwapPairList {
-		pairInstance, err := abi.NewSwappair(swapPairAddr, r.bscClient)
+	symbols := make([]string, 0, len(tokens))
+	for _, addr := range tokens {
+		token, err := r.tokens.token(addr)
 		if err != nil {
-			continue
-		}
-		token0, err := pairInstance.Token0(nil)
-		if err != nil {
-			continue
-		}
-		token1, err := pairInstance.Token1(nil)
-		if err != nil {
-			continue
-		}
-		token0Instance, err := abi.NewOip20(token0, r.bscClient)
-		if err != nil {
-			continue
-		}
-		token1Instance, err := abi.NewOip20(token1, r.bscClient)
-		if err != nil {
-			continue
-		}
-		symbol0, err := token0Instance.Symbol(nil)
-		if err != nil {
-			continue
+			return nil, nil, err
 		}
+		symbols = append(symbols, token.Symbol)
+	}
+	return tokens, symbols, nil
+}
 
-		symbol1, err := token1Instance.Symbol(nil)
+func (r *StatasSvc) refreshSwapPairs() []ethcmm.Address {
+	totalSwapPairList := r.executor.GetPairList()
+	swapPairList := make([]ethcmm.Address, 0)
+	_, certPairList, _ := r.reloadable()
+	allowTokens, denyTokens := r.tokenFilter()
+	owners := r.symbolOwners(allowTokens, certPairList)
+	lastSwapPairs := make(map[ethcmm.Address]bool, 0)
+	for _, swapPairAddr := range r.getSwapPairList() {
+		lastSwapPairs[swapPairAddr] = true
+	}
+	for _, swapPairAddr := range totalSwapPairList {
+		tokens, symbols, err := r.pairSymbols(swapPairAddr)
 		if err != nil {
+			// a pair listed before stays listed while the provider fails
+			if lastSwapPairs[swapPairAddr] && executor.IsRetryable(err) {
+				swapPairList = append(swapPairList, swapPairAddr)
+			}
 			continue
 		}
-		if addr, exist := certiMap[symbol0]; exist && addr != token0 {
-			continue
-		}
-		if addr, exist := certiMap[symbol1]; exist && addr != token1 {
-			continue
+		if listable(tokens, symbols, denyTokens, owners) {
+			swapPairList = append(swapPairList, swapPairAddr)
 		}
-		swapPairList = append(swapPairList, swapPairAddr)
 	}
 	return swapPairList
 }
 
-func (r *StatasSvc) Start() {
-	r.swapPairList = r.refreshSwapPairs()
+// Start refreshes the stats at once and then periodically until the context is done
+func (r *StatasSvc) Start(ctx context.Context) {
+	r.importTokenLists()
+	r.Refresh()
+	r.wg.Add(6)
+	go func() {
+		defer r.wg.Done()
+		r.refreshLoop(ctx)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.refreshSwapPairsRoute(ctx)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.detectLoop(ctx)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.supplyLoop(ctx)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.userStatsLoop(ctx)
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.metadataLoop(ctx)
+	}()
+}
+
+// Wait waits for the refresh routines to stop
+func (r *StatasSvc) Wait() {
+	r.wg.Wait()
+}
+
+// Refresh refreshes the swap pairs and their infos at once
+func (r *StatasSvc) Refresh() {
+	swapList := r.refreshSwapPairs()
+	r.mux.Lock()
+	r.swapPairList = swapList
+	r.mux.Unlock()
 	r.refreshSwapPairInfos()
-	go r.refreshLoop()
-	go r.refreshSwapPairsRoute()
 }
 
-func (r *StatasSvc) refreshSwapPairsRoute() {
+// refreshSwapPairsRoute lists the swap pairs periodically and after a config reload
+func (r *StatasSvc) refreshSwapPairsRoute(ctx context.Context) {
 	for {
+		timer := time.NewTimer(common.PairListInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-r.reloaded:
+			timer.Stop()
+		}
 		swapList := r.refreshSwapPairs()
 		r.mux.Lock()
 		r.swapPairList = swapList
 		r.mux.Unlock()
-		time.Sleep(1000 * time.Second)
 	}
 }
 
-func (r *StatasSvc) refreshLoop() {
-	tick := time.Tick(common.RefreshInterval)
+func (r *StatasSvc) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(common.RefreshInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-tick:
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 			r.refreshSwapPairInfos()
 		}
 	}
@@ -205,66 +378,186 @@ func (r *StatasSvc) GetDecimals(addr ethcmm.Address) (uint8, uint8, error) {
 	return info.decimal0, info.decimal1, nil
 }
 
+func (r *StatasSvc) GetTokenPrices(addr ethcmm.Address) (float64, float64, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	info, exist := r.swapPairInfoMap[addr]
+	if !exist || info.decimalsDegraded {
+		return 0, 0, false
+	}
+	price0, exist0 := r.tokenPrice[info.BaseSymbol]
+	price1, exist1 := r.tokenPrice[info.QuoteSymbol]
+	return price0, price1, exist0 && exist1
+}
+
 func (r *StatasSvc) GetSwapPairInfos() ([]SwapPairInfo, float64, float64, time.Time) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 	return r.swapPairInfos, r.totalVolume, r.totalLockVolume, r.updateAt
 }
 
+// GetAdjustedVolume returns the 24h total volume without the volume classified as wash trading
+func (r *StatasSvc) GetAdjustedVolume() float64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.adjustedVolume
+}
+
+// GetTradeVolumes returns the 24h volume summed per swap and the 24h volume of the user trades, which counts a
+// multi hop trade once, both valued as the trades are
+func (r *StatasSvc) GetTradeVolumes() (float64, float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.hopVolume, r.userTradeVolume
+}
+
+// GetAllSwapPairInfos returns every refreshed swap pair, including those below the qualified volume
+func (r *StatasSvc) GetAllSwapPairInfos() []*SwapPairInfo {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	infos := make([]*SwapPairInfo, 0, len(r.swapPairInfoMap))
+	for _, info := range r.swapPairInfoMap {
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (r *StatasSvc) GetSwapPairInfo(addr ethcmm.Address) (*SwapPairInfo, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	info, exist := r.swapPairInfoMap[addr]
+	return info, exist
+}
+
+func (r *StatasSvc) GetDB() *gorm.DB {
+	return r.statasDB
+}
+
+func (r *StatasSvc) GetFees() (FeeStats, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.totalFees, r.updateAt
+}
+
 func (r *StatasSvc) GetPrice() (map[string]float64, time.Time) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 	return r.tokenPrice, r.updateAt
 }
 
+// GetQuarantined returns the quarantined tokens of the last refresh and the checks their prices failed
+func (r *StatasSvc) GetQuarantined() map[string]string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.quarantined
+}
+
+// guardPrices returns the prices with the suspicious ones quarantined, the decisions are saved for audit
+func (r *StatasSvc) guardPrices(tokenPrice map[string]float64, metrics map[string]map[string]*PriceVolume,
+	anchors util.PricingAnchors) (map[string]float64, map[string]string) {
+	guarded, quarantined, decisions := r.priceGuard.check(time.Now(), tokenPrice, metrics, anchors.StableTokens)
+	if len(decisions) > 0 {
+		if err := model.SavePriceQuarantineLogs(r.statasDB, decisions); err != nil {
+			util.Logger.Errorf("save price quarantine logs error, chain=%s, err=%s", r.Chain(), err.Error())
+		}
+	}
+	return guarded, quarantined
+}
+
+// GetTVL returns the USD value locked in the pairs
+func (r *StatasSvc) GetTVL() (float64, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.totalLockVolume, r.updateAt
+}
+
 func (r *StatasSvc) GetSynup() ([]SyrupTVL, float64, time.Time) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 	return r.SyrupPools, r.TVL, r.updateAt
 }
 
+// markStale flags the served data stale after a failed refresh
+func (r *StatasSvc) markStale() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.stale = true
+}
+
+// Stale returns whether the served data is stale, for the last refresh failed or it is over two
+// refresh intervals old
+func (r *StatasSvc) Stale() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.stale || r.updateAt.IsZero() || time.Since(r.updateAt) > 2*common.RefreshInterval
+}
+
+func (r *StatasSvc) getSwapPairList() []ethcmm.Address {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.swapPairList
+}
+
 func (r *StatasSvc) refreshSwapPairInfos() {
 	swapPairInfoMap := make(map[ethcmm.Address]*SwapPairInfo, 0)
 	swapPairInfos := make([]SwapPairInfo, 0)
 	symbols := make(map[string]bool, 0)
 	tokenPrice := make(map[string]float64, 0)
 	tokePriceMetrics := make(map[string]map[string]*PriceVolume, 0)
-	for _, swapContract := range r.swapPairList {
-		swapInfo, err := r.refreshSwapPairInfo(swapContract)
-		if err!=nil{
-			continue
+	anchors, _, poolList := r.reloadable()
+	// stale is whether any pair or pool is served from the refresh before
+	feeOn, stale := r.getFeeOn()
+	for _, swapContract := range r.getSwapPairList() {
+		factory, _ := r.executor.GetPairFactory(swapContract)
+		swapInfo, err := r.refreshSwapPairInfo(swapContract, factory, feeOn[factory])
+		if err != nil {
+			if !executor.IsRetryable(err) {
+				continue
+			}
+			util.Logger.Errorf("refreshSwapPairInfo failed, chain=%s, pair=%s, err=%v, keep the last info",
+				r.Chain(), swapContract.String(), err)
+			stale = true
+			lastInfo, exist := r.GetSwapPairInfo(swapContract)
+			if !exist {
+				continue
+			}
+			info := *lastInfo
+			info.BaseVolume24h, info.QuoteVolume24h = 0, 0
+			swapInfo = &info
 		}
 		if swapInfo.reserve0*swapInfo.reserve1 < 100 {
 			continue
 		}
-		if err != nil {
-			util.Logger.Errorf("refreshSwapPairInfo failed, err=%v, will retry refresh later", err)
-			return
-		}
 		swapPairInfoMap[swapContract] = swapInfo
+		if swapInfo.decimalsDegraded {
+			continue
+		}
 		if tokePriceMetrics[swapInfo.BaseSymbol] == nil {
 			tokePriceMetrics[swapInfo.BaseSymbol] = make(map[string]*PriceVolume, 0)
 		}
 		if tokePriceMetrics[swapInfo.QuoteSymbol] == nil {
 			tokePriceMetrics[swapInfo.QuoteSymbol] = make(map[string]*PriceVolume, 0)
 		}
-		tokePriceMetrics[swapInfo.BaseSymbol][swapInfo.QuoteSymbol] = &PriceVolume{Price: swapInfo.LastPrice}
+		tokePriceMetrics[swapInfo.BaseSymbol][swapInfo.QuoteSymbol] = &PriceVolume{Price: swapInfo.LastPrice, Reserve: swapInfo.reserve1}
 		if swapInfo.LastPrice != 0 {
-			tokePriceMetrics[swapInfo.QuoteSymbol][swapInfo.BaseSymbol] = &PriceVolume{Price: 1 / swapInfo.LastPrice}
+			tokePriceMetrics[swapInfo.QuoteSymbol][swapInfo.BaseSymbol] = &PriceVolume{Price: 1 / swapInfo.LastPrice, Reserve: swapInfo.reserve0}
 		}
 		symbols[swapInfo.BaseSymbol] = true
 		symbols[swapInfo.QuoteSymbol] = true
 	}
 
 	for symbol := range symbols {
-		if STABLE_TOKENS[symbol] {
-			tokenPrice[symbol] = 1
+		for _, stable := range anchors.StableTokens {
+			if symbol == stable {
+				tokenPrice[symbol] = 1
+			}
 		}
 	}
 
-	totalStatas, err := model.GetLast24HourTotalAccount(r.statasDB)
+	totalStatas, err := model.GetLast24HourTotalAccount(r.statasDB, r.Chain())
 	if err != nil {
 		util.Logger.Errorf("refreshSwapPairInfo failed, err=%v, will retry refresh later", err)
+		r.markStale()
 		return
 	}
 	for _, stata := range totalStatas {
@@ -275,6 +568,9 @@ func (r *StatasSvc) refreshSwapPairInfos() {
 		}
 		swapInfo.BaseVolume24h = stata.TotalAmount0
 		swapInfo.QuoteVolume24h = stata.TotalAmount1
+		if swapInfo.decimalsDegraded {
+			continue
+		}
 		if _, exist := tokePriceMetrics[swapInfo.BaseSymbol]; exist {
 			tokePriceMetrics[swapInfo.BaseSymbol][swapInfo.QuoteSymbol].Volume = swapInfo.BaseVolume24h
 		}
@@ -283,9 +579,11 @@ func (r *StatasSvc) refreshSwapPairInfos() {
 		}
 	}
 	// to decrease the impact of low liquidity
-	tokenPrice["WOKT"] = tokePriceMetrics["WOKT"]["BUSD"].Price
+	if metric, exist := tokePriceMetrics[anchors.BaseTokens[0]][anchors.StableTokens[0]]; exist {
+		tokenPrice[anchors.BaseTokens[0]] = metric.Price
+	}
 
-	for _, s := range BASE_TOKENS {
+	for _, s := range anchors.BaseTokens {
 		p := tokenPrice[s]
 		for os, op := range tokePriceMetrics[s] {
 			if _, exist := tokenPrice[os]; !exist && op.Price != 0 && op.Volume*p > QulifiedVolume {
@@ -294,8 +592,8 @@ func (r *StatasSvc) refreshSwapPairInfos() {
 		}
 	}
 
-	// for pie,
-	s := "Pie"
+	// for the tokens only paired with the project token
+	s := anchors.ProjectToken
 	p := tokenPrice[s]
 	for os, op := range tokePriceMetrics[s] {
 		if _, exist := tokenPrice[os]; !exist && op.Price != 0 && op.Volume*p > QulifiedVolume {
@@ -303,149 +601,189 @@ func (r *StatasSvc) refreshSwapPairInfos() {
 		}
 	}
 
+	tokenPrice, quarantined := r.guardPrices(tokenPrice, tokePriceMetrics, anchors)
+
+	totalFees, err := r.refreshFees(swapPairInfoMap, tokenPrice)
+	if err != nil {
+		util.Logger.Errorf("refreshFees failed, err=%v, will retry refresh later", err)
+		r.markStale()
+		return
+	}
+
 	var totalVolume, totalLock float64
+	qualified := make([]*SwapPairInfo, 0)
 	for _, swapInfo := range swapPairInfoMap {
-		var swapPairVolume, swapLock float64
-		if price, exist := tokenPrice[swapInfo.BaseSymbol]; exist {
-			swapPairVolume = swapPairVolume + swapInfo.BaseVolume24h*price
-			swapLock = swapLock + swapInfo.reserve0*price
+		var swapLock float64
+		swapPairVolume := pairVolumeUSD(swapInfo, swapInfo.BaseVolume24h, swapInfo.QuoteVolume24h, tokenPrice)
+		if basePrice, baseExist := tokenPrice[swapInfo.BaseSymbol]; baseExist && !swapInfo.decimalsDegraded {
+			swapLock = swapLock + swapInfo.reserve0*basePrice
 		}
-		if price, exist := tokenPrice[swapInfo.QuoteSymbol]; exist {
-			swapPairVolume = swapPairVolume + swapInfo.QuoteVolume24h*price
-			swapLock = swapLock + swapInfo.reserve1*price
+		if quotePrice, quoteExist := tokenPrice[swapInfo.QuoteSymbol]; quoteExist && !swapInfo.decimalsDegraded {
+			swapLock = swapLock + swapInfo.reserve1*quotePrice
 		}
+		swapInfo.volumeUSD = swapPairVolume
+		swapInfo.reserveUSD = swapLock
 		if swapPairVolume >= QulifiedVolume {
 			totalVolume = totalVolume + swapPairVolume
 			totalLock = totalLock + swapLock
-			swapPairInfos = append(swapPairInfos, *swapInfo)
+			qualified = append(qualified, swapInfo)
 		}
 	}
 
-	cakeIns, err := abi.NewBep20(ethcmm.HexToAddress("0x0E09FaBB73Bd3Ade0a17ECC321fD13a19e81cE82"), r.bscClient)
+	if err := r.classifyWash(swapPairInfoMap, tokenPrice); err != nil {
+		util.Logger.Errorf("classifyWash failed, err=%v, will retry refresh later", err)
+		r.markStale()
+		return
+	}
+	var adjustedVolume float64
+	for _, swapInfo := range qualified {
+		adjustedVolume += swapInfo.volumeUSD * (1 - swapInfo.WashRatio)
+		swapPairInfos = append(swapPairInfos, *swapInfo)
+	}
+
+	hopVolume, userTradeVolume, err := r.tradeVolumes(qualified, tokenPrice)
 	if err != nil {
-		util.Logger.Errorf("failed to init cake Ins", err)
+		util.Logger.Errorf("tradeVolumes failed, err=%v, will retry refresh later", err)
+		r.markStale()
+		return
+	}
+
+	pieIns, err := abi.NewBep20(ethcmm.HexToAddress(anchors.SyrupToken), r.client)
+	if err != nil {
+		util.Logger.Errorf("failed to init pie Ins, err=%v", err)
+		r.markStale()
 		return
 	}
 	syrupPools := make([]SyrupTVL, 0)
 	totalSynupTvl := float64(0)
-	for idx, addr := range r.poolList {
-		poolIns, err := abi.NewSmartchef(addr, r.bscClient)
+	for idx, addr := range poolList {
+		poolIns, err := abi.NewSmartchef(addr, r.client)
 		if err != nil {
 			util.Logger.Errorf("failed to init poolIns Ins %v, %s", err, addr.String())
 			continue
 		}
 		var name string
 		if idx == 0 {
-			name = "Pie"
+			name = anchors.ProjectToken
 		} else {
 			rewardToken, err := poolIns.RewardToken(nil)
 			if err != nil {
 				util.Logger.Errorf("failed to init rewardToken Ins %v, %s", err, addr.String())
 				continue
 			}
-			rewardTokenIns, err := abi.NewBep20(rewardToken, r.bscClient)
-			if err != nil {
-				util.Logger.Errorf("failed to init rewardTokenIns Ins %v, %s", err, addr.String())
-				continue
-			}
-			name, err = rewardTokenIns.Name(nil)
+			token, err := r.tokens.token(rewardToken)
 			if err != nil {
+				util.Logger.Errorf("failed to get rewardToken metadata %v, %s", err, addr.String())
 				continue
 			}
+			name = token.Name
 		}
 		balance, err := pieIns.BalanceOf(nil, addr)
 		if err != nil {
 			util.Logger.Errorf("failed to get pie balance Ins %v, %s", err, addr.String())
+			stale = stale || executor.IsRetryable(err)
 			continue
 		}
-		cakePrice := tokenPrice["Cake"]
+		cakePrice := tokenPrice[anchors.SyrupTokenSymbol]
 		tvl := float64(new(big.Int).Div(balance, big.NewInt(1e18)).Int64()) * cakePrice
 		syrupPools = append(syrupPools, SyrupTVL{
-			Name: name,
-			Tvl:  tvl,
+			Chain: r.Chain(),
+			Name:  name,
+			Tvl:   tvl,
 		})
 		totalSynupTvl += tvl
 	}
 
+	updateAt := time.Now()
 	r.mux.Lock()
 	r.TVL = totalSynupTvl
 	r.SyrupPools = syrupPools
 	r.tokenPrice = tokenPrice
+	r.quarantined = quarantined
 	r.swapPairInfoMap = swapPairInfoMap
 	r.swapPairInfos = swapPairInfos
 	r.totalVolume = totalVolume
 	r.totalLockVolume = totalLock
-	r.updateAt = time.Now()
+	r.adjustedVolume = adjustedVolume
+	r.hopVolume = hopVolume
+	r.userTradeVolume = userTradeVolume
+	r.totalFees = totalFees
+	r.updateAt = updateAt
+	r.stale = stale
 	r.mux.Unlock()
-}
 
-func (r *StatasSvc) refreshSwapPairInfo(swapPairAddr ethcmm.Address) (*SwapPairInfo, error) {
-	pairInstance, err := abi.NewSwappair(swapPairAddr, r.bscClient)
-	if err != nil {
-		return nil, err
-	}
-	reserve, err := pairInstance.GetReserves(nil)
-	if err != nil {
-		return nil, err
-	}
+	r.snapshotPairs(swapPairInfoMap, updateAt)
+
+	r.bus.Publish(r.Chain(), pubsub.TopicPrices, PriceSnapshot{
+		UpdateAt: updateAt,
+		Prices:   tokenPrice,
+	})
+	r.bus.Publish(r.Chain(), pubsub.TopicStats, StatSnapshot{
+		UpdateAt:            updateAt,
+		TotalVolume:         totalVolume,
+		AdjustedVolume:      adjustedVolume,
+		LockVolume:          totalLock,
+		TotalValueLockedAll: totalSynupTvl + totalLock,
+		Fees:                totalFees,
+	})
+}
 
-	token0, err := pairInstance.Token0(nil)
-	if err != nil {
-		return nil, err
-	}
-	token1, err := pairInstance.Token1(nil)
-	if err != nil {
-		return nil, err
-	}
-	token0Instance, err := abi.NewBep20(token0, r.bscClient)
+func (r *StatasSvc) refreshSwapPairInfo(swapPairAddr ethcmm.Address, factory string, feeOn bool) (*SwapPairInfo, error) {
+	state, err := r.executor.GetPoolState(swapPairAddr)
 	if err != nil {
 		return nil, err
 	}
-	token1Instance, err := abi.NewBep20(token1, r.bscClient)
-	if err != nil {
-		return nil, err
-	}
-	symbol0, err := token0Instance.Symbol(nil)
-	if err != nil {
-		return nil, err
+	if len(state.Tokens) != 2 {
+		return nil, executor.Permanent(fmt.Errorf("pool %s has %d tokens, only two token pools are supported", swapPairAddr.String(), len(state.Tokens)))
 	}
+	token0, token1 := state.Tokens[0], state.Tokens[1]
 
-	symbol1, err := token1Instance.Symbol(nil)
+	tokenInfo0, err := r.tokens.token(token0)
 	if err != nil {
 		return nil, err
 	}
-
-	decimal0, err := token0Instance.Decimals(nil)
-	if err != nil {
-		return nil, err
-	}
-	decimal1, err := token1Instance.Decimals(nil)
-
+	tokenInfo1, err := r.tokens.token(token1)
 	if err != nil {
 		return nil, err
 	}
+	symbol0, symbol1 := tokenInfo0.Symbol, tokenInfo1.Symbol
+	decimal0, decimal1 := tokenInfo0.Decimals, tokenInfo1.Decimals
 
-	reserve0, _ := new(big.Float).Quo(new(big.Float).SetInt(reserve.Reserve0), new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal0))))).Float64()
-	reserve1, _ := new(big.Float).Quo(new(big.Float).SetInt(reserve.Reserve1), new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal1))))).Float64()
+	reserve0, _ := new(big.Float).Quo(new(big.Float).SetInt(state.Reserves[0]), new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal0))))).Float64()
+	reserve1, _ := new(big.Float).Quo(new(big.Float).SetInt(state.Reserves[1]), new(big.Float).SetInt(math.Exp(big.NewInt(10), big.NewInt(int64(decimal1))))).Float64()
 
 	var price float64
-	if reserve.Reserve0.Cmp(new(big.Int).SetInt64(0)) != 0 {
+	if state.Reserves[0].Cmp(new(big.Int).SetInt64(0)) != 0 {
 		price = reserve1 / reserve0
 	}
 
+	lpSupply, _ := new(big.Float).Quo(new(big.Float).SetInt(state.LpSupply), new(big.Float).SetInt64(1e18)).Float64()
+
 	return &SwapPairInfo{
+		Chain:            r.Chain(),
 		SwapPairContract: swapPairAddr.String(),
 		BaseSymbol:       symbol0,
 		QuoteSymbol:      symbol1,
 		LastPrice:        price,
+		Approximate:      tokenInfo0.Behaviour != "" || tokenInfo1.Behaviour != "",
+		factory:          factory,
+		protocolFeeOn:    feeOn && state.ProtocolFeeAccrues,
+		token0:           token0,
+		token1:           token1,
+		nonstandard0:     tokenInfo0.Behaviour != "",
+		nonstandard1:     tokenInfo1.Behaviour != "",
+		decimalsDegraded: decimalsDegraded(tokenInfo0) || decimalsDegraded(tokenInfo1),
 		decimal0:         decimal0,
 		decimal1:         decimal1,
 		reserve0:         reserve0,
 		reserve1:         reserve1,
+		lpSupply:         lpSupply,
 	}, nil
 }
 
 type PriceVolume struct {
 	Price  float64
 	Volume float64
+	// Reserve is the reserve of the token priced by the pair
+	Reserve float64
 }
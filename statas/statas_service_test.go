@@ -0,0 +1,934 @@
+package statas
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/simchain"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	testChain     = simchain.FixtureChain
+	testBlockTime = simchain.FixtureBlockTime
+)
+
+type testPair struct {
+	// token0 and token1 are the symbols, a suffix after @ deploys another token of the symbol
+	token0, token1     string
+	reserve0, reserve1 int64
+}
+
+type testSwap struct {
+	pair             int
+	amount0, amount1 float64
+	age              time.Duration
+}
+
+var tokenAmount = simchain.TokenAmount
+
+// newTestSvc returns the stat service of the pairs on a fake client and the swaps in an in memory db
+func newTestSvc(t *testing.T, pairs []testPair, swaps []testSwap) (*StatasSvc, []ethcmm.Address) {
+	fixturePairs := make([]simchain.FixturePair, 0, len(pairs))
+	for _, pair := range pairs {
+		fixturePairs = append(fixturePairs, simchain.FixturePair{Token0: pair.token0, Token1: pair.token1, Reserve0: pair.reserve0, Reserve1: pair.reserve1})
+	}
+	fixture := simchain.NewFixture(t, fixturePairs)
+	for idx, swap := range swaps {
+		require.NoError(t, fixture.DB.Create(&model.TxEventLog{
+			Chain:           testChain,
+			ContractAddress: fixture.Pairs[swap.pair].String(),
+			Amount0:         swap.amount0,
+			Amount1:         swap.amount1,
+			TxHash:          ethcmm.BigToHash(big.NewInt(int64(idx))).String(),
+			BlockTime:       testBlockTime - int64(swap.age.Seconds()),
+			Height:          100,
+		}).Error)
+	}
+	svc := NewStatasSvc(fixture.DB, fixture.Config, fixture.ChainConfig, fixture.Client, executor.NewExecutor(fixture.ChainConfig, fixture.Client))
+	return svc, fixture.Pairs
+}
+
+func requireNear(t *testing.T, expected, actual float64, msgAndArgs ...interface{}) {
+	require.True(t, math.Abs(expected-actual) < 1e-9, append([]interface{}{"expected %v, got %v", expected, actual}, msgAndArgs...)...)
+}
+
+func TestRefreshSwapPairInfos(t *testing.T) {
+	woktBusd := testPair{"WOKT", "BUSD", 1000, 20000}
+	pieWokt := testPair{"Pie", "WOKT", 10000, 500}
+	cakePie := testPair{"Cake", "Pie", 100, 500}
+
+	testCases := []struct {
+		name  string
+		pairs []testPair
+		swaps []testSwap
+
+		// prices are all the priced tokens
+		prices map[string]float64
+		// baseVolumes are the 24h base volumes of the pairs, nil for pairs skipped
+		baseVolumes []interface{}
+		totalVolume float64
+		tradePairs  int
+	}{
+		{
+			name:        "base token priced by stable token",
+			pairs:       []testPair{woktBusd},
+			swaps:       []testSwap{{0, 10, 200, 0}},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20},
+			baseVolumes: []interface{}{10.0},
+			totalVolume: 400,
+			tradePairs:  1,
+		},
+		{
+			name:        "base token priced without volume",
+			pairs:       []testPair{woktBusd},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20},
+			baseVolumes: []interface{}{0.0},
+		},
+		{
+			name:        "token priced by qualified base token volume",
+			pairs:       []testPair{woktBusd, pieWokt},
+			swaps:       []testSwap{{1, 200, 10, 0}},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20, "Pie": 1},
+			baseVolumes: []interface{}{0.0, 200.0},
+			totalVolume: 400,
+			tradePairs:  1,
+		},
+		{
+			name:  "token not priced by unqualified base token volume",
+			pairs: []testPair{woktBusd, pieWokt},
+			// 5 WOKT is just 100 USD, the pair volume counts the priced side only
+			swaps:       []testSwap{{1, 100, 5, 0}},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20},
+			baseVolumes: []interface{}{0.0, 100.0},
+			totalVolume: 100,
+			tradePairs:  1,
+		},
+		{
+			name:        "token priced by project token",
+			pairs:       []testPair{woktBusd, pieWokt, cakePie},
+			swaps:       []testSwap{{1, 200, 10, 0}, {2, 40, 200, 0}},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20, "Pie": 1, "Cake": 5},
+			baseVolumes: []interface{}{0.0, 200.0, 40.0},
+			totalVolume: 800,
+			tradePairs:  2,
+		},
+		{
+			name:  "swaps over a day old not counted",
+			pairs: []testPair{woktBusd},
+			swaps: []testSwap{{0, 10, 200, 25 * time.Hour}, {0, 2, 40, time.Hour}},
+			// 80 USD is below the qualified volume of a trade pair
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20},
+			baseVolumes: []interface{}{2.0},
+		},
+		{
+			name:        "low liquidity pair skipped",
+			pairs:       []testPair{woktBusd, {"Pie", "BUSD", 5, 10}},
+			swaps:       []testSwap{{0, 10, 200, 0}, {1, 1000, 2000, 0}},
+			prices:      map[string]float64{"BUSD": 1, "WOKT": 20},
+			baseVolumes: []interface{}{10.0, nil},
+			totalVolume: 400,
+			tradePairs:  1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			svc, pairList := newTestSvc(t, testCase.pairs, testCase.swaps)
+			svc.Refresh()
+
+			prices, _ := svc.GetPrice()
+			require.Len(t, prices, len(testCase.prices), "prices %v", prices)
+			for symbol, price := range testCase.prices {
+				requireNear(t, price, prices[symbol], "price of %s", symbol)
+			}
+
+			for idx, baseVolume := range testCase.baseVolumes {
+				info, exist := svc.GetSwapPairInfo(pairList[idx])
+				if baseVolume == nil {
+					require.False(t, exist, "pair %d", idx)
+					continue
+				}
+				require.True(t, exist, "pair %d", idx)
+				requireNear(t, baseVolume.(float64), info.BaseVolume24h, "base volume of pair %d", idx)
+			}
+
+			tradePairs, totalVolume, _, _ := svc.GetSwapPairInfos()
+			require.Len(t, tradePairs, testCase.tradePairs)
+			requireNear(t, testCase.totalVolume, totalVolume, "total volume")
+			// without multi hop trades every swap is a trade
+			hopVolume, userTradeVolume := svc.GetTradeVolumes()
+			requireNear(t, hopVolume, userTradeVolume, "user trade volume")
+		})
+	}
+}
+
+func TestRefreshServesStaleData(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, []testSwap{{0, 10, 200, 0}})
+	svc.Refresh()
+	require.False(t, svc.Stale())
+
+	client := svc.client.(*simchain.FakeClient)
+	client.Fail(errors.New("connection refused"))
+	svc.Refresh()
+	require.True(t, svc.Stale())
+	prices, _ := svc.GetPrice()
+	requireNear(t, 20, prices["WOKT"])
+	info, exist := svc.GetSwapPairInfo(pairList[0])
+	require.True(t, exist)
+	requireNear(t, 10, info.BaseVolume24h)
+
+	client.Fail(nil)
+	svc.Refresh()
+	require.False(t, svc.Stale())
+}
+
+func TestFeeToFailure(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, []testSwap{{0, 10, 200, 0}})
+	client := svc.client.(*simchain.FakeClient)
+	// a fork factory without feeTo answers no data
+	factory := ethcmm.HexToAddress(svc.chainConfig.SwapFactories[0])
+	require.NoError(t, client.SetCallResult(factory, ethcmm.FromHex("0x017e7e58"), nil))
+	// nor does a fork pair without kLast
+	require.NoError(t, client.SetCallResult(pairList[0], ethcmm.FromHex("0x7464fc3d"), nil))
+	svc.Refresh()
+	require.False(t, svc.Stale())
+	prices, _ := svc.GetPrice()
+	requireNear(t, 20, prices["WOKT"])
+	info, exist := svc.GetSwapPairInfo(pairList[0])
+	require.True(t, exist)
+	require.Zero(t, info.Fees.Last24h.ProtocolFee)
+	// the fee is charged on one side of the swap, 200 USD, while the total volume adds both
+	requireNear(t, 200, info.Fees.Last24h.VolumeUSD)
+	requireNear(t, 200*common.DefaultSwapFeeRate, info.Fees.Last24h.LpFee)
+	fees, _ := svc.GetFees()
+	requireNear(t, 200, fees.Last24h.VolumeUSD)
+	_, totalVolume, _, _ := svc.GetSwapPairInfos()
+	requireNear(t, 400, totalVolume)
+}
+
+func TestPriceQuarantine(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}, {"Pie", "WOKT", 10000, 500}}, []testSwap{{1, 200, 10, 0}})
+	client := svc.client.(*simchain.FakeClient)
+	setReserves := func(reserve0, reserve1 int64) {
+		require.NoError(t, client.SetPairState(pairList[1], tokenAmount(reserve0), tokenAmount(reserve1), tokenAmount(1000)))
+	}
+	svc.Refresh()
+	prices, _ := svc.GetPrice()
+	requireNear(t, 1, prices["Pie"])
+	require.Empty(t, svc.GetQuarantined())
+
+	// a swap of a flash loan moves the price of Pie 10 times
+	setReserves(1000, 5000)
+	svc.Refresh()
+	prices, _ = svc.GetPrice()
+	requireNear(t, 1, prices["Pie"])
+	require.Equal(t, map[string]string{"Pie": "jump,twap"}, svc.GetQuarantined())
+
+	// and is paid back in the next block
+	setReserves(10000, 500)
+	svc.Refresh()
+	require.Empty(t, svc.GetQuarantined())
+
+	// a price staying at the new level is accepted on the confirm refreshes
+	setReserves(5000, 500)
+	for idx := 0; idx < common.DefaultPriceConfirmRefreshes; idx++ {
+		prices, _ = svc.GetPrice()
+		requireNear(t, 1, prices["Pie"])
+		svc.Refresh()
+	}
+	prices, _ = svc.GetPrice()
+	requireNear(t, 2, prices["Pie"])
+	require.Empty(t, svc.GetQuarantined())
+
+	logs, err := model.GetPriceQuarantineLogs(svc.statasDB, testChain, 10)
+	require.NoError(t, err)
+	actions := make([]string, 0, len(logs))
+	for _, log := range logs {
+		require.Equal(t, "Pie", log.Symbol)
+		actions = append(actions, log.Action)
+	}
+	require.Equal(t, []string{model.QuarantineActionAccept, model.QuarantineActionQuarantine, model.QuarantineActionQuarantine,
+		model.QuarantineActionRelease, model.QuarantineActionQuarantine}, actions)
+}
+
+func TestCrossPrice(t *testing.T) {
+	prices := map[string]float64{"BUSD": 1, "WOKT": 20, "Pie": 1}
+	metrics := map[string]map[string]*PriceVolume{
+		"BUSD": {"Cake": {Price: 0.2, Reserve: 10000}},
+		"WOKT": {"Cake": {Price: 4, Reserve: 8000}},
+		// a thin pair pricing Cake at 50
+		"Pie": {"Cake": {Price: 0.02, Reserve: 10}},
+	}
+	requireNear(t, 5, crossPriceOf("Cake", prices, metrics))
+	// a single pair is no reference
+	require.Zero(t, crossPriceOf("Cake", prices, map[string]map[string]*PriceVolume{"BUSD": metrics["BUSD"]}))
+}
+
+func TestWhaleWatcher(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, nil)
+	svc.Refresh()
+	flagged := "0x00000000000000000000000000000000000000aa"
+	watcher := NewWhaleWatcher(svc, nil, nil, &util.AlertConfig{Whale: &util.WhaleConfig{
+		TradeUSD:         100,
+		LiquidityRemoval: 0.1,
+		FlaggedAddresses: []string{strings.ToUpper(flagged)},
+		FlaggedRepeat:    2,
+	}})
+	pair := pairList[0].String()
+	// the events are of the indexed block, received at once and notified when it is confirmed
+	rulesAt := func(data interface{}, blockHash string, now time.Time) []string {
+		switch ev := data.(type) {
+		case *model.TxEventLog:
+			ev.Height, ev.BlockHash, ev.BlockTime = 100, blockHash, testBlockTime
+		case *model.LiquidityEventLog:
+			ev.Height, ev.BlockHash, ev.BlockTime = 100, blockHash, testBlockTime
+		}
+		watcher.receive(data, now)
+		require.Empty(t, watcher.confirmed(100))
+		rules := make([]string, 0)
+		for _, alert := range watcher.confirmed(101) {
+			require.Equal(t, testChain, alert.Chain)
+			rules = append(rules, alert.Rule)
+		}
+		return rules
+	}
+	rulesOf := func(data interface{}) []string {
+		return rulesAt(data, "0x1", time.Unix(testBlockTime, 0))
+	}
+
+	// a swap of 10 WOKT for 200 BUSD is worth $200
+	require.Equal(t, []string{RuleWhaleTrade}, rulesOf(&model.TxEventLog{ContractAddress: pair, Amount0: 10, Amount1: 200}))
+	require.Empty(t, rulesOf(&model.TxEventLog{ContractAddress: pair, Amount0: 1, Amount1: 20}))
+
+	// a burn of 15% of the reserves, a mint of the same is not notified
+	burn := &model.LiquidityEventLog{ContractAddress: pair, EventType: model.LiquidityEventBurn, Amount0: 150, Amount1: 3000}
+	require.Equal(t, []string{RuleLiquidityRemoval}, rulesOf(burn))
+	mint := *burn
+	mint.EventType = model.LiquidityEventMint
+	require.Empty(t, rulesOf(&mint))
+
+	// the flagged address is notified on its second event and counted again after
+	small := &model.TxEventLog{ContractAddress: pair, Amount0: 1, Amount1: 20, Sender: flagged, To: flagged}
+	require.Empty(t, rulesOf(small))
+	require.Equal(t, []string{RuleFlaggedAddress}, rulesOf(small))
+	require.Empty(t, rulesOf(small))
+
+	// trades and deposits through a router are matched by the account sending the tx
+	router := "0x00000000000000000000000000000000000000bb"
+	viaRouter := &model.TxEventLog{ContractAddress: pair, Amount0: 1, Amount1: 20, Sender: router, To: router, Origin: flagged}
+	require.Equal(t, []string{RuleFlaggedAddress}, rulesOf(viaRouter))
+	deposit := &model.LiquidityEventLog{ContractAddress: pair, EventType: model.LiquidityEventMint, Amount0: 1, Amount1: 20,
+		Sender: router, Origin: flagged}
+	require.Empty(t, rulesOf(deposit))
+	require.Equal(t, []string{RuleFlaggedAddress}, rulesOf(deposit))
+
+	// a catch up indexes blocks older than the max event age, and a block reorged out is not notified
+	whale := &model.TxEventLog{ContractAddress: pair, Amount0: 10, Amount1: 200, Sender: flagged}
+	require.Empty(t, rulesAt(whale, "0x1", time.Unix(testBlockTime, 0).Add(time.Hour)))
+	require.Empty(t, rulesAt(whale, "0x1", time.Unix(testBlockTime, 0).Add(time.Hour)))
+	require.Empty(t, rulesAt(whale, "0x2", time.Unix(testBlockTime, 0)))
+	require.Empty(t, watcher.pending)
+
+	// the whale alerts are off without the setting
+	watcher.Reload(&util.AlertConfig{})
+	require.Empty(t, rulesOf(&model.TxEventLog{ContractAddress: pair, Amount0: 10, Amount1: 200}))
+}
+
+func TestTokenListing(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}, {"Pie", "BUSD@fake", 10000, 500}}, nil)
+	state, err := svc.executor.GetPoolState(pairList[0])
+	require.NoError(t, err)
+	wokt, busd := state.Tokens[0], state.Tokens[1]
+	listed := func(chainConfig *util.ChainConfig) []ethcmm.Address {
+		chainConfig.Name = testChain
+		svc.Reload(chainConfig)
+		return svc.refreshSwapPairs()
+	}
+
+	// the fake BUSD is listed until a BUSD owns the symbol
+	require.Equal(t, pairList, listed(&util.ChainConfig{}))
+	require.Equal(t, pairList[:1], listed(&util.ChainConfig{AllowTokens: []string{busd.String()}}))
+	require.Equal(t, pairList[1:], listed(&util.ChainConfig{DenyTokens: []string{wokt.String()}}))
+
+	// or the token list verifies it
+	dir, err := ioutil.TempDir("", "token-list")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "list.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"name": "Test List", "tokens": [
+		{"chainId": 66, "address": "`+busd.String()+`", "name": "Binance USD", "symbol": "BUSD", "decimals": 18, "logoURI": "https://logo/busd.png"},
+		{"chainId": 1, "address": "`+wokt.String()+`", "name": "Wrapped OKT", "symbol": "WOKT", "decimals": 18}
+	]}`), 0644))
+	svc.chainConfig.ChainId = 66
+	require.Equal(t, pairList[:1], listed(&util.ChainConfig{TokenLists: []string{path}}))
+
+	tokens, err := svc.GetTokens()
+	require.NoError(t, err)
+	require.Len(t, tokens, 4)
+	for _, token := range tokens {
+		require.Equal(t, int64(100), token.FirstSeenBlock)
+		require.Equal(t, 1e9, token.TotalSupply)
+		require.Equal(t, token.Address == strings.ToLower(busd.String()), token.Verified, token.Symbol)
+	}
+	saved, err := model.GetTokens(svc.statasDB, testChain)
+	require.NoError(t, err)
+	require.Len(t, saved, 4)
+	require.Equal(t, "Binance USD", saved[1].Name)
+	require.Equal(t, "https://logo/busd.png", saved[1].LogoURI)
+
+	// the token is no longer verified once the list is removed
+	require.Equal(t, pairList, listed(&util.ChainConfig{}))
+	saved, err = model.GetTokens(svc.statasDB, testChain)
+	require.NoError(t, err)
+	require.False(t, saved[1].Verified)
+}
+
+func TestTokenMetadata(t *testing.T) {
+	svc, _ := newTestSvc(t, nil, nil)
+	client := svc.client.(*simchain.FakeClient)
+	deploy := func(symbol, name, decimals []byte) ethcmm.Address {
+		token, err := client.DeployMock()
+		require.NoError(t, err)
+		require.NoError(t, client.SetCallResult(token, selectorSymbol, symbol))
+		require.NoError(t, client.SetCallResult(token, selectorName, name))
+		require.NoError(t, client.SetCallResult(token, selectorDecimals, decimals))
+		require.NoError(t, client.SetCallResult(token, selectorTotalSupply, ethcmm.BigToHash(tokenAmount(1000)).Bytes()))
+		return token
+	}
+	abiString := func(text string) []byte {
+		data := append(ethcmm.BigToHash(big.NewInt(32)).Bytes(), ethcmm.BigToHash(big.NewInt(int64(len(text)))).Bytes()...)
+		return append(data, ethcmm.RightPadBytes([]byte(text), 32)...)
+	}
+	bytes32 := func(text string) []byte {
+		return ethcmm.RightPadBytes([]byte(text), 32)
+	}
+	decimals := ethcmm.BigToHash(big.NewInt(18)).Bytes()
+	missing := deploy(nil, abiString("No Symbol"), decimals)
+
+	testCases := []struct {
+		name     string
+		token    ethcmm.Address
+		symbol   string
+		decimals uint8
+		degraded []string
+	}{
+		{"standard", deploy(abiString("Pie"), abiString("Pie Token"), decimals), "Pie", 18, nil},
+		{"bytes32 symbol", deploy(bytes32("MKR"), bytes32("Maker\x01\x02"), decimals), "MKR", 18, nil},
+		{"missing decimals", deploy(abiString("OLD"), abiString("Old Token"), nil), "OLD", 18, []string{degradedDecimals}},
+		{"garbage decimals", deploy(abiString("BIG"), abiString("Big Token"), ethcmm.BigToHash(big.NewInt(1000)).Bytes()), "BIG", 18,
+			[]string{degradedDecimals}},
+		{"missing symbol", missing, missing.String(), 18, []string{degradedSymbol}},
+	}
+
+	for _, tc := range testCases {
+		token, err := svc.tokens.token(tc.token)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.symbol, token.Symbol, tc.name)
+		require.Equal(t, tc.decimals, token.Decimals, tc.name)
+		require.Equal(t, strings.Join(tc.degraded, ","), token.Degraded, tc.name)
+		requireNear(t, 1000, token.TotalSupply, tc.name)
+	}
+	token, err := svc.tokens.token(testCases[1].token)
+	require.NoError(t, err)
+	require.Equal(t, "Maker", token.Name)
+
+	// a token is not degraded by a failing provider, it is read again later
+	client.Fail(errors.New("connection refused"))
+	failing := deploy(abiString("NEW"), abiString("New Token"), decimals)
+	_, err = svc.tokens.token(failing)
+	require.Error(t, err)
+	client.Fail(nil)
+	token, err = svc.tokens.token(failing)
+	require.NoError(t, err)
+	require.Empty(t, token.Degraded)
+
+	// a degraded token is read again, the decimals read now replace the default and scale the supply
+	require.NoError(t, client.SetCallResult(testCases[2].token, selectorDecimals, ethcmm.BigToHash(big.NewInt(6)).Bytes()))
+	changed, err := svc.tokens.refreshDegraded()
+	require.NoError(t, err)
+	require.Equal(t, []ethcmm.Address{testCases[2].token}, changed)
+	token, err = svc.tokens.token(testCases[2].token)
+	require.NoError(t, err)
+	require.Equal(t, uint8(6), token.Decimals)
+	require.Empty(t, token.Degraded)
+	requireNear(t, 1000e12, token.TotalSupply)
+	token, err = svc.tokens.token(missing)
+	require.NoError(t, err)
+	require.Equal(t, degradedSymbol, token.Degraded)
+}
+
+func TestDegradedDecimals(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}, {"Pie", "WOKT", 10000, 500}}, []testSwap{{1, 200, 10, 0}})
+	client := svc.client.(*simchain.FakeClient)
+	pieInfo, err := svc.executor.GetPoolState(pairList[1])
+	require.NoError(t, err)
+	require.NoError(t, client.SetCallResult(pieInfo.Tokens[0], selectorDecimals, nil))
+	svc.Refresh()
+
+	// the reserves of a token with the default decimals may be off by orders of magnitude
+	prices, _ := svc.GetPrice()
+	requireNear(t, 20, prices["WOKT"])
+	_, exist := prices["Pie"]
+	require.False(t, exist)
+	info, exist := svc.GetSwapPairInfo(pairList[1])
+	require.True(t, exist)
+	require.Zero(t, info.reserveUSD)
+}
+
+func TestTokenBehaviour(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"FOT", "BUSD", 1000, 1000}, {"REB", "BUSD", 1000, 1000}, {"WOKT", "BUSD", 1000, 20000}}, nil)
+	client := svc.client.(*simchain.FakeClient)
+	tokens := make(map[string]ethcmm.Address, 0)
+	for idx, pair := range pairList {
+		state, err := svc.executor.GetPoolState(pair)
+		require.NoError(t, err)
+		for tokenIdx, token := range state.Tokens {
+			info, err := svc.tokens.token(token)
+			require.NoError(t, err)
+			tokens[info.Symbol] = token
+			balance := state.Reserves[tokenIdx]
+			// the rebasing token shrank the balance of its pair
+			if idx == 1 && tokenIdx == 0 {
+				balance = tokenAmount(900)
+			}
+			require.NoError(t, client.SetBalance(token, pair, balance))
+		}
+	}
+	svc.Refresh()
+
+	// 100 BUSD are swapped for 90 FOT, of which the trader gets 81
+	user, feeWallet := ethcmm.HexToAddress("0x1"), ethcmm.HexToAddress("0x2")
+	require.NoError(t, client.Tx(func() error {
+		if err := client.TokenTransfer(tokens["BUSD"], user, pairList[0], tokenAmount(100)); err != nil {
+			return err
+		}
+		if err := client.Swap(pairList[0], user, user, big.NewInt(0), tokenAmount(100), tokenAmount(90), big.NewInt(0)); err != nil {
+			return err
+		}
+		if err := client.TokenTransfer(tokens["FOT"], pairList[0], user, tokenAmount(81)); err != nil {
+			return err
+		}
+		return client.TokenTransfer(tokens["FOT"], pairList[0], feeWallet, tokenAmount(9))
+	}))
+	client.Commit()
+	blockHash := client.Head().Hash()
+	logs, err := client.FilterLogs(context.Background(), ethereum.FilterQuery{BlockHash: &blockHash})
+	require.NoError(t, err)
+	require.Len(t, logs, 4)
+	require.NoError(t, svc.statasDB.Create(&model.TxEventLog{
+		Chain:           testChain,
+		ContractAddress: pairList[0].String(),
+		Amount0:         90,
+		Amount1:         100,
+		BlockHash:       blockHash.String(),
+		TxHash:          logs[1].TxHash.String(),
+		BlockTime:       testBlockTime,
+		Height:          100,
+	}).Error)
+
+	svc.detectTokenBehaviours()
+	infos, err := svc.GetTokens()
+	require.NoError(t, err)
+	behaviours := make(map[string]string, 0)
+	for _, info := range infos {
+		behaviours[info.Symbol] = info.Behaviour
+		if info.Symbol == "FOT" {
+			requireNear(t, 0.1, info.TransferFee)
+		}
+	}
+	require.Equal(t, map[string]string{
+		"FOT":  model.TokenBehaviourFeeOnTransfer,
+		"REB":  model.TokenBehaviourRebasing,
+		"BUSD": "",
+		"WOKT": "",
+	}, behaviours)
+
+	// the pairs of the detected tokens are approximate, and valued by their plain side
+	svc.Refresh()
+	for idx, pair := range pairList {
+		info, exist := svc.GetSwapPairInfo(pair)
+		require.True(t, exist)
+		require.Equal(t, idx < 2, info.Approximate)
+	}
+	info, _ := svc.GetSwapPairInfo(pairList[0])
+	requireNear(t, 100, tradeVolumeUSD(info, 90, 100, map[string]float64{"FOT": 2, "BUSD": 1}))
+}
+
+func TestTokenSupply(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, nil)
+	client := svc.client.(*simchain.FakeClient)
+	state, err := svc.executor.GetPoolState(pairList[0])
+	require.NoError(t, err)
+	wokt, busd := state.Tokens[0], state.Tokens[1]
+	burn, treasury := ethcmm.HexToAddress("0xdead"), ethcmm.HexToAddress("0x7")
+	for _, token := range state.Tokens {
+		require.NoError(t, client.SetBalance(token, burn, tokenAmount(1e8)))
+	}
+	require.NoError(t, client.SetBalance(wokt, treasury, tokenAmount(4e8)))
+
+	chainConfig := *svc.chainConfig
+	chainConfig.SupplyExcluded = map[string][]string{
+		common.SupplyExcludedAll: {burn.String()},
+		wokt.String():            {treasury.String()},
+	}
+	svc.Reload(&chainConfig)
+	svc.Refresh()
+	now := time.Unix(testBlockTime, 0)
+	svc.refreshSupplies(now)
+
+	supplies, updateAt := svc.GetSupplies()
+	require.Equal(t, now, updateAt)
+	require.Len(t, supplies, 2)
+	// sorted by market cap, the treasury and the burn address are not circulating
+	require.Equal(t, "WOKT", supplies[0].Symbol)
+	requireNear(t, 1e9, supplies[0].TotalSupply)
+	requireNear(t, 5e8, supplies[0].CirculatingSupply)
+	requireNear(t, 1e10, supplies[0].MarketCap)
+	requireNear(t, 2e10, supplies[0].FDV)
+	require.Equal(t, "BUSD", supplies[1].Symbol)
+	requireNear(t, 9e8, supplies[1].CirculatingSupply)
+	requireNear(t, 9e8, supplies[1].MarketCap)
+
+	// a day keeps its latest supply
+	require.NoError(t, client.SetBalance(wokt, treasury, tokenAmount(3e8)))
+	svc.refreshSupplies(now.Add(time.Minute))
+	svc.refreshSupplies(now.Add(24 * time.Hour))
+	history, err := svc.GetSupplyHistory(wokt, 2, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, dayStart(now).Unix(), history[0].Date)
+	requireNear(t, 6e8, history[0].CirculatingSupply)
+	requireNear(t, 1.2e10, history[1].MarketCap)
+	history, err = svc.GetSupplyHistory(busd, 1, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestUserStats(t *testing.T) {
+	svc, _ := newTestSvc(t, nil, nil)
+	pair0, pair1 := "0x00000000000000000000000000000000000000a0", "0x00000000000000000000000000000000000000a1"
+	monday := weekStart(dayStart(time.Unix(testBlockTime, 0)).Unix())
+	var swaps int64
+	swap := func(pair, origin string, date int64, status model.TxStatus) *model.TxEventLog {
+		swap := &model.TxEventLog{
+			Chain:           testChain,
+			ContractAddress: pair,
+			Origin:          origin,
+			TxHash:          ethcmm.BigToHash(big.NewInt(swaps)).String(),
+			Status:          status,
+			BlockTime:       monday + date*day + 3600,
+		}
+		require.NoError(t, svc.statasDB.Create(swap).Error)
+		swaps++
+		return swap
+	}
+	swap(pair0, "a", 0, model.TxStatusConfirmed)
+	swap(pair0, "a", 0, model.TxStatusConfirmed)
+	swap(pair1, "b", 0, model.TxStatusConfirmed)
+	swap(pair1, "a", 1, model.TxStatusConfirmed)
+	swap(pair0, "a", 8, model.TxStatusConfirmed)
+	swap(pair0, "c", 8, model.TxStatusConfirmed)
+	// swaps without an origin are not counted, nor those not confirmed yet
+	swap(pair0, "", 8, model.TxStatusConfirmed)
+	pending := swap(pair0, "d", 8, model.TxStatusInit)
+	require.NoError(t, svc.refreshUserStats())
+
+	now := time.Unix(monday+8*day+7200, 0)
+	stats, err := svc.GetUserStats("", 9, 2, now)
+	require.NoError(t, err)
+	require.Len(t, stats.Days, 9)
+	require.Equal(t, UserActivity{Date: time.Unix(monday, 0).UTC(), DAU: 2, WAU: 2, MAU: 2, NewUsers: 2}, stats.Days[0])
+	require.Equal(t, UserActivity{Date: time.Unix(monday+day, 0).UTC(), DAU: 1, WAU: 2, MAU: 2, ReturningUsers: 1}, stats.Days[1])
+	require.Equal(t, int64(0), stats.Days[2].DAU)
+	require.Equal(t, int64(2), stats.DAU)
+	require.Equal(t, int64(2), stats.WAU)
+	require.Equal(t, int64(3), stats.MAU)
+	require.Equal(t, int64(1), stats.Days[8].NewUsers)
+	require.Equal(t, int64(1), stats.Days[8].ReturningUsers)
+	require.Len(t, stats.Cohorts, 2)
+	require.Equal(t, int64(2), stats.Cohorts[0].Users)
+	require.Equal(t, []int64{2, 1}, stats.Cohorts[0].Active)
+	require.Equal(t, []float64{1, 0.5}, stats.Cohorts[0].Retention)
+	require.Equal(t, []int64{1}, stats.Cohorts[1].Active)
+
+	// a is new to pair1 on the second day
+	stats, err = svc.GetUserStats(pair1, 9, 2, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Days[0].NewUsers)
+	require.Equal(t, int64(1), stats.Days[1].NewUsers)
+	require.Equal(t, int64(2), stats.Days[1].WAU)
+
+	// the next rollup counts the confirmed swaps since and updates the days and cohorts they touch
+	require.NoError(t, svc.statasDB.Model(pending).Update("status", model.TxStatusConfirmed).Error)
+	swap(pair1, "b", 9, model.TxStatusConfirmed)
+	require.NoError(t, svc.refreshUserStats())
+	stats, err = svc.GetUserStats("", 10, 2, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Days[8].DAU)
+	require.Equal(t, int64(2), stats.Days[8].NewUsers)
+	require.Equal(t, int64(1), stats.Days[9].ReturningUsers)
+	require.Equal(t, []int64{2, 2}, stats.Cohorts[0].Active)
+	require.Equal(t, []int64{2}, stats.Cohorts[1].Active)
+}
+
+func TestTrades(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}, {"Pie", "WOKT", 10000, 500}}, nil)
+	swap := func(pair int, token0In bool, amount0, amount1 float64, to ethcmm.Address, txHash string, logIndex uint, height int64) {
+		require.NoError(t, svc.statasDB.Create(&model.TxEventLog{
+			Chain:           testChain,
+			ContractAddress: pairList[pair].String(),
+			Amount0:         amount0,
+			Amount1:         amount1,
+			To:              to.String(),
+			Origin:          "0x00000000000000000000000000000000000000aa",
+			LogIndex:        &logIndex,
+			Token0In:        token0In,
+			TxHash:          txHash,
+			BlockTime:       testBlockTime,
+			Height:          height,
+		}).Error)
+	}
+	trader := ethcmm.HexToAddress("0xaa")
+	multiHop, single := ethcmm.BigToHash(big.NewInt(1)).String(), ethcmm.BigToHash(big.NewInt(2)).String()
+	// 200 BUSD for 10 WOKT sent on to the Pie pair for 200 Pie
+	swap(0, false, 10, 200, pairList[1], multiHop, 3, 100)
+	swap(1, false, 200, 10, trader, multiHop, 5, 100)
+	swap(0, true, 10, 200, trader, single, 0, 101)
+	// a swap indexed before the log index was kept has no known direction
+	legacy := ethcmm.BigToHash(big.NewInt(3)).String()
+	require.NoError(t, svc.statasDB.Create(&model.TxEventLog{
+		Chain:           testChain,
+		ContractAddress: pairList[0].String(),
+		Amount0:         10,
+		Amount1:         200,
+		TxHash:          legacy,
+		BlockTime:       testBlockTime,
+		Height:          102,
+	}).Error)
+	svc.Refresh()
+
+	prices, _ := svc.GetPrice()
+	requireNear(t, 1, prices["Pie"])
+	// each swap is worth 400 USD of total volume and trades 200 USD, the multi hop trade counts once
+	_, totalVolume, _, _ := svc.GetSwapPairInfos()
+	hopVolume, userTradeVolume := svc.GetTradeVolumes()
+	requireNear(t, 1600, totalVolume)
+	requireNear(t, 800, hopVolume)
+	requireNear(t, 600, userTradeVolume)
+
+	trades, err := svc.GetTrades("", 10)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	require.Equal(t, single, trades[0].TxHash)
+	require.Equal(t, "WOKT", trades[0].SymbolIn)
+	requireNear(t, 20, trades[0].Price)
+	trade := trades[1]
+	require.Equal(t, "BUSD", trade.SymbolIn)
+	require.Equal(t, "Pie", trade.SymbolOut)
+	require.Len(t, trade.Path, 3)
+	require.Equal(t, []string{pairList[0].String(), pairList[1].String()}, trade.Pairs)
+	requireNear(t, 200, trade.AmountIn)
+	requireNear(t, 200, trade.AmountOut)
+	requireNear(t, 1, trade.Price)
+	requireNear(t, 200, trade.ValueUSD)
+	// the user trade volume is the sum of the trade values, with the legacy swap as a single hop trade
+	requireNear(t, userTradeVolume, trades[0].ValueUSD+trade.ValueUSD+200)
+
+	trades, err = svc.GetTrades(multiHop, 10)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	require.Equal(t, trade, trades[0])
+	trades, err = svc.GetTrades(legacy, 10)
+	require.NoError(t, err)
+	require.Empty(t, trades)
+}
+
+func TestWashTrading(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}, {"Pie", "WOKT", 10000, 500}, {"Pie", "BUSD", 10000, 10000}}, nil)
+	var swaps int64
+	swap := func(pair int, token0In bool, amount0, amount1 float64, origin string, to ethcmm.Address, height int64) {
+		swaps++
+		logIndex := uint(swaps)
+		require.NoError(t, svc.statasDB.Create(&model.TxEventLog{
+			Chain:           testChain,
+			ContractAddress: pairList[pair].String(),
+			Amount0:         amount0,
+			Amount1:         amount1,
+			To:              to.String(),
+			Origin:          origin,
+			LogIndex:        &logIndex,
+			Token0In:        token0In,
+			TxHash:          ethcmm.BigToHash(big.NewInt(height)).String(),
+			BlockTime:       testBlockTime,
+			Height:          height,
+		}).Error)
+	}
+	trader := ethcmm.HexToAddress("0xaa")
+	// a buys WOKT and sells it back 5 blocks later, b only after 100 blocks
+	swap(0, false, 10, 200, "a", trader, 100)
+	swap(0, true, 10, 200, "a", trader, 105)
+	swap(0, false, 10, 200, "b", trader, 101)
+	swap(0, true, 10, 200, "b", trader, 201)
+	// c trades BUSD to WOKT to Pie and back to BUSD
+	swap(0, false, 10, 200, "c", pairList[1], 110)
+	swap(1, false, 200, 10, "c", pairList[2], 110)
+	swap(2, true, 200, 200, "c", trader, 110)
+	swap(2, true, 1800, 1800, "d", trader, 120)
+	svc.chainConfig.Wash = &util.WashConfig{MaxVolumeToLiquidity: 0.05}
+	svc.Refresh()
+
+	prices, _ := svc.GetPrice()
+	requireNear(t, 1, prices["Pie"])
+	for idx, expected := range []struct {
+		ratio float64
+		flags []string
+	}{
+		{0.6, []string{WashCircular, WashRoundTrip}},
+		{1, []string{WashCircular}},
+		// the 1800 USD left are beyond 5% of the 20000 USD reserves
+		{0.5, []string{WashCircular, WashVolumeToLiquidity}},
+	} {
+		info, exist := svc.GetSwapPairInfo(pairList[idx])
+		require.True(t, exist)
+		requireNear(t, expected.ratio, info.WashRatio, "wash ratio of pair %d", idx)
+		require.Equal(t, expected.flags, info.WashFlags, "pair %d", idx)
+	}
+	_, totalVolume, _, _ := svc.GetSwapPairInfos()
+	requireNear(t, 6400, totalVolume)
+	requireNear(t, 2800, svc.GetAdjustedVolume())
+
+	// a failed refresh copies the infos served, classifying them again leaves those served as they are
+	served, _ := svc.GetSwapPairInfo(pairList[0])
+	servedFlags := append([]string(nil), served.WashFlags...)
+	require.NoError(t, svc.statasDB.Where("contract_address = ?", strings.ToLower(pairList[1].String())).
+		Delete(model.TxEventLog{}).Error)
+	infos := make(map[ethcmm.Address]*SwapPairInfo, 0)
+	prices, _ = svc.GetPrice()
+	for _, pair := range pairList {
+		info, _ := svc.GetSwapPairInfo(pair)
+		copied := *info
+		infos[pair] = &copied
+	}
+	for idx := 0; idx < 2; idx++ {
+		require.NoError(t, svc.classifyWash(infos, prices))
+	}
+	require.Equal(t, servedFlags, served.WashFlags)
+	// the circular trade is broken without its swap in the second pair, which has no wash left
+	require.Equal(t, []string{WashRoundTrip}, infos[pairList[0]].WashFlags)
+	require.Zero(t, infos[pairList[1]].WashRatio)
+	require.Nil(t, infos[pairList[1]].WashFlags)
+}
+
+func TestTokenDonation(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, nil)
+	client := svc.client.(*simchain.FakeClient)
+	state, err := svc.executor.GetPoolState(pairList[0])
+	require.NoError(t, err)
+	busd := state.Tokens[1]
+	setBalance := func(balance *big.Int) {
+		require.NoError(t, client.SetBalance(state.Tokens[0], pairList[0], state.Reserves[0]))
+		require.NoError(t, client.SetBalance(busd, pairList[0], balance))
+	}
+	behaviour := func() string {
+		info, err := svc.tokens.token(busd)
+		require.NoError(t, err)
+		return info.Behaviour
+	}
+	setBalance(state.Reserves[1])
+	svc.Refresh()
+	svc.detectTokenBehaviours()
+
+	// 1 wei of BUSD donated to the pair is no rebase
+	setBalance(new(big.Int).Add(state.Reserves[1], big.NewInt(1)))
+	svc.detectTokenBehaviours()
+	svc.detectTokenBehaviours()
+	require.Empty(t, behaviour())
+	svc.Refresh()
+	info, _ := svc.GetSwapPairInfo(pairList[0])
+	require.False(t, info.Approximate)
+
+	// a balance moving on every check without a Sync is
+	for idx := 0; idx < common.TokenBehaviourRebaseMoves; idx++ {
+		setBalance(new(big.Int).Add(state.Reserves[1], big.NewInt(int64(idx+2))))
+		svc.detectTokenBehaviours()
+	}
+	require.Equal(t, model.TokenBehaviourRebasing, behaviour())
+
+	// and cleared when it is not detected again within the expiry
+	expired, err := svc.tokens.expire(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []ethcmm.Address{busd}, expired)
+	require.Empty(t, behaviour())
+}
+
+func TestPairPerformance(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, nil)
+	svc.Refresh()
+	info, exist := svc.GetSwapPairInfo(pairList[0])
+	require.True(t, exist)
+
+	// a snapshot without a price is left out of the average rather than counted as no loss
+	snapshotTime := time.Now().Add(-10 * 24 * time.Hour).Unix()
+	for _, price := range []float64{info.LastPrice * 4, 0} {
+		require.NoError(t, svc.statasDB.Create(&model.PairSnapshot{Chain: svc.Chain(), ContractAddress: pairList[0].String(),
+			Reserve0: 1000, Reserve1: 20000, LpSupply: 1, Price: price, SnapshotTime: snapshotTime}).Error)
+	}
+	performance, err := svc.GetPairPerformance(pairList[0])
+	require.NoError(t, err)
+	// the refresh took a snapshot at the current price as well
+	require.Equal(t, 3, performance.Snapshots)
+	requireNear(t, impermanentLoss(0.25)/2, performance.ImpermanentLoss30d)
+	require.Zero(t, performance.ImpermanentLoss7d)
+}
+
+func TestWalletPerformance(t *testing.T) {
+	svc, pairList := newTestSvc(t, []testPair{{"WOKT", "BUSD", 1000, 20000}}, nil)
+	svc.Refresh()
+
+	// one lp token is worth 1 WOKT and 20 BUSD, as it was at the entry
+	for wallet, position := range map[string]model.LpPosition{
+		"0xa": {EntryAmount0: 1, EntryAmount1: 20, EntryValueUSD: 40},
+		"0xb": {EntryUnknown: true},
+		"0xc": {},
+	} {
+		position.Chain, position.ContractAddress, position.Wallet, position.Balance = svc.Chain(), strings.ToLower(pairList[0].String()), wallet, 1
+		require.NoError(t, svc.statasDB.Create(&position).Error)
+	}
+
+	performances, err := svc.GetWalletPerformance("0xa")
+	require.NoError(t, err)
+	require.Len(t, performances, 1)
+	require.True(t, performances[0].Priced)
+	requireNear(t, 40, performances[0].ValueUSD)
+	requireNear(t, 40, performances[0].HoldValueUSD)
+	requireNear(t, 0, performances[0].ImpermanentLoss)
+	requireNear(t, 0, performances[0].NetPnlUSD)
+
+	// an unknown or zero entry is not reported as a total loss and all fees
+	for _, wallet := range []string{"0xb", "0xc"} {
+		performances, err := svc.GetWalletPerformance(wallet)
+		require.NoError(t, err)
+		require.Len(t, performances, 1)
+		require.False(t, performances[0].Priced, wallet)
+		requireNear(t, 40, performances[0].ValueUSD)
+		require.Zero(t, performances[0].ImpermanentLoss, wallet)
+		require.Zero(t, performances[0].FeesEarnedUSD, wallet)
+		require.Zero(t, performances[0].NetPnlUSD, wallet)
+	}
+}
@@ -0,0 +1,175 @@
+package statas
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/abi"
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// TokenSupply is the supply and valuation of a priced token. The circulating supply leaves out the
+// balances of the excluded holders, the fully diluted valuation is of the total supply.
+type TokenSupply struct {
+	Symbol            string  `json:"symbol"`
+	Address           string  `json:"address"`
+	TotalSupply       float64 `json:"total_supply"`
+	CirculatingSupply float64 `json:"circulating_supply"`
+	Price             float64 `json:"price"`
+	MarketCap         float64 `json:"market_cap"`
+	FDV               float64 `json:"fdv"`
+}
+
+func toSupplyExcluded(supplyExcluded map[string][]string) map[string][]ethcmm.Address {
+	excluded := make(map[string][]ethcmm.Address, len(supplyExcluded))
+	for token, holders := range supplyExcluded {
+		if token != common.SupplyExcludedAll {
+			token = strings.ToLower(token)
+		}
+		excluded[token] = append(excluded[token], toAddresses(holders)...)
+	}
+	return excluded
+}
+
+// supplyLoop reads the supplies at once and then periodically until the context is done
+func (r *StatasSvc) supplyLoop(ctx context.Context) {
+	for {
+		r.refreshSupplies(time.Now())
+		if !util.Sleep(ctx, common.SupplyInterval) {
+			return
+		}
+	}
+}
+
+// GetSupplies returns the supplies of the priced tokens and when they were read
+func (r *StatasSvc) GetSupplies() ([]TokenSupply, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	supplies := make([]TokenSupply, 0, len(r.supplies))
+	for _, supply := range r.supplies {
+		supplies = append(supplies, *supply)
+	}
+	sort.Slice(supplies, func(i, j int) bool { return supplies[i].MarketCap > supplies[j].MarketCap })
+	return supplies, r.supplyAt
+}
+
+// GetSupplyHistory returns the daily supplies of the token in the last days, oldest first
+func (r *StatasSvc) GetSupplyHistory(token ethcmm.Address, days int, now time.Time) ([]model.TokenSupplySnapshot, error) {
+	since := dayStart(now).AddDate(0, 0, -days+1)
+	return model.GetTokenSupplySnapshots(r.statasDB, r.Chain(), token.String(), since.Unix())
+}
+
+func dayStart(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// refreshSupplies reads the supplies of the priced tokens and saves them as the history of the day. The
+// last supply of a token is kept while it can not be read.
+func (r *StatasSvc) refreshSupplies(now time.Time) {
+	tokenPrice, _ := r.GetPrice()
+	tokenOf := make(map[string]ethcmm.Address, 0)
+	for _, info := range r.GetAllSwapPairInfos() {
+		if _, exist := tokenOf[info.BaseSymbol]; !exist {
+			tokenOf[info.BaseSymbol] = info.token0
+		}
+		if _, exist := tokenOf[info.QuoteSymbol]; !exist {
+			tokenOf[info.QuoteSymbol] = info.token1
+		}
+	}
+	r.mux.Lock()
+	excluded := r.supplyExcluded
+	lastSupplies := r.supplies
+	r.mux.Unlock()
+
+	supplies := make(map[string]*TokenSupply, len(tokenPrice))
+	snapshots := make([]*model.TokenSupplySnapshot, 0, len(tokenPrice))
+	for symbol, price := range tokenPrice {
+		token, exist := tokenOf[symbol]
+		if !exist {
+			continue
+		}
+		holders := append(append([]ethcmm.Address{}, excluded[common.SupplyExcludedAll]...), excluded[strings.ToLower(token.String())]...)
+		totalSupply, circulatingSupply, err := r.readSupply(token, holders)
+		if err != nil {
+			util.Logger.Errorf("read token supply error, chain=%s, token=%s, err=%s", r.Chain(), token.String(), err.Error())
+			if last, exist := lastSupplies[symbol]; exist && executor.IsRetryable(err) {
+				supplies[symbol] = last
+			}
+			continue
+		}
+		supply := &TokenSupply{
+			Symbol:            symbol,
+			Address:           token.String(),
+			TotalSupply:       totalSupply,
+			CirculatingSupply: circulatingSupply,
+			Price:             price,
+			MarketCap:         circulatingSupply * price,
+			FDV:               totalSupply * price,
+		}
+		supplies[symbol] = supply
+		snapshots = append(snapshots, &model.TokenSupplySnapshot{
+			Chain:             r.Chain(),
+			Token:             supply.Address,
+			Symbol:            symbol,
+			Date:              dayStart(now).Unix(),
+			TotalSupply:       supply.TotalSupply,
+			CirculatingSupply: supply.CirculatingSupply,
+			Price:             supply.Price,
+			MarketCap:         supply.MarketCap,
+			FDV:               supply.FDV,
+		})
+	}
+
+	r.mux.Lock()
+	r.supplies = supplies
+	r.supplyAt = now
+	r.mux.Unlock()
+	if err := model.SaveTokenSupplySnapshots(r.statasDB, snapshots); err != nil {
+		util.Logger.Errorf("save token supply snapshots error, chain=%s, err=%s", r.Chain(), err.Error())
+	}
+}
+
+// readSupply returns the total supply of the token and the part not held by the excluded holders
+func (r *StatasSvc) readSupply(token ethcmm.Address, excluded []ethcmm.Address) (float64, float64, error) {
+	metadata, err := r.tokens.token(token)
+	if err != nil {
+		return 0, 0, err
+	}
+	tokenIns, err := abi.NewBep20(token, r.client)
+	if err != nil {
+		return 0, 0, err
+	}
+	totalSupply, err := tokenIns.TotalSupply(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	circulating := new(big.Int).Set(totalSupply)
+	seen := make(map[ethcmm.Address]bool, len(excluded))
+	for _, holder := range excluded {
+		if seen[holder] {
+			continue
+		}
+		seen[holder] = true
+		balance, err := tokenIns.BalanceOf(nil, holder)
+		if err != nil {
+			return 0, 0, err
+		}
+		circulating.Sub(circulating, balance)
+	}
+	if circulating.Sign() < 0 {
+		circulating.SetInt64(0)
+	}
+	unit := big.NewFloat(math.Pow10(int(metadata.Decimals)))
+	total, _ := new(big.Float).Quo(new(big.Float).SetInt(totalSupply), unit).Float64()
+	circulatingSupply, _ := new(big.Float).Quo(new(big.Float).SetInt(circulating), unit).Float64()
+	return total, circulatingSupply, nil
+}
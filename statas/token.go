@@ -0,0 +1,503 @@
+package statas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/executor"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+// TokenList is a token list file of the token list standard, only the fields used are read
+type TokenList struct {
+	Name   string           `json:"name"`
+	Tokens []TokenListEntry `json:"tokens"`
+}
+
+type TokenListEntry struct {
+	ChainId  int64  `json:"chainId"`
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+	LogoURI  string `json:"logoURI"`
+}
+
+// ReadTokenList reads the token list file from disk
+func ReadTokenList(path string) (*TokenList, error) {
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list := &TokenList{}
+	if err := json.Unmarshal(bz, list); err != nil {
+		return nil, fmt.Errorf("parse token list %s error, err=%s", path, err.Error())
+	}
+	for _, entry := range list.Tokens {
+		if !ethcmm.IsHexAddress(entry.Address) {
+			return nil, fmt.Errorf("token list %s has invalid address %s", path, entry.Address)
+		}
+	}
+	return list, nil
+}
+
+// listedToken is a token of the token lists and the list verifying it
+type listedToken struct {
+	entry TokenListEntry
+	list  string
+}
+
+// tokenRegistry keeps the metadata of the tokens of the chain. A token is read from the chain and saved
+// when it is first seen, it is read again only while its metadata is degraded, and it is verified while
+// it is in one of the token lists.
+type tokenRegistry struct {
+	mux    sync.Mutex
+	db     *gorm.DB
+	chain  string
+	client executor.ChainClient
+	// defaultDecimals are the decimals of the tokens whose decimals can not be read
+	defaultDecimals uint8
+	loaded          bool
+	tokens          map[ethcmm.Address]*model.Token
+	listed          map[ethcmm.Address]listedToken
+}
+
+func newTokenRegistry(db *gorm.DB, chain string, client executor.ChainClient, defaultDecimals uint8) *tokenRegistry {
+	return &tokenRegistry{
+		db:              db,
+		chain:           chain,
+		client:          client,
+		defaultDecimals: defaultDecimals,
+		tokens:          make(map[ethcmm.Address]*model.Token, 0),
+		listed:          make(map[ethcmm.Address]listedToken, 0),
+	}
+}
+
+// load reads the tokens saved before, it is called with the lock held
+func (reg *tokenRegistry) load() error {
+	if reg.loaded {
+		return nil
+	}
+	tokens, err := model.GetTokens(reg.db, reg.chain)
+	if err != nil {
+		return err
+	}
+	for idx := range tokens {
+		reg.tokens[ethcmm.HexToAddress(tokens[idx].Address)] = &tokens[idx]
+	}
+	reg.loaded = true
+	return nil
+}
+
+// token returns the metadata of the token, it is read from the chain and saved when it is first seen
+func (reg *tokenRegistry) token(addr ethcmm.Address) (model.Token, error) {
+	reg.mux.Lock()
+	err := reg.load()
+	token, exist := reg.tokens[addr]
+	reg.mux.Unlock()
+	if err != nil {
+		return model.Token{}, err
+	}
+	if exist {
+		return *token, nil
+	}
+
+	token, err = reg.read(addr)
+	if err != nil {
+		return model.Token{}, err
+	}
+
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if seen, exist := reg.tokens[addr]; exist {
+		return *seen, nil
+	}
+	reg.applyListed(addr, token)
+	if err := model.SaveToken(reg.db, token); err != nil {
+		return model.Token{}, err
+	}
+	reg.tokens[addr] = token
+	return *token, nil
+}
+
+// read reads the metadata of the token from the chain, a token not following the standard is degraded
+func (reg *tokenRegistry) read(addr ethcmm.Address) (*model.Token, error) {
+	metadata, err := readTokenMetadata(reg.client, addr, reg.defaultDecimals)
+	if err != nil {
+		return nil, err
+	}
+	height, err := model.GetLatestHeight(reg.db, reg.chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata.degraded) > 0 {
+		util.Logger.Warningf("token metadata degraded, chain=%s, token=%s, degraded=%s", reg.chain, addr.String(),
+			strings.Join(metadata.degraded, ","))
+	}
+	supply, _ := new(big.Float).Quo(new(big.Float).SetInt(metadata.totalSupply), big.NewFloat(math.Pow10(int(metadata.decimals)))).Float64()
+	return &model.Token{
+		Chain:          reg.chain,
+		Address:        addr.String(),
+		Symbol:         metadata.symbol,
+		Name:           metadata.name,
+		Decimals:       metadata.decimals,
+		TotalSupply:    supply,
+		FirstSeenBlock: height,
+		Degraded:       strings.Join(metadata.degraded, ","),
+	}, nil
+}
+
+// refreshDegraded reads the tokens with degraded metadata again, the fields read now replace the defaults.
+// It returns the tokens which changed.
+func (reg *tokenRegistry) refreshDegraded() ([]ethcmm.Address, error) {
+	reg.mux.Lock()
+	err := reg.load()
+	degraded := make([]ethcmm.Address, 0)
+	for addr, token := range reg.tokens {
+		if token.Degraded != "" {
+			degraded = append(degraded, addr)
+		}
+	}
+	reg.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]ethcmm.Address, 0)
+	for _, addr := range degraded {
+		metadata, err := readTokenMetadata(reg.client, addr, reg.defaultDecimals)
+		if err != nil {
+			return changed, err
+		}
+		still := make(map[string]bool, len(metadata.degraded))
+		for _, field := range metadata.degraded {
+			still[field] = true
+		}
+
+		reg.mux.Lock()
+		token := reg.tokens[addr]
+		// a field read before is kept, only the defaults are replaced
+		remaining := make([]string, 0)
+		var supplyChanged bool
+		for _, field := range strings.Split(token.Degraded, ",") {
+			if still[field] {
+				remaining = append(remaining, field)
+				continue
+			}
+			switch field {
+			case degradedSymbol:
+				token.Symbol = metadata.symbol
+			case degradedName:
+				token.Name = metadata.name
+			case degradedDecimals:
+				token.Decimals = metadata.decimals
+				supplyChanged = true
+			case degradedTotalSupply:
+				supplyChanged = true
+			}
+		}
+		// the total supply read with the default decimals is scaled again
+		if supplyChanged && !still[degradedTotalSupply] {
+			token.TotalSupply, _ = new(big.Float).Quo(new(big.Float).SetInt(metadata.totalSupply),
+				big.NewFloat(math.Pow10(int(token.Decimals)))).Float64()
+		}
+		if len(remaining) == len(strings.Split(token.Degraded, ",")) {
+			reg.mux.Unlock()
+			continue
+		}
+		token.Degraded = strings.Join(remaining, ",")
+		reg.applyListed(addr, token)
+		err = model.SaveToken(reg.db, token)
+		reg.mux.Unlock()
+		if err != nil {
+			return changed, err
+		}
+		changed = append(changed, addr)
+	}
+	return changed, nil
+}
+
+// decimalsDegraded returns whether the decimals of the token could not be read and are the default
+func decimalsDegraded(token model.Token) bool {
+	for _, field := range strings.Split(token.Degraded, ",") {
+		if field == degradedDecimals {
+			return true
+		}
+	}
+	return false
+}
+
+// applyListed sets the token list metadata of the token, it returns whether the token changed
+func (reg *tokenRegistry) applyListed(addr ethcmm.Address, token *model.Token) bool {
+	listed, exist := reg.listed[addr]
+	if !exist {
+		if !token.Verified {
+			return false
+		}
+		token.Verified, token.ListName = false, ""
+		return true
+	}
+	name := token.Name
+	if listed.entry.Name != "" {
+		name = listed.entry.Name
+	}
+	if token.Verified && token.ListName == listed.list && token.Name == name && token.LogoURI == listed.entry.LogoURI {
+		return false
+	}
+	token.Verified, token.ListName, token.Name, token.LogoURI = true, listed.list, name, listed.entry.LogoURI
+	return true
+}
+
+// setLists replaces the token lists, the tokens of the chain in them are verified and the others are not.
+// The first list verifying a token wins.
+func (reg *tokenRegistry) setLists(lists []*TokenList, chainId int64) error {
+	listed := make(map[ethcmm.Address]listedToken, 0)
+	for _, list := range lists {
+		for _, entry := range list.Tokens {
+			addr := ethcmm.HexToAddress(entry.Address)
+			if _, exist := listed[addr]; exist || (chainId != 0 && entry.ChainId != chainId) {
+				continue
+			}
+			listed[addr] = listedToken{entry: entry, list: list.Name}
+		}
+	}
+
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if err := reg.load(); err != nil {
+		return err
+	}
+	reg.listed = listed
+	for addr, token := range reg.tokens {
+		if !reg.applyListed(addr, token) {
+			continue
+		}
+		if err := model.SaveToken(reg.db, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classify records the behaviour detected of the token. The first behaviour detected is kept until it
+// expires, and the highest fee seen of a fee on transfer token. It returns whether the token changed.
+func (reg *tokenRegistry) classify(addr ethcmm.Address, behaviour string, fee float64) (bool, error) {
+	if _, err := reg.token(addr); err != nil {
+		return false, err
+	}
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	token := reg.tokens[addr]
+	if token.Behaviour != "" && token.Behaviour != behaviour {
+		return false, nil
+	}
+	changed := token.Behaviour == "" || fee > token.TransferFee
+	token.Behaviour = behaviour
+	token.TransferFee = math.Max(token.TransferFee, fee)
+	token.DetectedAt = time.Now().Unix()
+	return changed, model.SaveToken(reg.db, token)
+}
+
+// expire clears the behaviours last detected before the given time, the tokens cleared are returned
+func (reg *tokenRegistry) expire(before time.Time) ([]ethcmm.Address, error) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if err := reg.load(); err != nil {
+		return nil, err
+	}
+	expired := make([]ethcmm.Address, 0)
+	for addr, token := range reg.tokens {
+		if token.Behaviour == "" || token.DetectedAt >= before.Unix() {
+			continue
+		}
+		token.Behaviour, token.TransferFee = "", 0
+		if err := model.SaveToken(reg.db, token); err != nil {
+			return expired, err
+		}
+		expired = append(expired, addr)
+	}
+	return expired, nil
+}
+
+// all returns the tokens seen, in the order they were seen
+func (reg *tokenRegistry) all() ([]model.Token, error) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if err := reg.load(); err != nil {
+		return nil, err
+	}
+	tokens := make([]model.Token, 0, len(reg.tokens))
+	for _, token := range reg.tokens {
+		tokens = append(tokens, *token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+type TokenInfo struct {
+	Chain          string  `json:"chain"`
+	Address        string  `json:"address"`
+	Symbol         string  `json:"symbol"`
+	Name           string  `json:"name"`
+	Decimals       uint8   `json:"decimals"`
+	TotalSupply    float64 `json:"total_supply"`
+	FirstSeenBlock int64   `json:"first_seen_block"`
+	Verified       bool    `json:"verified"`
+	LogoURI        string  `json:"logo_uri,omitempty"`
+	ListName       string  `json:"list_name,omitempty"`
+	// Behaviour is fee_on_transfer or rebasing for the tokens not behaving as plain erc20, the amounts and
+	// the prices involving them are approximate
+	Behaviour   string  `json:"behaviour,omitempty"`
+	TransferFee float64 `json:"transfer_fee,omitempty"`
+	// MetadataDegraded lists the metadata which could not be read from the chain, the defaults are served
+	MetadataDegraded []string `json:"metadata_degraded,omitempty"`
+}
+
+// GetTokens returns the metadata of the tokens seen on the chain
+func (r *StatasSvc) GetTokens() ([]TokenInfo, error) {
+	tokens, err := r.tokens.all()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]TokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		var degraded []string
+		if token.Degraded != "" {
+			degraded = strings.Split(token.Degraded, ",")
+		}
+		infos = append(infos, TokenInfo{
+			Chain:          token.Chain,
+			Address:        token.Address,
+			Symbol:         token.Symbol,
+			Name:           token.Name,
+			Decimals:       token.Decimals,
+			TotalSupply:    token.TotalSupply,
+			FirstSeenBlock: token.FirstSeenBlock,
+			Verified:       token.Verified,
+			LogoURI:        token.LogoURI,
+			ListName:       token.ListName,
+			Behaviour:      token.Behaviour,
+			TransferFee:    token.TransferFee,
+
+			MetadataDegraded: degraded,
+		})
+	}
+	return infos, nil
+}
+
+// metadataLoop reads the tokens with degraded metadata again periodically until the context is done
+func (r *StatasSvc) metadataLoop(ctx context.Context) {
+	for util.Sleep(ctx, common.TokenMetadataInterval) {
+		r.refreshDegradedTokens()
+	}
+}
+
+// refreshDegradedTokens reads the tokens with degraded metadata again. The amounts of the events indexed
+// with the default decimals are not corrected.
+func (r *StatasSvc) refreshDegradedTokens() {
+	changed, err := r.tokens.refreshDegraded()
+	if err != nil {
+		util.Logger.Errorf("refresh degraded tokens error, chain=%s, err=%s", r.Chain(), err.Error())
+	}
+	for _, addr := range changed {
+		util.Logger.Infof("token metadata read again, chain=%s, token=%s", r.Chain(), addr.String())
+	}
+}
+
+// importTokenLists reads the token lists of the chain from disk and verifies their tokens, a list
+// which can not be read is skipped
+func (r *StatasSvc) importTokenLists() {
+	r.mux.Lock()
+	paths := r.tokenLists
+	r.mux.Unlock()
+	lists := make([]*TokenList, 0, len(paths))
+	for _, path := range paths {
+		list, err := ReadTokenList(path)
+		if err != nil {
+			util.Logger.Errorf("read token list error, chain=%s, err=%s", r.Chain(), err.Error())
+			continue
+		}
+		if list.Name == "" {
+			list.Name = path
+		}
+		lists = append(lists, list)
+	}
+	if err := r.tokens.setLists(lists, r.chainConfig.ChainId); err != nil {
+		util.Logger.Errorf("import token lists error, chain=%s, err=%s", r.Chain(), err.Error())
+	}
+}
+
+// symbolOwners returns the addresses owning each symbol, the allowed tokens, the tokens of the
+// certificated pairs and the verified tokens
+func (r *StatasSvc) symbolOwners(allowTokens, certPairList []ethcmm.Address) map[string]map[ethcmm.Address]bool {
+	owners := make(map[string]map[ethcmm.Address]bool, 0)
+	own := func(symbol string, addr ethcmm.Address) {
+		if owners[symbol] == nil {
+			owners[symbol] = make(map[ethcmm.Address]bool, 0)
+		}
+		owners[symbol][addr] = true
+	}
+	for _, addr := range allowTokens {
+		token, err := r.tokens.token(addr)
+		if err != nil {
+			util.Logger.Errorf("get allowed token error, chain=%s, token=%s, err=%s", r.Chain(), addr.String(), err.Error())
+			continue
+		}
+		own(token.Symbol, addr)
+	}
+	for _, swapPairAddr := range certPairList {
+		tokens, symbols, err := r.pairSymbols(swapPairAddr)
+		if err != nil {
+			continue
+		}
+		for idx, symbol := range symbols {
+			own(symbol, tokens[idx])
+		}
+	}
+	verified, err := r.tokens.all()
+	if err != nil {
+		util.Logger.Errorf("get tokens error, chain=%s, err=%s", r.Chain(), err.Error())
+	}
+	for _, token := range verified {
+		if token.Verified {
+			own(token.Symbol, ethcmm.HexToAddress(token.Address))
+		}
+	}
+	return owners
+}
+
+// listable reports whether the pair of the tokens is listed, none of its tokens is denied or
+// impersonates the symbol of a token owning it
+func listable(tokens []ethcmm.Address, symbols []string, denyTokens map[ethcmm.Address]bool,
+	owners map[string]map[ethcmm.Address]bool) bool {
+	for idx, token := range tokens {
+		if denyTokens[token] {
+			return false
+		}
+		if owner := owners[symbols[idx]]; len(owner) > 0 && !owner[token] {
+			return false
+		}
+	}
+	return true
+}
+
+func toAddressSet(addrs []string) map[ethcmm.Address]bool {
+	set := make(map[ethcmm.Address]bool, len(addrs))
+	for _, addr := range addrs {
+		set[ethcmm.HexToAddress(strings.TrimSpace(addr))] = true
+	}
+	return set
+}
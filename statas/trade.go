@@ -0,0 +1,152 @@
+package statas
+
+import (
+	"sort"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/model"
+)
+
+// Trade is a trade of a user reconstructed from the swaps of a transaction. A multi hop trade through the
+// router swaps in several pairs, each pair sends its output to the next pair of the path.
+type Trade struct {
+	TxHash string `json:"tx_hash"`
+	Trader string `json:"trader,omitempty"`
+	// Path are the tokens from the input to the output and Pairs the pairs swapped in between
+	Path      []string `json:"path"`
+	Pairs     []string `json:"pairs"`
+	TokenIn   string   `json:"token_in"`
+	TokenOut  string   `json:"token_out"`
+	SymbolIn  string   `json:"symbol_in"`
+	SymbolOut string   `json:"symbol_out"`
+	AmountIn  float64  `json:"amount_in"`
+	AmountOut float64  `json:"amount_out"`
+	// Price is the effective price, the output received for one input
+	Price     float64 `json:"price"`
+	ValueUSD  float64 `json:"value_usd"`
+	Height    int64   `json:"height"`
+	BlockTime int64   `json:"block_time"`
+
+	// swaps are the swaps of the trade and hops their values
+	swaps []*model.TxEventLog
+	hops  []float64
+}
+
+// hop is a swap with its direction resolved by the pair
+type hop struct {
+	swap              *model.TxEventLog
+	info              *SwapPairInfo
+	tokenIn, tokenOut ethcmm.Address
+	symbolIn          string
+	symbolOut         string
+	amountIn          float64
+	amountOut         float64
+}
+
+func newHop(swap *model.TxEventLog, info *SwapPairInfo) *hop {
+	if swap.Token0In {
+		return &hop{swap, info, info.token0, info.token1, info.BaseSymbol, info.QuoteSymbol, swap.Amount0, swap.Amount1}
+	}
+	return &hop{swap, info, info.token1, info.token0, info.QuoteSymbol, info.BaseSymbol, swap.Amount1, swap.Amount0}
+}
+
+// buildTrades groups the swaps, ordered by transaction and log index, into trades. A swap continues the
+// trade of the swap before it when that one sent its output token to the pair. Swaps of unknown pairs are
+// skipped, and the swaps indexed before the log index was kept, their direction is unknown. A trade is valued
+// by its first swap, or its last one if the first has no priced token.
+func buildTrades(swaps []model.TxEventLog, infos map[ethcmm.Address]*SwapPairInfo, tokenPrice map[string]float64) []*Trade {
+	trades := make([]*Trade, 0)
+	var trade *Trade
+	var last *hop
+	for idx := range swaps {
+		swap := &swaps[idx]
+		pair := ethcmm.HexToAddress(swap.ContractAddress)
+		info, exist := infos[pair]
+		if !exist || swap.LogIndex == nil {
+			trade, last = nil, nil
+			continue
+		}
+		current := newHop(swap, info)
+		value := tradeVolumeUSD(info, swap.Amount0, swap.Amount1, tokenPrice)
+		if trade != nil && last.swap.TxHash == swap.TxHash && ethcmm.HexToAddress(last.swap.To) == pair &&
+			last.tokenOut == current.tokenIn {
+			trade.Path = append(trade.Path, current.tokenOut.String())
+			trade.Pairs = append(trade.Pairs, pair.String())
+			trade.TokenOut, trade.SymbolOut, trade.AmountOut = current.tokenOut.String(), current.symbolOut, current.amountOut
+			trade.swaps = append(trade.swaps, swap)
+			trade.hops = append(trade.hops, value)
+		} else {
+			trade = &Trade{
+				TxHash:    swap.TxHash,
+				Trader:    swap.Origin,
+				Path:      []string{current.tokenIn.String(), current.tokenOut.String()},
+				Pairs:     []string{pair.String()},
+				TokenIn:   current.tokenIn.String(),
+				TokenOut:  current.tokenOut.String(),
+				SymbolIn:  current.symbolIn,
+				SymbolOut: current.symbolOut,
+				AmountIn:  current.amountIn,
+				AmountOut: current.amountOut,
+				Height:    swap.Height,
+				BlockTime: swap.BlockTime,
+				swaps:     []*model.TxEventLog{swap},
+				hops:      []float64{value},
+			}
+			trades = append(trades, trade)
+		}
+		last = current
+	}
+	for _, trade := range trades {
+		if trade.AmountIn != 0 {
+			trade.Price = trade.AmountOut / trade.AmountIn
+		}
+		trade.ValueUSD = trade.hops[0]
+		if trade.ValueUSD == 0 {
+			trade.ValueUSD = trade.hops[len(trade.hops)-1]
+		}
+	}
+	return trades
+}
+
+// tradeVolumes returns the 24h volume of the qualified pairs summed per swap, and the volume of the trades of
+// the users, which counts a multi hop trade once. Every swap is valued as a trade is, so the user trade volume
+// is the sum of the trade values, and only the transactions with several swaps need to be rebuilt.
+func (r *StatasSvc) tradeVolumes(qualified []*SwapPairInfo, tokenPrice map[string]float64) (float64, float64, error) {
+	var hopVolume float64
+	infos := make(map[ethcmm.Address]*SwapPairInfo, len(qualified))
+	for _, info := range qualified {
+		infos[ethcmm.HexToAddress(info.SwapPairContract)] = info
+		hopVolume += tradeVolumeUSD(info, info.BaseVolume24h, info.QuoteVolume24h, tokenPrice)
+	}
+	blockTime, err := model.GetLatestBlockTime(r.statasDB, r.Chain())
+	if err != nil {
+		return 0, 0, err
+	}
+	swaps, err := model.GetMultiSwapTxs(r.statasDB, r.Chain(), blockTime-day)
+	if err != nil {
+		return 0, 0, err
+	}
+	userVolume := hopVolume
+	for _, trade := range buildTrades(swaps, infos, tokenPrice) {
+		for _, value := range trade.hops {
+			userVolume -= value
+		}
+		userVolume += trade.ValueUSD
+	}
+	return hopVolume, userVolume, nil
+}
+
+// GetTrades returns the trades of the latest transactions with swaps, or of the given transaction, latest first
+func (r *StatasSvc) GetTrades(txHash string, limit int) ([]*Trade, error) {
+	swaps, err := model.GetLatestTxSwaps(r.statasDB, r.Chain(), txHash, limit)
+	if err != nil {
+		return nil, err
+	}
+	r.mux.Lock()
+	infos, tokenPrice := r.swapPairInfoMap, r.tokenPrice
+	r.mux.Unlock()
+	trades := buildTrades(swaps, infos, tokenPrice)
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Height > trades[j].Height })
+	return trades, nil
+}
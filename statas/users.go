@@ -0,0 +1,205 @@
+package statas
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pieswap/pie-statas/common"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	day  = int64(24 * time.Hour / time.Second)
+	week = 7 * day
+)
+
+// UserStats are the active traders of a pair, or of all pairs, the latest day first in DAU, WAU and MAU
+type UserStats struct {
+	Pair    string         `json:"pair,omitempty"`
+	DAU     int64          `json:"dau"`
+	WAU     int64          `json:"wau"`
+	MAU     int64          `json:"mau"`
+	Days    []UserActivity `json:"days"`
+	Cohorts []UserCohort   `json:"cohorts"`
+}
+
+// UserActivity are the active traders on a day and in the week and the month to it
+type UserActivity struct {
+	Date           time.Time `json:"date"`
+	DAU            int64     `json:"dau"`
+	WAU            int64     `json:"wau"`
+	MAU            int64     `json:"mau"`
+	NewUsers       int64     `json:"new_users"`
+	ReturningUsers int64     `json:"returning_users"`
+}
+
+// UserCohort are the traders first seen in a week, Active are those of them trading in each week since
+type UserCohort struct {
+	Cohort    time.Time `json:"cohort"`
+	Users     int64     `json:"users"`
+	Active    []int64   `json:"active"`
+	Retention []float64 `json:"retention"`
+}
+
+// weekStart returns the start of the monday week of the day
+func weekStart(date int64) int64 {
+	return date - (date/day+3)%7*day
+}
+
+// userStatsLoop rolls up the user stats at once and then periodically until the context is done
+func (r *StatasSvc) userStatsLoop(ctx context.Context) {
+	for {
+		if err := r.refreshUserStats(); err != nil {
+			util.Logger.Errorf("refresh user stats error, chain=%s, err=%s", r.Chain(), err.Error())
+		}
+		if !util.Sleep(ctx, common.UserStatsInterval) {
+			return
+		}
+	}
+}
+
+// refreshUserStats counts the swaps confirmed since the last rollup into the trader days, then computes the
+// stats of the touched pairs and of the protocol again from the earliest day touched
+func (r *StatasSvc) refreshUserStats() error {
+	pairs := make(map[string]bool, 0)
+	earliest := int64(-1)
+	for {
+		touched, date, err := model.RollupTraders(r.statasDB, r.Chain(), common.UserRollupBatch)
+		if err != nil {
+			return err
+		}
+		if date < 0 {
+			break
+		}
+		for pair := range touched {
+			pairs[pair] = true
+		}
+		if earliest < 0 || date < earliest {
+			earliest = date
+		}
+	}
+	if earliest < 0 {
+		return nil
+	}
+	latest, err := model.GetLatestTraderDate(r.statasDB, r.Chain())
+	if err != nil {
+		return err
+	}
+
+	stats := make([]*model.UserStatDay, 0)
+	cohorts := make([]*model.UserCohort, 0)
+	pairs[model.ProtocolWide] = true
+	for pair := range pairs {
+		for date := earliest; date <= latest; date += day {
+			stat, err := r.userStatDay(pair, date)
+			if err != nil {
+				return err
+			}
+			stats = append(stats, stat)
+		}
+		for activeWeek := weekStart(earliest); activeWeek <= latest; activeWeek += week {
+			byFirstDate, err := model.TradersByFirstDate(r.statasDB, r.Chain(), pair, activeWeek, activeWeek+week)
+			if err != nil {
+				return err
+			}
+			byCohort := make(map[int64]int64, 0)
+			for firstDate, traders := range byFirstDate {
+				byCohort[weekStart(firstDate)] += traders
+			}
+			for cohort, traders := range byCohort {
+				cohorts = append(cohorts, &model.UserCohort{
+					Chain:  r.Chain(),
+					Pair:   pair,
+					Cohort: cohort,
+					Week:   (activeWeek - cohort) / week,
+					Users:  traders,
+				})
+			}
+		}
+	}
+	return model.SaveUserStats(r.statasDB, stats, cohorts)
+}
+
+func (r *StatasSvc) userStatDay(pair string, date int64) (*model.UserStatDay, error) {
+	stat := &model.UserStatDay{Chain: r.Chain(), Pair: pair, Date: date}
+	for _, active := range []struct {
+		count *int64
+		days  int64
+	}{{&stat.DAU, 1}, {&stat.WAU, 7}, {&stat.MAU, 30}} {
+		count, err := model.CountActiveTraders(r.statasDB, r.Chain(), pair, date-(active.days-1)*day, date+day)
+		if err != nil {
+			return nil, err
+		}
+		*active.count = count
+	}
+	newUsers, err := model.CountNewTraders(r.statasDB, r.Chain(), pair, date, date+day)
+	if err != nil {
+		return nil, err
+	}
+	stat.NewUsers = newUsers
+	stat.ReturningUsers = stat.DAU - newUsers
+	return stat, nil
+}
+
+// GetUserStats returns the user stats of the pair, or of all pairs if it is empty, of the last days and the
+// cohorts of the last weeks
+func (r *StatasSvc) GetUserStats(pair string, days, weeks int, now time.Time) (*UserStats, error) {
+	pair = strings.ToLower(pair)
+	today := dayStart(now).Unix()
+	statDays, err := model.GetUserStatDays(r.statasDB, r.Chain(), pair, today-int64(days-1)*day)
+	if err != nil {
+		return nil, err
+	}
+	statCohorts, err := model.GetUserCohorts(r.statasDB, r.Chain(), pair, weekStart(today)-int64(weeks-1)*week)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &UserStats{
+		Pair:    pair,
+		Days:    make([]UserActivity, 0, len(statDays)),
+		Cohorts: make([]UserCohort, 0, weeks),
+	}
+	for _, stat := range statDays {
+		stats.Days = append(stats.Days, UserActivity{
+			Date:           time.Unix(stat.Date, 0).UTC(),
+			DAU:            stat.DAU,
+			WAU:            stat.WAU,
+			MAU:            stat.MAU,
+			NewUsers:       stat.NewUsers,
+			ReturningUsers: stat.ReturningUsers,
+		})
+	}
+	if len(statDays) > 0 {
+		latest := statDays[len(statDays)-1]
+		stats.DAU, stats.WAU, stats.MAU = latest.DAU, latest.WAU, latest.MAU
+	}
+	for _, stat := range statCohorts {
+		if len(stats.Cohorts) == 0 || stats.Cohorts[len(stats.Cohorts)-1].Cohort.Unix() != stat.Cohort {
+			stats.Cohorts = append(stats.Cohorts, UserCohort{Cohort: time.Unix(stat.Cohort, 0).UTC()})
+		}
+		cohort := &stats.Cohorts[len(stats.Cohorts)-1]
+		// the weeks without traders have no row
+		for int64(len(cohort.Active)) < stat.Week {
+			cohort.Active = append(cohort.Active, 0)
+		}
+		cohort.Active = append(cohort.Active, stat.Users)
+		if stat.Week == 0 {
+			cohort.Users = stat.Users
+		}
+	}
+	for idx := range stats.Cohorts {
+		cohort := &stats.Cohorts[idx]
+		cohort.Retention = make([]float64, 0, len(cohort.Active))
+		for _, active := range cohort.Active {
+			var retention float64
+			if cohort.Users > 0 {
+				retention = float64(active) / float64(cohort.Users)
+			}
+			cohort.Retention = append(cohort.Retention, retention)
+		}
+	}
+	return stats, nil
+}
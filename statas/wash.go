@@ -0,0 +1,124 @@
+package statas
+
+import (
+	"sort"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/model"
+)
+
+// the reasons a swap or the volume of a pair is classified as wash trading
+const (
+	WashRoundTrip         = "round_trip"
+	WashCircular          = "circular"
+	WashVolumeToLiquidity = "volume_to_liquidity"
+)
+
+type traderPair struct {
+	trader string
+	pair   string
+}
+
+// classifyWash flags the 24h swaps of likely wash trading and sets the wash ratios of the pairs. A swap is a
+// round trip when its trader swaps the pair the other way within the round trip blocks, and circular when its
+// trade ends in the token it started with. The volume left beyond the max volume to liquidity of the reserves
+// of a pair is wash as well. The reserves of the pairs should be valued before.
+func (r *StatasSvc) classifyWash(infos map[ethcmm.Address]*SwapPairInfo, tokenPrice map[string]float64) error {
+	// an info kept from the last refresh shares its flags with the info served
+	for _, info := range infos {
+		info.WashRatio, info.WashFlags = 0, nil
+	}
+	blockTime, err := model.GetLatestBlockTime(r.statasDB, r.Chain())
+	if err != nil {
+		return err
+	}
+	swaps, err := model.GetSwapsSince(r.statasDB, r.Chain(), blockTime-day)
+	if err != nil {
+		return err
+	}
+	setting := r.chainConfig.WashSetting()
+
+	flagged := make(map[uint]bool, 0)
+	reasons := make(map[ethcmm.Address]map[string]bool, 0)
+	flag := func(swap *model.TxEventLog, reason string) {
+		flagged[swap.ID] = true
+		pair := ethcmm.HexToAddress(swap.ContractAddress)
+		if reasons[pair] == nil {
+			reasons[pair] = make(map[string]bool, 0)
+		}
+		reasons[pair][reason] = true
+	}
+
+	// the swaps of a trader in a pair within the round trip blocks, the swaps are ordered by height. The
+	// direction of the swaps indexed before the log index was kept is unknown.
+	recent := make(map[traderPair][]*model.TxEventLog, 0)
+	for idx := range swaps {
+		swap := &swaps[idx]
+		if swap.Origin == "" || swap.LogIndex == nil {
+			continue
+		}
+		key := traderPair{swap.Origin, swap.ContractAddress}
+		kept := recent[key][:0]
+		for _, before := range recent[key] {
+			if swap.Height-before.Height > setting.RoundTripBlocks {
+				continue
+			}
+			kept = append(kept, before)
+			if before.Token0In != swap.Token0In {
+				flag(before, WashRoundTrip)
+				flag(swap, WashRoundTrip)
+			}
+		}
+		recent[key] = append(kept, swap)
+	}
+	for _, trade := range buildTrades(swaps, infos, tokenPrice) {
+		if len(trade.swaps) > 1 && trade.TokenIn == trade.TokenOut {
+			for _, swap := range trade.swaps {
+				flag(swap, WashCircular)
+			}
+		}
+	}
+
+	volumes := make(map[ethcmm.Address]*struct{ total, wash float64 }, 0)
+	for idx := range swaps {
+		swap := &swaps[idx]
+		pair := ethcmm.HexToAddress(swap.ContractAddress)
+		info, exist := infos[pair]
+		if !exist {
+			continue
+		}
+		if volumes[pair] == nil {
+			volumes[pair] = &struct{ total, wash float64 }{}
+		}
+		value := tradeVolumeUSD(info, swap.Amount0, swap.Amount1, tokenPrice)
+		volumes[pair].total += value
+		if flagged[swap.ID] {
+			volumes[pair].wash += value
+		}
+	}
+	for pair, volume := range volumes {
+		info := infos[pair]
+		maxVolume := info.reserveUSD * setting.MaxVolumeToLiquidity
+		if info.reserveUSD > 0 && volume.total-volume.wash > maxVolume {
+			volume.wash = volume.total - maxVolume
+			if reasons[pair] == nil {
+				reasons[pair] = make(map[string]bool, 0)
+			}
+			reasons[pair][WashVolumeToLiquidity] = true
+		}
+		if volume.total > 0 {
+			info.WashRatio = volume.wash / volume.total
+		}
+		if len(reasons[pair]) == 0 {
+			continue
+		}
+		flags := make([]string, 0, len(reasons[pair]))
+		for reason := range reasons[pair] {
+			flags = append(flags, reason)
+		}
+		sort.Strings(flags)
+		info.WashFlags = flags
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package statas
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
+
+	"github.com/pieswap/pie-statas/alert"
+	"github.com/pieswap/pie-statas/model"
+	"github.com/pieswap/pie-statas/pubsub"
+	"github.com/pieswap/pie-statas/util"
+)
+
+const (
+	RuleWhaleTrade       = "whale_trade"
+	RuleLiquidityRemoval = "liquidity_removal"
+	RuleFlaggedAddress   = "flagged_address"
+)
+
+// EventNotifier sends the alerts of indexed events at once
+type EventNotifier interface {
+	Notify(ctx context.Context, alerts ...*alert.Alert)
+}
+
+// WhaleWatcher values the swaps, mints and burns of the chain in USD as they are confirmed, and notifies
+// the large trades, the burns taking a large share of a pair and the repeated activity of flagged addresses.
+// The events of old blocks are not notified, nor those of the blocks reorged out before confirmation.
+type WhaleWatcher struct {
+	svc      *StatasSvc
+	bus      *pubsub.Bus
+	notifier EventNotifier
+
+	mux     sync.Mutex
+	setting util.WhaleConfig
+	enabled bool
+	flagged map[string]bool
+	// activity are the block times of the recent events of the flagged addresses
+	activity map[string][]time.Time
+	// pending are the events waiting for their blocks to be confirmed
+	pending []*pendingEvent
+
+	wg sync.WaitGroup
+}
+
+// pendingEvent is an indexed event and its block
+type pendingEvent struct {
+	data      interface{}
+	height    int64
+	blockHash string
+	blockTime int64
+}
+
+func NewWhaleWatcher(svc *StatasSvc, bus *pubsub.Bus, notifier EventNotifier, cfg *util.AlertConfig) *WhaleWatcher {
+	w := &WhaleWatcher{
+		svc:      svc,
+		bus:      bus,
+		notifier: notifier,
+		activity: make(map[string][]time.Time, 0),
+	}
+	w.Reload(cfg)
+	return w
+}
+
+// Reload applies the whale setting of the alert config, the activity of the addresses still flagged is kept
+func (w *WhaleWatcher) Reload(cfg *util.AlertConfig) {
+	setting, enabled := cfg.WhaleSetting()
+	flagged := make(map[string]bool, len(setting.FlaggedAddresses))
+	for _, addr := range setting.FlaggedAddresses {
+		flagged[strings.ToLower(addr)] = true
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.setting, w.enabled, w.flagged = setting, enabled, flagged
+	for addr := range w.activity {
+		if !flagged[addr] {
+			delete(w.activity, addr)
+		}
+	}
+}
+
+// Start watches the trades and liquidity events of the chain until the context is done
+func (w *WhaleWatcher) Start(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		topics := []string{pubsub.TopicTrades, pubsub.TopicLiquidity, pubsub.TopicBlocks}
+		sub := w.bus.Subscribe(w.svc.Chain(), topics)
+		defer func() { sub.Unsubscribe() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					util.Logger.Warningf("whale watcher evicted by the bus, chain=%s", w.svc.Chain())
+					sub = w.bus.Subscribe(w.svc.Chain(), topics)
+					continue
+				}
+				blockLog, isBlock := event.Data.(*model.BlockLog)
+				if !isBlock {
+					w.receive(event.Data, time.Now())
+					continue
+				}
+				if alerts := w.confirmed(blockLog.Height); len(alerts) > 0 {
+					w.notifier.Notify(ctx, alerts...)
+				}
+			}
+		}
+	}()
+}
+
+// Wait waits for the watch routine to stop
+func (w *WhaleWatcher) Wait() {
+	w.wg.Wait()
+}
+
+// receive keeps a swap or a mint or burn until its block is confirmed, the events of blocks older than the
+// max event age are dropped
+func (w *WhaleWatcher) receive(data interface{}, now time.Time) {
+	event := &pendingEvent{data: data}
+	switch ev := data.(type) {
+	case *model.TxEventLog:
+		event.height, event.blockHash, event.blockTime = ev.Height, ev.BlockHash, ev.BlockTime
+	case *model.LiquidityEventLog:
+		event.height, event.blockHash, event.blockTime = ev.Height, ev.BlockHash, ev.BlockTime
+	default:
+		return
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if !w.enabled || now.Unix()-event.blockTime > w.setting.MaxEventAge {
+		return
+	}
+	w.pending = append(w.pending, event)
+}
+
+// confirmed returns the alerts of the events confirmed at the indexed height, the events whose block is no
+// longer the block log at their height were reorged out and are dropped
+func (w *WhaleWatcher) confirmed(height int64) []*alert.Alert {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	alerts := make([]*alert.Alert, 0)
+	pending := w.pending[:0]
+	for _, event := range w.pending {
+		if event.height > height-w.svc.chainConfig.ConfirmNum {
+			pending = append(pending, event)
+			continue
+		}
+		blockHash, err := model.GetBlockHash(w.svc.statasDB, w.svc.Chain(), event.height)
+		if err != nil {
+			util.Logger.Errorf("get block hash error, chain=%s, height=%d, err=%s", w.svc.Chain(), event.height, err.Error())
+			pending = append(pending, event)
+			continue
+		}
+		if !strings.EqualFold(blockHash, event.blockHash) {
+			continue
+		}
+		alerts = append(alerts, w.check(event.data, time.Unix(event.blockTime, 0))...)
+	}
+	w.pending = pending
+	return alerts
+}
+
+// check returns the alerts of a swap or a mint or burn at its block time, it is valued by the prices of the
+// last refresh
+func (w *WhaleWatcher) check(data interface{}, blockTime time.Time) []*alert.Alert {
+	if !w.enabled {
+		return nil
+	}
+
+	var pair, txHash, action string
+	var amount0, amount1 float64
+	var addrs []string
+	switch ev := data.(type) {
+	case *model.TxEventLog:
+		pair, txHash, action = ev.ContractAddress, ev.TxHash, "swap"
+		amount0, amount1 = ev.Amount0, ev.Amount1
+		addrs = []string{ev.Sender, ev.To, ev.Origin}
+	case *model.LiquidityEventLog:
+		pair, txHash, action = ev.ContractAddress, ev.TxHash, "mint"
+		if ev.EventType == model.LiquidityEventBurn {
+			action = "burn"
+		}
+		amount0, amount1 = ev.Amount0, ev.Amount1
+		addrs = []string{ev.Sender, ev.To, ev.Origin}
+	default:
+		return nil
+	}
+
+	alerts := make([]*alert.Alert, 0)
+	valueUSD, symbols := 0.0, pair
+	if info, exist := w.svc.GetSwapPairInfo(ethcmm.HexToAddress(pair)); exist {
+		tokenPrice, _ := w.svc.GetPrice()
+		symbols = info.BaseSymbol + "/" + info.QuoteSymbol
+		if action == "swap" {
+			valueUSD = tradeVolumeUSD(info, amount0, amount1, tokenPrice)
+			if w.setting.TradeUSD > 0 && valueUSD >= w.setting.TradeUSD {
+				alerts = append(alerts, w.alertOf(RuleWhaleTrade, txHash, alert.SeverityInfo,
+					"swap of $%.2f on %s, tx=%s", valueUSD, symbols, txHash))
+			}
+		} else {
+			valueUSD = amount0*tokenPrice[info.BaseSymbol] + amount1*tokenPrice[info.QuoteSymbol]
+		}
+		if action == "burn" && w.setting.LiquidityRemoval > 0 {
+			reserve0, reserve1 := info.Reserves()
+			if share := reserveShare(amount0, amount1, reserve0, reserve1); share >= w.setting.LiquidityRemoval {
+				alerts = append(alerts, w.alertOf(RuleLiquidityRemoval, txHash, alert.SeverityWarning,
+					"burn of %.2f%% of the reserves of %s ($%.2f), tx=%s", share*100, symbols, valueUSD, txHash))
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.ToLower(addr)
+		if !w.flagged[addr] || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if w.track(addr, blockTime) {
+			alerts = append(alerts, w.alertOf(RuleFlaggedAddress, addr, alert.SeverityWarning,
+				"flagged address %s made %d trades or liquidity changes in %s, last %s of $%.2f on %s, tx=%s", addr,
+				w.setting.FlaggedRepeat, time.Duration(w.setting.FlaggedWindow)*time.Second, action, valueUSD, symbols, txHash))
+		}
+	}
+	return alerts
+}
+
+// track records an event of the flagged address at its block time, it returns true when the address repeats
+// FlaggedRepeat events in the window, its count is started again then
+func (w *WhaleWatcher) track(addr string, blockTime time.Time) bool {
+	window := time.Duration(w.setting.FlaggedWindow) * time.Second
+	recent := make([]time.Time, 0, len(w.activity[addr])+1)
+	for _, at := range w.activity[addr] {
+		if blockTime.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, blockTime)
+	if len(recent) >= w.setting.FlaggedRepeat {
+		delete(w.activity, addr)
+		return true
+	}
+	w.activity[addr] = recent
+	return false
+}
+
+func (w *WhaleWatcher) alertOf(rule, subject string, severity alert.Severity, format string, args ...interface{}) *alert.Alert {
+	return &alert.Alert{
+		Rule:     rule,
+		Chain:    w.svc.Chain(),
+		Subject:  subject,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// reserveShare returns the share of the reserves the amounts are, the reserves are those of the last
+// refresh. A burn takes both tokens in the same share, the larger one is taken as the reserves may lag.
+func reserveShare(amount0, amount1, reserve0, reserve1 float64) float64 {
+	share := 0.0
+	if reserve0 > 0 {
+		share = amount0 / reserve0
+	}
+	if reserve1 > 0 {
+		share = math.Max(share, amount1/reserve1)
+	}
+	return share
+}
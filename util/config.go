@@ -3,73 +3,627 @@ package util
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"strings"
+
+	ethcmm "github.com/ethereum/go-ethereum/common"
 
 	"github.com/pieswap/pie-statas/common"
 )
 
 type Config struct {
 	StatasDBConfig *DBConfig    `json:"statas_db_config"`
-	ChainConfig    *ChainConfig `json:"chain_config"`
+	ChainConfigs   ChainConfigs `json:"chain_config"`
 	LogConfig      *LogConfig   `json:"log_config"`
 	AlertConfig    *AlertConfig `json:"alert_config"`
 	ServerConfig   ServerConfig `json:"server_config"`
 }
 
-func (cfg *Config) Validate() {
-	cfg.StatasDBConfig.Validate()
-	cfg.ChainConfig.Validate()
-	cfg.LogConfig.Validate()
-	cfg.AlertConfig.Validate()
+// ValidationErrors are all the problems found in a config
+type ValidationErrors []string
+
+func (errs ValidationErrors) Error() string {
+	return strings.Join(errs, "; ")
+}
+
+func (errs *ValidationErrors) add(format string, args ...interface{}) {
+	*errs = append(*errs, fmt.Sprintf(format, args...))
+}
+
+// merge adds the problems of a nested config
+func (errs *ValidationErrors) merge(err error) {
+	if nested, ok := err.(ValidationErrors); ok {
+		*errs = append(*errs, nested...)
+	} else if err != nil {
+		*errs = append(*errs, err.Error())
+	}
+}
+
+func (errs ValidationErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate returns all the problems of the config as ValidationErrors, nil if it is valid
+func (cfg *Config) Validate() error {
+	var errs ValidationErrors
+	if cfg.StatasDBConfig == nil {
+		errs.add("statas_db_config should not be empty")
+	} else {
+		errs.merge(cfg.StatasDBConfig.Validate())
+	}
+	if len(cfg.ChainConfigs) == 0 {
+		errs.add("chain_config should not be empty")
+	}
+	names := make(map[string]bool, 0)
+	for _, chainConfig := range cfg.ChainConfigs {
+		if chainConfig == nil {
+			errs.add("chain_config should not contain null")
+			continue
+		}
+		errs.merge(chainConfig.Validate())
+		if names[chainConfig.Name] {
+			errs.add("duplicated chain name %s", chainConfig.Name)
+		}
+		names[chainConfig.Name] = true
+	}
+	if cfg.LogConfig == nil {
+		errs.add("log_config should not be empty")
+	} else {
+		errs.merge(cfg.LogConfig.Validate())
+	}
+	if cfg.AlertConfig == nil {
+		errs.add("alert_config should not be empty")
+	} else {
+		errs.merge(cfg.AlertConfig.Validate())
+	}
+	return errs.err()
 }
 
 type AlertConfig struct {
-	TelegramBotId  string `json:"telegram_bot_id"`
-	TelegramChatId string `json:"telegram_chat_id"`
+	// TelegramBotId and TelegramChatId add a telegram notifier, they were the only channel before notifiers
+	TelegramBotId  string `json:"telegram_bot_id" secret:"true"`
+	TelegramChatId string `json:"telegram_chat_id" secret:"true"`
 
+	// BlockUpdateTimeout is the seconds without a new block before the lag alert fires
 	BlockUpdateTimeout int64 `json:"block_update_timeout"`
+
+	Notifiers []*NotifierConfig `json:"notifiers"`
+	// Cooldown is the seconds a firing alert is not notified again, unless its severity rises
+	Cooldown int64 `json:"cooldown"`
+
+	// RPCFailureRate is the rate of failed provider calls in a check interval before the rpc alert fires
+	RPCFailureRate float64 `json:"rpc_failure_rate"`
+	// PriceDeviation is the change of a token price between two refreshes before the price alert fires
+	PriceDeviation float64 `json:"price_deviation"`
+	// TVLDrop is the drop of the TVL from its high of the last hour before the tvl alert fires
+	TVLDrop float64 `json:"tvl_drop"`
+	// ReorgDepth is the depth of a reorg, in blocks, from which the reorg alert fires
+	ReorgDepth int64 `json:"reorg_depth"`
+
+	// Whale notifies large trades, large liquidity removals and flagged addresses, it is off if absent
+	Whale *WhaleConfig `json:"whale"`
+}
+
+// WhaleConfig is which swaps, mints and burns are notified as they are indexed, a zero threshold is off
+type WhaleConfig struct {
+	// TradeUSD is the value of a swap from which it is notified
+	TradeUSD float64 `json:"trade_usd"`
+	// LiquidityRemoval is the share of the reserves of a pair a burn takes from which it is notified
+	LiquidityRemoval float64 `json:"liquidity_removal"`
+	// FlaggedAddresses are notified when they trade or move liquidity FlaggedRepeat times in FlaggedWindow seconds
+	FlaggedAddresses []string `json:"flagged_addresses"`
+	FlaggedRepeat    int      `json:"flagged_repeat"`
+	FlaggedWindow    int64    `json:"flagged_window"`
+	// MaxEventAge is the seconds of block age beyond which an event is not notified, the blocks of a catch up
+	// or a backfill are older
+	MaxEventAge int64 `json:"max_event_age"`
 }
 
-func (cfg *AlertConfig) Validate() {
+// WhaleSetting returns the whale alerts, the defaults are used for the flagged activity not configured
+func (cfg *AlertConfig) WhaleSetting() (WhaleConfig, bool) {
+	if cfg.Whale == nil {
+		return WhaleConfig{}, false
+	}
+	setting := *cfg.Whale
+	if setting.FlaggedRepeat == 0 {
+		setting.FlaggedRepeat = common.DefaultWhaleFlaggedRepeat
+	}
+	if setting.FlaggedWindow == 0 {
+		setting.FlaggedWindow = int64(common.DefaultWhaleFlaggedWindow.Seconds())
+	}
+	if setting.MaxEventAge == 0 {
+		setting.MaxEventAge = int64(common.DefaultWhaleMaxEventAge.Seconds())
+	}
+	return setting, true
+}
+
+func (cfg *WhaleConfig) Validate() error {
+	var errs ValidationErrors
+	if cfg.TradeUSD < 0 || cfg.FlaggedRepeat < 0 || cfg.FlaggedWindow < 0 || cfg.MaxEventAge < 0 {
+		errs.add("trade_usd, flagged_repeat, flagged_window and max_event_age of whale should not be negative")
+	}
+	if cfg.LiquidityRemoval < 0 || cfg.LiquidityRemoval > 1 {
+		errs.add("liquidity_removal of whale should be in [0, 1]")
+	}
+	for _, addr := range cfg.FlaggedAddresses {
+		if !ethcmm.IsHexAddress(addr) {
+			errs.add("flagged address %s of whale is not an address", addr)
+		}
+	}
+	return errs.err()
+}
+
+func (cfg *AlertConfig) Validate() error {
+	var errs ValidationErrors
 	if cfg.BlockUpdateTimeout <= 0 {
-		panic(fmt.Sprintf("block_update_timeout should be larger than 0"))
+		errs.add("block_update_timeout should be larger than 0")
+	}
+	if cfg.Cooldown < 0 || cfg.ReorgDepth < 0 {
+		errs.add("cooldown and reorg_depth of alert_config should not be negative")
 	}
+	if cfg.RPCFailureRate < 0 || cfg.RPCFailureRate > 1 || cfg.PriceDeviation < 0 || cfg.TVLDrop < 0 || cfg.TVLDrop > 1 {
+		errs.add("rpc_failure_rate and tvl_drop of alert_config should be in [0, 1], price_deviation should not be negative")
+	}
+	if cfg.Whale != nil {
+		if err := cfg.Whale.Validate(); err != nil {
+			errs.add("alert_config: %s", err.Error())
+		}
+	}
+	for idx, notifier := range cfg.Notifiers {
+		if notifier == nil {
+			errs.add("notifiers of alert_config should not contain null")
+			continue
+		}
+		if err := notifier.Validate(); err != nil {
+			errs.add("notifier %d of alert_config: %s", idx, err.Error())
+		}
+	}
+	return errs.err()
+}
+
+// NotifierConfig is an alert channel, the fields used depend on its type
+type NotifierConfig struct {
+	// Type is one of telegram, webhook, slack and email
+	Type string `json:"type"`
+	// MinSeverity is the least severity notified, one of info, warning and critical, all if empty
+	MinSeverity string `json:"min_severity"`
+
+	// URL is the endpoint of webhook and slack
+	URL string `json:"url" secret:"true"`
+
+	BotId  string `json:"bot_id" secret:"true"`
+	ChatId string `json:"chat_id" secret:"true"`
+
+	// SMTPAddr is the host:port of the smtp server of email, which authenticates with plain auth
+	SMTPAddr string   `json:"smtp_addr"`
+	Username string   `json:"username"`
+	Password string   `json:"password" secret:"true"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func (cfg *NotifierConfig) Validate() error {
+	var errs ValidationErrors
+	switch cfg.Type {
+	case common.AlertNotifierTelegram:
+		if cfg.BotId == "" || cfg.ChatId == "" {
+			errs.add("bot_id and chat_id of telegram should not be empty")
+		}
+	case common.AlertNotifierWebhook, common.AlertNotifierSlack:
+		if cfg.URL == "" {
+			errs.add("url of %s should not be empty", cfg.Type)
+		}
+	case common.AlertNotifierEmail:
+		if cfg.SMTPAddr == "" || cfg.From == "" || len(cfg.To) == 0 {
+			errs.add("smtp_addr, from and to of email should not be empty")
+		}
+	default:
+		errs.add("unknown notifier type %s", cfg.Type)
+	}
+	switch cfg.MinSeverity {
+	case "", "info", "warning", "critical":
+	default:
+		errs.add("unknown min_severity %s", cfg.MinSeverity)
+	}
+	return errs.err()
 }
 
 type DBConfig struct {
 	Dialect string `json:"dialect"`
-	DBPath  string `json:"db_path"`
+	DBPath  string `json:"db_path" secret:"true"`
 }
 
-func (cfg *DBConfig) Validate() {
+func (cfg *DBConfig) Validate() error {
+	var errs ValidationErrors
 	if cfg.Dialect != common.DBDialectMysql && cfg.Dialect != common.DBDialectSqlite3 {
-		panic(fmt.Sprintf("only %s and %s supported", common.DBDialectMysql, common.DBDialectSqlite3))
+		errs.add("only %s and %s supported", common.DBDialectMysql, common.DBDialectSqlite3)
 	}
 	if cfg.DBPath == "" {
-		panic("db path should not be empty")
+		errs.add("db path should not be empty")
 	}
+	return errs.err()
 }
 
 type ChainConfig struct {
-	BSCStartHeight    int64    `json:"bsc_start_height"`
-	BSCProvider       string   `json:"bsc_provider"`
-	BSCConfirmNum     int64    `json:"bsc_confirm_num"`
-	BSCFetchInterval  int64    `json:"bsc_fetch_interval"`
-	SwapFactory       string   `json:"swap_factory"`
+	// Name identifies the chain, every indexed row is tagged with it
+	Name          string   `json:"name"`
+	StartHeight   int64    `json:"start_height"`
+	Provider      string   `json:"provider" secret:"true"`
+	ConfirmNum    int64    `json:"confirm_num"`
+	FetchInterval int64    `json:"fetch_interval"`
+	SwapFactories []string `json:"swap_factories"`
+
+	// CertificatedPairs trust the tokens of the pairs like AllowTokens, kept for the configs before them
 	CertificatedPairs []string `json:"certificated_pairs"`
 	SynupPools        []string `json:"synup_pools"`
+
+	// TokenLists are the paths of the token list files verifying tokens, ChainId selects the tokens
+	// of the chain in them, all are read if it is 0
+	TokenLists []string `json:"token_lists"`
+	ChainId    int64    `json:"chain_id"`
+	// AllowTokens and the verified tokens own their symbols, the pairs of other tokens with the same
+	// symbols are not listed, nor the pairs of DenyTokens
+	AllowTokens []string `json:"allow_tokens"`
+	DenyTokens  []string `json:"deny_tokens"`
+	// DefaultDecimals are the decimals of the tokens whose decimals can not be read, 18 if it is 0
+	DefaultDecimals int `json:"default_decimals"`
+	// SupplyExcluded are the holders whose balances are not circulating, such as the treasury, burn and
+	// vesting addresses, keyed by token address, the holders of "*" are excluded for every token
+	SupplyExcluded map[string][]string `json:"supply_excluded"`
+	// HolderTokens are the tokens whose Transfer events are indexed into holder balances, they must be
+	// indexed from before their first transfer, a later start_height leaves the balances partial
+	HolderTokens []string `json:"holder_tokens"`
+
+	// StableTokens are priced at 1 USD, BaseTokens price the tokens paired with them in order,
+	// the first base token is priced by its pair with the first stable token
+	StableTokens []string `json:"stable_tokens"`
+	BaseTokens   []string `json:"base_tokens"`
+	// ProjectToken prices the tokens which are only paired with it
+	ProjectToken string `json:"project_token"`
+	// SyrupToken is the token staked in the syrup pools, priced by SyrupTokenSymbol
+	SyrupToken       string `json:"syrup_token"`
+	SyrupTokenSymbol string `json:"syrup_token_symbol"`
+
+	// FactoryFees is the fee setting of each factory, keyed by factory address
+	FactoryFees map[string]*FeeConfig `json:"factory_fees"`
+	// FactoryProtocols is the protocol adapter of each factory, keyed by factory address
+	FactoryProtocols map[string]string `json:"factory_protocols"`
+
+	// Retry is the retry policy of the calls to the provider
+	Retry *RetryConfig `json:"retry"`
+	// PriceGuard is the sanity check of the token prices
+	PriceGuard *PriceGuardConfig `json:"price_guard"`
+	// Wash is when swaps are classified as wash trading
+	Wash *WashConfig `json:"wash"`
 }
 
-func (cfg *ChainConfig) Validate() {
-	if cfg.BSCStartHeight < 0 {
-		panic("bsc_start_height should not be less than 0")
+// legacyChainConfig holds the keys of the single chain config before multi chain support
+type legacyChainConfig struct {
+	BSCStartHeight   int64  `json:"bsc_start_height"`
+	BSCProvider      string `json:"bsc_provider"`
+	BSCConfirmNum    int64  `json:"bsc_confirm_num"`
+	BSCFetchInterval int64  `json:"bsc_fetch_interval"`
+	SwapFactory      string `json:"swap_factory"`
+}
+
+// UnmarshalJSON fills the chain config from the legacy bsc_* keys when the new keys are absent
+func (cfg *ChainConfig) UnmarshalJSON(data []byte) error {
+	type plain ChainConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+	var legacy legacyChainConfig
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
 	}
-	if cfg.BSCProvider == "" {
-		panic("bsc_provider should not be empty")
+	if cfg.Name == "" && legacy.BSCProvider != "" {
+		cfg.Name = common.DefaultChainName
 	}
-	if cfg.BSCConfirmNum <= 0 {
-		panic("bsc_confirm_num should be larger than 0")
+	if cfg.StartHeight == 0 {
+		cfg.StartHeight = legacy.BSCStartHeight
 	}
+	if cfg.Provider == "" {
+		cfg.Provider = legacy.BSCProvider
+	}
+	if cfg.ConfirmNum == 0 {
+		cfg.ConfirmNum = legacy.BSCConfirmNum
+	}
+	if cfg.FetchInterval == 0 {
+		cfg.FetchInterval = legacy.BSCFetchInterval
+	}
+	if len(cfg.SwapFactories) == 0 && legacy.SwapFactory != "" {
+		cfg.SwapFactories = []string{legacy.SwapFactory}
+	}
+	return nil
+}
+
+// PricingAnchors are the tokens the prices of a chain are derived from
+type PricingAnchors struct {
+	StableTokens     []string
+	BaseTokens       []string
+	ProjectToken     string
+	SyrupToken       string
+	SyrupTokenSymbol string
+}
+
+// PricingAnchors returns the pricing anchors of the chain, the ones of the original bsc deployment
+// are used for those not configured
+func (cfg *ChainConfig) PricingAnchors() PricingAnchors {
+	anchors := PricingAnchors{
+		StableTokens:     cfg.StableTokens,
+		BaseTokens:       cfg.BaseTokens,
+		ProjectToken:     cfg.ProjectToken,
+		SyrupToken:       cfg.SyrupToken,
+		SyrupTokenSymbol: cfg.SyrupTokenSymbol,
+	}
+	if len(anchors.StableTokens) == 0 {
+		anchors.StableTokens = common.DefaultStableTokens
+	}
+	if len(anchors.BaseTokens) == 0 {
+		anchors.BaseTokens = common.DefaultBaseTokens
+	}
+	if anchors.ProjectToken == "" {
+		anchors.ProjectToken = common.DefaultProjectToken
+	}
+	if anchors.SyrupToken == "" {
+		anchors.SyrupToken = common.DefaultSyrupToken
+	}
+	if anchors.SyrupTokenSymbol == "" {
+		anchors.SyrupTokenSymbol = common.DefaultSyrupTokenSymbol
+	}
+	return anchors
+}
+
+// RetryConfig is the retry policy of the calls to a provider, the backoff doubles from the initial
+// backoff up to the max backoff between attempts, with a random jitter
+type RetryConfig struct {
+	// MaxAttempts counts the first call as well
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoff and MaxBackoff are in milliseconds
+	InitialBackoff int64 `json:"initial_backoff"`
+	MaxBackoff     int64 `json:"max_backoff"`
+}
+
+// PriceGuardConfig is when a token price is suspicious, the deviations are fractions of the reference
+type PriceGuardConfig struct {
+	// MaxJump is against the last good price
+	MaxJump float64 `json:"max_jump"`
+	// MaxTwapDeviation is against the time weighted average of the good prices in TwapWindow seconds
+	MaxTwapDeviation float64 `json:"max_twap_deviation"`
+	TwapWindow       int64   `json:"twap_window"`
+	// MaxCrossDeviation is against the reserve weighted median of the prices implied by the pairs of the token
+	MaxCrossDeviation float64 `json:"max_cross_deviation"`
+	// ConfirmRefreshes is how many refreshes in a row a suspicious price persists before it is accepted
+	ConfirmRefreshes int `json:"confirm_refreshes"`
+}
+
+// PriceGuardSetting returns the price guard of the chain, the defaults are used for those not configured
+func (cfg *ChainConfig) PriceGuardSetting() PriceGuardConfig {
+	setting := PriceGuardConfig{}
+	if cfg.PriceGuard != nil {
+		setting = *cfg.PriceGuard
+	}
+	if setting.MaxJump == 0 {
+		setting.MaxJump = common.DefaultPriceMaxJump
+	}
+	if setting.MaxTwapDeviation == 0 {
+		setting.MaxTwapDeviation = common.DefaultPriceMaxTwapDeviation
+	}
+	if setting.TwapWindow == 0 {
+		setting.TwapWindow = int64(common.DefaultPriceTwapWindow.Seconds())
+	}
+	if setting.MaxCrossDeviation == 0 {
+		setting.MaxCrossDeviation = common.DefaultPriceMaxCrossDeviation
+	}
+	if setting.ConfirmRefreshes == 0 {
+		setting.ConfirmRefreshes = common.DefaultPriceConfirmRefreshes
+	}
+	return setting
+}
+
+// WashConfig is when the 24h swaps are classified as wash trading
+type WashConfig struct {
+	// RoundTripBlocks is within how many blocks a trader swapping a pair back the other way is a round trip
+	RoundTripBlocks int64 `json:"round_trip_blocks"`
+	// MaxVolumeToLiquidity is the 24h volume of a pair against its reserves beyond which the volume is wash
+	MaxVolumeToLiquidity float64 `json:"max_volume_to_liquidity"`
+}
+
+// WashSetting returns the wash classification of the chain, the defaults are used for those not configured
+func (cfg *ChainConfig) WashSetting() WashConfig {
+	setting := WashConfig{}
+	if cfg.Wash != nil {
+		setting = *cfg.Wash
+	}
+	if setting.RoundTripBlocks == 0 {
+		setting.RoundTripBlocks = common.DefaultWashRoundTripBlocks
+	}
+	if setting.MaxVolumeToLiquidity == 0 {
+		setting.MaxVolumeToLiquidity = common.DefaultWashMaxVolumeToLiquidity
+	}
+	return setting
+}
+
+// TokenDecimals returns the decimals of the tokens whose decimals can not be read
+func (cfg *ChainConfig) TokenDecimals() uint8 {
+	if cfg.DefaultDecimals == 0 {
+		return common.DefaultTokenDecimals
+	}
+	return uint8(cfg.DefaultDecimals)
+}
+
+// RetrySetting returns the retry policy of the chain, the defaults are used for those not configured
+func (cfg *ChainConfig) RetrySetting() RetryConfig {
+	setting := RetryConfig{}
+	if cfg.Retry != nil {
+		setting = *cfg.Retry
+	}
+	if setting.MaxAttempts == 0 {
+		setting.MaxAttempts = common.DefaultRetryMaxAttempts
+	}
+	if setting.InitialBackoff == 0 {
+		setting.InitialBackoff = common.DefaultRetryInitialBackoff.Milliseconds()
+	}
+	if setting.MaxBackoff == 0 {
+		setting.MaxBackoff = common.DefaultRetryMaxBackoff.Milliseconds()
+	}
+	return setting
+}
+
+// ChainConfigs is the list of indexed chains, the first one is the default chain of the api
+type ChainConfigs []*ChainConfig
+
+// UnmarshalJSON also accepts a single chain object, which was the format before multi chain support
+func (cfgs *ChainConfigs) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var cfg ChainConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return err
+		}
+		*cfgs = ChainConfigs{&cfg}
+		return nil
+	}
+	var list []*ChainConfig
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*cfgs = list
+	return nil
+}
+
+// Get returns the config of the named chain, nil if it is not configured
+func (cfgs ChainConfigs) Get(name string) *ChainConfig {
+	for _, cfg := range cfgs {
+		if cfg.Name == name {
+			return cfg
+		}
+	}
+	return nil
+}
+
+type FeeConfig struct {
+	// SwapFeeRate is the fee charged on the input amount of a swap
+	SwapFeeRate float64 `json:"swap_fee_rate"`
+	// ProtocolFeeShare is the part of the swap fee minted to feeTo when the protocol fee is on
+	ProtocolFeeShare float64 `json:"protocol_fee_share"`
+}
+
+func (cfg *FeeConfig) Validate() error {
+	var errs ValidationErrors
+	if cfg.SwapFeeRate < 0 || cfg.SwapFeeRate >= 1 {
+		errs.add("swap_fee_rate should be in [0, 1)")
+	}
+	if cfg.ProtocolFeeShare < 0 || cfg.ProtocolFeeShare > 1 {
+		errs.add("protocol_fee_share should be in [0, 1]")
+	}
+	return errs.err()
+}
+
+// FeeConfigOf returns the fee setting of the factory, the uniswap v2 setting if it is not configured
+func (cfg *ChainConfig) FeeConfigOf(factory string) FeeConfig {
+	for addr, feeConfig := range cfg.FactoryFees {
+		if strings.EqualFold(addr, factory) && feeConfig != nil {
+			return *feeConfig
+		}
+	}
+	return FeeConfig{
+		SwapFeeRate:      common.DefaultSwapFeeRate,
+		ProtocolFeeShare: common.DefaultProtocolFeeShare,
+	}
+}
+
+// protocols are the names of the protocol adapters registered by the executor
+var protocols = map[string]bool{common.DefaultProtocol: true}
+
+// RegisterProtocolName registers the name of a protocol adapter, so factory_protocols can select it
+func RegisterProtocolName(name string) {
+	protocols[strings.ToLower(name)] = true
+}
+
+// ProtocolOf returns the protocol adapter of the factory, uniswap v2 if it is not configured
+func (cfg *ChainConfig) ProtocolOf(factory string) string {
+	for addr, protocol := range cfg.FactoryProtocols {
+		if strings.EqualFold(addr, factory) && protocol != "" {
+			return protocol
+		}
+	}
+	return common.DefaultProtocol
+}
+
+func (cfg *ChainConfig) Validate() error {
+	var errs ValidationErrors
+	if cfg.Name == "" {
+		errs.add("chain name should not be empty")
+	}
+	if strings.EqualFold(cfg.Name, common.AllChains) {
+		errs.add("chain name %s is reserved", common.AllChains)
+	}
+	if cfg.StartHeight < 0 {
+		errs.add("start_height of chain %s should not be less than 0", cfg.Name)
+	}
+	if cfg.Provider == "" {
+		errs.add("provider of chain %s should not be empty", cfg.Name)
+	}
+	if cfg.ConfirmNum <= 0 {
+		errs.add("confirm_num of chain %s should be larger than 0", cfg.Name)
+	}
+	if len(cfg.SwapFactories) == 0 {
+		errs.add("swap_factories of chain %s should not be empty", cfg.Name)
+	}
+	for factory, feeConfig := range cfg.FactoryFees {
+		if feeConfig != nil {
+			if err := feeConfig.Validate(); err != nil {
+				errs.add("factory_fees of %s of chain %s: %s", factory, cfg.Name, err.Error())
+			}
+		}
+	}
+	for factory, protocol := range cfg.FactoryProtocols {
+		if protocol != "" && !protocols[strings.ToLower(protocol)] {
+			errs.add("factory_protocols of %s of chain %s: unknown protocol %s", factory, cfg.Name, protocol)
+		}
+	}
+	if cfg.Retry != nil {
+		if cfg.Retry.MaxAttempts < 0 || cfg.Retry.InitialBackoff < 0 || cfg.Retry.MaxBackoff < 0 {
+			errs.add("retry of chain %s should not be negative", cfg.Name)
+		} else if setting := cfg.RetrySetting(); setting.InitialBackoff > setting.MaxBackoff {
+			errs.add("retry initial_backoff of chain %s should not be larger than max_backoff", cfg.Name)
+		}
+	}
+	for _, addr := range append(append([]string{}, cfg.AllowTokens...), cfg.DenyTokens...) {
+		if !ethcmm.IsHexAddress(addr) {
+			errs.add("allow_tokens and deny_tokens of chain %s should be addresses, got %s", cfg.Name, addr)
+		}
+	}
+	for _, token := range cfg.HolderTokens {
+		if !ethcmm.IsHexAddress(token) {
+			errs.add("holder_tokens of chain %s should be addresses, got %s", cfg.Name, token)
+		}
+	}
+	for token, holders := range cfg.SupplyExcluded {
+		if token != common.SupplyExcludedAll && !ethcmm.IsHexAddress(token) {
+			errs.add("supply_excluded of chain %s should be keyed by token addresses or %s, got %s", cfg.Name, common.SupplyExcludedAll, token)
+		}
+		for _, holder := range holders {
+			if !ethcmm.IsHexAddress(holder) {
+				errs.add("supply_excluded of chain %s should list addresses, got %s", cfg.Name, holder)
+			}
+		}
+	}
+	if cfg.DefaultDecimals < 0 || cfg.DefaultDecimals > 255 {
+		errs.add("default_decimals of chain %s should be in [0, 255]", cfg.Name)
+	}
+	if guard := cfg.PriceGuard; guard != nil {
+		if guard.MaxJump < 0 || guard.MaxTwapDeviation < 0 || guard.TwapWindow < 0 || guard.MaxCrossDeviation < 0 || guard.ConfirmRefreshes < 0 {
+			errs.add("price_guard of chain %s should not be negative", cfg.Name)
+		}
+	}
+	if wash := cfg.Wash; wash != nil && (wash.RoundTripBlocks < 0 || wash.MaxVolumeToLiquidity < 0) {
+		errs.add("wash of chain %s should not be negative", cfg.Name)
+	}
+	return errs.err()
 }
 
 type LogConfig struct {
@@ -83,40 +637,44 @@ type LogConfig struct {
 	Compress                     bool   `json:"compress"`
 }
 
-func (cfg *LogConfig) Validate() {
+func (cfg *LogConfig) Validate() error {
+	var errs ValidationErrors
+	if _, exist := levels[cfg.Level]; !exist && cfg.Level != "" {
+		errs.add("unknown log level %s", cfg.Level)
+	}
 	if cfg.UseFileLogger {
 		if cfg.Filename == "" {
-			panic("filename should not be empty if use file logger")
+			errs.add("filename should not be empty if use file logger")
 		}
 		if cfg.MaxFileSizeInMB <= 0 {
-			panic("max_file_size_in_mb should be larger than 0 if use file logger")
+			errs.add("max_file_size_in_mb should be larger than 0 if use file logger")
 		}
 		if cfg.MaxBackupsOfLogFiles <= 0 {
-			panic("max_backups_off_log_files should be larger than 0 if use file logger")
+			errs.add("max_backups_off_log_files should be larger than 0 if use file logger")
 		}
 	}
+	return errs.err()
 }
 
 type ServerConfig struct {
 	ListenAddr string `json:"listen_addr"`
-}
 
-func ParseConfigFromFile(filePath string) *Config {
-	bz, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		panic(err)
-	}
+	// ReadyMaxBlockLag is the max distance between chain head and the indexed height before /readyz fails
+	ReadyMaxBlockLag int64 `json:"ready_max_block_lag"`
+	// ReadyRefreshIntervals is how many RefreshInterval the pair info may be stale before /readyz fails
+	ReadyRefreshIntervals int64 `json:"ready_refresh_intervals"`
 
-	var config Config
-	if err := json.Unmarshal(bz, &config); err != nil {
-		panic(err)
-	}
-	return &config
-}
+	// StreamListenAddr enables the event stream endpoint when not empty
+	StreamListenAddr string `json:"stream_listen_addr"`
+	// StreamBufferSize is the number of events buffered for each stream subscriber
+	StreamBufferSize int `json:"stream_buffer_size"`
+	// StreamMaxDropped is the number of consecutive dropped events after which a slow subscriber is disconnected
+	StreamMaxDropped int `json:"stream_max_dropped"`
 
-func ParseConfigFromJson(content string, config *Config) *Config {
-	if err := json.Unmarshal([]byte(content), &config); err != nil {
-		panic(err)
-	}
-	return config
+	// GraphqlMaxComplexity is the max number of rows a single graphql query may request
+	GraphqlMaxComplexity int `json:"graphql_max_complexity"`
+	GraphqlMaxDepth      int `json:"graphql_max_depth"`
+
+	// ShutdownTimeout is the seconds a shutdown waits for the routines to stop and the requests to drain
+	ShutdownTimeout int64 `json:"shutdown_timeout"`
 }
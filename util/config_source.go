@@ -0,0 +1,276 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the config
+const EnvPrefix = "STATAS_"
+
+const redactedValue = "***"
+
+// ConfigSources are the sources of the config, each one overrides the ones before it in the order
+// of the fields
+type ConfigSources struct {
+	// FilePath is the json config file
+	FilePath string
+	// AwsSecretKey and AwsRegion locate a json config in the aws secrets manager, it is merged over
+	// the file
+	AwsSecretKey string
+	AwsRegion    string
+	// Environ is the environment as of os.Environ, the variables starting with EnvPrefix override
+	// the fields they name, for example STATAS_CHAIN_CONFIG_BSC_PROVIDER
+	Environ []string
+	// Overrides are the key=value flags, the keys name the fields like the environment variables
+	// without the prefix, in upper or lower case and with dots or underscores
+	Overrides []string
+}
+
+// LoadConfig reads and layers the config from the sources, it is not validated
+func LoadConfig(sources ConfigSources) (*Config, error) {
+	if sources.FilePath == "" {
+		return nil, fmt.Errorf("config path should not be empty")
+	}
+	bz, err := ioutil.ReadFile(sources.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(bz, &config); err != nil {
+		return nil, fmt.Errorf("parse config file error, err=%s", err.Error())
+	}
+
+	if sources.AwsSecretKey != "" && sources.AwsRegion != "" {
+		content, err := GetSecret(sources.AwsSecretKey, sources.AwsRegion)
+		if err != nil {
+			return nil, fmt.Errorf("get aws config error, err=%s", err.Error())
+		}
+		if err := json.Unmarshal([]byte(content), &config); err != nil {
+			return nil, fmt.Errorf("parse aws config error, err=%s", err.Error())
+		}
+	}
+
+	envOverrides := make([]configOverride, 0)
+	for _, env := range sources.Environ {
+		if !strings.HasPrefix(env, EnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		envOverrides = append(envOverrides, configOverride{source: kv[0], key: strings.TrimPrefix(kv[0], EnvPrefix), value: kv[1]})
+	}
+	// the environment is shared with other tools, a variable naming no field is only warned about
+	if err := applyOverrides(&config, envOverrides, false); err != nil {
+		return nil, err
+	}
+
+	flagOverrides := make([]configOverride, 0, len(sources.Overrides))
+	for _, flag := range sources.Overrides {
+		kv := strings.SplitN(flag, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("config override %s should be key=value", flag)
+		}
+		flagOverrides = append(flagOverrides, configOverride{source: kv[0], key: overrideKey(kv[0]), value: kv[1]})
+	}
+	if err := applyOverrides(&config, flagOverrides, true); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+type configOverride struct {
+	// source is the variable or flag key as given, for the errors
+	source string
+	key    string
+	value  string
+}
+
+// overrideKey returns the key in the form of the environment variables, the chain names in the key
+// are normalized the same way
+func overrideKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// applyOverrides sets the fields named by the overrides. When strict, a key naming no field is an error so a
+// typo does not silently fall back to the file, otherwise it is logged and ignored.
+func applyOverrides(config *Config, overrides []configOverride, strict bool) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(overrides))
+	for _, override := range overrides {
+		values[override.key] = override.value
+	}
+	used := make(map[string]bool, len(overrides))
+	var errs ValidationErrors
+	setOverrides(reflect.ValueOf(config).Elem(), "", values, used, &errs)
+	for _, override := range overrides {
+		if used[override.key] {
+			continue
+		}
+		if strict {
+			errs.add("config override %s names no field", override.source)
+		} else {
+			Logger.Warningf("config override %s names no field, ignored", override.source)
+		}
+	}
+	return errs.err()
+}
+
+// setOverrides walks the fields of the struct by their json keys. A key naming a struct, a map or a
+// list sets it as json, a list of strings is also accepted comma separated. The chains are named by
+// their names instead of their indexes.
+func setOverrides(v reflect.Value, prefix string, values map[string]string, used map[string]bool, errs *ValidationErrors) {
+	t := v.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + overrideKey(tag)
+		setOverride(v.Field(idx), key, values, used, errs)
+	}
+}
+
+func setOverride(v reflect.Value, key string, values map[string]string, used map[string]bool, errs *ValidationErrors) {
+	if value, exist := values[key]; exist {
+		used[key] = true
+		if err := setValue(v, value); err != nil {
+			errs.add("config override %s error, err=%s", key, err.Error())
+		}
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		setOverrides(v, key+"_", values, used, errs)
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct:
+		// a nil config is only allocated when one of its fields is overridden
+		elem := v
+		if v.IsNil() {
+			elem = reflect.New(v.Type().Elem())
+		}
+		before := len(used)
+		setOverrides(elem.Elem(), key+"_", values, used, errs)
+		if v.IsNil() && len(used) > before {
+			v.Set(elem)
+		}
+	case v.Type() == reflect.TypeOf(ChainConfigs{}):
+		for _, chainConfig := range v.Interface().(ChainConfigs) {
+			if chainConfig != nil {
+				setOverrides(reflect.ValueOf(chainConfig).Elem(), key+"_"+overrideKey(chainConfig.Name)+"_", values, used, errs)
+			}
+		}
+	}
+}
+
+// setValue parses the value into the field by its kind
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			items := make([]string, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			v.Set(reflect.ValueOf(items).Convert(v.Type()))
+			return nil
+		}
+		return unmarshalValue(v, value)
+	default:
+		return unmarshalValue(v, value)
+	}
+	return nil
+}
+
+func unmarshalValue(v reflect.Value, value string) error {
+	ptr := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// Redacted returns a copy of the config with the fields tagged secret replaced
+func (cfg *Config) Redacted() *Config {
+	bz, err := json.Marshal(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("marshal config error, err=%s", err.Error()))
+	}
+	var redacted Config
+	if err := json.Unmarshal(bz, &redacted); err != nil {
+		panic(fmt.Sprintf("unmarshal config error, err=%s", err.Error()))
+	}
+	redact(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			redact(v.Elem())
+		}
+	case reflect.Slice:
+		for idx := 0; idx < v.Len(); idx++ {
+			redact(v.Index(idx))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := v.Field(idx)
+			if t.Field(idx).Tag.Get("secret") == "true" && field.Kind() == reflect.String {
+				if field.String() != "" {
+					field.SetString(redactedValue)
+				}
+				continue
+			}
+			redact(field)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pieswap/pie-statas/common"
+)
+
+const testConfig = `{
+  "statas_db_config": {"dialect": "sqlite3", "db_path": "root:secret@/statas"},
+  "chain_config": [
+    {"name": "bsc", "provider": "wss://bsc", "confirm_num": 5, "swap_factories": ["0x1"]},
+    {"name": "okex-chain", "provider": "wss://okex", "confirm_num": 1, "swap_factories": ["0x2"]}
+  ],
+  "log_config": {"level": "INFO"},
+  "alert_config": {"telegram_bot_id": "bot", "block_update_timeout": 60},
+  "server_config": {"listen_addr": ":8080"}
+}`
+
+func writeTestConfig(t *testing.T) string {
+	return writeConfig(t, testConfig)
+}
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path := writeTestConfig(t)
+	config, err := LoadConfig(ConfigSources{
+		FilePath: path,
+		Environ: []string{
+			"HOME=/root",
+			"STATAS_CHAIN_CONFIG_BSC_PROVIDER=wss://env",
+			"STATAS_CHAIN_CONFIG_OKEX_CHAIN_CONFIRM_NUM=3",
+			"STATAS_CHAIN_CONFIG_BSC_STABLE_TOKENS=BUSD, USDT",
+			"STATAS_SERVER_CONFIG_LISTEN_ADDR=:9090",
+			"STATAS_CHAIN_CONFIG_BSC_RETRY_MAX_ATTEMPTS=7",
+		},
+		// the flags override the environment
+		Overrides: []string{"server_config.listen_addr=:7070", "chain_config.bsc.factory_fees={\"0x1\":{\"swap_fee_rate\":0.002}}"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "wss://env", config.ChainConfigs.Get("bsc").Provider)
+	require.Equal(t, []string{"BUSD", "USDT"}, config.ChainConfigs.Get("bsc").StableTokens)
+	require.Equal(t, 7, config.ChainConfigs.Get("bsc").Retry.MaxAttempts)
+	require.Equal(t, 0.002, config.ChainConfigs.Get("bsc").FeeConfigOf("0x1").SwapFeeRate)
+	require.Equal(t, int64(3), config.ChainConfigs.Get("okex-chain").ConfirmNum)
+	require.Equal(t, ":7070", config.ServerConfig.ListenAddr)
+	require.NoError(t, config.Validate())
+
+	// a variable of the environment naming no field is ignored, a flag fails
+	_, err = LoadConfig(ConfigSources{FilePath: path, Environ: []string{"STATAS_CHAIN_CONFIG_ETH_PROVIDER=wss://eth"}})
+	require.NoError(t, err)
+	_, err = LoadConfig(ConfigSources{FilePath: path, Overrides: []string{"chain_config.eth.provider=wss://eth"}})
+	require.EqualError(t, err, "config override chain_config.eth.provider names no field")
+	_, err = LoadConfig(ConfigSources{FilePath: path, Overrides: []string{"chain_config.bsc.confirm_num=many"}})
+	require.Error(t, err)
+}
+
+func TestLegacyChainConfig(t *testing.T) {
+	// the single chain object of the config before multi chain support, with the bsc_* keys
+	config, err := LoadConfig(ConfigSources{FilePath: writeConfig(t, `{
+  "statas_db_config": {"dialect": "sqlite3", "db_path": "statas.db"},
+  "chain_config": {"bsc_provider": "wss://bsc", "bsc_start_height": 100, "bsc_confirm_num": 5, "bsc_fetch_interval": 3, "swap_factory": "0x1"},
+  "log_config": {"level": "INFO"},
+  "alert_config": {"block_update_timeout": 60}
+}`)})
+	require.NoError(t, err)
+	require.Len(t, config.ChainConfigs, 1)
+	chainConfig := config.ChainConfigs[0]
+	require.Equal(t, common.DefaultChainName, chainConfig.Name)
+	require.Equal(t, "wss://bsc", chainConfig.Provider)
+	require.Equal(t, int64(100), chainConfig.StartHeight)
+	require.Equal(t, int64(5), chainConfig.ConfirmNum)
+	require.Equal(t, int64(3), chainConfig.FetchInterval)
+	require.Equal(t, []string{"0x1"}, chainConfig.SwapFactories)
+	require.NoError(t, config.Validate())
+
+	// the new keys win over the legacy ones
+	config, err = LoadConfig(ConfigSources{FilePath: writeConfig(t, `{
+  "chain_config": [{"name": "okex-chain", "provider": "wss://okex", "bsc_provider": "wss://bsc", "swap_factories": ["0x2"], "swap_factory": "0x1"}]
+}`)})
+	require.NoError(t, err)
+	require.Equal(t, "okex-chain", config.ChainConfigs[0].Name)
+	require.Equal(t, "wss://okex", config.ChainConfigs[0].Provider)
+	require.Equal(t, []string{"0x2"}, config.ChainConfigs[0].SwapFactories)
+}
+
+func TestValidateAggregatesErrors(t *testing.T) {
+	config, err := LoadConfig(ConfigSources{
+		FilePath: writeTestConfig(t),
+		Overrides: []string{"statas_db_config.dialect=postgres", "chain_config.bsc.confirm_num=0", "alert_config.block_update_timeout=0",
+			"chain_config.bsc.factory_protocols={\"0x1\":\"uniswap_v9\"}"},
+	})
+	require.NoError(t, err)
+	err = config.Validate()
+	require.Equal(t, ValidationErrors{
+		"only mysql and sqlite3 supported",
+		"confirm_num of chain bsc should be larger than 0",
+		"factory_protocols of 0x1 of chain bsc: unknown protocol uniswap_v9",
+		"block_update_timeout should be larger than 0",
+	}, err)
+}
+
+func TestRedacted(t *testing.T) {
+	config, err := LoadConfig(ConfigSources{FilePath: writeTestConfig(t)})
+	require.NoError(t, err)
+	redacted := config.Redacted()
+	require.Equal(t, redactedValue, redacted.StatasDBConfig.DBPath)
+	require.Equal(t, redactedValue, redacted.ChainConfigs.Get("bsc").Provider)
+	require.Equal(t, redactedValue, redacted.AlertConfig.TelegramBotId)
+	// empty secrets stay empty, so a missing one is still visible
+	require.Equal(t, "", redacted.AlertConfig.TelegramChatId)
+	require.Equal(t, "wss://bsc", config.ChainConfigs.Get("bsc").Provider)
+}
+
+func TestColdChanges(t *testing.T) {
+	path := writeTestConfig(t)
+	current, err := LoadConfig(ConfigSources{FilePath: path})
+	require.NoError(t, err)
+
+	next, err := LoadConfig(ConfigSources{FilePath: path, Overrides: []string{
+		"chain_config.bsc.certificated_pairs=0xa",
+		"chain_config.okex_chain.synup_pools=0xb",
+		"chain_config.bsc.stable_tokens=USDT",
+		"alert_config.block_update_timeout=120",
+		"log_config.level=DEBUG",
+	}})
+	require.NoError(t, err)
+	require.Empty(t, current.ColdChanges(next))
+
+	next, err = LoadConfig(ConfigSources{FilePath: path, Overrides: []string{
+		"statas_db_config.dialect=mysql",
+		"server_config.listen_addr=:9090",
+		"chain_config.bsc.provider=wss://other",
+		"chain_config.bsc.synup_pools=0xb",
+		"log_config.use_file_logger=true",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"statas_db_config.dialect",
+		"chain_config.bsc.provider",
+		"log_config.use_file_logger",
+		"server_config.listen_addr",
+	}, current.ColdChanges(next))
+
+	next, err = LoadConfig(ConfigSources{FilePath: path, Overrides: []string{`chain_config=[{"name": "bsc"}]`}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"chain_config"}, current.ColdChanges(next))
+}
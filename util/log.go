@@ -1,7 +1,9 @@
 package util
 
 import (
+	"fmt"
 	"os"
+	"sync/atomic"
 
 	"github.com/op/go-logging"
 	"github.com/tendermint/tendermint/libs/log"
@@ -22,19 +24,44 @@ var (
 		"INFO":     logging.INFO,
 		"DEBUG":    logging.DEBUG,
 	}
+
+	// logLevel is the level of all backends, records less severe are dropped
+	logLevel = int32(logging.INFO)
 )
 
+// levelBackend drops the records less severe than logLevel, unlike the module levels of go-logging
+// the level can be changed while logging
+type levelBackend struct {
+	backend logging.Backend
+}
+
+func (b *levelBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	if level > logging.Level(atomic.LoadInt32(&logLevel)) {
+		return nil
+	}
+	return b.backend.Log(level, calldepth+1, rec)
+}
+
+// SetLogLevel changes the level of the logger
+func SetLogLevel(level string) error {
+	logLevelValue, exist := levels[level]
+	if !exist {
+		return fmt.Errorf("unknown log level %s", level)
+	}
+	atomic.StoreInt32(&logLevel, int32(logLevelValue))
+	return nil
+}
+
 // InitLogger initialises the logger.
 func InitLogger(config LogConfig) {
 	backends := make([]logging.Backend, 0)
+	atomic.StoreInt32(&logLevel, int32(levels[config.Level]))
 
 	if config.UseConsoleLogger {
 		consoleFormat := logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level} %{shortfunc} %{message}`)
 		consoleLogger := logging.NewLogBackend(os.Stdout, "", 0)
 		consoleFormatter := logging.NewBackendFormatter(consoleLogger, consoleFormat)
-		consoleLoggerLeveled := logging.AddModuleLevel(consoleFormatter)
-		consoleLoggerLeveled.SetLevel(levels[config.Level], "")
-		backends = append(backends, consoleLoggerLeveled)
+		backends = append(backends, &levelBackend{backend: consoleFormatter})
 	}
 
 	if config.UseFileLogger {
@@ -47,9 +74,7 @@ func InitLogger(config LogConfig) {
 		}, "", 0)
 		fileFormat := logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level} %{shortfunc} %{message}`)
 		fileFormatter := logging.NewBackendFormatter(fileLogger, fileFormat)
-		fileLoggerLeveled := logging.AddModuleLevel(fileFormatter)
-		fileLoggerLeveled.SetLevel(levels[config.Level], "")
-		backends = append(backends, fileLoggerLeveled)
+		backends = append(backends, &levelBackend{backend: fileFormatter})
 	}
 
 	logging.SetBackend(backends...)
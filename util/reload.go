@@ -0,0 +1,99 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+)
+
+// hotChainFields are the json keys of the chain config which are applied without a restart
+var hotChainFields = map[string]bool{
+	"certificated_pairs": true,
+	"token_lists":        true,
+	"allow_tokens":       true,
+	"deny_tokens":        true,
+	"supply_excluded":    true,
+	"synup_pools":        true,
+	"stable_tokens":      true,
+	"base_tokens":        true,
+	"project_token":      true,
+	"syrup_token":        true,
+	"syrup_token_symbol": true,
+}
+
+// hotFields are the json paths outside the chains which are applied without a restart
+var hotFields = map[string]bool{
+	"alert_config":     true,
+	"log_config.level": true,
+}
+
+// ColdChanges returns the json paths of the fields changed in next which can only be applied by a
+// restart, the chains are named by their names in the paths
+func (cfg *Config) ColdChanges(next *Config) []string {
+	changes := make([]string, 0)
+	diffFields(reflect.ValueOf(cfg).Elem(), reflect.ValueOf(next).Elem(), "", &changes)
+	cold := make([]string, 0, len(changes))
+	for _, path := range changes {
+		if isHotField(path) {
+			continue
+		}
+		cold = append(cold, path)
+	}
+	return cold
+}
+
+func isHotField(path string) bool {
+	for hot := range hotFields {
+		if path == hot || strings.HasPrefix(path, hot+".") {
+			return true
+		}
+	}
+	parts := strings.Split(path, ".")
+	return len(parts) == 3 && parts[0] == "chain_config" && hotChainFields[parts[2]]
+}
+
+// diffFields adds the paths of the fields which differ, down to the fields of the nested configs
+func diffFields(cur, next reflect.Value, path string, changes *[]string) {
+	switch {
+	case cur.Kind() == reflect.Ptr && cur.Type().Elem().Kind() == reflect.Struct:
+		if cur.IsNil() || next.IsNil() {
+			if cur.IsNil() != next.IsNil() {
+				*changes = append(*changes, path)
+			}
+			return
+		}
+		diffFields(cur.Elem(), next.Elem(), path, changes)
+	case cur.Kind() == reflect.Struct:
+		t := cur.Type()
+		for idx := 0; idx < t.NumField(); idx++ {
+			tag := strings.Split(t.Field(idx).Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			fieldPath := tag
+			if path != "" {
+				fieldPath = path + "." + tag
+			}
+			diffFields(cur.Field(idx), next.Field(idx), fieldPath, changes)
+		}
+	case cur.Type() == reflect.TypeOf(ChainConfigs{}):
+		curChains, nextChains := cur.Interface().(ChainConfigs), next.Interface().(ChainConfigs)
+		// chains added, removed or reordered need a restart, the first chain is the default of the api
+		if len(curChains) != len(nextChains) {
+			*changes = append(*changes, path)
+			return
+		}
+		for idx := range curChains {
+			if curChains[idx] == nil || nextChains[idx] == nil || curChains[idx].Name != nextChains[idx].Name {
+				*changes = append(*changes, path)
+				return
+			}
+		}
+		for idx := range curChains {
+			diffFields(reflect.ValueOf(curChains[idx]), reflect.ValueOf(nextChains[idx]), path+"."+curChains[idx].Name, changes)
+		}
+	default:
+		if !reflect.DeepEqual(cur.Interface(), next.Interface()) {
+			*changes = append(*changes, path)
+		}
+	}
+}